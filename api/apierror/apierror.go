@@ -0,0 +1,89 @@
+// Package apierror is the structured error envelope and request ID shared by
+// the API's handlers and middleware.
+package apierror
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code identifies a failure for clients to branch on. Codes are stable; the
+// accompanying message is for humans and may change.
+type Code string
+
+const (
+	CodeInvalidJSON          Code = "INVALID_JSON"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeEmptyBody            Code = "EMPTY_BODY"
+	CodeMissingEmail         Code = "MISSING_EMAIL"
+	CodeRateLimited          Code = "RATE_LIMITED"
+	CodeQuotaExceeded        Code = "QUOTA_EXCEEDED"
+	CodePublishFailed        Code = "PUBLISH_FAILED"
+	CodeMissingAPIKey        Code = "MISSING_API_KEY"
+	CodeInvalidAPIKey        Code = "INVALID_API_KEY"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeInvalidRequest       Code = "INVALID_REQUEST"
+	CodeInvalidSignature     Code = "INVALID_SIGNATURE"
+	CodeStaleSignature       Code = "STALE_SIGNATURE"
+	CodeInternal             Code = "INTERNAL_ERROR"
+)
+
+// RequestIDHeader carries the caller's request ID, which is echoed back on
+// the response. Requests without one are given a generated ID.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key the request ID is kept under.
+const requestIDKey = "requestID"
+
+// maxRequestIDLength bounds caller-supplied IDs, which end up in logs.
+const maxRequestIDLength = 128
+
+// Response is the body of a structured error response.
+type Response struct {
+	Error Detail `json:"error"`
+}
+
+// Detail describes a failed request.
+type Detail struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// Respond writes a structured error response with the request's ID and
+// aborts the remaining handlers.
+func Respond(c *gin.Context, status int, code Code, message string) {
+	c.AbortWithStatusJSON(status, Response{Error: Detail{
+		Code:      code,
+		Message:   message,
+		RequestID: RequestID(c),
+	}})
+}
+
+// RequestID returns the request's ID: the RequestIDHeader value if the
+// caller sent a usable one, otherwise a generated ID. The ID is fixed for the
+// rest of the request and set on the response header.
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,54 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveRateLimited(requestID string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook", func(c *gin.Context) {
+		Respond(c, http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRespondEchoesRequestID(t *testing.T) {
+	w := serveRateLimited("req-123")
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.JSONEq(t, `{"error":{"code":"RATE_LIMITED","message":"Rate limit exceeded","request_id":"req-123"}}`, w.Body.String())
+	assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+}
+
+func TestRespondGeneratesRequestID(t *testing.T) {
+	for name, sent := range map[string]string{
+		"missing":  "",
+		"too long": strings.Repeat("x", maxRequestIDLength+1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := serveRateLimited(sent)
+
+			var body Response
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, CodeRateLimited, body.Error.Code)
+			assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, body.Error.RequestID)
+			assert.Equal(t, body.Error.RequestID, w.Header().Get(RequestIDHeader))
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/health"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/stats"
+	"webhook-processor/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AdminHandler serves operator endpoints under /admin.
+type AdminHandler struct {
+	logger     *zap.Logger
+	throughput *stats.ThroughputCounter
+	publisher  queue.Publisher
+	store      storage.EventStore
+
+	// replaying holds the events a Replay is republishing, so a concurrent
+	// replay of the same client skips them rather than publishing twice
+	mu        sync.Mutex
+	replaying map[eventKey]struct{}
+}
+
+type eventKey struct {
+	webhookID string
+	clientID  string
+}
+
+// NewAdminHandler creates the admin handler. store may be nil when MongoDB is
+// not configured, in which case storage-backed endpoints return 503.
+func NewAdminHandler(logger *zap.Logger, throughput *stats.ThroughputCounter, publisher queue.Publisher, store storage.EventStore) *AdminHandler {
+	return &AdminHandler{
+		logger:     logger,
+		throughput: throughput,
+		publisher:  publisher,
+		store:      store,
+		replaying:  make(map[eventKey]struct{}),
+	}
+}
+
+// Throughput returns the number of events accepted per client over the
+// rolling window.
+func (h *AdminHandler) Throughput(c *gin.Context) {
+	counts := h.throughput.Counts()
+	window := h.throughput.Window().Seconds()
+
+	clients := make(map[string]gin.H, len(counts))
+	for clientID, count := range counts {
+		clients[clientID] = gin.H{
+			"events":     count,
+			"per_second": float64(count) / window,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window_seconds": window,
+		"clients":        clients,
+	})
+}
+
+// ClientStats returns a client's accepted-event count over the throughput
+// window along with its most recent processing failure, if any. Only admins
+// can read another client's stats.
+func (h *AdminHandler) ClientStats(c *gin.Context) {
+	clientID, ok := scopedClient(c, c.Param("clientID"))
+	if !ok {
+		return
+	}
+
+	resp := gin.H{
+		"client_id":      clientID,
+		"window_seconds": h.throughput.Window().Seconds(),
+		"events":         h.throughput.Counts()[clientID],
+		"last_error":     nil,
+		"last_error_at":  nil,
+	}
+
+	if h.store != nil {
+		lastErr, err := h.store.GetClientLastError(c.Request.Context(), clientID)
+		if err != nil {
+			h.logger.Error("Failed to load client last error", zap.Error(err), zap.String("client_id", clientID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client stats"})
+			return
+		}
+		if lastErr != nil {
+			resp["last_error"] = lastErr.LastError
+			resp["last_error_at"] = lastErr.LastErrorAt
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Reprocess re-publishes a single stored event by webhook ID. An optional
+// client_id query parameter disambiguates IDs shared across clients; clients
+// other than admins can only reprocess their own events.
+func (h *AdminHandler) Reprocess(c *gin.Context) {
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Event storage is not configured"})
+		return
+	}
+
+	webhookID := c.Param("webhookID")
+	clientID, ok := scopedClient(c, c.Query("client_id"))
+	if !ok {
+		return
+	}
+
+	event, err := h.store.GetEventByWebhookID(c.Request.Context(), webhookID, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEventNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found", "webhook_id": webhookID})
+			return
+		}
+		h.logger.Error("Failed to load event for reprocessing", zap.Error(err), zap.String("webhook_id", webhookID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load event"})
+		return
+	}
+
+	if err := h.publisher.Publish(*event); err != nil {
+		h.logger.Error("Failed to re-publish event", zap.Error(err), zap.String("webhook_id", webhookID))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to re-publish event", "webhook_id": webhookID})
+		return
+	}
+
+	event.RetryCount = 0
+	if err := h.store.UpdateEventStatus(c.Request.Context(), event, models.EventStatusPending); err != nil {
+		h.logger.Warn("Failed to reset status of reprocessed event", zap.Error(err), zap.String("webhook_id", webhookID))
+	}
+
+	h.logger.Info("Re-published event for reprocessing",
+		zap.String("webhook_id", event.WebhookID),
+		zap.String("client_id", event.ClientID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "requeued",
+		"webhook_id": event.WebhookID,
+		"client_id":  event.ClientID,
+		"event":      event.Event,
+	})
+}
+
+// Replay re-publishes a client's failed events, optionally only those of one
+// event type, from a {"client_id": "...", "event": "..."} body. Each event
+// goes back with its retry count reset and its status set to pending, so it
+// isn't loaded by a later replay unless it fails again. Events another
+// replay is republishing or has requeued are skipped. A publish failure
+// stops the replay; the events already requeued are reported either way.
+// Clients other than admins can only replay their own events, and may leave
+// client_id out.
+func (h *AdminHandler) Replay(c *gin.Context) {
+	if h.store == nil {
+		apierror.Respond(c, http.StatusServiceUnavailable, apierror.CodeInternal, "Event storage is not configured")
+		return
+	}
+
+	var req struct {
+		ClientID string `json:"client_id"`
+		Event    string `json:"event"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, `Body must be {"client_id": "...", "event": "..."} with an optional event`)
+		return
+	}
+	var ok bool
+	if req.ClientID, ok = scopedClient(c, req.ClientID); !ok {
+		return
+	}
+	if req.ClientID == "" {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, `Body must be {"client_id": "...", "event": "..."} with an optional event`)
+		return
+	}
+
+	ctx := c.Request.Context()
+	events, err := h.store.GetFailedEvents(ctx, req.ClientID)
+	if err != nil {
+		h.logger.Error("Failed to load failed events for replay", zap.Error(err), zap.String("client_id", req.ClientID))
+		apierror.Respond(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to load failed events")
+		return
+	}
+
+	var matched, requeued, skipped int
+	for _, event := range events {
+		// Matched like the stored event_type, so "bounced" finds "hard_bounce"
+		if req.Event != "" && event.Type() != models.NormalizeEvent(req.Event) {
+			continue
+		}
+		matched++
+
+		if !h.claimReplay(event) {
+			skipped++
+			continue
+		}
+		replayed, err := h.replayEvent(c, event)
+		h.releaseReplay(event)
+		if err != nil {
+			h.logger.Error("Failed to re-publish event for replay",
+				zap.Error(err),
+				zap.String("client_id", event.ClientID),
+				zap.String("webhook_id", event.WebhookID))
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":      "Failed to re-publish event",
+				"client_id":  req.ClientID,
+				"webhook_id": event.WebhookID,
+				"matched":    matched,
+				"requeued":   requeued,
+				"skipped":    skipped,
+			})
+			return
+		}
+		if !replayed {
+			skipped++
+			continue
+		}
+		requeued++
+	}
+
+	h.logger.Info("Replayed failed events",
+		zap.String("client_id", req.ClientID),
+		zap.String("event", req.Event),
+		zap.Int("requeued", requeued),
+		zap.Int("skipped", skipped))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "requeued",
+		"client_id": req.ClientID,
+		"event":     req.Event,
+		"matched":   matched,
+		"requeued":  requeued,
+		"skipped":   skipped,
+	})
+}
+
+// replayEvent publishes event as a first attempt and marks it pending,
+// unless it is no longer failed: a concurrent replay that loaded it too may
+// have requeued it already.
+func (h *AdminHandler) replayEvent(c *gin.Context, event *models.WebhookEvent) (bool, error) {
+	current, err := h.store.GetEventByWebhookID(c.Request.Context(), event.WebhookID, event.ClientID)
+	if errors.Is(err, storage.ErrEventNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.Status != string(models.EventStatusFailed) {
+		return false, nil
+	}
+
+	event.RetryCount = 0
+	event.Status = string(models.EventStatusPending)
+	if err := h.publisher.Publish(*event); err != nil {
+		return false, err
+	}
+	if err := h.store.UpdateEventStatus(c.Request.Context(), event, models.EventStatusPending); err != nil {
+		h.logger.Warn("Failed to reset status of replayed event", zap.Error(err), zap.String("webhook_id", event.WebhookID))
+	}
+	return true, nil
+}
+
+// claimReplay marks event as being replayed, and reports false if another
+// replay already has it.
+func (h *AdminHandler) claimReplay(event *models.WebhookEvent) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := eventKey{event.WebhookID, event.ClientID}
+	if _, ok := h.replaying[key]; ok {
+		return false
+	}
+	h.replaying[key] = struct{}{}
+	return true
+}
+
+func (h *AdminHandler) releaseReplay(event *models.WebhookEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.replaying, eventKey{event.WebhookID, event.ClientID})
+}
+
+// scopedClient returns the client a request acts on: requested for admins,
+// and otherwise the authenticated client, which requested must name if it
+// is set. It answers 403 and returns false if requested is another client.
+func scopedClient(c *gin.Context, requested string) (string, bool) {
+	if c.GetBool("admin") {
+		return requested, true
+	}
+	clientID := c.GetString("clientID")
+	if requested != "" && requested != clientID {
+		apierror.Respond(c, http.StatusForbidden, apierror.CodeForbidden, "API key is not authorized for this client")
+		return "", false
+	}
+	return clientID, true
+}
+
+// StatusHandler serves the aggregated subsystem status.
+type StatusHandler struct {
+	checker *health.Checker
+}
+
+func NewStatusHandler(checker *health.Checker) *StatusHandler {
+	return &StatusHandler{checker: checker}
+}
+
+// Status reports every subsystem's state with an overall verdict. It answers
+// 503 when the service is down and 200 otherwise, including when degraded.
+func (h *StatusHandler) Status(c *gin.Context) {
+	report := h.checker.Run(c.Request.Context())
+
+	code := http.StatusOK
+	if report.Status == health.StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, report)
+}
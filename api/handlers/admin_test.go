@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/health"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/stats"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAdminThroughput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	counter := stats.NewThroughputCounter(time.Minute, clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	for i := 0; i < 30; i++ {
+		counter.Record("client-a")
+	}
+	handler := NewAdminHandler(zap.NewNop(), counter, nil, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/throughput", nil)
+	handler.Throughput(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		WindowSeconds float64 `json:"window_seconds"`
+		Clients       map[string]struct {
+			Events    int     `json:"events"`
+			PerSecond float64 `json:"per_second"`
+		} `json:"clients"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 60.0, body.WindowSeconds)
+	assert.Equal(t, 30, body.Clients["client-a"].Events)
+	assert.Equal(t, 0.5, body.Clients["client-a"].PerSecond)
+}
+
+// authenticatedAs stands in for SecurityMiddleware.Authenticate.
+func authenticatedAs(clientID string, admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("clientID", clientID)
+		c.Set("admin", admin)
+	}
+}
+
+func serveAdmin(handler *AdminHandler, method, path string) *httptest.ResponseRecorder {
+	return serveAdminAs(handler, "ops", true, method, path)
+}
+
+func serveAdminAs(handler *AdminHandler, clientID string, admin bool, method, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authenticatedAs(clientID, admin))
+	r.POST("/admin/reprocess/:webhookID", handler.Reprocess)
+	r.GET("/admin/stats/:clientID", handler.ClientStats)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestAdminReprocessFound(t *testing.T) {
+	store := storagetest.NewFakeStore(&models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Event: "bounced", RetryCount: 3})
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool {
+		return e.WebhookID == "wh-1" && e.ClientID == "client-a"
+	})).Return(nil)
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+	w := serveAdmin(handler, http.MethodPost, "/admin/reprocess/wh-1")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"requeued"`)
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusPending, status)
+	pub.AssertExpectations(t)
+}
+
+func TestAdminReprocessNotFound(t *testing.T) {
+	pub := new(MockPublisher)
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, storagetest.NewFakeStore())
+
+	w := serveAdmin(handler, http.MethodPost, "/admin/reprocess/missing")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestAdminReprocessWithoutStore(t *testing.T) {
+	handler := NewAdminHandler(zap.NewNop(), nil, new(MockPublisher), nil)
+
+	w := serveAdmin(handler, http.MethodPost, "/admin/reprocess/wh-1")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func serveReplay(handler *AdminHandler, body string) *httptest.ResponseRecorder {
+	return serveReplayAs(handler, "ops", true, body)
+}
+
+func serveReplayAs(handler *AdminHandler, clientID string, admin bool, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authenticatedAs(clientID, admin))
+	r.POST("/admin/replay", handler.Replay)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(body)))
+	return w
+}
+
+type replaySummary struct {
+	Matched  int `json:"matched"`
+	Requeued int `json:"requeued"`
+	Skipped  int `json:"skipped"`
+}
+
+func decodeReplay(t *testing.T, w *httptest.ResponseRecorder) replaySummary {
+	t.Helper()
+	var summary replaySummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	return summary
+}
+
+func failedEvents() []*models.WebhookEvent {
+	failed := string(models.EventStatusFailed)
+	return []*models.WebhookEvent{
+		{WebhookID: "wh-1", ClientID: "client-a", Event: "hard_bounce", Status: failed, RetryCount: 3},
+		{WebhookID: "wh-2", ClientID: "client-a", Event: "opened", Status: failed, RetryCount: 3},
+		{WebhookID: "wh-3", ClientID: "client-a", Event: "bounced", Status: string(models.EventStatusProcessed)},
+		{WebhookID: "wh-4", ClientID: "client-b", Event: "bounced", Status: failed, RetryCount: 3},
+	}
+}
+
+func TestAdminReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantIDs   []string
+		wantCode  int
+		wantError bool
+	}{
+		{name: "all failed events", body: `{"client_id": "client-a"}`, wantIDs: []string{"wh-1", "wh-2"}, wantCode: http.StatusOK},
+		{name: "event filter", body: `{"client_id": "client-a", "event": "bounced"}`, wantIDs: []string{"wh-1"}, wantCode: http.StatusOK},
+		{name: "nothing failed", body: `{"client_id": "client-c"}`, wantCode: http.StatusOK},
+		{name: "missing client", body: `{"event": "bounced"}`, wantCode: http.StatusBadRequest},
+		{name: "invalid body", body: `client-a`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storagetest.NewFakeStore(failedEvents()...)
+			pub := new(MockPublisher)
+			var published []string
+			pub.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool {
+				return e.RetryCount == 0 && e.Status == string(models.EventStatusPending)
+			})).Run(func(args mock.Arguments) {
+				published = append(published, args.Get(0).(models.WebhookEvent).WebhookID)
+			}).Return(nil)
+			handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+			w := serveReplay(handler, tt.body)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			assert.ElementsMatch(t, tt.wantIDs, published)
+			if tt.wantCode != http.StatusOK {
+				assert.Equal(t, apierror.CodeInvalidRequest, errorCode(t, w))
+				return
+			}
+			assert.Equal(t, replaySummary{Matched: len(tt.wantIDs), Requeued: len(tt.wantIDs)}, decodeReplay(t, w))
+			for _, id := range tt.wantIDs {
+				event, _ := store.Event(id, "client-a")
+				assert.Equal(t, string(models.EventStatusPending), event.Status)
+				assert.Zero(t, event.RetryCount)
+			}
+			event, _ := store.Event("wh-4", "client-b")
+			assert.Equal(t, string(models.EventStatusFailed), event.Status, "other clients' events are left alone")
+		})
+	}
+}
+
+// staleFailedStore returns the failed events it was loaded with whatever
+// their current status, like a replay that loaded them before a concurrent
+// one requeued them.
+type staleFailedStore struct {
+	*storagetest.FakeStore
+	failed []*models.WebhookEvent
+}
+
+func (s *staleFailedStore) GetFailedEvents(ctx context.Context, clientID string) ([]*models.WebhookEvent, error) {
+	return s.failed, nil
+}
+
+func TestAdminReplayScopedToClient(t *testing.T) {
+	store := storagetest.NewFakeStore(failedEvents()...)
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+	w := serveReplayAs(handler, "client-a", false, `{"client_id": "client-b"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, "a client can't replay another client's events")
+	assert.Equal(t, apierror.CodeForbidden, errorCode(t, w))
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+
+	w = serveReplayAs(handler, "client-b", false, `{}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, replaySummary{Matched: 1, Requeued: 1}, decodeReplay(t, w), "client_id defaults to the caller")
+	event, _ := store.Event("wh-4", "client-b")
+	assert.Equal(t, string(models.EventStatusPending), event.Status)
+
+	w = serveReplay(handler, `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "admins must name the client")
+	assert.Equal(t, apierror.CodeInvalidRequest, errorCode(t, w))
+}
+
+func TestAdminReplaySkipsConcurrentReplays(t *testing.T) {
+	events := failedEvents()
+	store := &staleFailedStore{FakeStore: storagetest.NewFakeStore(events...), failed: events[:2]}
+	require.NoError(t, store.UpdateEventStatus(context.Background(), events[1], models.EventStatusPending))
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+	// Another replay is republishing wh-1; wh-2 has already been requeued
+	require.True(t, handler.claimReplay(events[0]))
+	w := serveReplay(handler, `{"client_id": "client-a"}`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, replaySummary{Matched: 2, Skipped: 2}, decodeReplay(t, w))
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+
+	handler.releaseReplay(events[0])
+	w = serveReplay(handler, `{"client_id": "client-a"}`)
+	assert.Equal(t, replaySummary{Matched: 2, Requeued: 1, Skipped: 1}, decodeReplay(t, w))
+	pub.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestAdminReplayPublishFailure(t *testing.T) {
+	store := storagetest.NewFakeStore(failedEvents()...)
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(errors.New("broker unavailable")).Once()
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+	w := serveReplay(handler, `{"client_id": "client-a"}`)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, replaySummary{Matched: 1}, decodeReplay(t, w), "the replay stops at the failure")
+	assert.Empty(t, handler.replaying, "claims are released")
+	for _, id := range []string{"wh-1", "wh-2"} {
+		event, _ := store.Event(id, "client-a")
+		assert.Equal(t, string(models.EventStatusFailed), event.Status)
+	}
+}
+
+func TestAdminReplayWithoutStore(t *testing.T) {
+	handler := NewAdminHandler(zap.NewNop(), nil, new(MockPublisher), nil)
+
+	w := serveReplay(handler, `{"client_id": "client-a"}`)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, apierror.CodeInternal, errorCode(t, w))
+}
+
+func TestAdminReplayStoreFailure(t *testing.T) {
+	store := storagetest.NewFakeStore(failedEvents()...)
+	store.SetError(storagetest.GetFailedEvents, errors.New("mongo unavailable"))
+	pub := new(MockPublisher)
+	handler := NewAdminHandler(zap.NewNop(), nil, pub, store)
+
+	w := serveReplay(handler, `{"client_id": "client-a"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, apierror.CodeInternal, errorCode(t, w))
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestAdminClientStatsIncludesLastError(t *testing.T) {
+	counter := stats.NewThroughputCounter(time.Minute, clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	counter.Record("client-a")
+	store := storagetest.NewFakeStore()
+	failedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.RecordClientError(context.Background(), &models.WebhookEvent{ClientID: "client-a"}, "insert failed", failedAt))
+	handler := NewAdminHandler(zap.NewNop(), counter, nil, store)
+
+	w := serveAdmin(handler, http.MethodGet, "/admin/stats/client-a")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Events      int       `json:"events"`
+		LastError   string    `json:"last_error"`
+		LastErrorAt time.Time `json:"last_error_at"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 1, body.Events)
+	assert.Equal(t, "insert failed", body.LastError)
+	assert.True(t, failedAt.Equal(body.LastErrorAt))
+}
+
+func TestAdminScopedToClient(t *testing.T) {
+	counter := stats.NewThroughputCounter(time.Minute, clock.New())
+	store := storagetest.NewFakeStore(
+		&models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Event: "bounced"},
+		&models.WebhookEvent{WebhookID: "wh-2", ClientID: "client-b", Event: "bounced"},
+	)
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewAdminHandler(zap.NewNop(), counter, pub, store)
+
+	w := serveAdminAs(handler, "client-a", false, http.MethodGet, "/admin/stats/client-b")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, apierror.CodeForbidden, errorCode(t, w))
+	assert.Equal(t, http.StatusOK, serveAdminAs(handler, "client-a", false, http.MethodGet, "/admin/stats/client-a").Code)
+	assert.Equal(t, http.StatusOK, serveAdmin(handler, http.MethodGet, "/admin/stats/client-b").Code, "admins read any client's stats")
+
+	assert.Equal(t, http.StatusForbidden, serveAdminAs(handler, "client-a", false, http.MethodPost, "/admin/reprocess/wh-2?client_id=client-b").Code)
+	assert.Equal(t, http.StatusNotFound, serveAdminAs(handler, "client-a", false, http.MethodPost, "/admin/reprocess/wh-2").Code,
+		"without client_id the lookup is still limited to the caller's events")
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+
+	assert.Equal(t, http.StatusOK, serveAdminAs(handler, "client-a", false, http.MethodPost, "/admin/reprocess/wh-1").Code)
+	pub.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestAdminClientStatsWithoutErrors(t *testing.T) {
+	counter := stats.NewThroughputCounter(time.Minute, clock.New())
+	handler := NewAdminHandler(zap.NewNop(), counter, nil, storagetest.NewFakeStore())
+
+	w := serveAdmin(handler, http.MethodGet, "/admin/stats/client-b")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"last_error":null`)
+}
+
+func TestAdminStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		rabbitmq   health.Status
+		mongodb    health.Status
+		wantStatus health.Status
+		wantCode   int
+	}{
+		{name: "healthy", rabbitmq: health.StatusOK, mongodb: health.StatusOK, wantStatus: health.StatusOK, wantCode: http.StatusOK},
+		{name: "optional subsystem down", rabbitmq: health.StatusOK, mongodb: health.StatusDown, wantStatus: health.StatusDegraded, wantCode: http.StatusOK},
+		{name: "broker down", rabbitmq: health.StatusDown, mongodb: health.StatusOK, wantStatus: health.StatusDown, wantCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(time.Second)
+			checker.Add("rabbitmq", true, func(ctx context.Context) health.Result { return health.Result{Status: tt.rabbitmq} })
+			checker.Add("mongodb", false, func(ctx context.Context) health.Result { return health.Result{Status: tt.mongodb} })
+
+			r := gin.New()
+			r.GET("/admin/status", NewStatusHandler(checker).Status)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.Equal(t, tt.wantStatus, report.Status)
+			assert.Equal(t, tt.mongodb, report.Subsystems["mongodb"].Status)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// BatchResult is the response for array (batch) payloads, reporting the
+// outcome of every item.
+type BatchResult struct {
+	Accepted []string         `json:"accepted"`
+	Rejected []BatchRejection `json:"rejected"`
+	// Dropped items were accepted but not stored, as their client is over
+	// its daily storage quota
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// BatchRejection describes why the item at Index was not accepted.
+type BatchRejection struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func newBatchResult() *BatchResult {
+	return &BatchResult{
+		Accepted: []string{},
+		Rejected: []BatchRejection{},
+	}
+}
+
+func (r *BatchResult) accept(webhookID string) {
+	r.Accepted = append(r.Accepted, webhookID)
+}
+
+func (r *BatchResult) drop(webhookID string) {
+	r.Dropped = append(r.Dropped, webhookID)
+}
+
+func (r *BatchResult) reject(index int, reason string) {
+	r.Rejected = append(r.Rejected, BatchRejection{Index: index, Error: reason})
+}
+
+// StatusCode returns 200 when every item was accepted or dropped, 207 for a
+// partial batch and 422 when nothing was.
+func (r *BatchResult) StatusCode() int {
+	switch {
+	case len(r.Rejected) == 0:
+		return http.StatusOK
+	case len(r.Accepted) == 0 && len(r.Dropped) == 0:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+// handleBatch ingests each element of an array payload independently.
+func (h *MailerCloudWebhookHandler) handleBatch(c *gin.Context, items []interface{}) {
+	result := newBatchResult()
+	clientID := h.extractClientID(c, nil)
+
+	for i, item := range items {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			result.reject(i, "item is not a JSON object")
+			continue
+		}
+		applyQueryFields(data, c.Request.URL.Query(), h.cfg.QueryFields)
+
+		if allowed, limit := h.rateLimiter.Allow(clientID, c.GetHeader("Webhook-Id")); !allowed {
+			metrics.RateLimitExceeded.WithLabelValues(clientID, string(limit)).Inc()
+			result.reject(i, "rate limit exceeded")
+			continue
+		}
+
+		event := h.buildEvent(nil, clientID, data)
+		event.RequestID = apierror.RequestID(c)
+		if isTooOld(&event, event.ReceivedAt, h.cfg.MaxEventAge) {
+			metrics.WebhookTooOld.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+			result.reject(i, "event older than maximum age")
+			continue
+		}
+		if missingEmail(&event, h.cfg.RequireEmailEvents) {
+			metrics.WebhookMissingEmail.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+			result.reject(i, "email is required for "+event.Event+" events")
+			continue
+		}
+
+		metrics.WebhookReceived.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+
+		if h.overQuota(&event) {
+			if h.overQuotaAction() == config.OverQuotaReject {
+				result.reject(i, "daily storage quota exceeded")
+			} else {
+				result.drop(event.WebhookID)
+			}
+			continue
+		}
+
+		if err := h.publish(c.Request.Context(), h.publisher, event); err != nil {
+			metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "failed").Inc()
+			h.logger.Error("Failed to publish batch item",
+				zap.Error(err),
+				zap.Int("index", i),
+				zap.String("webhook_id", event.WebhookID))
+			result.reject(i, "failed to process event")
+			continue
+		}
+
+		metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "success").Inc()
+		h.recordAccepted(&event)
+		result.accept(event.WebhookID)
+	}
+
+	h.logger.Info("Processed webhook batch",
+		zap.String("client_id", clientID),
+		zap.Int("accepted", len(result.Accepted)),
+		zap.Int("rejected", len(result.Rejected)))
+
+	status := result.StatusCode()
+	if status == http.StatusOK {
+		status = h.acceptedStatus()
+	}
+	c.JSON(status, result)
+}
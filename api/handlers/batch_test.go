@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func postBatch(t *testing.T, handler *MailerCloudWebhookHandler, payload interface{}) (*httptest.ResponseRecorder, BatchResult) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "test-webhook")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler.HandleWebhook(c)
+
+	var result BatchResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	return w, result
+}
+
+func TestBatchAllAccepted(t *testing.T) {
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	w, result := postBatch(t, handler, []interface{}{
+		map[string]interface{}{"event": "opened", "email": "a@example.com", "message_id": "m1"},
+		map[string]interface{}{"event": "clicked", "email": "b@example.com", "message_id": "m2"},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"m1", "m2"}, result.Accepted)
+	assert.Empty(t, result.Rejected)
+	pub.AssertNumberOfCalls(t, "Publish", 2)
+}
+
+func TestBatchAllRejected(t *testing.T) {
+	pub := new(MockPublisher)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	w, result := postBatch(t, handler, []interface{}{"not-an-object", 42})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Empty(t, result.Accepted)
+	assert.Equal(t, []BatchRejection{
+		{Index: 0, Error: "item is not a JSON object"},
+		{Index: 1, Error: "item is not a JSON object"},
+	}, result.Rejected)
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestBatchStorageQuota(t *testing.T) {
+	for _, policy := range []string{config.OverQuotaDrop, config.OverQuotaReject} {
+		t.Run(policy, func(t *testing.T) {
+			pub := new(MockPublisher)
+			pub.On("Publish", mock.Anything).Return(nil)
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{},
+				WithStorageQuota(&stubQuota{remaining: 1}, policy))
+
+			w, result := postBatch(t, handler, []interface{}{
+				map[string]interface{}{"event": "opened", "email": "a@example.com", "message_id": "m1"},
+				map[string]interface{}{"event": "opened", "email": "b@example.com", "message_id": "m2"},
+			})
+
+			assert.Equal(t, []string{"m1"}, result.Accepted)
+			if policy == config.OverQuotaDrop {
+				assert.Equal(t, http.StatusOK, w.Code, "dropped items aren't retried")
+				assert.Equal(t, []string{"m2"}, result.Dropped)
+				assert.Empty(t, result.Rejected)
+			} else {
+				assert.Equal(t, http.StatusMultiStatus, w.Code)
+				assert.Empty(t, result.Dropped)
+				assert.Equal(t, []BatchRejection{{Index: 1, Error: "daily storage quota exceeded"}}, result.Rejected)
+			}
+			pub.AssertNumberOfCalls(t, "Publish", 1)
+		})
+	}
+}
+
+func TestBatchMixedResult(t *testing.T) {
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool { return e.WebhookID == "m1" })).Return(nil)
+	pub.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool { return e.WebhookID == "m3" })).Return(errors.New("broker down"))
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	w, result := postBatch(t, handler, []interface{}{
+		map[string]interface{}{"event": "opened", "message_id": "m1"},
+		"garbage",
+		map[string]interface{}{"event": "opened", "message_id": "m3"},
+	})
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, []string{"m1"}, result.Accepted)
+	assert.Equal(t, []BatchRejection{
+		{Index: 1, Error: "item is not a JSON object"},
+		{Index: 2, Error: "failed to process event"},
+	}, result.Rejected)
+}
+
+func TestBatchRejectsMissingEmail(t *testing.T) {
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true},
+		config.WebhookConfig{RequireEmailEvents: []string{"opened"}})
+
+	w, result := postBatch(t, handler, []interface{}{
+		map[string]interface{}{"event": "opened", "email": "a@example.com", "message_id": "m1"},
+		map[string]interface{}{"event": "opened", "message_id": "m2"},
+	})
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, []string{"m1"}, result.Accepted)
+	require.Len(t, result.Rejected, 1)
+	assert.Equal(t, 1, result.Rejected[0].Index)
+	assert.Contains(t, result.Rejected[0].Error, "email is required")
+	pub.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestBatchAcceptsCampaignEventsWithoutEmail(t *testing.T) {
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true},
+		config.WebhookConfig{RequireEmailEvents: []string{"opened", "campaign_sent", "Campaign Error"}})
+
+	w, result := postBatch(t, handler, []interface{}{
+		map[string]interface{}{"event": "campaign_sent", "campaign_id": "c-1", "message_id": "m1"},
+		map[string]interface{}{"event": "Campaign Error", "campaign_id": "c-1", "message_id": "m2"},
+		map[string]interface{}{"event": "opened", "campaign_id": "c-1", "message_id": "m3"},
+	})
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, []string{"m1", "m2"}, result.Accepted, "campaign-level events never have an email")
+	require.Len(t, result.Rejected, 1)
+	assert.Equal(t, 2, result.Rejected[0].Index, "subscriber-level events still need one")
+}
+
+func TestBatchAndSingleDeliveriesShareDedupKeys(t *testing.T) {
+	type dedupKey struct{ webhookID, clientID string }
+	var published []dedupKey
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Run(func(args mock.Arguments) {
+		event := args.Get(0).(models.WebhookEvent)
+		published = append(published, dedupKey{event.WebhookID, event.ClientID})
+	}).Return(nil)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	handler.clock = clk
+
+	withID := map[string]interface{}{"event": "opened", "email": "a@example.com", "message_id": "m1"}
+	derived := map[string]interface{}{"event": "clicked", "email": "b@example.com", "campaign_id": "c-1", "ts": 1717243200}
+	bare := map[string]interface{}{"status": "delivered", "list_id": "l-9"}
+
+	for _, event := range []map[string]interface{}{withID, derived, bare} {
+		w, _ := postBatch(t, handler, event)
+		require.Equal(t, http.StatusOK, w.Code)
+		clk.Advance(time.Minute)
+	}
+	w, _ := postBatch(t, handler, []interface{}{bare, derived, withID})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Len(t, published, 6)
+	assert.Equal(t, published[0], published[5], "provider-supplied ID")
+	assert.Equal(t, published[1], published[4], "ID derived from the event fields")
+	assert.Equal(t, published[2], published[3], "ID derived from the whole payload")
+	assert.NotEqual(t, published[0], published[1])
+	assert.NotEqual(t, published[1], published[2])
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// captureFileFormat names the raw payload files written by the debug
+// handler; captureFilePattern matches them.
+const (
+	captureFileFormat  = "raw_webhook_data_%d.json"
+	captureFilePattern = "raw_webhook_data_*.json"
+)
+
+// CaptureFile describes a raw payload captured by the debug handler.
+type CaptureFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// CaptureHandler serves the /admin/captures endpoints, which let operators
+// retrieve debug captures without shell access to the pod. Only files named
+// like captures are listed or served, since the directory may be the
+// working directory.
+type CaptureHandler struct {
+	logger *zap.Logger
+	dir    string
+}
+
+func NewCaptureHandler(logger *zap.Logger, dir string) *CaptureHandler {
+	return &CaptureHandler{logger: logger, dir: dir}
+}
+
+// List returns the capture files, oldest first.
+func (h *CaptureHandler) List(c *gin.Context) {
+	files, err := h.captures()
+	if err != nil {
+		h.logger.Error("Failed to list debug captures", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captures"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(files), "captures": files})
+}
+
+// Download streams a zip of the capture files, or only those named by
+// repeated name query parameters.
+func (h *CaptureHandler) Download(c *gin.Context) {
+	files, err := h.captures()
+	if err != nil {
+		h.logger.Error("Failed to list debug captures", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captures"})
+		return
+	}
+
+	if names := c.QueryArray("name"); len(names) > 0 {
+		wanted := make(map[string]bool, len(names))
+		for _, name := range names {
+			wanted[name] = true
+		}
+		selected := files[:0]
+		for _, f := range files {
+			if wanted[f.Name] {
+				selected = append(selected, f)
+			}
+		}
+		files = selected
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No captures found"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="debug_captures_%s.zip"`, time.Now().UTC().Format("20060102T150405Z")))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	for _, f := range files {
+		if err := h.addToZip(zw, f); err != nil {
+			// The response has started; all we can do is cut it short
+			h.logger.Error("Failed to write debug capture to zip", zap.String("file", f.Name), zap.Error(err))
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		h.logger.Error("Failed to finish debug capture zip", zap.Error(err))
+	}
+}
+
+// captures lists the capture files in the capture directory, oldest first.
+// Names embed the capture time in nanoseconds, so they sort chronologically.
+func (h *CaptureHandler) captures() ([]CaptureFile, error) {
+	paths, err := filepath.Glob(filepath.Join(h.dir, captureFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files := make([]CaptureFile, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, CaptureFile{Name: info.Name(), Size: info.Size(), ModifiedAt: info.ModTime().UTC()})
+	}
+	return files, nil
+}
+
+func (h *CaptureHandler) addToZip(zw *zip.Writer, f CaptureFile) error {
+	src, err := os.Open(filepath.Join(h.dir, f.Name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.ModifiedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
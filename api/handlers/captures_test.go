@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func serveCaptures(t *testing.T, dir, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	handler := NewCaptureHandler(zap.NewNop(), dir)
+	r := gin.New()
+	r.GET("/admin/captures", handler.List)
+	r.GET("/admin/captures/download", handler.Download)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func writeCaptures(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"raw_webhook_data_200.json": `{"event":"open"}`,
+		"raw_webhook_data_100.json": `{"event":"click"}`,
+		"config.yaml":               "secret: true",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestCaptureList(t *testing.T) {
+	dir := writeCaptures(t)
+
+	w := serveCaptures(t, dir, "/admin/captures")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Count    int           `json:"count"`
+		Captures []CaptureFile `json:"captures"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 2, body.Count)
+	require.Len(t, body.Captures, 2)
+	assert.Equal(t, "raw_webhook_data_100.json", body.Captures[0].Name, "oldest first")
+	assert.Equal(t, int64(len(`{"event":"click"}`)), body.Captures[0].Size)
+	assert.Equal(t, "raw_webhook_data_200.json", body.Captures[1].Name)
+}
+
+func TestCaptureDownload(t *testing.T) {
+	dir := writeCaptures(t)
+
+	tests := []struct {
+		name      string
+		path      string
+		wantFiles map[string]string
+	}{
+		{
+			name: "all captures",
+			path: "/admin/captures/download",
+			wantFiles: map[string]string{
+				"raw_webhook_data_100.json": `{"event":"click"}`,
+				"raw_webhook_data_200.json": `{"event":"open"}`,
+			},
+		},
+		{
+			name:      "selected by name",
+			path:      "/admin/captures/download?name=raw_webhook_data_200.json&name=config.yaml",
+			wantFiles: map[string]string{"raw_webhook_data_200.json": `{"event":"open"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveCaptures(t, dir, tt.path)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			require.NoError(t, err)
+			got := make(map[string]string)
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				require.NoError(t, err)
+				content, err := io.ReadAll(rc)
+				rc.Close()
+				require.NoError(t, err)
+				got[f.Name] = string(content)
+			}
+			assert.Equal(t, tt.wantFiles, got)
+		})
+	}
+}
+
+func TestCaptureDownloadNothingCaptured(t *testing.T) {
+	w := serveCaptures(t, t.TempDir(), "/admin/captures/download")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveCaptures(t, writeCaptures(t), "/admin/captures/download?name=../config.yaml")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
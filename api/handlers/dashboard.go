@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Dashboard aggregation settings.
+const (
+	// DashboardWindow is how far back the dashboard counts events
+	DashboardWindow = 24 * time.Hour
+	// DashboardCacheTTL is how long a client's dashboard is served from
+	// cache before it is aggregated again
+	DashboardCacheTTL = 30 * time.Second
+	// DashboardTopCampaigns is the number of campaigns listed
+	DashboardTopCampaigns = 5
+)
+
+// DashboardStore is the storage a client's dashboard is aggregated from.
+type DashboardStore interface {
+	storage.DashboardQuerier
+	GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error)
+}
+
+// Dashboard is the data behind a client's dashboard widget.
+type Dashboard struct {
+	ClientID      string                  `json:"client_id"`
+	WindowSeconds float64                 `json:"window_seconds"`
+	GeneratedAt   time.Time               `json:"generated_at"`
+	Throughput    DashboardThroughput     `json:"throughput"`
+	Bounces       int64                   `json:"bounces"`
+	BounceRate    float64                 `json:"bounce_rate"`
+	TopCampaigns  []storage.CampaignCount `json:"top_campaigns"`
+	LastError     *models.ClientError     `json:"last_error"`
+}
+
+// DashboardThroughput is the number of events received over the window.
+type DashboardThroughput struct {
+	Events  int64   `json:"events"`
+	PerHour float64 `json:"per_hour"`
+}
+
+type cachedDashboard struct {
+	dashboard Dashboard
+	expires   time.Time
+}
+
+// DashboardHandler serves each client a summary of its recent events,
+// aggregated from storage and cached briefly per client so a busy widget
+// doesn't turn into a stream of aggregations.
+type DashboardHandler struct {
+	logger *zap.Logger
+	store  DashboardStore
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	cache map[string]cachedDashboard
+}
+
+// NewDashboardHandler creates the dashboard handler. store may be nil when
+// MongoDB is not configured, in which case Get returns 503.
+func NewDashboardHandler(logger *zap.Logger, store DashboardStore, clk clock.Clock) *DashboardHandler {
+	return &DashboardHandler{
+		logger: logger,
+		store:  store,
+		clock:  clk,
+		cache:  make(map[string]cachedDashboard),
+	}
+}
+
+// Get returns the dashboard of the client in the path, which must be the
+// authenticated client.
+func (h *DashboardHandler) Get(c *gin.Context) {
+	clientID := c.Param("clientID")
+	if clientID != c.GetString("clientID") {
+		apierror.Respond(c, http.StatusForbidden, apierror.CodeForbidden, "API key is not authorized for this client")
+		return
+	}
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Event storage is not configured"})
+		return
+	}
+
+	now := h.clock.Now().UTC()
+	h.mu.Lock()
+	cached, ok := h.cache[clientID]
+	h.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		c.JSON(http.StatusOK, cached.dashboard)
+		return
+	}
+
+	dashboard, err := h.aggregate(c.Request.Context(), clientID, now)
+	if err != nil {
+		h.logger.Error("Failed to aggregate client dashboard", zap.Error(err), zap.String("client_id", clientID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dashboard"})
+		return
+	}
+
+	h.mu.Lock()
+	h.cache[clientID] = cachedDashboard{dashboard: dashboard, expires: now.Add(DashboardCacheTTL)}
+	h.mu.Unlock()
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+// aggregate builds clientID's dashboard over the window ending at now.
+func (h *DashboardHandler) aggregate(ctx context.Context, clientID string, now time.Time) (Dashboard, error) {
+	summary, err := h.store.SummarizeClientEvents(ctx, clientID, now.Add(-DashboardWindow), DashboardTopCampaigns)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	lastErr, err := h.store.GetClientLastError(ctx, clientID)
+	if err != nil {
+		return Dashboard{}, err
+	}
+
+	dashboard := Dashboard{
+		ClientID:      clientID,
+		WindowSeconds: DashboardWindow.Seconds(),
+		GeneratedAt:   now,
+		Throughput: DashboardThroughput{
+			Events:  summary.Events,
+			PerHour: float64(summary.Events) / DashboardWindow.Hours(),
+		},
+		Bounces:      summary.Bounces,
+		TopCampaigns: summary.TopCampaigns,
+		LastError:    lastErr,
+	}
+	if summary.Events > 0 {
+		dashboard.BounceRate = float64(summary.Bounces) / float64(summary.Events)
+	}
+	if dashboard.TopCampaigns == nil {
+		dashboard.TopCampaigns = []storage.CampaignCount{}
+	}
+	return dashboard, nil
+}
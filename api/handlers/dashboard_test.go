@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stubDashboardStore returns a fixed summary and counts aggregations.
+type stubDashboardStore struct {
+	summary storage.ClientSummary
+	lastErr *models.ClientError
+	calls   int
+	since   time.Time
+	top     int
+}
+
+func (s *stubDashboardStore) SummarizeClientEvents(ctx context.Context, clientID string, since time.Time, topCampaigns int) (*storage.ClientSummary, error) {
+	s.calls++
+	s.since = since
+	s.top = topCampaigns
+	summary := s.summary
+	return &summary, nil
+}
+
+func (s *stubDashboardStore) GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error) {
+	return s.lastErr, nil
+}
+
+func serveDashboard(handler *DashboardHandler, authenticated, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/dashboard/:clientID", func(c *gin.Context) { c.Set("clientID", authenticated) }, handler.Get)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestDashboardAggregation(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	errAt := now.Add(-time.Hour)
+	store := &stubDashboardStore{
+		summary: storage.ClientSummary{
+			Events:  480,
+			Bounces: 24,
+			TopCampaigns: []storage.CampaignCount{
+				{CampaignID: "c-2", CampaignName: "June newsletter", Events: 300},
+				{CampaignID: "c-1", CampaignName: "Welcome", Events: 100},
+			},
+		},
+		lastErr: &models.ClientError{ClientID: "client-a", LastError: "parse failed", LastErrorAt: errAt, WebhookID: "wh-9"},
+	}
+	handler := NewDashboardHandler(zap.NewNop(), store, clock.NewMock(now))
+
+	w := serveDashboard(handler, "client-a", "/dashboard/client-a")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body Dashboard
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "client-a", body.ClientID)
+	assert.Equal(t, DashboardWindow.Seconds(), body.WindowSeconds)
+	assert.Equal(t, int64(480), body.Throughput.Events)
+	assert.Equal(t, 20.0, body.Throughput.PerHour)
+	assert.Equal(t, int64(24), body.Bounces)
+	assert.Equal(t, 0.05, body.BounceRate)
+	assert.Equal(t, store.summary.TopCampaigns, body.TopCampaigns)
+	require.NotNil(t, body.LastError)
+	assert.Equal(t, "parse failed", body.LastError.LastError)
+	assert.Equal(t, errAt, body.LastError.LastErrorAt)
+
+	assert.Equal(t, now.Add(-DashboardWindow), store.since)
+	assert.Equal(t, DashboardTopCampaigns, store.top)
+}
+
+func TestDashboardWithoutEvents(t *testing.T) {
+	handler := NewDashboardHandler(zap.NewNop(), &stubDashboardStore{}, clock.NewMock(time.Now()))
+
+	w := serveDashboard(handler, "client-a", "/dashboard/client-a")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 0.0, body["bounce_rate"])
+	assert.Equal(t, []interface{}{}, body["top_campaigns"])
+	assert.Nil(t, body["last_error"])
+}
+
+func TestDashboardRejectsOtherClients(t *testing.T) {
+	store := &stubDashboardStore{}
+	handler := NewDashboardHandler(zap.NewNop(), store, clock.NewMock(time.Now()))
+
+	w := serveDashboard(handler, "client-b", "/dashboard/client-a")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, apierror.CodeForbidden, errorCode(t, w))
+	assert.Zero(t, store.calls, "nothing is aggregated for another client's key")
+}
+
+func TestDashboardIsCachedBriefly(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	store := &stubDashboardStore{summary: storage.ClientSummary{Events: 10}}
+	handler := NewDashboardHandler(zap.NewNop(), store, clk)
+
+	require.Equal(t, http.StatusOK, serveDashboard(handler, "client-a", "/dashboard/client-a").Code)
+	store.summary.Events = 20
+	clk.Advance(DashboardCacheTTL - time.Second)
+
+	var body Dashboard
+	w := serveDashboard(handler, "client-a", "/dashboard/client-a")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(10), body.Throughput.Events, "served from cache")
+	assert.Equal(t, 1, store.calls)
+
+	clk.Advance(time.Second)
+	w = serveDashboard(handler, "client-a", "/dashboard/client-a")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(20), body.Throughput.Events, "aggregated again once the cache expires")
+	assert.Equal(t, 2, store.calls)
+}
+
+func TestDashboardWithoutStore(t *testing.T) {
+	handler := NewDashboardHandler(zap.NewNop(), nil, clock.NewMock(time.Now()))
+
+	w := serveDashboard(handler, "client-a", "/dashboard/client-a")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// EventsHandler serves stored events to their owning client, so dashboards
+// don't need direct database access.
+type EventsHandler struct {
+	logger *zap.Logger
+	store  storage.EventQuerier
+}
+
+// NewEventsHandler creates the events handler. store may be nil when MongoDB
+// is not configured, in which case List returns 503.
+func NewEventsHandler(logger *zap.Logger, store storage.EventQuerier) *EventsHandler {
+	return &EventsHandler{logger: logger, store: store}
+}
+
+// eventView exposes the storage metadata that the queue format omits.
+type eventView struct {
+	models.WebhookEvent
+	ClientID   string    `json:"client_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Status     string    `json:"status"`
+	RetryCount int       `json:"retry_count"`
+}
+
+// List returns one page of the authenticated client's events, newest first.
+// It accepts event, status, from and to (RFC 3339) filters and limit and
+// page (1-based) for paging. A client_id parameter must name the
+// authenticated client; it can't be used to read another client's events.
+func (h *EventsHandler) List(c *gin.Context) {
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Event storage is not configured"})
+		return
+	}
+
+	clientID := c.GetString("clientID")
+	if requested := c.Query("client_id"); requested != "" && requested != clientID {
+		apierror.Respond(c, http.StatusForbidden, apierror.CodeForbidden, "API key is not authorized for this client")
+		return
+	}
+
+	opts, page, err := parseEventsQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, total, err := h.store.GetEventsByClient(c.Request.Context(), clientID, opts)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidTimeRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to query events", zap.Error(err), zap.String("client_id", clientID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query events"})
+		return
+	}
+
+	views := make([]eventView, 0, len(events))
+	for _, event := range events {
+		views = append(views, eventView{
+			WebhookEvent: *event,
+			ClientID:     event.ClientID,
+			ReceivedAt:   event.ReceivedAt,
+			Status:       event.Status,
+			RetryCount:   event.RetryCount,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": views,
+		"total":  total,
+		"page":   page,
+	})
+}
+
+// parseEventsQuery reads the List query parameters into storage options,
+// returning the requested page alongside.
+func parseEventsQuery(c *gin.Context) (storage.QueryOptions, int, error) {
+	opts := storage.QueryOptions{
+		Event:  c.Query("event"),
+		Status: models.EventStatus(c.Query("status")),
+	}
+
+	switch opts.Status {
+	case "", models.EventStatusPending, models.EventStatusProcessed, models.EventStatusFailed, models.EventStatusRetrying:
+	default:
+		return opts, 0, errors.New("invalid status")
+	}
+
+	for param, dst := range map[string]*time.Time{"from": &opts.From, "to": &opts.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return opts, 0, errors.New("invalid " + param + ", want RFC 3339")
+			}
+			*dst = t
+		}
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return opts, 0, errors.New("invalid limit")
+		}
+		opts.Limit = limit
+	}
+	// Page offsets are computed from the limit the store will apply
+	switch {
+	case opts.Limit == 0:
+		opts.Limit = storage.DefaultQueryLimit
+	case opts.Limit > storage.MaxQueryLimit:
+		opts.Limit = storage.MaxQueryLimit
+	}
+
+	page := 1
+	if v := c.Query("page"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 {
+			return opts, 0, errors.New("invalid page")
+		}
+		page = p
+	}
+	opts.Offset = (page - 1) * opts.Limit
+
+	return opts, page, nil
+}
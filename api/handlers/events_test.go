@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stubQuerier returns events and records the last query.
+type stubQuerier struct {
+	events   []*models.WebhookEvent
+	total    int64
+	clientID string
+	opts     storage.QueryOptions
+}
+
+func (q *stubQuerier) GetEventsByClient(ctx context.Context, clientID string, opts storage.QueryOptions) ([]*models.WebhookEvent, int64, error) {
+	q.clientID = clientID
+	q.opts = opts
+	return q.events, q.total, nil
+}
+
+func serveEvents(handler *EventsHandler, clientID, query string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events", func(c *gin.Context) { c.Set("clientID", clientID) }, handler.List)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events"+query, nil))
+	return w
+}
+
+func TestEventsList(t *testing.T) {
+	receivedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := &stubQuerier{
+		events: []*models.WebhookEvent{{
+			WebhookID: "wh-1", ClientID: "client-a", Event: "bounced",
+			Status: string(models.EventStatusProcessed), ReceivedAt: receivedAt,
+		}},
+		total: 41,
+	}
+	handler := NewEventsHandler(zap.NewNop(), store)
+
+	w := serveEvents(handler, "client-a",
+		"?client_id=client-a&event=bounced&status=processed&from=2024-06-01T00:00:00Z&to=2024-06-02T00:00:00Z&limit=20&page=3")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Events []map[string]interface{} `json:"events"`
+		Total  int64                    `json:"total"`
+		Page   int                      `json:"page"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(41), body.Total)
+	assert.Equal(t, 3, body.Page)
+	require.Len(t, body.Events, 1)
+	assert.Equal(t, "wh-1", body.Events[0]["webhook_id"])
+	assert.Equal(t, "client-a", body.Events[0]["client_id"])
+	assert.Equal(t, "processed", body.Events[0]["status"])
+	assert.Equal(t, "2024-06-01T12:00:00Z", body.Events[0]["received_at"])
+
+	assert.Equal(t, "client-a", store.clientID)
+	assert.Equal(t, storage.QueryOptions{
+		Limit:  20,
+		Offset: 40,
+		From:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC),
+		Event:  "bounced",
+		Status: models.EventStatusProcessed,
+	}, store.opts)
+}
+
+func TestEventsListScopedToAuthenticatedClient(t *testing.T) {
+	store := &stubQuerier{}
+	handler := NewEventsHandler(zap.NewNop(), store)
+
+	w := serveEvents(handler, "client-a", "?client_id=client-b")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, apierror.CodeForbidden, errorCode(t, w))
+	assert.Empty(t, store.clientID, "another client's events are never queried")
+
+	w = serveEvents(handler, "client-a", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client-a", store.clientID, "the client comes from the API key")
+	assert.Equal(t, storage.DefaultQueryLimit, store.opts.Limit)
+	assert.Zero(t, store.opts.Offset)
+}
+
+func TestEventsListPagesByCappedLimit(t *testing.T) {
+	store := &stubQuerier{}
+	handler := NewEventsHandler(zap.NewNop(), store)
+
+	w := serveEvents(handler, "client-a", "?limit=100000&page=2")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, storage.MaxQueryLimit, store.opts.Limit)
+	assert.Equal(t, storage.MaxQueryLimit, store.opts.Offset)
+}
+
+func TestEventsListInvalidParams(t *testing.T) {
+	handler := NewEventsHandler(zap.NewNop(), &stubQuerier{})
+
+	for _, query := range []string{
+		"?limit=0", "?limit=abc", "?page=0", "?page=-1",
+		"?from=yesterday", "?to=2024-06-01", "?status=lost",
+	} {
+		t.Run(query, func(t *testing.T) {
+			w := serveEvents(handler, "client-a", query)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestEventsListWithoutStorage(t *testing.T) {
+	w := serveEvents(NewEventsHandler(zap.NewNop(), nil), "client-a", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
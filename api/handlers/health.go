@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"webhook-processor/internal/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler serves the Kubernetes liveness and readiness probes.
+type HealthHandler struct {
+	readiness *health.Checker
+}
+
+// NewHealthHandler creates the probe handler. Every check registered on
+// readiness must pass for the API to be ready.
+func NewHealthHandler(readiness *health.Checker) *HealthHandler {
+	return &HealthHandler{readiness: readiness}
+}
+
+// Live reports that the process is up. It checks no dependencies, so an
+// outage elsewhere doesn't get the pod restarted.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready answers 200 when every readiness check passes and 503 listing the
+// failed checks otherwise, so traffic is routed away while a dependency is
+// unavailable.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	report := h.readiness.Run(c.Request.Context())
+
+	failed := []string{}
+	for name, result := range report.Subsystems {
+		if result.Status != health.StatusOK {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+
+	if len(failed) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "unavailable",
+			"failed": failed,
+			"checks": report.Subsystems,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": report.Subsystems})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHealth(handler *HealthHandler, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health/live", handler.Live)
+	r.GET("/health/ready", handler.Ready)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func readinessChecker(statuses map[string]health.Status) *health.Checker {
+	checker := health.NewChecker(time.Second)
+	for name, status := range statuses {
+		checker.Add(name, true, func(ctx context.Context) health.Result {
+			return health.Result{Status: status, Detail: "probe " + string(status)}
+		})
+	}
+	return checker
+}
+
+func TestReadyWhenEveryCheckPasses(t *testing.T) {
+	handler := NewHealthHandler(readinessChecker(map[string]health.Status{
+		"rabbitmq": health.StatusOK,
+		"mongodb":  health.StatusOK,
+	}))
+
+	w := serveHealth(handler, "/health/ready")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "ready", body["status"])
+	assert.Len(t, body["checks"], 2)
+}
+
+func TestNotReadyListsFailedChecks(t *testing.T) {
+	handler := NewHealthHandler(readinessChecker(map[string]health.Status{
+		"rabbitmq": health.StatusOK,
+		"mongodb":  health.StatusDown,
+		"warmup":   health.StatusDown,
+	}))
+
+	w := serveHealth(handler, "/health/ready")
+
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var body struct {
+		Status string                   `json:"status"`
+		Failed []string                 `json:"failed"`
+		Checks map[string]health.Result `json:"checks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "unavailable", body.Status)
+	assert.Equal(t, []string{"mongodb", "warmup"}, body.Failed)
+	assert.Equal(t, "probe down", body.Checks["mongodb"].Detail)
+}
+
+func TestLiveIgnoresDependencies(t *testing.T) {
+	handler := NewHealthHandler(readinessChecker(map[string]health.Status{"mongodb": health.StatusDown}))
+
+	w := serveHealth(handler, "/health/live")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// admit runs the checks a single webhook event goes through once it has
+// been built: events older than the maximum age are acknowledged but
+// discarded so the sender stops retrying, events without a required email
+// are rejected, and events past their client's storage quota are answered
+// as the over-quota policy says. It reports whether event should be
+// published; if not, the request has been answered.
+func (o *handlerOptions) admit(c *gin.Context, logger *zap.Logger, cfg config.WebhookConfig, event *models.WebhookEvent) bool {
+	if isTooOld(event, event.ReceivedAt, cfg.MaxEventAge) {
+		metrics.WebhookTooOld.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+		logger.Warn("Discarding webhook older than maximum age",
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("client_id", event.ClientID),
+			zap.Int64("ts", event.Timestamp))
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Event discarded: older than maximum age",
+			"webhook_id": event.WebhookID,
+			"client_id":  event.ClientID,
+		})
+		return false
+	}
+
+	if missingEmail(event, cfg.RequireEmailEvents) {
+		metrics.WebhookMissingEmail.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+		logger.Warn("Rejecting webhook without email",
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("client_id", event.ClientID),
+			zap.String("event", event.Event))
+		apierror.Respond(c, http.StatusUnprocessableEntity, apierror.CodeMissingEmail, "Email is required for "+event.Event+" events")
+		return false
+	}
+
+	metrics.WebhookReceived.WithLabelValues(event.ClientID, string(event.Type())).Inc()
+
+	// Count but don't store events past the client's storage quota
+	if o.overQuota(event) {
+		logger.Warn("Client over daily storage quota, event not stored",
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("client_id", event.ClientID))
+		o.respondOverQuota(c, event)
+		return false
+	}
+	return true
+}
+
+// publishEvent sends an admitted event to the message queue, timing it as
+// the publish stage of stages. It reports whether the event was
+// published; if not, the request has been answered.
+func (o *handlerOptions) publishEvent(c *gin.Context, logger *zap.Logger, publisher queue.Publisher, event *models.WebhookEvent, stages *stageTimer) bool {
+	endPublish := stages.start(stagePublish)
+	err := o.publish(c.Request.Context(), publisher, *event)
+	endPublish()
+	if err != nil {
+		metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "failed").Inc()
+		logger.Error("Failed to publish event",
+			append(stages.fields,
+				zap.Error(err),
+				zap.String("webhook_id", event.WebhookID),
+				zap.String("client_id", event.ClientID))...)
+		apierror.Respond(c, publishFailedStatus(err), apierror.CodePublishFailed, "Failed to process event")
+		return false
+	}
+
+	metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "success").Inc()
+	o.recordAccepted(event)
+	return true
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaintenanceToggle switches webhook maintenance mode.
+type MaintenanceToggle interface {
+	Enabled() bool
+	SetEnabled(enabled bool)
+}
+
+// MaintenanceHandler serves the /admin/maintenance endpoints.
+type MaintenanceHandler struct {
+	toggle MaintenanceToggle
+}
+
+func NewMaintenanceHandler(toggle MaintenanceToggle) *MaintenanceHandler {
+	return &MaintenanceHandler{toggle: toggle}
+}
+
+// Status reports whether maintenance mode is enabled.
+func (h *MaintenanceHandler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": h.toggle.Enabled()})
+}
+
+// Set enables or disables maintenance mode from a {"enabled": bool} body.
+func (h *MaintenanceHandler) Set(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Body must be {"enabled": true|false}`})
+		return
+	}
+
+	h.toggle.SetEnabled(*req.Enabled)
+	c.JSON(http.StatusOK, gin.H{"enabled": h.toggle.Enabled()})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/mapping"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// MappingSource is the webhook-to-client mapping served by MappingHandler.
+type MappingSource interface {
+	Mapping() *mapping.WebhookMapping
+	Refresh(ctx context.Context) error
+	Overrides() map[string]string
+	SetOverride(ctx context.Context, webhookID, clientID string) (string, error)
+}
+
+// MappingHandler serves the /admin/mappings endpoints, for finding out why
+// a webhook resolves to the wrong client. API keys are never returned.
+type MappingHandler struct {
+	logger *zap.Logger
+	source MappingSource
+}
+
+func NewMappingHandler(logger *zap.Logger, source MappingSource) *MappingHandler {
+	return &MappingHandler{logger: logger, source: source}
+}
+
+// Get returns the current mapping, with each client's API key redacted, and
+// the overrides that take precedence over it.
+func (h *MappingHandler) Get(c *gin.Context) {
+	current := h.source.Mapping()
+
+	webhooks := make(map[string]int, len(current.ClientToAPIKey))
+	for _, clientID := range current.WebhookToClient {
+		webhooks[clientID]++
+	}
+	clients := make(map[string]gin.H, len(current.ClientToAPIKey))
+	for clientID, apiKey := range current.ClientToAPIKey {
+		_, signed := current.ClientToSecret[clientID]
+		clients[clientID] = gin.H{
+			"api_key":        redactKey(apiKey),
+			"webhooks":       webhooks[clientID],
+			"signing_secret": signed,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total_webhooks":    len(current.WebhookToClient),
+		"total_clients":     len(current.ClientToAPIKey),
+		"last_updated":      current.LastUpdated,
+		"webhook_to_client": current.WebhookToClient,
+		"overrides":         h.source.Overrides(),
+		"clients":           clients,
+	})
+}
+
+// Refresh reloads the mapping now and returns what changed. A failed reload
+// may still have changed some clients, so the delta is returned either way.
+func (h *MappingHandler) Refresh(c *gin.Context) {
+	previous := h.source.Mapping()
+	err := h.source.Refresh(c.Request.Context())
+	current := h.source.Mapping()
+	delta := mapping.Diff(previous, current)
+
+	body := gin.H{
+		"added":          delta.Added,
+		"removed":        delta.Removed,
+		"moved":          delta.Moved,
+		"total_webhooks": len(current.WebhookToClient),
+		"last_updated":   current.LastUpdated,
+	}
+	if err != nil {
+		h.logger.Error("Admin webhook mapping refresh failed", zap.Error(err))
+		body["error"] = "Failed to refresh webhook mappings"
+		c.JSON(http.StatusBadGateway, body)
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// Override maps a webhook to a client by hand from a {"webhook_id": "...",
+// "client_id": "..."} body, for webhooks MailerCloud attributes to the
+// wrong client. The override is persistent and refreshes don't change it.
+// The client must be one of the mapping's configured clients.
+func (h *MappingHandler) Override(c *gin.Context) {
+	var req struct {
+		WebhookID string `json:"webhook_id"`
+		ClientID  string `json:"client_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.WebhookID == "" || req.ClientID == "" {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, `Body must be {"webhook_id": "...", "client_id": "..."}`)
+		return
+	}
+
+	if _, ok := h.source.Mapping().ClientToAPIKey[req.ClientID]; !ok {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "client_id is not a configured client")
+		return
+	}
+
+	previous, err := h.source.SetOverride(c.Request.Context(), req.WebhookID, req.ClientID)
+	if errors.Is(err, mapping.ErrNoOverrideStore) {
+		apierror.Respond(c, http.StatusServiceUnavailable, apierror.CodeInternal, "Webhook mapping overrides need MongoDB to be configured")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Admin webhook mapping override failed",
+			zap.Error(err),
+			zap.String("webhook_id", req.WebhookID),
+			zap.String("client_id", req.ClientID))
+		apierror.Respond(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save webhook mapping override")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook_id":         req.WebhookID,
+		"client_id":          req.ClientID,
+		"previous_client_id": previous,
+	})
+}
+
+// redactKey keeps the last four characters of keys long enough that they
+// don't give the key away, to tell keys apart.
+func redactKey(key string) string {
+	if len(key) < 12 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
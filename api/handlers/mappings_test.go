@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/internal/mapping"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stubMappingSource replaces its mapping with next on Refresh.
+type stubMappingSource struct {
+	current     *mapping.WebhookMapping
+	next        *mapping.WebhookMapping
+	err         error
+	overrides   map[string]string
+	overrideErr error
+}
+
+func (s *stubMappingSource) Mapping() *mapping.WebhookMapping { return s.current }
+
+func (s *stubMappingSource) Refresh(ctx context.Context) error {
+	if s.next != nil {
+		s.current = s.next
+	}
+	return s.err
+}
+
+func (s *stubMappingSource) Overrides() map[string]string { return s.overrides }
+
+func (s *stubMappingSource) SetOverride(ctx context.Context, webhookID, clientID string) (string, error) {
+	if s.overrideErr != nil {
+		return "", s.overrideErr
+	}
+	previous := s.current.WebhookToClient[webhookID]
+	if s.overrides == nil {
+		s.overrides = make(map[string]string)
+	}
+	s.overrides[webhookID] = clientID
+	return previous, nil
+}
+
+func serveMappings(source MappingSource, method, path string) *httptest.ResponseRecorder {
+	return serveMappingsBody(source, method, path, "")
+}
+
+func serveMappingsBody(source MappingSource, method, path, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	handler := NewMappingHandler(zap.NewNop(), source)
+	r := gin.New()
+	r.GET("/admin/mappings", handler.Get)
+	r.POST("/admin/mappings/refresh", handler.Refresh)
+	r.PUT("/admin/mapping/override", handler.Override)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestMappingsGet(t *testing.T) {
+	source := &stubMappingSource{current: &mapping.WebhookMapping{
+		WebhookToClient: map[string]string{"wh-1": "client-a", "wh-2": "client-a"},
+		ClientToAPIKey:  map[string]string{"client-a": "mc-live-0123456789abcd", "client-b": "short"},
+		ClientToSecret:  map[string]string{"client-a": "s3cret"},
+		LastUpdated:     time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, overrides: map[string]string{"wh-3": "client-b"}}
+
+	w := serveMappings(source, http.MethodGet, "/admin/mappings")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "mc-live-0123456789abcd")
+	assert.NotContains(t, w.Body.String(), "short")
+	assert.NotContains(t, w.Body.String(), "s3cret")
+	assert.JSONEq(t, `{
+		"total_webhooks": 2,
+		"total_clients": 2,
+		"last_updated": "2024-06-01T12:00:00Z",
+		"webhook_to_client": {"wh-1": "client-a", "wh-2": "client-a"},
+		"overrides": {"wh-3": "client-b"},
+		"clients": {
+			"client-a": {"api_key": "****abcd", "webhooks": 2, "signing_secret": true},
+			"client-b": {"api_key": "****", "webhooks": 0, "signing_secret": false}
+		}
+	}`, w.Body.String())
+}
+
+func TestMappingsRefresh(t *testing.T) {
+	source := &stubMappingSource{
+		current: &mapping.WebhookMapping{WebhookToClient: map[string]string{"wh-1": "client-a", "wh-2": "client-a"}},
+		next:    &mapping.WebhookMapping{WebhookToClient: map[string]string{"wh-1": "client-b", "wh-3": "client-a"}},
+	}
+
+	w := serveMappings(source, http.MethodPost, "/admin/mappings/refresh")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		mapping.Delta
+		TotalWebhooks int `json:"total_webhooks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]string{"wh-3": "client-a"}, body.Added)
+	assert.Equal(t, map[string]string{"wh-2": "client-a"}, body.Removed)
+	assert.Equal(t, map[string]string{"wh-1": "client-b"}, body.Moved)
+	assert.Equal(t, 2, body.TotalWebhooks)
+}
+
+func TestMappingsRefreshFailure(t *testing.T) {
+	source := &stubMappingSource{
+		current: &mapping.WebhookMapping{WebhookToClient: map[string]string{"wh-1": "client-a"}},
+		err:     errors.New("MailerCloud unreachable"),
+	}
+
+	w := serveMappings(source, http.MethodPost, "/admin/mappings/refresh")
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.JSONEq(t, `{
+		"error": "Failed to refresh webhook mappings",
+		"added": {}, "removed": {}, "moved": {},
+		"total_webhooks": 1,
+		"last_updated": "0001-01-01T00:00:00Z"
+	}`, w.Body.String())
+}
+
+func TestMappingsOverride(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		overrideErr   error
+		wantCode      int
+		wantBody      string
+		wantError     apierror.Code
+		wantOverrides map[string]string
+	}{
+		{
+			name:          "remaps a webhook",
+			body:          `{"webhook_id": "wh-1", "client_id": "client-b"}`,
+			wantCode:      http.StatusOK,
+			wantBody:      `{"webhook_id": "wh-1", "client_id": "client-b", "previous_client_id": "client-a"}`,
+			wantOverrides: map[string]string{"wh-1": "client-b"},
+		},
+		{
+			name:          "maps an unknown webhook",
+			body:          `{"webhook_id": "wh-9", "client_id": "client-b"}`,
+			wantCode:      http.StatusOK,
+			wantBody:      `{"webhook_id": "wh-9", "client_id": "client-b", "previous_client_id": ""}`,
+			wantOverrides: map[string]string{"wh-9": "client-b"},
+		},
+		{name: "missing client", body: `{"webhook_id": "wh-1"}`, wantCode: http.StatusBadRequest, wantError: apierror.CodeInvalidRequest},
+		{name: "unknown client", body: `{"webhook_id": "wh-1", "client_id": "client-z"}`, wantCode: http.StatusBadRequest, wantError: apierror.CodeInvalidRequest},
+		{name: "invalid body", body: `wh-1`, wantCode: http.StatusBadRequest, wantError: apierror.CodeInvalidRequest},
+		{name: "no override store", body: `{"webhook_id": "wh-1", "client_id": "client-b"}`, overrideErr: mapping.ErrNoOverrideStore, wantCode: http.StatusServiceUnavailable, wantError: apierror.CodeInternal},
+		{name: "save failure", body: `{"webhook_id": "wh-1", "client_id": "client-b"}`, overrideErr: errors.New("mongo unavailable"), wantCode: http.StatusInternalServerError, wantError: apierror.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &stubMappingSource{
+				current: &mapping.WebhookMapping{
+					WebhookToClient: map[string]string{"wh-1": "client-a"},
+					ClientToAPIKey:  map[string]string{"client-a": "key-a", "client-b": "key-b"},
+				},
+				overrideErr: tt.overrideErr,
+			}
+
+			w := serveMappingsBody(source, http.MethodPut, "/admin/mapping/override", tt.body)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+			if tt.wantError != "" {
+				assert.Equal(t, tt.wantError, errorCode(t, w))
+			}
+			assert.Equal(t, tt.wantOverrides, source.overrides)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/stats"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Option configures optional dependencies shared by the webhook handlers.
+type Option func(*handlerOptions)
+
+type handlerOptions struct {
+	throughput  *stats.ThroughputCounter
+	reconciler  *stats.Reconciler
+	async       queue.Publisher
+	retry       queue.Publisher
+	quota       StorageQuota
+	quotaPolicy string
+}
+
+// StorageQuota counts events against each client's daily storage quota.
+type StorageQuota interface {
+	// ChargeStorage counts an event about to be stored for the client,
+	// reporting false, without counting it, once the quota is used up.
+	ChargeStorage(clientID string) bool
+}
+
+func newHandlerOptions(opts []Option) handlerOptions {
+	var o handlerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithThroughputCounter records every accepted event in counter.
+func WithThroughputCounter(counter *stats.ThroughputCounter) Option {
+	return func(o *handlerOptions) {
+		o.throughput = counter
+	}
+}
+
+// WithReconciler counts every published event for reconciliation against
+// storage.
+func WithReconciler(r *stats.Reconciler) Option {
+	return func(o *handlerOptions) {
+		o.reconciler = r
+	}
+}
+
+// WithAsyncPublisher publishes webhooks through p, which buffers them and
+// publishes in the background, and answers 202 Accepted instead of 200.
+func WithAsyncPublisher(p queue.Publisher) Option {
+	return func(o *handlerOptions) {
+		o.async = p
+	}
+}
+
+// WithRetryBuffer publishes webhooks synchronously through p, which holds
+// events that fail to publish in memory and retries them in the background.
+// It has no effect in async mode.
+func WithRetryBuffer(p queue.Publisher) Option {
+	return func(o *handlerOptions) {
+		o.retry = p
+	}
+}
+
+// WithStorageQuota stops events past a client's daily storage quota from
+// being published. policy is config.OverQuotaDrop to answer them with 200,
+// so MailerCloud doesn't retry them, or config.OverQuotaReject to answer
+// 429; empty means drop.
+func WithStorageQuota(quota StorageQuota, policy string) Option {
+	return func(o *handlerOptions) {
+		o.quota = quota
+		o.quotaPolicy = policy
+	}
+}
+
+// publish sends event through the async publisher if configured, otherwise
+// synchronously through the retry buffer or publisher under ctx, normally
+// the request's, so a client disconnect cancels it.
+func (o *handlerOptions) publish(ctx context.Context, publisher queue.Publisher, event models.WebhookEvent) error {
+	if o.async != nil {
+		return o.async.Publish(event)
+	}
+	if o.retry != nil {
+		return queue.PublishContext(ctx, o.retry, event)
+	}
+	return queue.PublishContext(ctx, publisher, event)
+}
+
+// acceptedStatus is the response code for an accepted event: 202 when it is
+// only buffered for publishing, 200 once it has been published.
+func (o *handlerOptions) acceptedStatus() int {
+	if o.async != nil {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
+
+// publishFailedStatus maps a publish error to a response code. A full async
+// or retry buffer is a temporary overload the sender should retry.
+func publishFailedStatus(err error) int {
+	if errors.Is(err, queue.ErrPublishBufferFull) || errors.Is(err, queue.ErrNotConnected) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// recordAccepted updates the in-memory counters for an accepted event.
+func (o *handlerOptions) recordAccepted(event *models.WebhookEvent) {
+	if o.throughput != nil {
+		o.throughput.Record(event.ClientID)
+	}
+	if o.reconciler != nil {
+		o.reconciler.RecordPublished(event.ReceivedAt)
+	}
+}
+
+// overQuota charges event against its client's storage quota, reporting
+// true, and counting the event in the quota metric, if it is over.
+func (o *handlerOptions) overQuota(event *models.WebhookEvent) bool {
+	if o.quota == nil || o.quota.ChargeStorage(event.ClientID) {
+		return false
+	}
+	metrics.StorageQuotaExceeded.WithLabelValues(event.ClientID, o.overQuotaAction()).Inc()
+	return true
+}
+
+// overQuotaAction is the policy for events over quota, defaulting to drop.
+func (o *handlerOptions) overQuotaAction() string {
+	if o.quotaPolicy == "" {
+		return config.OverQuotaDrop
+	}
+	return o.quotaPolicy
+}
+
+// respondOverQuota answers a webhook whose event is over its client's
+// storage quota, as the over-quota policy says.
+func (o *handlerOptions) respondOverQuota(c *gin.Context, event *models.WebhookEvent) {
+	if o.overQuotaAction() == config.OverQuotaReject {
+		apierror.Respond(c, http.StatusTooManyRequests, apierror.CodeQuotaExceeded, "Daily storage quota exceeded")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Event not stored: daily storage quota exceeded",
+		"status":     "quota_exceeded",
+		"webhook_id": event.WebhookID,
+		"client_id":  event.ClientID,
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"webhook-processor/internal/models"
+)
+
+// numericFields are payload fields the parser reads as JSON numbers.
+var numericFields = map[string]bool{"ts": true, "ts_event": true}
+
+// applyQueryFields fills payload fields missing from data with the query
+// parameters mapped to them in fields (parameter -> field). Numeric fields
+// are converted so the parser treats them like values from a JSON body.
+func applyQueryFields(data map[string]interface{}, query url.Values, fields map[string]string) {
+	for param, field := range fields {
+		if _, ok := data[field]; ok {
+			continue
+		}
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		if numericFields[field] {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			data[field] = n
+			continue
+		}
+		data[field] = value
+	}
+}
+
+// flagTimestampSkew marks events whose client-supplied ts diverges from the
+// server receive time by more than maxSkew, since client clocks can be wrong
+// or spoofed. A zero maxSkew or missing ts disables the check.
+func flagTimestampSkew(event *models.WebhookEvent, receivedAt time.Time, maxSkew time.Duration) {
+	if maxSkew <= 0 || event.Timestamp == 0 {
+		return
+	}
+
+	skew := receivedAt.Sub(time.Unix(event.Timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		event.TimestampSkewed = true
+		event.TimestampSkewSeconds = int64(skew.Seconds())
+	}
+}
+
+// isTooOld reports whether the event's client ts is older than maxAge. Events
+// without a ts are never considered too old. A zero maxAge disables the check.
+func isTooOld(event *models.WebhookEvent, now time.Time, maxAge time.Duration) bool {
+	if maxAge <= 0 || event.Timestamp == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(event.Timestamp, 0)) > maxAge
+}
+
+// missingEmail reports whether event is of one of the required types but has
+// neither an email nor any valid emails. Campaign-level events never have an
+// email, so they always pass.
+func missingEmail(event *models.WebhookEvent, required []string) bool {
+	if event.Email != "" || len(event.Emails) > 0 || event.Level() == models.EventLevelCampaign {
+		return false
+	}
+	for _, eventType := range required {
+		if strings.EqualFold(eventType, event.Event) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestFlagTimestampSkew(t *testing.T) {
+	receivedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		ts          int64
+		maxSkew     time.Duration
+		wantSkewed  bool
+		wantSeconds int64
+	}{
+		{name: "within threshold", ts: receivedAt.Add(-30 * time.Second).Unix(), maxSkew: time.Minute},
+		{name: "too far in the past", ts: receivedAt.Add(-2 * time.Hour).Unix(), maxSkew: time.Minute, wantSkewed: true, wantSeconds: 7200},
+		{name: "too far in the future", ts: receivedAt.Add(10 * time.Minute).Unix(), maxSkew: time.Minute, wantSkewed: true, wantSeconds: 600},
+		{name: "check disabled", ts: receivedAt.Add(-2 * time.Hour).Unix(), maxSkew: 0},
+		{name: "missing ts", ts: 0, maxSkew: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &models.WebhookEvent{Timestamp: tt.ts}
+			flagTimestampSkew(event, receivedAt, tt.maxSkew)
+
+			assert.Equal(t, tt.wantSkewed, event.TimestampSkewed)
+			assert.Equal(t, tt.wantSeconds, event.TimestampSkewSeconds)
+		})
+	}
+}
+
+func TestBuildEventFlagsSkewAgainstReceiveTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), new(MockPublisher), nil, &stubLimiter{allow: true}, config.WebhookConfig{MaxTimestampSkew: 5 * time.Minute})
+	handler.clock = clock.NewMock(now)
+
+	event := handler.buildEvent(nil, "client-a", map[string]interface{}{
+		"event": "opened",
+		"ts":    float64(now.Add(-time.Hour).Unix()),
+	})
+
+	assert.True(t, event.TimestampSkewed)
+	assert.Equal(t, int64(3600), event.TimestampSkewSeconds)
+	assert.Equal(t, now, event.ReceivedAt)
+}
+
+func TestApplyQueryFields(t *testing.T) {
+	fields := map[string]string{"camp": "campaign_id", "ts": "ts", "client": "client_id"}
+	data := map[string]interface{}{"event": "opened", "client_id": "from-body"}
+
+	applyQueryFields(data, url.Values{
+		"camp":   {"c-1"},
+		"ts":     {"1717200000"},
+		"client": {"from-query"},
+		"other":  {"ignored"},
+	}, fields)
+
+	assert.Equal(t, map[string]interface{}{
+		"event":       "opened",
+		"campaign_id": "c-1",
+		"ts":          float64(1717200000),
+		"client_id":   "from-body",
+	}, data, "query parameters only fill fields missing from the body")
+}
+
+func TestHandleWebhookQueryFieldFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool {
+		return e.CampaignID == "c-1" && e.TagName == "body-tag"
+	})).Return(nil)
+	cfg := config.WebhookConfig{QueryFields: map[string]string{"camp": "campaign_id", "tag": "tag_name"}}
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook?camp=c-1&tag=query-tag", bytes.NewBufferString(`{"event":"opened","tag_name":"body-tag"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "test-webhook")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler.HandleWebhook(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	pub.AssertExpectations(t)
+}
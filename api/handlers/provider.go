@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider"
+	"webhook-processor/internal/queue"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ProviderWebhookHandler ingests single-event webhooks from any registered
+// provider. The provider is named by the :provider path parameter, or the
+// provider.Header header on routes without one.
+type ProviderWebhookHandler struct {
+	logger      *zap.Logger
+	publisher   queue.Publisher
+	providers   *provider.Registry
+	rateLimiter Limiter
+	clock       clock.Clock
+	cfg         config.WebhookConfig
+	handlerOptions
+}
+
+func NewProviderWebhookHandler(logger *zap.Logger, publisher queue.Publisher, providers *provider.Registry, limiter Limiter, cfg config.WebhookConfig, opts ...Option) *ProviderWebhookHandler {
+	return &ProviderWebhookHandler{
+		logger:         logger,
+		publisher:      publisher,
+		providers:      providers,
+		rateLimiter:    limiter,
+		clock:          clock.New(),
+		cfg:            cfg,
+		handlerOptions: newHandlerOptions(opts),
+	}
+}
+
+func (h *ProviderWebhookHandler) HandleWebhook(c *gin.Context) {
+	start := time.Now()
+
+	name := c.Param("provider")
+	if name == "" {
+		name = c.GetHeader(provider.Header)
+	}
+	p, err := h.providers.Get(name)
+	if err != nil {
+		apierror.Respond(c, http.StatusNotFound, apierror.CodeNotFound, fmt.Sprintf("Unknown webhook provider %q", name))
+		return
+	}
+
+	if rejectMissingContentType(c, h.cfg.MissingContentType, h.logger) {
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to read request body")
+		return
+	}
+
+	if err := p.ValidateRequest(c.Request.Header, body); err != nil {
+		h.logger.Warn("Rejected webhook request",
+			zap.Error(err),
+			zap.String("provider", p.Name()),
+			zap.String("request_id", apierror.RequestID(c)))
+		apierror.Respond(c, http.StatusUnauthorized, apierror.CodeInvalidRequest, "Invalid webhook request")
+		return
+	}
+
+	// Other providers have no MailerCloud webhook IDs to count
+	clientID := p.Identify(c.Request.Header, body)
+	if allowed, limit := h.rateLimiter.Allow(clientID, ""); !allowed {
+		metrics.RateLimitExceeded.WithLabelValues(clientID, string(limit)).Inc()
+		apierror.Respond(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "Rate limit exceeded")
+		return
+	}
+
+	event, err := p.Parse(c.Request.Header, body)
+	if err != nil {
+		h.logger.Warn("Failed to parse webhook payload",
+			zap.Error(err),
+			zap.String("provider", p.Name()),
+			zap.String("client_id", clientID),
+			zap.String("request_id", apierror.RequestID(c)))
+		if errors.Is(err, provider.ErrInvalidPayload) {
+			apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidJSON, "Invalid payload")
+			return
+		}
+		apierror.Respond(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to process event")
+		return
+	}
+
+	event.ClientID = clientID
+	event.RequestID = apierror.RequestID(c)
+	event.ReceivedAt = h.clock.Now().UTC()
+	event.Status = string(models.EventStatusPending)
+	flagTimestampSkew(&event, event.ReceivedAt, h.cfg.MaxTimestampSkew)
+	if h.cfg.StoreRawPayload {
+		var raw map[string]interface{}
+		if json.Unmarshal(body, &raw) == nil {
+			event.RawPayload = raw
+		}
+	}
+
+	logger := h.logger.With(zap.String("provider", p.Name()), zap.String("request_id", event.RequestID))
+	if !h.admit(c, logger, h.cfg, &event) {
+		return
+	}
+	if !h.publishEvent(c, logger, h.publisher, &event, &stageTimer{}) {
+		return
+	}
+
+	metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, string(event.Type())).Observe(time.Since(start).Seconds())
+
+	c.JSON(h.acceptedStatus(), gin.H{
+		"message":    "Event accepted",
+		"webhook_id": event.WebhookID,
+		"client_id":  event.ClientID,
+		"provider":   p.Name(),
+	})
+}
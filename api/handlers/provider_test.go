@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stubProvider parses {"type": ..., "to": ...} payloads and identifies the
+// client from an X-Account header. Requests must carry X-Signature: signed.
+// A "broken" type fails to parse for a reason other than the payload.
+type stubProvider struct{}
+
+func (stubProvider) Name() string { return "sendgrid" }
+
+func (stubProvider) ValidateRequest(headers http.Header, body []byte) error {
+	if headers.Get("X-Signature") != "signed" {
+		return fmt.Errorf("%w: bad signature", provider.ErrInvalidRequest)
+	}
+	return nil
+}
+
+func (stubProvider) Parse(headers http.Header, body []byte) (models.WebhookEvent, error) {
+	var payload struct {
+		Type string `json:"type"`
+		To   string `json:"to"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return models.WebhookEvent{}, fmt.Errorf("%w: %v", provider.ErrInvalidPayload, err)
+	}
+	if payload.Type == "broken" {
+		return models.WebhookEvent{}, errors.New("provider lookup failed")
+	}
+	return models.WebhookEvent{WebhookID: "sg-1", WebhookType: "sendgrid", Event: payload.Type, Email: payload.To}, nil
+}
+
+func (stubProvider) Identify(headers http.Header, body []byte) string {
+	return headers.Get("X-Account")
+}
+
+func serveProvider(handler *ProviderWebhookHandler, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook", handler.HandleWebhook)
+	r.POST("/webhook/:provider", handler.HandleWebhook)
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestProviderWebhookDispatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+	}{
+		{name: "by path", path: "/webhook/sendgrid", headers: map[string]string{"X-Account": "client-a", "X-Signature": "signed"}},
+		{name: "by header", path: "/webhook", headers: map[string]string{"X-Account": "client-a", "X-Signature": "signed", provider.Header: "SendGrid"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published models.WebhookEvent
+			pub := new(MockPublisher)
+			pub.On("Publish", mock.Anything).Run(func(args mock.Arguments) {
+				published = args.Get(0).(models.WebhookEvent)
+			}).Return(nil)
+			handler := NewProviderWebhookHandler(zap.NewNop(), pub, provider.NewRegistry(stubProvider{}), &stubLimiter{allow: true}, config.WebhookConfig{})
+
+			w := serveProvider(handler, tt.path, tt.headers, `{"type":"delivered","to":"a@example.com"}`)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.JSONEq(t, `{"message":"Event accepted","webhook_id":"sg-1","client_id":"client-a","provider":"sendgrid"}`, w.Body.String())
+			assert.Equal(t, "delivered", published.Event)
+			assert.Equal(t, "a@example.com", published.Email)
+			assert.Equal(t, "client-a", published.ClientID)
+			assert.Equal(t, string(models.EventStatusPending), published.Status)
+			assert.False(t, published.ReceivedAt.IsZero())
+		})
+	}
+}
+
+func TestProviderWebhookRejections(t *testing.T) {
+	pub := new(MockPublisher)
+	handler := NewProviderWebhookHandler(zap.NewNop(), pub, provider.NewRegistry(stubProvider{}), &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	w := serveProvider(handler, "/webhook/mailgun", nil, `{"type":"delivered"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code, "unknown provider")
+	assert.Equal(t, apierror.CodeNotFound, errorCode(t, w))
+
+	w = serveProvider(handler, "/webhook", nil, `{"type":"delivered"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code, "no provider named")
+	assert.Equal(t, apierror.CodeNotFound, errorCode(t, w))
+
+	w = serveProvider(handler, "/webhook/sendgrid", map[string]string{"X-Signature": "forged"}, `{"type":"delivered"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "failed provider validation")
+	assert.Contains(t, w.Body.String(), string(apierror.CodeInvalidRequest))
+
+	w = serveProvider(handler, "/webhook/sendgrid", map[string]string{"X-Signature": "signed"}, `{"type":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid payload")
+
+	w = serveProvider(handler, "/webhook/sendgrid", map[string]string{"X-Signature": "signed"}, `{"type":"broken"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "parse failure")
+	assert.Contains(t, w.Body.String(), string(apierror.CodeInternal))
+
+	limited := NewProviderWebhookHandler(zap.NewNop(), pub, provider.NewRegistry(stubProvider{}), &stubLimiter{allow: false}, config.WebhookConfig{})
+	w = serveProvider(limited, "/webhook/sendgrid", map[string]string{"X-Account": "client-a", "X-Signature": "signed"}, `{"type":"delivered"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestProviderWebhookSharedSteps(t *testing.T) {
+	headers := map[string]string{"X-Account": "client-a", "X-Signature": "signed"}
+
+	pub := new(MockPublisher)
+	cfg := config.WebhookConfig{RequireEmailEvents: []string{"delivered"}}
+	handler := NewProviderWebhookHandler(zap.NewNop(), pub, provider.NewRegistry(stubProvider{}), &stubLimiter{allow: true}, cfg)
+	w := serveProvider(handler, "/webhook/sendgrid", headers, `{"type":"delivered"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "email required")
+	assert.Equal(t, apierror.CodeMissingEmail, errorCode(t, w))
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+
+	pub = new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(errors.New("broker down"))
+	handler = NewProviderWebhookHandler(zap.NewNop(), pub, provider.NewRegistry(stubProvider{}), &stubLimiter{allow: true}, config.WebhookConfig{})
+	w = serveProvider(handler, "/webhook/sendgrid", headers, `{"type":"delivered","to":"a@example.com"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), string(apierror.CodePublishFailed))
+}
@@ -1,84 +1,343 @@
 package handlers
 
 import (
+	"context"
+	"math"
 	"sync"
 	"time"
+
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+)
+
+// Limiter decides whether a client may make another request and reports its
+// daily quota.
+type Limiter interface {
+	// Allow reports whether the client may make another request through
+	// the webhook webhookID, and if not which limit it has exceeded. An
+	// empty webhookID isn't counted against the webhook limit.
+	Allow(clientID, webhookID string) (bool, LimitType)
+	// DailyLimit returns the client's daily event quota; 0 means unlimited.
+	DailyLimit(clientID string) int
+	// DailyUsage returns how many events the client has made today.
+	DailyUsage(clientID string) int
+}
+
+// LimitType names a rate limit; it is the limit_type label of the
+// rate-limit-exceeded metric.
+type LimitType string
+
+const (
+	LimitPerSecond LimitType = "per_second"
+	LimitDaily     LimitType = "daily"
+	LimitWebhooks  LimitType = "webhooks"
 )
 
+var _ Limiter = (*RateLimiter)(nil)
+
+// burstSweepInterval is how often idle per-second buckets are evicted.
+const burstSweepInterval = time.Minute
+
+// StaleClientAge is how long after its daily window started a client's
+// state is dropped by the janitor. The daily count would be reset on the
+// client's next request anyway; its webhooks are counted again as they are
+// used.
+const StaleClientAge = 48 * time.Hour
+
 type RateLimiter struct {
-	mu       sync.RWMutex
-	limits   map[string]*clientLimit
-	freePlan struct {
-		dailyLimit   int
-		webhookLimit int
+	mu     sync.RWMutex
+	clock  clock.Clock
+	limits map[string]*clientLimit
+	// perSecond and burst configure a token bucket per client, separate
+	// from the daily count; zero perSecond disables it.
+	perSecond   float64
+	burst       int
+	buckets     map[string]*tokenBucket
+	lastSweep   time.Time
+	freePlan    Plan
+	premiumPlan Plan
+	premium     map[string]bool
+}
+
+// Plan holds the limits of a pricing plan.
+type Plan struct {
+	// DailyLimit is how many events a client may send a day; 0 means
+	// unlimited.
+	DailyLimit int
+	// WebhookLimit is how many distinct webhooks a client may send
+	// through; 0 means unlimited.
+	WebhookLimit int
+	// DailyStoredLimit is how many events a day are stored for a client;
+	// 0 means unlimited.
+	DailyStoredLimit int
+}
+
+// DefaultFreePlan and DefaultPremiumPlan are the plans used unless
+// WithPlans says otherwise.
+var (
+	DefaultFreePlan    = Plan{DailyLimit: 10000, WebhookLimit: 20}
+	DefaultPremiumPlan = Plan{DailyLimit: 0, WebhookLimit: 50}
+)
+
+type clientLimit struct {
+	dailyCount int
+	// storedCount is how many of today's events were let through to
+	// storage
+	storedCount int
+	lastReset   time.Time
+	// webhooks holds the distinct webhook IDs the client has sent through
+	webhooks map[string]bool
+}
+
+// tokenBucket holds a client's per-second allowance: up to burst tokens,
+// refilled at perSecond.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiterOption configures optional RateLimiter behaviour.
+type RateLimiterOption func(*RateLimiter)
+
+// WithPerSecondLimit limits each client to perSecond requests a second on
+// average, allowing bursts of up to burst requests. A burst below 1 defaults
+// to perSecond rounded up. Zero perSecond disables the limit.
+func WithPerSecondLimit(perSecond float64, burst int) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.perSecond = perSecond
+		rl.burst = burst
+		if rl.burst < 1 {
+			rl.burst = int(math.Max(1, math.Ceil(perSecond)))
+		}
 	}
-	premiumPlan struct {
-		webhookLimit int
+}
+
+// WithPlans replaces the default free and premium plan limits.
+func WithPlans(free, premium Plan) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.freePlan = free
+		rl.premiumPlan = premium
 	}
 }
 
-type clientLimit struct {
-	dailyCount   int
-	lastReset    time.Time
-	webhookCount int
-	isPremium    bool
-}
-
-func NewRateLimiter() *RateLimiter {
-	return &RateLimiter{
-		limits: make(map[string]*clientLimit),
-		freePlan: struct {
-			dailyLimit   int
-			webhookLimit int
-		}{
-			dailyLimit:   10000, // 10k events per day
-			webhookLimit: 20,    // 20 webhooks
-		},
-		premiumPlan: struct {
-			webhookLimit int
-		}{
-			webhookLimit: 50, // 50 webhooks
-		},
+// WithPremiumClients puts the given clients on the premium plan; every
+// other client is on the free plan.
+func WithPremiumClients(clientIDs []string) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		for _, clientID := range clientIDs {
+			rl.premium[clientID] = true
+		}
+	}
+}
+
+func NewRateLimiter(clk clock.Clock, opts ...RateLimiterOption) *RateLimiter {
+	rl := &RateLimiter{
+		clock:       clk,
+		limits:      make(map[string]*clientLimit),
+		buckets:     make(map[string]*tokenBucket),
+		freePlan:    DefaultFreePlan,
+		premiumPlan: DefaultPremiumPlan,
+		premium:     make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(rl)
 	}
+	return rl
 }
 
+// AllowRequest is Allow without a webhook ID or the exceeded limit.
 func (rl *RateLimiter) AllowRequest(clientID string) bool {
+	allowed, _ := rl.Allow(clientID, "")
+	return allowed
+}
+
+// Allow checks the limits of the client's plan and then its per-second
+// rate. A request denied by one isn't counted against the others.
+func (rl *RateLimiter) Allow(clientID, webhookID string) (bool, LimitType) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
+	now := rl.clock.Now().UTC()
+	limit := rl.clientLimit(clientID, now)
+	plan := rl.plan(clientID)
+	newWebhook := webhookID != "" && !limit.webhooks[webhookID]
+	if newWebhook && plan.WebhookLimit > 0 && len(limit.webhooks) >= plan.WebhookLimit {
+		return false, LimitWebhooks
+	}
+	if plan.DailyLimit > 0 && limit.dailyCount >= plan.DailyLimit {
+		return false, LimitDaily
+	}
+
+	if !rl.takeToken(clientID, now) {
+		return false, LimitPerSecond
+	}
+
+	if newWebhook {
+		if limit.webhooks == nil {
+			limit.webhooks = make(map[string]bool)
+		}
+		limit.webhooks[webhookID] = true
+	}
+	limit.dailyCount++
+	return true, ""
+}
+
+// ChargeStorage counts an event about to be stored for the client against
+// its plan's daily stored limit, reporting false, without counting it, once
+// the limit is used up.
+func (rl *RateLimiter) ChargeStorage(clientID string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	limit := rl.clientLimit(clientID, rl.clock.Now().UTC())
+	if quota := rl.plan(clientID).DailyStoredLimit; quota > 0 && limit.storedCount >= quota {
+		return false
+	}
+	limit.storedCount++
+	return true
+}
+
+// clientLimit returns the client's state, starting a new daily window once
+// the last one is a day old. rl.mu must be held.
+func (rl *RateLimiter) clientLimit(clientID string, now time.Time) *clientLimit {
 	limit, exists := rl.limits[clientID]
 	if !exists {
-		limit = &clientLimit{
-			lastReset: time.Now().UTC(),
-		}
+		limit = &clientLimit{lastReset: now}
 		rl.limits[clientID] = limit
+		metrics.RateLimitTrackedClients.WithLabelValues("daily").Set(float64(len(rl.limits)))
 	}
 
-	// Reset daily count if it's a new day
-	now := time.Now().UTC()
+	// Reset daily counts if it's a new day
 	if now.Sub(limit.lastReset) >= 24*time.Hour {
 		limit.dailyCount = 0
+		limit.storedCount = 0
 		limit.lastReset = now
 	}
+	return limit
+}
 
-	// Check limits based on plan
-	if limit.isPremium {
-		if limit.webhookCount >= rl.premiumPlan.webhookLimit {
-			return false
-		}
-		// Premium has unlimited daily events
-		limit.dailyCount++
+// plan returns the client's plan. rl.mu must be held.
+func (rl *RateLimiter) plan(clientID string) Plan {
+	if rl.premium[clientID] {
+		return rl.premiumPlan
+	}
+	return rl.freePlan
+}
+
+// takeToken takes a token from the client's per-second bucket, reporting
+// false if it is empty. rl.mu must be held.
+func (rl *RateLimiter) takeToken(clientID string, now time.Time) bool {
+	if rl.perSecond <= 0 {
 		return true
 	}
+	rl.evictIdleBuckets(now)
 
-	// Free plan limits
-	if limit.webhookCount >= rl.freePlan.webhookLimit {
-		return false
+	b, exists := rl.buckets[clientID]
+	if !exists {
+		b = &tokenBucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[clientID] = b
 	}
-	if limit.dailyCount >= rl.freePlan.dailyLimit {
+	b.tokens = math.Min(float64(rl.burst), b.tokens+now.Sub(b.last).Seconds()*rl.perSecond)
+	b.last = now
+	if b.tokens < 1 {
 		return false
 	}
-
-	limit.dailyCount++
+	b.tokens--
 	return true
 }
+
+// evictIdleBuckets drops, at most once per burstSweepInterval, the buckets
+// of clients idle long enough to have refilled completely. A full bucket is
+// what a new client starts with, so forgetting it changes nothing, and the
+// map only holds recently active clients. rl.mu must be held.
+func (rl *RateLimiter) evictIdleBuckets(now time.Time) {
+	if now.Sub(rl.lastSweep) < burstSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	refill := time.Duration(float64(rl.burst) / rl.perSecond * float64(time.Second))
+	for clientID, b := range rl.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(rl.buckets, clientID)
+		}
+	}
+}
+
+// RunJanitor evicts stale clients every interval until ctx is done, so
+// one-off and spoofed client IDs don't accumulate forever.
+func (rl *RateLimiter) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.EvictStale(StaleClientAge)
+		}
+	}
+}
+
+// EvictStale drops clients whose daily window started more than maxAge
+// ago, along with their per-second buckets, and returns how many were
+// dropped.
+func (rl *RateLimiter) EvictStale(maxAge time.Duration) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock.Now().UTC()
+	evicted := 0
+	for clientID, limit := range rl.limits {
+		if now.Sub(limit.lastReset) > maxAge {
+			delete(rl.limits, clientID)
+			delete(rl.buckets, clientID)
+			evicted++
+		}
+	}
+	metrics.RateLimitTrackedClients.WithLabelValues("daily").Set(float64(len(rl.limits)))
+	return evicted
+}
+
+func (rl *RateLimiter) DailyLimit(clientID string) int {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	return rl.plan(clientID).DailyLimit
+}
+
+func (rl *RateLimiter) DailyUsage(clientID string) int {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	limit, exists := rl.limits[clientID]
+	if !exists || rl.clock.Now().UTC().Sub(limit.lastReset) >= 24*time.Hour {
+		return 0
+	}
+	return limit.dailyCount
+}
+
+// RestoreDailyUsage sets each client's usage, and stored events, for the day
+// that started at since, e.g. from the events stored since midnight, so a
+// restart doesn't hand out fresh daily quotas. Usage already recorded is
+// kept if higher.
+func (rl *RateLimiter) RestoreDailyUsage(usage map[string]int, since time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	since = since.UTC()
+	for clientID, count := range usage {
+		limit, exists := rl.limits[clientID]
+		if !exists {
+			limit = &clientLimit{lastReset: since}
+			rl.limits[clientID] = limit
+		}
+		if count > limit.dailyCount {
+			limit.dailyCount = count
+		}
+		if count > limit.storedCount {
+			limit.storedCount = count
+		}
+	}
+	metrics.RateLimitTrackedClients.WithLabelValues("daily").Set(float64(len(rl.limits)))
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterDailyResetAcrossDayBoundary(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk)
+
+	for i := 0; i < rl.freePlan.DailyLimit; i++ {
+		if !rl.AllowRequest("client-a") {
+			t.Fatalf("request %d unexpectedly denied", i)
+		}
+	}
+	assert.False(t, rl.AllowRequest("client-a"), "daily limit reached")
+
+	clk.Advance(23 * time.Hour)
+	assert.False(t, rl.AllowRequest("client-a"), "limit holds until a full day has passed")
+
+	clk.Advance(time.Hour)
+	assert.True(t, rl.AllowRequest("client-a"), "limit resets after a day")
+}
+
+func TestRateLimiterTracksClientsIndependently(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk)
+
+	for i := 0; i < rl.freePlan.DailyLimit; i++ {
+		rl.AllowRequest("client-a")
+	}
+
+	assert.False(t, rl.AllowRequest("client-a"))
+	assert.True(t, rl.AllowRequest("client-b"))
+}
+
+func TestRateLimiterQuotaAccessors(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk)
+
+	assert.Equal(t, 10000, rl.DailyLimit("client-a"))
+	assert.Zero(t, rl.DailyUsage("client-a"))
+
+	rl.AllowRequest("client-a")
+	rl.AllowRequest("client-a")
+	assert.Equal(t, 2, rl.DailyUsage("client-a"))
+
+	clk.Advance(24 * time.Hour)
+	assert.Zero(t, rl.DailyUsage("client-a"), "usage resets after a day")
+}
+
+func TestRateLimiterRestoreDailyUsage(t *testing.T) {
+	midnight := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(midnight.Add(15 * time.Hour))
+	rl := NewRateLimiter(clk)
+	rl.AllowRequest("client-b")
+
+	rl.RestoreDailyUsage(map[string]int{"client-a": rl.freePlan.DailyLimit - 1, "client-b": 0}, midnight)
+
+	assert.Equal(t, rl.freePlan.DailyLimit-1, rl.DailyUsage("client-a"))
+	assert.Equal(t, 1, rl.DailyUsage("client-b"), "higher usage already recorded is kept")
+	assert.True(t, rl.AllowRequest("client-a"))
+	assert.False(t, rl.AllowRequest("client-a"), "the restored usage counts against today's quota")
+
+	clk.Advance(9 * time.Hour)
+	assert.True(t, rl.AllowRequest("client-a"), "the quota resets a day after the restored day began")
+}
+
+func TestRateLimiterPerSecondBurstAndRefill(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk, WithPerSecondLimit(2, 4))
+
+	for i := 0; i < 4; i++ {
+		allowed, _ := rl.Allow("client-a", "")
+		if !allowed {
+			t.Fatalf("request %d within the burst unexpectedly denied", i)
+		}
+	}
+	allowed, limit := rl.Allow("client-a", "")
+	assert.False(t, allowed, "burst exhausted")
+	assert.Equal(t, LimitPerSecond, limit)
+	assert.Equal(t, 4, rl.DailyUsage("client-a"), "denied requests aren't counted against the daily quota")
+
+	allowed, _ = rl.Allow("client-b", "")
+	assert.True(t, allowed, "other clients have their own bucket")
+
+	clk.Advance(500 * time.Millisecond)
+	allowed, _ = rl.Allow("client-a", "")
+	assert.True(t, allowed, "one token refilled after half a second")
+	allowed, _ = rl.Allow("client-a", "")
+	assert.False(t, allowed)
+}
+
+func TestRateLimiterLimitTypes(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk, WithPerSecondLimit(1, 1))
+	rl.RestoreDailyUsage(map[string]int{"client-a": rl.freePlan.DailyLimit}, clk.Now().UTC())
+
+	allowed, limit := rl.Allow("client-a", "")
+	assert.False(t, allowed)
+	assert.Equal(t, LimitDaily, limit)
+
+	allowed, _ = rl.Allow("client-b", "")
+	assert.True(t, allowed)
+	allowed, limit = rl.Allow("client-b", "")
+	assert.False(t, allowed)
+	assert.Equal(t, LimitPerSecond, limit)
+}
+
+func TestRateLimiterPerSecondDisabledByDefault(t *testing.T) {
+	rl := NewRateLimiter(clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)))
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, rl.AllowRequest("client-a"))
+	}
+	assert.Empty(t, rl.buckets)
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk, WithPerSecondLimit(1, 5))
+
+	rl.Allow("client-a", "")
+	rl.Allow("client-b", "")
+	assert.Len(t, rl.buckets, 2)
+
+	clk.Advance(30 * time.Second)
+	rl.Allow("client-b", "")
+	clk.Advance(burstSweepInterval)
+	rl.Allow("client-c", "")
+
+	assert.Len(t, rl.buckets, 1, "idle buckets are evicted")
+	assert.Contains(t, rl.buckets, "client-c")
+}
+
+func TestRateLimiterConcurrentAccess(t *testing.T) {
+	rl := NewRateLimiter(clock.New(), WithPerSecondLimit(1000, 1000))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clientID := fmt.Sprintf("client-%d", i%4)
+			for j := 0; j < 100; j++ {
+				rl.Allow(clientID, "")
+				rl.DailyUsage(clientID)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < 4; i++ {
+		total += rl.DailyUsage(fmt.Sprintf("client-%d", i))
+	}
+	assert.Equal(t, 800, total)
+}
+
+func TestRateLimiterEvictStale(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk, WithPerSecondLimit(1, 1))
+
+	rl.Allow("one-off", "")
+	rl.RestoreDailyUsage(map[string]int{"spoofed": 3}, clk.Now().Add(-72*time.Hour))
+
+	clk.Advance(47 * time.Hour)
+	rl.Allow("active", "")
+
+	assert.Equal(t, 1, rl.EvictStale(StaleClientAge))
+	assert.NotContains(t, rl.limits, "spoofed")
+	assert.Contains(t, rl.limits, "one-off")
+
+	clk.Advance(2 * time.Hour)
+	assert.Equal(t, 1, rl.EvictStale(StaleClientAge))
+	assert.NotContains(t, rl.limits, "one-off")
+	assert.NotContains(t, rl.buckets, "one-off")
+	assert.Contains(t, rl.limits, "active")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.RateLimitTrackedClients.WithLabelValues("daily")))
+}
+
+func TestRateLimiterPlans(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk,
+		WithPlans(Plan{DailyLimit: 5, WebhookLimit: 2}, Plan{WebhookLimit: 3}),
+		WithPremiumClients([]string{"premium"}))
+
+	assert.Equal(t, 5, rl.DailyLimit("free"))
+	assert.Zero(t, rl.DailyLimit("premium"), "premium has no daily cap")
+
+	t.Run("premium client exceeding the webhook limit", func(t *testing.T) {
+		for _, webhookID := range []string{"wh-1", "wh-2", "wh-3"} {
+			allowed, _ := rl.Allow("premium", webhookID)
+			assert.True(t, allowed, webhookID)
+		}
+		allowed, limit := rl.Allow("premium", "wh-4")
+		assert.False(t, allowed)
+		assert.Equal(t, LimitWebhooks, limit)
+
+		allowed, _ = rl.Allow("premium", "wh-1")
+		assert.True(t, allowed, "webhooks already in use stay allowed")
+	})
+
+	t.Run("free client hitting the daily cap", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			allowed, _ := rl.Allow("free", "wh-1")
+			require.True(t, allowed, "request %d", i)
+		}
+		allowed, limit := rl.Allow("free", "wh-1")
+		assert.False(t, allowed)
+		assert.Equal(t, LimitDaily, limit)
+	})
+
+	t.Run("premium client past the free daily cap", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			allowed, _ := rl.Allow("premium", "wh-2")
+			require.True(t, allowed, "request %d", i)
+		}
+	})
+}
+
+func TestRateLimiterChargeStorage(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl := NewRateLimiter(clk,
+		WithPlans(Plan{DailyStoredLimit: 2}, Plan{}),
+		WithPremiumClients([]string{"premium"}))
+
+	assert.True(t, rl.ChargeStorage("free"))
+	assert.True(t, rl.ChargeStorage("free"))
+	assert.False(t, rl.ChargeStorage("free"), "free plan stores two events a day")
+	for i := 0; i < 5; i++ {
+		assert.True(t, rl.ChargeStorage("premium"), "premium plan has no storage quota")
+	}
+
+	clk.Advance(24 * time.Hour)
+	assert.True(t, rl.ChargeStorage("free"), "quota resets the next day")
+
+	restored := NewRateLimiter(clk, WithPlans(Plan{DailyStoredLimit: 2}, Plan{}))
+	restored.RestoreDailyUsage(map[string]int{"free": 2}, clk.Now())
+	assert.False(t, restored.ChargeStorage("free"), "events stored before a restart count")
+}
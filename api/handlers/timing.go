@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"time"
+
+	"webhook-processor/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Request stages timed by stageTimer. auth covers resolving the client from
+// the request; API key checks happen in the router before the handler runs.
+const (
+	stageParse     = "parse"
+	stageAuth      = "auth"
+	stageRateLimit = "ratelimit"
+	stagePublish   = "publish"
+)
+
+// stageTimer records how long each stage of a request takes, both in the
+// WebhookStageDuration histogram and as fields for the request's log line.
+type stageTimer struct {
+	fields []zap.Field
+}
+
+// start begins timing stage; call the returned func when it ends.
+func (t *stageTimer) start(stage string) func() {
+	begin := time.Now()
+	return func() {
+		elapsed := time.Since(begin)
+		metrics.WebhookStageDuration.WithLabelValues(stage).Observe(elapsed.Seconds())
+		t.fields = append(t.fields, zap.Duration(stage+"_duration", elapsed))
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"webhook-processor/config"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func stageSamples(t *testing.T, stage string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, metrics.WebhookStageDuration.WithLabelValues(stage).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestHandleWebhookRecordsStageTimings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stages := []string{stageParse, stageAuth, stageRateLimit, stagePublish}
+	before := make(map[string]uint64)
+	for _, stage := range stages {
+		before[stage] = stageSamples(t, stage)
+	}
+
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	core, logs := observer.New(zap.InfoLevel)
+	handler := NewMailerCloudWebhookHandler(zap.New(core), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{"event":"opened","email":"a@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "test-webhook")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler.HandleWebhook(c)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, stage := range stages {
+		assert.Equal(t, before[stage]+1, stageSamples(t, stage), "stage %s observed once", stage)
+	}
+
+	entries := logs.FilterMessage("Recorded processing time metric").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	for _, stage := range stages {
+		assert.Contains(t, fields, stage+"_duration")
+	}
+}
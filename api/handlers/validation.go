@@ -0,0 +1,19 @@
+package handlers
+
+import "regexp"
+
+// DefaultValidationUserAgent matches MailerCloud's validation requests,
+// whether the agent is the bare "MailerCloud" or versioned, e.g.
+// "MailerCloud/2.0".
+const DefaultValidationUserAgent = `^MailerCloud(/|$)`
+
+// ValidationUserAgent compiles the pattern identifying MailerCloud
+// validation requests by User-Agent, falling back to
+// DefaultValidationUserAgent when it is empty. The pattern is expected to
+// have been validated by config.Load.
+func ValidationUserAgent(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		pattern = DefaultValidationUserAgent
+	}
+	return regexp.MustCompile(pattern)
+}
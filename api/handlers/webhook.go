@@ -1,13 +1,17 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
 	"webhook-processor/internal/mapping"
 	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider/mailercloud"
 	"webhook-processor/internal/queue"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/metrics"
 
 	"github.com/gin-gonic/gin"
@@ -15,18 +19,26 @@ import (
 )
 
 type MailerCloudWebhookHandler struct {
-	logger        *zap.Logger
-	publisher     queue.Publisher
-	rateLimiter   *RateLimiter
-	webhookMapper *mapping.WebhookMappingService
+	logger       *zap.Logger
+	publisher    queue.Publisher
+	rateLimiter  Limiter
+	clock        clock.Clock
+	provider     *mailercloud.Provider
+	cfg          config.WebhookConfig
+	validationUA *regexp.Regexp
+	handlerOptions
 }
 
-func NewMailerCloudWebhookHandler(logger *zap.Logger, publisher queue.Publisher, webhookMapper *mapping.WebhookMappingService) *MailerCloudWebhookHandler {
+func NewMailerCloudWebhookHandler(logger *zap.Logger, publisher queue.Publisher, webhookMapper *mapping.WebhookMappingService, limiter Limiter, cfg config.WebhookConfig, opts ...Option) *MailerCloudWebhookHandler {
 	return &MailerCloudWebhookHandler{
-		logger:        logger,
-		publisher:     publisher,
-		rateLimiter:   NewRateLimiter(),
-		webhookMapper: webhookMapper,
+		logger:         logger,
+		publisher:      publisher,
+		rateLimiter:    limiter,
+		clock:          clock.New(),
+		provider:       mailercloud.New(logger, webhookMapper, mailercloud.WithDeliveryIDHeader(cfg.IdempotencyHeader)),
+		cfg:            cfg,
+		validationUA:   ValidationUserAgent(cfg.ValidationUserAgent),
+		handlerOptions: newHandlerOptions(opts),
 	}
 }
 
@@ -34,10 +46,12 @@ func (h *MailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 	// Start timing for metrics
 	start := time.Now()
 	var clientID string
+	var stages stageTimer
+	logger := h.logger.With(zap.String("request_id", apierror.RequestID(c)))
 
 	// Handle GET requests for URL validation
 	if c.Request.Method == "GET" {
-		h.logger.Info("Handling GET request for webhook validation")
+		logger.Info("Handling GET request for webhook validation")
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "ok",
 			"message": "Webhook endpoint is valid",
@@ -47,20 +61,39 @@ func (h *MailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
+	if rejectMissingContentType(c, h.cfg.MissingContentType, logger) {
+		return
+	}
+
 	// For MailerCloud webhooks, parse the request body
-	var data map[string]interface{}
-	if err := c.ShouldBindJSON(&data); err != nil {
-		h.logger.Error("Failed to parse webhook payload",
+	var payload interface{}
+	endParse := stages.start(stageParse)
+	err := c.ShouldBindJSON(&payload)
+	endParse()
+	if err != nil {
+		logger.Error("Failed to parse webhook payload",
 			zap.Error(err),
 			zap.String("content_type", c.GetHeader("Content-Type")),
 			zap.String("user_agent", c.GetHeader("User-Agent")),
 		)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidJSON, "Invalid JSON payload")
+		return
+	}
+
+	// Array payloads are ingested as a batch with a per-item result
+	if items, ok := payload.([]interface{}); ok {
+		h.handleBatch(c, items)
+		return
+	}
+
+	data, ok := payload.(map[string]interface{})
+	if !ok {
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidJSON, "Invalid JSON payload")
 		return
 	}
 
 	// Log request details for debugging
-	h.logger.Info("Received webhook request",
+	logger.Info("Received webhook request",
 		zap.String("method", c.Request.Method),
 		zap.String("content-type", c.GetHeader("Content-Type")),
 		zap.String("user-agent", c.GetHeader("User-Agent")),
@@ -74,12 +107,12 @@ func (h *MailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 	webhookId := c.GetHeader("Webhook-Id")
 
 	// Validation scenarios:
-	// 1. User-Agent is "MailerCloud" (classic test requests)
+	// 1. User-Agent matches the validation pattern, e.g. "MailerCloud/2.0"
 	// 2. Webhook-Id is "WebhookID" (URL validation)
 	// 3. Empty or test payload
 	isValidationRequest := false
 
-	if userAgent == "MailerCloud" || webhookId == "WebhookID" {
+	if h.validationUA.MatchString(userAgent) || webhookId == "WebhookID" {
 		isValidationRequest = true
 	}
 
@@ -96,7 +129,7 @@ func (h *MailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 	}
 
 	if isValidationRequest {
-		h.logger.Info("Handling MailerCloud validation/test request",
+		logger.Info("Handling MailerCloud validation/test request",
 			zap.String("user_agent", userAgent),
 			zap.String("webhook_id", webhookId),
 			zap.Any("payload", data))
@@ -110,193 +143,87 @@ func (h *MailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
+	applyQueryFields(data, c.Request.URL.Query(), h.cfg.QueryFields)
+
 	// Extract client ID using the webhook mapping service
+	endAuth := stages.start(stageAuth)
 	clientID = h.extractClientID(c, data)
+	endAuth()
 
 	// Check rate limits for the identified client
-	if !h.rateLimiter.AllowRequest(clientID) {
-		metrics.RateLimitExceeded.WithLabelValues(clientID, "requests").Inc()
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+	endRateLimit := stages.start(stageRateLimit)
+	allowed, limit := h.rateLimiter.Allow(clientID, webhookId)
+	endRateLimit()
+	if !allowed {
+		metrics.RateLimitExceeded.WithLabelValues(clientID, string(limit)).Inc()
+		apierror.Respond(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "Rate limit exceeded")
 		return
 	}
 
 	// Create webhook event from request body
-	event := models.WebhookEvent{
-		WebhookID:   h.generateWebhookID(data),
-		WebhookType: "email_event",
-		ClientID:    clientID,
-		ReceivedAt:  time.Now().UTC(),
-		Status:      string(models.EventStatusPending),
-	}
-
-	// Extract fields from the payload with type assertions and error handling
-	if val, ok := data["event"].(string); ok {
-		event.Event = val
-	}
-
-	// Campaign name variations
-	if val, ok := data["campaign_name"].(string); ok {
-		event.CampaignName = val
-	} else if val, ok := data["campaign name"].(string); ok {
-		event.CampaignName = val
-	}
-
-	// Campaign ID variations
-	if val, ok := data["campaign_id"].(string); ok {
-		event.CampaignID = val
-	} else if val, ok := data["camp_id"].(string); ok {
-		event.CampaignID = val
-	}
-
-	// Tag name variations
-	if val, ok := data["tag_name"].(string); ok {
-		event.TagName = val
-	} else if val, ok := data["tag"].(string); ok {
-		event.TagName = val
-	}
-
-	if val, ok := data["date_event"].(string); ok {
-		event.DateEvent = val
-	}
-	if val, ok := data["ts"].(float64); ok {
-		event.Timestamp = int64(val)
-	}
-	if val, ok := data["ts_event"].(float64); ok {
-		event.TimestampEvent = int64(val)
-	}
-	if val, ok := data["email"].(string); ok {
-		event.Email = val
-	}
-
-	// URL field variations (for click events)
-	if val, ok := data["URL"].(string); ok {
-		event.URL = val
-	} else if val, ok := data["url"].(string); ok {
-		event.URL = val
-	} else if val, ok := data["click_url"].(string); ok {
-		event.URL = val
-	}
-
-	// Reason field (for bounce, spam, campaign_error events)
-	if val, ok := data["reason"].(string); ok {
-		event.Reason = val
-	}
-
-	// Ensure variables are set for metrics (after all parsing)
-	clientID = event.ClientID
-
-	// Handle list_id which can be string, number, or array (for unsubscribe events)
-	if val, exists := data["list_id"]; exists {
-		event.ListID = val
-	}
-
-	// Handle emails array
-	if val, ok := data["emails"].([]interface{}); ok {
-		emails := make([]string, 0, len(val))
-		for _, email := range val {
-			if emailStr, ok := email.(string); ok {
-				emails = append(emails, emailStr)
-			}
-		}
-		event.Emails = emails
-	}
-
-	// Record the received event metric
-	metrics.WebhookReceived.WithLabelValues(event.ClientID, event.Event).Inc()
-
-	// Send the event to the message queue
-	if err := h.publisher.Publish(event); err != nil {
-		metrics.WebhookProcessed.WithLabelValues(event.ClientID, event.Event, "failed").Inc()
+	event := h.buildEvent(c.Request.Header, clientID, data)
+	event.RequestID = apierror.RequestID(c)
 
-		// Record processing time metric for failed requests too
-		if event.ClientID != "" && event.Event != "" {
-			duration := time.Since(start).Seconds()
-			metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, event.Event).Observe(duration)
-		}
-
-		h.logger.Error("Failed to publish event",
-			zap.Error(err),
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+	if !h.admit(c, logger, h.cfg, &event) {
 		return
 	}
+	published := h.publishEvent(c, logger, h.publisher, &event, &stages)
 
-	metrics.WebhookProcessed.WithLabelValues(event.ClientID, event.Event, "success").Inc()
-
-	// Record processing time metric
+	// Record processing time metric, for failed requests too
 	if event.ClientID != "" && event.Event != "" {
 		duration := time.Since(start).Seconds()
-		metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, event.Event).Observe(duration)
-		h.logger.Info("Recorded processing time metric",
-			zap.String("client_id", event.ClientID),
-			zap.String("event", event.Event),
-			zap.Float64("duration_seconds", duration))
+		metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, string(event.Type())).Observe(duration)
+		if published {
+			logger.Info("Recorded processing time metric",
+				append(stages.fields,
+					zap.String("client_id", event.ClientID),
+					zap.String("event", event.Event),
+					zap.Float64("duration_seconds", duration))...)
+		}
+	}
+	if !published {
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(h.acceptedStatus(), gin.H{
 		"message":    "Event accepted",
 		"webhook_id": event.WebhookID,
 		"client_id":  event.ClientID,
 	})
 }
 
+// buildEvent creates a pending webhook event from a single payload object.
+// headers are those of a webhook posted on its own, and nil for a batch
+// item, which can't be told apart from the rest of its batch by them.
+func (h *MailerCloudWebhookHandler) buildEvent(headers http.Header, clientID string, data map[string]interface{}) models.WebhookEvent {
+	now := h.clock.Now()
+	event := h.provider.DeliveryEvent(headers, clientID, data)
+	event.ClientID = clientID
+	event.ReceivedAt = now.UTC()
+	event.Status = string(models.EventStatusPending)
+
+	flagTimestampSkew(&event, event.ReceivedAt, h.cfg.MaxTimestampSkew)
+	if h.cfg.StoreRawPayload {
+		event.RawPayload = data
+	}
+	return event
+}
+
 // extractClientID identifies the client using webhook ID mapping
 func (h *MailerCloudWebhookHandler) extractClientID(c *gin.Context, data map[string]interface{}) string {
-	// Primary Strategy: Use Webhook-Id header to lookup client via mapping service
-	webhookID := c.GetHeader("Webhook-Id")
-	if webhookID != "" && h.webhookMapper != nil {
-		h.logger.Info("Attempting to lookup client via webhook ID", zap.String("webhook_id", webhookID))
-
-		if clientID, found := h.webhookMapper.GetClientForWebhook(webhookID); found {
-			h.logger.Info("Successfully mapped webhook ID to client",
-				zap.String("webhook_id", webhookID),
-				zap.String("client_id", clientID))
-			return clientID
-		}
-
-		h.logger.Warn("Webhook ID not found in mapping, falling back to webhook ID",
-			zap.String("webhook_id", webhookID))
-	}
-
-	// Fallback: Use webhook ID as client identifier if available
-	if webhookID != "" {
-		return webhookID
-	}
-
-	// Final fallback: Unknown client
-	return "unknown"
+	return h.provider.Identify(c.Request.Header, nil)
 }
 
-// generateWebhookID creates a unique ID for the webhook event
-func (h *MailerCloudWebhookHandler) generateWebhookID(data map[string]interface{}) string {
-	// Strategy 1: Use existing webhook/message ID if available
-	idFields := []string{"webhook_id", "message_id", "event_id", "delivery_id", "tracking_id"}
-	for _, field := range idFields {
-		if val, ok := data[field].(string); ok && val != "" {
-			return val
-		}
-	}
-
-	// Strategy 2: Generate based on combination of fields for uniqueness
-	var components []string
-
-	if val, ok := data["campaign_id"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-	if val, ok := data["email"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-	if val, ok := data["ts"].(float64); ok {
-		components = append(components, fmt.Sprintf("%.0f", val))
-	}
-	if val, ok := data["event"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-
-	if len(components) > 0 {
-		return fmt.Sprintf("mc_%x", components)
-	}
-
-	// Strategy 3: Fallback to timestamp-based ID
-	return fmt.Sprintf("mc_%d", time.Now().UnixNano())
+// rejectMissingContentType answers 415 if the request has no Content-Type
+// and policy is config.MissingContentTypeReject. Otherwise such a body is
+// parsed as JSON, as is any other content type.
+func rejectMissingContentType(c *gin.Context, policy string, logger *zap.Logger) bool {
+	if c.GetHeader("Content-Type") != "" || policy != config.MissingContentTypeReject {
+		return false
+	}
+	logger.Warn("Rejecting webhook without Content-Type",
+		zap.String("ip", c.ClientIP()),
+		zap.String("user_agent", c.GetHeader("User-Agent")))
+	apierror.Respond(c, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, "Content-Type must be application/json")
+	return true
 }
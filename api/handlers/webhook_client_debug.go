@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"strings"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/mapping"
+	"webhook-processor/internal/queue"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ClientDebugWebhookHandler sends webhooks from clients listed in
+// config.WebhookConfig.DebugClients to the debug handler, with raw payload
+// capture enabled, and everything else to the production handler.
+type ClientDebugWebhookHandler struct {
+	logger       *zap.Logger
+	production   *MailerCloudWebhookHandler
+	debug        *DebugMailerCloudWebhookHandler
+	debugClients map[string]bool
+}
+
+func NewClientDebugWebhookHandler(logger *zap.Logger, publisher queue.Publisher, webhookMapper *mapping.WebhookMappingService, limiter Limiter, cfg config.WebhookConfig, opts ...Option) *ClientDebugWebhookHandler {
+	debug := NewDebugMailerCloudWebhookHandler(logger, publisher, webhookMapper, limiter, cfg, opts...)
+	debug.debugMode = true
+
+	clients := make(map[string]bool, len(cfg.DebugClients))
+	for _, clientID := range cfg.DebugClients {
+		if clientID = strings.TrimSpace(clientID); clientID != "" {
+			clients[clientID] = true
+		}
+	}
+
+	return &ClientDebugWebhookHandler{
+		logger:       logger,
+		production:   NewMailerCloudWebhookHandler(logger, publisher, webhookMapper, limiter, cfg, opts...),
+		debug:        debug,
+		debugClients: clients,
+	}
+}
+
+func (h *ClientDebugWebhookHandler) HandleWebhook(c *gin.Context) {
+	// The production handler resolves clients from the Webhook-Id header
+	// alone, so this is the same client ID it would use.
+	clientID := h.production.extractClientID(c, nil)
+	if h.debugClients[clientID] {
+		h.logger.Debug("Using debug handler for client", zap.String("client_id", clientID))
+		h.debug.HandleWebhook(c)
+		return
+	}
+	h.production.HandleWebhook(c)
+}
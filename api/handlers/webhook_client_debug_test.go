@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"webhook-processor/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestClientDebugWebhookHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		webhookID   string
+		wantCapture bool
+	}{
+		{name: "flagged client is captured", webhookID: "client-debug", wantCapture: true},
+		{name: "other clients use production path", webhookID: "client-prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := new(MockPublisher)
+			pub.On("Publish", mock.Anything).Return(nil)
+			core, logs := observer.New(zap.InfoLevel)
+			cfg := config.WebhookConfig{DebugClients: []string{" client-debug "}}
+			handler := NewClientDebugWebhookHandler(zap.New(core), pub, nil, &stubLimiter{allow: true}, cfg)
+			handler.debug.captureDir = t.TempDir()
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{"event":"opened","email":"a@example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", tt.webhookID)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			pub.AssertExpectations(t)
+
+			captured, err := os.ReadDir(handler.debug.captureDir)
+			require.NoError(t, err)
+			rawLogs := logs.FilterMessage("=== RAW MAILERCLOUD WEBHOOK DATA ===").Len()
+			if tt.wantCapture {
+				assert.Len(t, captured, 1, "raw payload saved")
+				assert.Equal(t, 1, rawLogs)
+			} else {
+				assert.Empty(t, captured)
+				assert.Zero(t, rawLogs)
+			}
+		})
+	}
+}
@@ -1,18 +1,23 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
+	"regexp"
 	"time"
 
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
 	"webhook-processor/internal/mapping"
 	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider/mailercloud"
 	"webhook-processor/internal/queue"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/metrics"
 
 	"github.com/gin-gonic/gin"
@@ -20,73 +25,146 @@ import (
 )
 
 type DebugMailerCloudWebhookHandler struct {
-	logger        *zap.Logger
-	publisher     queue.Publisher
-	rateLimiter   *RateLimiter
-	debugMode     bool
+	logger      *zap.Logger
+	publisher   queue.Publisher
+	rateLimiter Limiter
+	clock       clock.Clock
+	debugMode   bool
+	// captureDir is where raw payloads are saved; empty means the working
+	// directory.
+	captureDir    string
 	webhookMapper *mapping.WebhookMappingService
+	cfg           config.WebhookConfig
+	validationUA  *regexp.Regexp
+	handlerOptions
 }
 
+// RawWebhookData is the layout of a capture file. Body is left empty when
+// the rest is encoded, and the request body is streamed in after it, so
+// large payloads are never held in memory as raw bytes.
 type RawWebhookData struct {
-	Timestamp time.Time              `json:"timestamp"`
-	Method    string                 `json:"method"`
-	Headers   map[string][]string    `json:"headers"`
-	Body      map[string]interface{} `json:"body"`
-	UserAgent string                 `json:"user_agent"`
-	RemoteIP  string                 `json:"remote_ip"`
+	Timestamp time.Time           `json:"timestamp"`
+	Method    string              `json:"method"`
+	Headers   map[string][]string `json:"headers"`
+	Body      json.RawMessage     `json:"body,omitempty"`
+	UserAgent string              `json:"user_agent"`
+	RemoteIP  string              `json:"remote_ip"`
 }
 
-func NewDebugMailerCloudWebhookHandler(logger *zap.Logger, publisher queue.Publisher, webhookMapper *mapping.WebhookMappingService) *DebugMailerCloudWebhookHandler {
+// rawCapture is a capture file that the request body is being written to.
+type rawCapture struct {
+	file *os.File
+	data RawWebhookData
+}
+
+func NewDebugMailerCloudWebhookHandler(logger *zap.Logger, publisher queue.Publisher, webhookMapper *mapping.WebhookMappingService, limiter Limiter, cfg config.WebhookConfig, opts ...Option) *DebugMailerCloudWebhookHandler {
 	debugMode := os.Getenv("WEBHOOK_DEBUG") == "true"
 	return &DebugMailerCloudWebhookHandler{
-		logger:        logger,
-		publisher:     publisher,
-		rateLimiter:   NewRateLimiter(),
-		debugMode:     debugMode,
-		webhookMapper: webhookMapper,
+		logger:         logger,
+		publisher:      publisher,
+		rateLimiter:    limiter,
+		clock:          clock.New(),
+		debugMode:      debugMode,
+		captureDir:     cfg.DebugCaptureDir,
+		webhookMapper:  webhookMapper,
+		cfg:            cfg,
+		validationUA:   ValidationUserAgent(cfg.ValidationUserAgent),
+		handlerOptions: newHandlerOptions(opts),
 	}
 }
 
-func (h *DebugMailerCloudWebhookHandler) saveRawWebhookData(c *gin.Context, data map[string]interface{}) {
+// startCapture opens a capture file and writes everything but the body to
+// it. It returns nil when debug mode is off or the file can't be written.
+func (h *DebugMailerCloudWebhookHandler) startCapture(c *gin.Context) *rawCapture {
 	if !h.debugMode {
-		return
+		return nil
 	}
 
 	rawData := RawWebhookData{
-		Timestamp: time.Now().UTC(),
+		Timestamp: h.clock.Now().UTC(),
 		Method:    c.Request.Method,
 		Headers:   c.Request.Header,
-		Body:      data,
 		UserAgent: c.GetHeader("User-Agent"),
 		RemoteIP:  c.ClientIP(),
 	}
+	header, err := json.Marshal(rawData)
+	if err != nil {
+		h.logger.Error("Failed to write debug data", zap.Error(err))
+		return nil
+	}
 
 	// Save to file for analysis
-	filename := fmt.Sprintf("raw_webhook_data_%d.json", time.Now().UnixNano())
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if h.captureDir != "" {
+		if err := os.MkdirAll(h.captureDir, 0755); err != nil {
+			h.logger.Error("Failed to create debug capture directory", zap.Error(err))
+			return nil
+		}
+	}
+	filename := filepath.Join(h.captureDir, fmt.Sprintf(captureFileFormat, h.clock.Now().UnixNano()))
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		h.logger.Error("Failed to create debug file", zap.Error(err))
+		return nil
+	}
+
+	// Reopen the object so the body can follow as its last field
+	header = append(header[:len(header)-1], `,"body":`...)
+	if _, err := file.Write(header); err != nil {
+		h.logger.Error("Failed to write debug data", zap.Error(err))
+		file.Close()
+		os.Remove(filename)
+		return nil
+	}
+	return &rawCapture{file: file, data: rawData}
+}
+
+// finishCapture closes the capture file once the body has been streamed
+// into it. Payloads that weren't valid JSON are discarded, as the file
+// wouldn't be either.
+func (h *DebugMailerCloudWebhookHandler) finishCapture(capture *rawCapture, data map[string]interface{}, valid bool) {
+	if capture == nil {
 		return
 	}
-	defer file.Close()
+	if !valid {
+		capture.file.Close()
+		os.Remove(capture.file.Name())
+		return
+	}
+	defer capture.file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(rawData); err != nil {
+	if _, err := capture.file.WriteString("}\n"); err != nil {
 		h.logger.Error("Failed to write debug data", zap.Error(err))
 	}
 
 	// Also log detailed information
 	h.logger.Info("=== RAW MAILERCLOUD WEBHOOK DATA ===",
-		zap.String("timestamp", rawData.Timestamp.Format(time.RFC3339)),
-		zap.String("method", rawData.Method),
-		zap.String("user_agent", rawData.UserAgent),
-		zap.String("remote_ip", rawData.RemoteIP),
-		zap.Any("headers", rawData.Headers),
-		zap.Any("body", rawData.Body),
+		zap.String("timestamp", capture.data.Timestamp.Format(time.RFC3339)),
+		zap.String("method", capture.data.Method),
+		zap.String("user_agent", capture.data.UserAgent),
+		zap.String("remote_ip", capture.data.RemoteIP),
+		zap.Any("headers", capture.data.Headers),
+		zap.Any("body", data),
 	)
 }
 
+// decodePayload decodes the single JSON object in r, reading it only once.
+func decodePayload(r io.Reader) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(r)
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	// Read to the end, as json.Unmarshal would, so trailing data is
+	// rejected and the capture gets the whole body
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after JSON payload")
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
 func (h *DebugMailerCloudWebhookHandler) analyzeClientIdentification(data map[string]interface{}) map[string]interface{} {
 	analysis := map[string]interface{}{
 		"potential_client_identifiers": []string{},
@@ -142,34 +220,43 @@ func (h *DebugMailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Read the request body
-	bodyBytes, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		h.logger.Error("Failed to read request body", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+	if rejectMissingContentType(c, h.cfg.MissingContentType, h.logger) {
 		return
 	}
 
-	// Reset the request body for further processing
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	// Parse the JSON data
-	var data map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		h.logger.Error("Failed to parse webhook payload", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+	// Decode the body as it's read, streaming it to the capture file in
+	// debug mode. The router doesn't buffer it, so the size limit is
+	// enforced here.
+	var body io.Reader = c.Request.Body
+	if h.cfg.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.MaxBodyBytes)
+	}
+	capture := h.startCapture(c)
+	if capture != nil {
+		body = io.TeeReader(body, capture.file)
+	}
+	data, err := decodePayload(body)
+	h.finishCapture(capture, data, err == nil)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		metrics.BodyTooLarge.Inc()
+		h.logger.Warn("Request body too large", zap.Int64("max_bytes", h.cfg.MaxBodyBytes))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large", "max_bytes": h.cfg.MaxBodyBytes})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to parse webhook payload", zap.Error(err), zap.String("request_id", apierror.RequestID(c)))
+		apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidJSON, "Invalid JSON payload")
 		return
 	}
-
-	// Save raw webhook data for analysis
-	h.saveRawWebhookData(c, data)
 
 	// Analyze potential client and unique identifiers
 	analysis := h.analyzeClientIdentification(data)
 	h.logger.Info("=== WEBHOOK DATA ANALYSIS ===", zap.Any("analysis", analysis))
 
-	// For test requests from MailerCloud
-	if c.Request.UserAgent() == "MailerCloud" {
+	// For test requests from MailerCloud, which the router can't spot by
+	// their (empty or {"test": ...}) payload without buffering it
+	if h.validationUA.MatchString(c.Request.UserAgent()) || len(data) == 0 || (len(data) == 1 && data["test"] != nil) {
 		h.logger.Info("Handling MailerCloud test request")
 		metrics.WebhookReceived.WithLabelValues("test", "verification").Inc()
 		c.JSON(http.StatusOK, gin.H{
@@ -179,6 +266,8 @@ func (h *DebugMailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
+	applyQueryFields(data, c.Request.URL.Query(), h.cfg.QueryFields)
+
 	// Extract client ID from multiple potential sources
 	clientID := h.extractClientID(c, data)
 
@@ -190,23 +279,32 @@ func (h *DebugMailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 	)
 
 	// Check rate limits
-	if !h.rateLimiter.AllowRequest(clientID) {
-		metrics.RateLimitExceeded.WithLabelValues(clientID, "requests").Inc()
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+	if allowed, limit := h.rateLimiter.Allow(clientID, c.GetHeader("Webhook-Id")); !allowed {
+		metrics.RateLimitExceeded.WithLabelValues(clientID, string(limit)).Inc()
+		apierror.Respond(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "Rate limit exceeded")
 		return
 	}
 
 	// Create webhook event with enhanced identification
+	var deliveryID string
+	if h.cfg.IdempotencyHeader != "" {
+		deliveryID = c.GetHeader(h.cfg.IdempotencyHeader)
+	}
 	event := models.WebhookEvent{
-		WebhookID:   h.generateWebhookID(data),
+		WebhookID:   mailercloud.DeliveryWebhookID(clientID, deliveryID, data),
 		WebhookType: "email_event",
 		ClientID:    clientID,
-		ReceivedAt:  time.Now().UTC(),
+		RequestID:   apierror.RequestID(c),
+		ReceivedAt:  h.clock.Now().UTC(),
 		Status:      string(models.EventStatusPending),
 	}
 
 	// Extract all available fields from the payload
 	h.extractAllFields(&event, data)
+	flagTimestampSkew(&event, event.ReceivedAt, h.cfg.MaxTimestampSkew)
+	if h.cfg.StoreRawPayload {
+		event.RawPayload = data
+	}
 
 	// Log extracted event for debugging
 	h.logger.Info("=== EXTRACTED EVENT DATA ===",
@@ -220,20 +318,15 @@ func (h *DebugMailerCloudWebhookHandler) HandleWebhook(c *gin.Context) {
 		zap.String("date_event", event.DateEvent),
 	)
 
-	// Record the received event metric
-	metrics.WebhookReceived.WithLabelValues(event.ClientID, event.Event).Inc()
-
-	// Send the event to the message queue
-	if err := h.publisher.Publish(event); err != nil {
-		metrics.WebhookProcessed.WithLabelValues(event.ClientID, event.Event, "failed").Inc()
-		h.logger.Error("Failed to publish event", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+	logger := h.logger.With(zap.String("request_id", event.RequestID))
+	if !h.admit(c, logger, h.cfg, &event) {
+		return
+	}
+	if !h.publishEvent(c, logger, h.publisher, &event, &stageTimer{}) {
 		return
 	}
 
-	metrics.WebhookProcessed.WithLabelValues(event.ClientID, event.Event, "success").Inc()
-
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(h.acceptedStatus(), gin.H{
 		"message":    "Event accepted",
 		"webhook_id": event.WebhookID,
 		"client_id":  event.ClientID,
@@ -255,7 +348,8 @@ func (h *DebugMailerCloudWebhookHandler) extractClientID(c *gin.Context, data ma
 		}
 
 		h.logger.Warn("Webhook ID not found in mapping, falling back to webhook ID",
-			zap.String("webhook_id", webhookID))
+			zap.String("webhook_id", webhookID),
+			zap.String("webhook_bucket", mailercloud.RecordUnknownClient(webhookID)))
 	}
 
 	// Fallback 1: Use webhook ID as client identifier if available
@@ -274,112 +368,13 @@ func (h *DebugMailerCloudWebhookHandler) extractClientID(c *gin.Context, data ma
 	}
 
 	// Final fallback: Unknown client
+	mailercloud.RecordUnknownClient("")
 	h.logger.Warn("No client identification available, using unknown client")
 	return "unknown"
 }
 
-func (h *DebugMailerCloudWebhookHandler) generateWebhookID(data map[string]interface{}) string {
-	// Strategy 1: Use existing webhook/message ID if available
-	idFields := []string{"webhook_id", "message_id", "event_id", "delivery_id", "tracking_id"}
-	for _, field := range idFields {
-		if val, ok := data[field].(string); ok && val != "" {
-			return val
-		}
-	}
-
-	// Strategy 2: Generate based on combination of fields for uniqueness
-	var components []string
-
-	if val, ok := data["campaign_id"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-	if val, ok := data["email"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-	if val, ok := data["ts"].(float64); ok {
-		components = append(components, fmt.Sprintf("%.0f", val))
-	}
-	if val, ok := data["event"].(string); ok && val != "" {
-		components = append(components, val)
-	}
-
-	if len(components) > 0 {
-		return fmt.Sprintf("mc_%x", components)
-	}
-
-	// Strategy 3: Fallback to timestamp-based ID
-	return fmt.Sprintf("mc_%d", time.Now().UnixNano())
-}
-
 func (h *DebugMailerCloudWebhookHandler) extractAllFields(event *models.WebhookEvent, data map[string]interface{}) {
-	// Extract standard fields with type assertions and error handling
-	if val, ok := data["event"].(string); ok {
-		event.Event = val
-	}
-
-	// Campaign name variations
-	if val, ok := data["campaign_name"].(string); ok {
-		event.CampaignName = val
-	} else if val, ok := data["campaign name"].(string); ok {
-		event.CampaignName = val
-	}
-
-	// Campaign ID variations
-	if val, ok := data["campaign_id"].(string); ok {
-		event.CampaignID = val
-	} else if val, ok := data["camp_id"].(string); ok {
-		event.CampaignID = val
-	}
-
-	// Tag name variations
-	if val, ok := data["tag_name"].(string); ok {
-		event.TagName = val
-	} else if val, ok := data["tag"].(string); ok {
-		event.TagName = val
-	}
-
-	if val, ok := data["date_event"].(string); ok {
-		event.DateEvent = val
-	}
-	if val, ok := data["ts"].(float64); ok {
-		event.Timestamp = int64(val)
-	}
-	if val, ok := data["ts_event"].(float64); ok {
-		event.TimestampEvent = int64(val)
-	}
-	if val, ok := data["email"].(string); ok {
-		event.Email = val
-	}
-
-	// URL field variations (for click events)
-	if val, ok := data["URL"].(string); ok {
-		event.URL = val
-	} else if val, ok := data["url"].(string); ok {
-		event.URL = val
-	} else if val, ok := data["click_url"].(string); ok {
-		event.URL = val
-	}
-
-	// Reason field (for bounce, spam, campaign_error events)
-	if val, ok := data["reason"].(string); ok {
-		event.Reason = val
-	}
-
-	// Handle list_id which can be string, number, or array (for unsubscribe events)
-	if val, exists := data["list_id"]; exists {
-		event.ListID = val
-	}
-
-	// Handle emails array
-	if val, ok := data["emails"].([]interface{}); ok {
-		emails := make([]string, 0, len(val))
-		for _, email := range val {
-			if emailStr, ok := email.(string); ok {
-				emails = append(emails, emailStr)
-			}
-		}
-		event.Emails = emails
-	}
+	mailercloud.ExtractEventFields(event, data)
 
 	// Event-specific field validation and logging
 	h.logEventSpecificFields(event, data)
@@ -387,10 +382,8 @@ func (h *DebugMailerCloudWebhookHandler) extractAllFields(event *models.WebhookE
 
 // logEventSpecificFields logs event-specific field validation and processing
 func (h *DebugMailerCloudWebhookHandler) logEventSpecificFields(event *models.WebhookEvent, data map[string]interface{}) {
-	eventType := strings.ToLower(event.Event)
-
-	switch eventType {
-	case "clicked", "click":
+	switch event.Type() {
+	case models.EventTypeClick:
 		if event.URL != "" {
 			h.logger.Info("=== CLICK EVENT PROCESSING ===",
 				zap.String("event", event.Event),
@@ -402,7 +395,7 @@ func (h *DebugMailerCloudWebhookHandler) logEventSpecificFields(event *models.We
 				zap.Any("raw_data", data))
 		}
 
-	case "bounced", "bounce", "hard_bounce", "soft_bounce":
+	case models.EventTypeBounce:
 		if event.Reason != "" {
 			h.logger.Info("=== BOUNCE EVENT PROCESSING ===",
 				zap.String("event", event.Event),
@@ -414,7 +407,7 @@ func (h *DebugMailerCloudWebhookHandler) logEventSpecificFields(event *models.We
 				zap.Any("raw_data", data))
 		}
 
-	case "spam":
+	case models.EventTypeSpam:
 		if event.Reason != "" {
 			h.logger.Info("=== SPAM EVENT PROCESSING ===",
 				zap.String("event", event.Event),
@@ -426,7 +419,7 @@ func (h *DebugMailerCloudWebhookHandler) logEventSpecificFields(event *models.We
 				zap.Any("raw_data", data))
 		}
 
-	case "campaign_error":
+	case models.EventTypeCampaignError:
 		if event.Reason != "" {
 			h.logger.Info("=== CAMPAIGN ERROR PROCESSING ===",
 				zap.String("event", event.Event),
@@ -438,7 +431,7 @@ func (h *DebugMailerCloudWebhookHandler) logEventSpecificFields(event *models.We
 				zap.Any("raw_data", data))
 		}
 
-	case "unsubscribe", "unsubscribed":
+	case models.EventTypeUnsubscribe:
 		if event.ListID != nil {
 			h.logger.Info("=== UNSUBSCRIBE EVENT PROCESSING ===",
 				zap.String("event", event.Event),
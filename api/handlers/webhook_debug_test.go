@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"webhook-processor/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDebugWebhookHandlerBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := new(MockPublisher)
+	cfg := config.WebhookConfig{MaxBodyBytes: 32, DebugCaptureDir: t.TempDir()}
+	handler := NewDebugMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{"event":"opened","email":"a@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "client-a")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler.HandleWebhook(c)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+// onePassReader fails the test if it is read again after reaching EOF.
+type onePassReader struct {
+	t    *testing.T
+	r    io.Reader
+	read int
+	eof  bool
+}
+
+func (r *onePassReader) Read(p []byte) (int, error) {
+	if r.eof {
+		r.t.Error("body read again after EOF")
+	}
+	n, err := r.r.Read(p)
+	r.read += n
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *onePassReader) Close() error { return nil }
+
+func TestDebugWebhookHandlerStreamsLargeBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := new(MockPublisher)
+	pub.On("Publish", mock.Anything).Return(nil)
+	cfg := config.WebhookConfig{MaxBodyBytes: 8 << 20, DebugCaptureDir: t.TempDir()}
+	handler := NewDebugMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg)
+	handler.debugMode = true
+
+	payload := `{"event":"opened","email":"a@example.com","html":"` + strings.Repeat("x", 4<<20) + `"}`
+	body := &onePassReader{t: t, r: strings.NewReader(payload)}
+	req := httptest.NewRequest(http.MethodPost, "/webhook", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "client-a")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler.HandleWebhook(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, len(payload), body.read, "body read exactly once")
+	assert.Same(t, body, c.Request.Body, "body isn't buffered and replaced")
+
+	captured, err := filepath.Glob(filepath.Join(cfg.DebugCaptureDir, captureFilePattern))
+	require.NoError(t, err)
+	require.Len(t, captured, 1)
+	raw, err := os.ReadFile(captured[0])
+	require.NoError(t, err)
+	var capture RawWebhookData
+	require.NoError(t, json.Unmarshal(raw, &capture))
+	assert.Equal(t, http.MethodPost, capture.Method)
+	assert.JSONEq(t, payload, string(capture.Body))
+}
+
+func TestDebugWebhookHandlerDiscardsInvalidCapture(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.WebhookConfig{DebugCaptureDir: t.TempDir()}
+	handler := NewDebugMailerCloudWebhookHandler(zap.NewNop(), new(MockPublisher), nil, &stubLimiter{allow: true}, cfg)
+	handler.debugMode = true
+
+	for _, payload := range []string{`{"event":"opened"`, `{"event":"opened"} {"event":"click"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		handler.HandleWebhook(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+	}
+
+	captured, err := os.ReadDir(cfg.DebugCaptureDir)
+	require.NoError(t, err)
+	assert.Empty(t, captured)
+}
+
+func TestDebugWebhookHandlerValidationPayload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := new(MockPublisher)
+	handler := NewDebugMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	for _, payload := range []string{`{}`, `{"test": true}`} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		handler.HandleWebhook(c)
+
+		assert.Equal(t, http.StatusOK, w.Code, payload)
+	}
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
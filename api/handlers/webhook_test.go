@@ -2,16 +2,26 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
 	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -24,6 +34,10 @@ func (m *MockPublisher) Publish(event models.WebhookEvent) error {
 	return args.Error(0)
 }
 
+func (m *MockPublisher) Close() error {
+	return nil
+}
+
 func TestHandleWebhook(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
@@ -31,14 +45,15 @@ func TestHandleWebhook(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		clientID   string
+		webhookID  string
 		payload    interface{}
 		setupMock  func(*MockPublisher)
 		wantStatus int
+		wantCode   apierror.Code
 	}{
 		{
-			name:     "Valid request",
-			clientID: "test-client",
+			name:      "Valid request",
+			webhookID: "test-webhook",
 			payload: models.WebhookEvent{
 				Event:        "Campaign Sent",
 				CampaignName: "Test Campaign",
@@ -47,23 +62,36 @@ func TestHandleWebhook(t *testing.T) {
 			setupMock: func(m *MockPublisher) {
 				m.On("Publish", mock.Anything).Return(nil)
 			},
-			wantStatus: http.StatusAccepted,
+			wantStatus: http.StatusOK,
 		},
 		{
-			name:     "Missing client ID",
-			clientID: "",
+			name:      "Missing webhook ID falls back to unknown client",
+			webhookID: "",
 			payload: models.WebhookEvent{
 				Event: "Campaign Sent",
 			},
-			setupMock:  func(m *MockPublisher) {},
-			wantStatus: http.StatusBadRequest,
+			setupMock: func(m *MockPublisher) {
+				m.On("Publish", mock.MatchedBy(func(e models.WebhookEvent) bool {
+					return e.ClientID == "unknown"
+				})).Return(nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Publish failure",
+			webhookID:  "test-webhook",
+			payload:    models.WebhookEvent{Event: "Campaign Sent"},
+			setupMock:  func(m *MockPublisher) { m.On("Publish", mock.Anything).Return(assert.AnError) },
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   apierror.CodePublishFailed,
 		},
 		{
 			name:       "Invalid payload",
-			clientID:   "test-client",
+			webhookID:  "test-webhook",
 			payload:    "invalid",
 			setupMock:  func(m *MockPublisher) {},
 			wantStatus: http.StatusBadRequest,
+			wantCode:   apierror.CodeInvalidJSON,
 		},
 	}
 
@@ -74,14 +102,14 @@ func TestHandleWebhook(t *testing.T) {
 			tt.setupMock(mockPub)
 
 			// Create handler
-			handler := NewWebhookHandler(logger, mockPub)
+			handler := NewMailerCloudWebhookHandler(logger, mockPub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
 
 			// Create request
 			payload, _ := json.Marshal(tt.payload)
 			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBuffer(payload))
 			req.Header.Set("Content-Type", "application/json")
-			if tt.clientID != "" {
-				req.Header.Set("X-Client-ID", tt.clientID)
+			if tt.webhookID != "" {
+				req.Header.Set("Webhook-Id", tt.webhookID)
 			}
 
 			// Create response recorder
@@ -94,7 +122,493 @@ func TestHandleWebhook(t *testing.T) {
 
 			// Assert response
 			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantCode != "" {
+				var body apierror.Response
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.wantCode, body.Error.Code)
+				assert.NotEmpty(t, body.Error.RequestID)
+			}
 			mockPub.AssertExpectations(t)
 		})
 	}
 }
+
+// errorCode returns the code of the structured error response in w.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) apierror.Code {
+	t.Helper()
+	var body apierror.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Error.Code
+}
+
+func TestHandleWebhookMaxEventAge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		ts          time.Time
+		wantPublish bool
+	}{
+		{name: "within threshold", ts: now.Add(-time.Hour), wantPublish: true},
+		{name: "too old", ts: now.Add(-72 * time.Hour), wantPublish: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPub := new(MockPublisher)
+			if tt.wantPublish {
+				mockPub.On("Publish", mock.Anything).Return(nil)
+			}
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), mockPub, nil, &stubLimiter{allow: true}, config.WebhookConfig{MaxEventAge: 24 * time.Hour})
+			handler.clock = clock.NewMock(now)
+
+			payload, _ := json.Marshal(map[string]interface{}{"event": "opened", "email": "a@example.com", "ts": tt.ts.Unix()})
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBuffer(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "test-webhook")
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+
+			assert.Equal(t, http.StatusOK, w.Code, "too-old events still get 200 so they aren't retried")
+			if tt.wantPublish {
+				mockPub.AssertNumberOfCalls(t, "Publish", 1)
+			} else {
+				mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+				assert.Contains(t, w.Body.String(), "older than maximum age")
+			}
+		})
+	}
+}
+
+// stubLimiter allows or denies every request and records the clients checked.
+type stubLimiter struct {
+	allow   bool
+	checked []string
+}
+
+func (s *stubLimiter) Allow(clientID, webhookID string) (bool, LimitType) {
+	s.checked = append(s.checked, clientID)
+	if !s.allow {
+		return false, LimitDaily
+	}
+	return true, ""
+}
+
+func (s *stubLimiter) DailyLimit(clientID string) int { return 0 }
+
+func (s *stubLimiter) DailyUsage(clientID string) int { return len(s.checked) }
+
+func TestHandleWebhookRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		allow      bool
+		wantStatus int
+	}{
+		{name: "limiter allows", allow: true, wantStatus: http.StatusOK},
+		{name: "limiter denies", allow: false, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPub := new(MockPublisher)
+			if tt.allow {
+				mockPub.On("Publish", mock.Anything).Return(nil)
+			}
+			limiter := &stubLimiter{allow: tt.allow}
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), mockPub, nil, limiter, config.WebhookConfig{})
+
+			payload, _ := json.Marshal(map[string]interface{}{"event": "opened", "email": "a@example.com"})
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBuffer(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "test-webhook")
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, []string{"test-webhook"}, limiter.checked)
+			if !tt.allow {
+				mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+			}
+			mockPub.AssertExpectations(t)
+		})
+	}
+}
+
+// stubQuota lets remaining events through to storage.
+type stubQuota struct {
+	remaining int
+}
+
+func (q *stubQuota) ChargeStorage(clientID string) bool {
+	if q.remaining == 0 {
+		return false
+	}
+	q.remaining--
+	return true
+}
+
+func TestHandleWebhookStorageQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		policy     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "drop by default", wantStatus: http.StatusOK, wantBody: `"status":"quota_exceeded"`},
+		{name: "drop", policy: config.OverQuotaDrop, wantStatus: http.StatusOK, wantBody: `"status":"quota_exceeded"`},
+		{name: "reject", policy: config.OverQuotaReject, wantStatus: http.StatusTooManyRequests, wantBody: `"code":"QUOTA_EXCEEDED"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := new(MockPublisher)
+			pub.On("Publish", mock.Anything).Return(nil).Once()
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{},
+				WithStorageQuota(&stubQuota{remaining: 1}, tt.policy))
+
+			post := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened","email":"a@example.com"}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Webhook-Id", "test-webhook")
+				w := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(w)
+				c.Request = req
+				handler.HandleWebhook(c)
+				return w
+			}
+
+			first := post()
+			require.Equal(t, http.StatusOK, first.Code)
+			assert.Contains(t, first.Body.String(), "Event accepted")
+			var accepted struct {
+				ClientID string `json:"client_id"`
+			}
+			require.NoError(t, json.Unmarshal(first.Body.Bytes(), &accepted))
+			action := tt.policy
+			if action == "" {
+				action = config.OverQuotaDrop
+			}
+			exceeded := metrics.StorageQuotaExceeded.WithLabelValues(accepted.ClientID, action)
+			before := testutil.ToFloat64(exceeded)
+
+			second := post()
+			assert.Equal(t, tt.wantStatus, second.Code)
+			assert.Contains(t, second.Body.String(), tt.wantBody)
+			assert.Equal(t, before+1, testutil.ToFloat64(exceeded))
+			pub.AssertNumberOfCalls(t, "Publish", 1)
+		})
+	}
+}
+
+func TestHandleWebhookPublishModes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		async      bool
+		asyncErr   error
+		retryErr   error
+		retry      bool
+		wantStatus int
+	}{
+		{name: "sync publishes before responding", wantStatus: http.StatusOK},
+		{name: "async accepts once buffered", async: true, wantStatus: http.StatusAccepted},
+		{name: "async buffer full", async: true, asyncErr: queue.ErrPublishBufferFull, wantStatus: http.StatusServiceUnavailable},
+		{name: "sync via retry buffer", retry: true, wantStatus: http.StatusOK},
+		{name: "retry buffer full", retry: true, retryErr: queue.ErrPublishBufferFull, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncPub := new(MockPublisher)
+			asyncPub := new(MockPublisher)
+			retryPub := new(MockPublisher)
+			var opts []Option
+			if tt.async {
+				asyncPub.On("Publish", mock.Anything).Return(tt.asyncErr)
+				opts = append(opts, WithAsyncPublisher(asyncPub))
+			} else if tt.retry {
+				retryPub.On("Publish", mock.Anything).Return(tt.retryErr)
+				opts = append(opts, WithRetryBuffer(retryPub))
+			} else {
+				syncPub.On("Publish", mock.Anything).Return(nil)
+			}
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), syncPub, nil, &stubLimiter{allow: true}, config.WebhookConfig{}, opts...)
+
+			payload, _ := json.Marshal(map[string]interface{}{"event": "opened", "email": "a@example.com"})
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBuffer(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "test-webhook")
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.async || tt.retry {
+				syncPub.AssertNotCalled(t, "Publish", mock.Anything)
+			}
+			syncPub.AssertExpectations(t)
+			asyncPub.AssertExpectations(t)
+			retryPub.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleWebhookMissingContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		policy     string
+		debug      bool
+		wantStatus int
+	}{
+		{name: "assume json", policy: config.MissingContentTypeAssumeJSON, wantStatus: http.StatusOK},
+		{name: "reject", policy: config.MissingContentTypeReject, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "debug handler, assume json", policy: config.MissingContentTypeAssumeJSON, debug: true, wantStatus: http.StatusOK},
+		{name: "debug handler, reject", policy: config.MissingContentTypeReject, debug: true, wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := new(MockPublisher)
+			if tt.wantStatus == http.StatusOK {
+				pub.On("Publish", mock.Anything).Return(nil)
+			}
+			cfg := config.WebhookConfig{MissingContentType: tt.policy}
+			var handle gin.HandlerFunc
+			if tt.debug {
+				h := NewDebugMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg)
+				h.captureDir = t.TempDir()
+				handle = h.HandleWebhook
+			} else {
+				handle = NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg).HandleWebhook
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{"event":"opened","email":"a@example.com"}`))
+			req.Header.Set("Webhook-Id", "test-webhook")
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handle(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, apierror.CodeUnsupportedMediaType, errorCode(t, w))
+			}
+			pub.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleWebhookRequireEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	required := []string{"open", "click", "bounce"}
+
+	tests := []struct {
+		name       string
+		required   []string
+		payload    string
+		wantStatus int
+	}{
+		{name: "open with email", required: required, payload: `{"event":"open","email":"a@example.com"}`, wantStatus: http.StatusOK},
+		{name: "open without email", required: required, payload: `{"event":"open"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "click with email", required: required, payload: `{"event":"click","email":"a@example.com"}`, wantStatus: http.StatusOK},
+		{name: "click without email", required: required, payload: `{"event":"click","email":""}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "bounce with emails array", required: required, payload: `{"event":"bounce","emails":["a@example.com"]}`, wantStatus: http.StatusOK},
+		{name: "bounce without email", required: required, payload: `{"event":"Bounce"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "bounce with only invalid emails", required: required, payload: `{"event":"bounce","emails":["not-an-address"]}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "other type without email", required: required, payload: `{"event":"campaign_error"}`, wantStatus: http.StatusOK},
+		{name: "lenient by default", payload: `{"event":"open"}`, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := new(MockPublisher)
+			if tt.wantStatus == http.StatusOK {
+				pub.On("Publish", mock.Anything).Return(nil)
+			}
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{RequireEmailEvents: tt.required})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(tt.payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "test-webhook")
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			pub.AssertExpectations(t)
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, apierror.CodeMissingEmail, errorCode(t, w))
+				pub.AssertNotCalled(t, "Publish", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestHandleWebhookValidationUserAgent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		pattern        string
+		userAgent      string
+		wantValidation bool
+	}{
+		{name: "exact", userAgent: "MailerCloud", wantValidation: true},
+		{name: "versioned", userAgent: "MailerCloud/2.0", wantValidation: true},
+		{name: "other agent", userAgent: "curl/8.4.0"},
+		{name: "prefix of another product", userAgent: "MailerCloudflare/1.0"},
+		{name: "containing the name", userAgent: "Mozilla/5.0 MailerCloud"},
+		{name: "custom pattern", pattern: `^MC-Validator`, userAgent: "MC-Validator/1", wantValidation: true},
+		{name: "custom pattern replaces default", pattern: `^MC-Validator`, userAgent: "MailerCloud"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPub := new(MockPublisher)
+			if !tt.wantValidation {
+				mockPub.On("Publish", mock.Anything).Return(nil)
+			}
+			handler := NewMailerCloudWebhookHandler(zap.NewNop(), mockPub, nil, &stubLimiter{allow: true},
+				config.WebhookConfig{ValidationUserAgent: tt.pattern})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened","email":"a@example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "wh-1")
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+
+			handler.HandleWebhook(c)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockPub.AssertExpectations(t)
+			if tt.wantValidation {
+				mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+			}
+		})
+	}
+}
+
+// contextPublisher fails publishes whose context is already done, like the
+// broker client does when the request is cancelled.
+type contextPublisher struct {
+	MockPublisher
+	ctx context.Context
+}
+
+func (p *contextPublisher) PublishContext(ctx context.Context, event models.WebhookEvent) error {
+	p.ctx = ctx
+	return ctx.Err()
+}
+
+func TestHandleWebhookPublishesUnderRequestContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := &contextPublisher{}
+	handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the client has gone away
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened","email":"a@example.com"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "wh-1")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	handler.HandleWebhook(c)
+
+	require.NotNil(t, pub.ctx, "PublishContext is preferred over Publish")
+	assert.Equal(t, context.Canceled, pub.ctx.Err())
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	pub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestHandleWebhookIdempotencyHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	payload := `{"event":"opened","email":"a@example.com"}`
+
+	// Webhook-Id also picks the client, so the deliveries come from one
+	// webhook and differ only in X-Delivery-Id
+	publishedIDs := func(cfg config.WebhookConfig, deliveryIDs ...string) []string {
+		var ids []string
+		pub := new(MockPublisher)
+		pub.On("Publish", mock.Anything).Run(func(args mock.Arguments) {
+			ids = append(ids, args.Get(0).(models.WebhookEvent).WebhookID)
+		}).Return(nil)
+		handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, cfg)
+		for _, deliveryID := range deliveryIDs {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Webhook-Id", "wh-1")
+			req.Header.Set("X-Delivery-Id", deliveryID)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+			handler.HandleWebhook(c)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		return ids
+	}
+
+	keyed := config.WebhookConfig{IdempotencyHeader: "X-Delivery-Id"}
+	retried := publishedIDs(keyed, "delivery-1", "delivery-1")
+	assert.Equal(t, retried[0], retried[1], "a retry with the same delivery ID is a duplicate")
+	distinct := publishedIDs(keyed, "delivery-1", "delivery-2")
+	assert.NotEqual(t, distinct[0], distinct[1], "distinct deliveries with identical fields are kept apart")
+
+	unkeyed := publishedIDs(config.WebhookConfig{}, "delivery-1", "delivery-2")
+	assert.Equal(t, unkeyed[0], unkeyed[1], "without the setting the fields are the key")
+}
+
+func TestHandleWebhookStoreRawPayload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	payload := `{"event":"bounced","email":"a@example.com","campaign_id":"c-1","x_new_field":{"nested":true}}`
+
+	for _, enabled := range []bool{true, false} {
+		var published models.WebhookEvent
+		pub := new(MockPublisher)
+		pub.On("Publish", mock.Anything).Run(func(args mock.Arguments) {
+			published = args.Get(0).(models.WebhookEvent)
+		}).Return(nil)
+		handler := NewMailerCloudWebhookHandler(zap.NewNop(), pub, nil, &stubLimiter{allow: true}, config.WebhookConfig{StoreRawPayload: enabled})
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Webhook-Id", "test-webhook")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		handler.HandleWebhook(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "c-1", published.CampaignID)
+		if !enabled {
+			assert.Nil(t, published.RawPayload, "the raw payload is only kept when enabled")
+			continue
+		}
+		assert.Equal(t, map[string]interface{}{
+			"event":       "bounced",
+			"email":       "a@example.com",
+			"campaign_id": "c-1",
+			"x_new_field": map[string]interface{}{"nested": true},
+		}, published.RawPayload)
+	}
+}
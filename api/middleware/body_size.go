@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// LimitBodySize rejects requests whose body is larger than maxBytes with 413,
+// before anything reads it into memory unbounded. The body is buffered and
+// reset so later handlers can read it again.
+func LimitBodySize(logger *zap.Logger, maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		// A declared length is checked without reading anything
+		if c.Request.ContentLength > maxBytes {
+			rejectTooLarge(c, logger, maxBytes)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			rejectTooLarge(c, logger, maxBytes)
+			return
+		}
+		// A short body is left for ValidateContentLength, which compares
+		// lengths, to report
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+func rejectTooLarge(c *gin.Context, logger *zap.Logger, maxBytes int64) {
+	metrics.BodyTooLarge.Inc()
+	logger.Warn("Request body too large",
+		zap.Int64("content_length", c.Request.ContentLength),
+		zap.Int64("max_bytes", maxBytes),
+		zap.String("ip", c.ClientIP()))
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":     "Request body too large",
+		"max_bytes": maxBytes,
+	})
+	c.Abort()
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func serveBodySize(req *http.Request, maxBytes int64) (*httptest.ResponseRecorder, string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	var seen string
+	r.POST("/webhook", LimitBodySize(zap.NewNop(), maxBytes), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		seen = string(body)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, seen
+}
+
+func TestLimitBodySizeWithinLimit(t *testing.T) {
+	body := `{"event":"opened"}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+
+	w, seen := serveBodySize(req, int64(len(body)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, seen, "handler can still read the body")
+}
+
+func TestLimitBodySizeOverLimit(t *testing.T) {
+	body := `{"event":"opened","padding":"` + strings.Repeat("x", 100) + `"}`
+
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		{name: "declared length", contentLength: int64(len(body))},
+		{name: "chunked", contentLength: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.BodyTooLarge)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			req.ContentLength = tt.contentLength
+
+			w, seen := serveBodySize(req, 64)
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+			assert.JSONEq(t, `{"error":"Request body too large","max_bytes":64}`, w.Body.String())
+			assert.Empty(t, seen, "handler must not run")
+			assert.Equal(t, before+1, testutil.ToFloat64(metrics.BodyTooLarge))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ValidateContentLength rejects requests whose body does not match the
+// declared Content-Length, which usually means a proxy truncated the payload.
+// Requests without a declared length (e.g. chunked) are passed through. The
+// body is buffered and reset so later handlers can read it again.
+func ValidateContentLength(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		declared := c.Request.ContentLength
+		if declared < 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+
+		// net/http reports a body shorter than Content-Length as an
+		// unexpected EOF rather than a short read
+		if errors.Is(err, io.ErrUnexpectedEOF) || int64(len(body)) != declared {
+			metrics.ContentLengthMismatch.Inc()
+			logger.Warn("Request body does not match Content-Length",
+				zap.Int64("content_length", declared),
+				zap.Int("body_bytes", len(body)),
+				zap.String("ip", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":          "Request body does not match Content-Length",
+				"content_length": declared,
+				"body_bytes":     len(body),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
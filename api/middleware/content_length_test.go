@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func serveContentLength(req *http.Request) (*httptest.ResponseRecorder, string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	var seen string
+	r.POST("/webhook", ValidateContentLength(zap.NewNop()), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		seen = string(body)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, seen
+}
+
+func TestValidateContentLengthMatching(t *testing.T) {
+	body := `{"event":"opened"}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+
+	w, seen := serveContentLength(req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, seen, "handler can still read the body")
+}
+
+func TestValidateContentLengthMismatched(t *testing.T) {
+	before := testutil.ToFloat64(metrics.ContentLengthMismatch)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"op`))
+	req.ContentLength = 18
+
+	w, seen := serveContentLength(req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, seen, "handler must not run")
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.ContentLengthMismatch))
+}
+
+func TestValidateContentLengthUnknownLength(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened"}`))
+	req.ContentLength = -1
+
+	w, _ := serveContentLength(req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
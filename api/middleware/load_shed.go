@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Pressure is a measurement of the process's resource usage.
+type Pressure struct {
+	Goroutines int
+	HeapBytes  uint64
+}
+
+// readPressure measures the running process.
+func readPressure() Pressure {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return Pressure{Goroutines: runtime.NumGoroutine(), HeapBytes: mem.HeapInuse}
+}
+
+// LoadShedder answers 503 to a share of requests while goroutines or heap
+// exceed their thresholds. The share grows with the overshoot: 20% over a
+// threshold sheds 20% of requests, up to the configured maximum. Usage is
+// measured at most once per sample interval, on the request path, so
+// shedding stops on the first request after pressure has eased.
+type LoadShedder struct {
+	logger        *zap.Logger
+	clock         clock.Clock
+	maxGoroutines int
+	maxHeapBytes  uint64
+	maxFraction   float64
+	interval      time.Duration
+	retryAfter    time.Duration
+	// sample and random are replaced in tests
+	sample func() Pressure
+	random func() float64
+
+	mu         sync.Mutex
+	lastSample time.Time
+	fraction   float64
+}
+
+func NewLoadShedder(logger *zap.Logger, cfg config.LoadShedConfig, clk clock.Clock) *LoadShedder {
+	return &LoadShedder{
+		logger:        logger,
+		clock:         clk,
+		maxGoroutines: cfg.MaxGoroutines,
+		maxHeapBytes:  uint64(cfg.MaxHeapMB) << 20,
+		maxFraction:   cfg.MaxFraction,
+		interval:      cfg.SampleInterval,
+		retryAfter:    cfg.RetryAfter,
+		sample:        readPressure,
+		random:        rand.Float64,
+	}
+}
+
+// Fraction returns the share of requests currently being shed, measuring
+// usage first if the last sample is older than the sample interval.
+func (s *LoadShedder) Fraction() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if s.lastSample.IsZero() || now.Sub(s.lastSample) >= s.interval {
+		s.lastSample = now
+		s.update(s.sample())
+	}
+	return s.fraction
+}
+
+// update sets the shed fraction for p. s.mu must be held.
+func (s *LoadShedder) update(p Pressure) {
+	var overshoot float64
+	if s.maxGoroutines > 0 {
+		overshoot = math.Max(overshoot, float64(p.Goroutines)/float64(s.maxGoroutines)-1)
+	}
+	if s.maxHeapBytes > 0 {
+		overshoot = math.Max(overshoot, float64(p.HeapBytes)/float64(s.maxHeapBytes)-1)
+	}
+	fraction := math.Min(math.Max(overshoot, 0), s.maxFraction)
+
+	if (fraction > 0) != (s.fraction > 0) {
+		if fraction > 0 {
+			s.logger.Warn("Shedding load under resource pressure",
+				zap.Int("goroutines", p.Goroutines),
+				zap.Uint64("heap_bytes", p.HeapBytes),
+				zap.Float64("fraction", fraction))
+		} else {
+			s.logger.Info("Resource pressure eased, no longer shedding load",
+				zap.Int("goroutines", p.Goroutines),
+				zap.Uint64("heap_bytes", p.HeapBytes))
+		}
+	}
+	s.fraction = fraction
+	metrics.LoadShedFraction.Set(fraction)
+}
+
+// Shed answers 503 with a Retry-After header to the current fraction of
+// requests.
+func (s *LoadShedder) Shed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fraction := s.Fraction()
+		if fraction == 0 || s.random() >= fraction {
+			c.Next()
+			return
+		}
+		metrics.LoadShedRequests.Inc()
+		c.Header("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service overloaded, retry later"})
+		c.Abort()
+	}
+}
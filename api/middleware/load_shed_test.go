@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/pkg/clock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestLoadShedderShedsUnderPressureAndRecovers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s := NewLoadShedder(zap.NewNop(), config.LoadShedConfig{
+		MaxGoroutines:  1000,
+		MaxHeapMB:      100,
+		MaxFraction:    0.9,
+		SampleInterval: time.Second,
+		RetryAfter:     5 * time.Second,
+	}, clk)
+	pressure := Pressure{Goroutines: 10}
+	s.sample = func() Pressure { return pressure }
+	roll := 0.5
+	s.random = func() float64 { return roll }
+
+	r := gin.New()
+	r.POST("/webhook", s.Shed(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+
+	// 1800 goroutines is 80% over the threshold
+	pressure = Pressure{Goroutines: 1800}
+	assert.Equal(t, http.StatusOK, serve().Code, "usage isn't measured again within the sample interval")
+	clk.Advance(time.Second)
+	w := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.InDelta(t, 0.8, s.Fraction(), 1e-9)
+
+	roll = 0.85
+	assert.Equal(t, http.StatusOK, serve().Code, "requests outside the shed fraction get through")
+
+	// Heap pressure counts too, capped at the maximum fraction
+	pressure = Pressure{Goroutines: 10, HeapBytes: 300 << 20}
+	clk.Advance(time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, serve().Code)
+	assert.InDelta(t, 0.9, s.Fraction(), 1e-9)
+
+	pressure = Pressure{Goroutines: 10, HeapBytes: 50 << 20}
+	clk.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, serve().Code, "shedding stops once pressure eases")
+	assert.Zero(t, s.Fraction())
+}
+
+func TestLoadShedderIgnoresUnsetThresholds(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s := NewLoadShedder(zap.NewNop(), config.LoadShedConfig{
+		MaxHeapMB:      100,
+		MaxFraction:    0.9,
+		SampleInterval: time.Second,
+	}, clk)
+	s.sample = func() Pressure { return Pressure{Goroutines: 1_000_000, HeapBytes: 10 << 20} }
+
+	assert.Zero(t, s.Fraction())
+}
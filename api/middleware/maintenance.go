@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Maintenance rejects requests with 503 and a Retry-After header while
+// enabled, so webhook senders retry them once maintenance is over. It can be
+// toggled at runtime.
+type Maintenance struct {
+	logger     *zap.Logger
+	retryAfter time.Duration
+	enabled    atomic.Bool
+}
+
+func NewMaintenance(logger *zap.Logger, enabled bool, retryAfter time.Duration) *Maintenance {
+	m := &Maintenance{logger: logger, retryAfter: retryAfter}
+	m.SetEnabled(enabled)
+	return m
+}
+
+// Enabled reports whether requests are currently being rejected.
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled turns maintenance mode on or off.
+func (m *Maintenance) SetEnabled(enabled bool) {
+	if m.enabled.Swap(enabled) != enabled {
+		m.logger.Warn("Maintenance mode changed", zap.Bool("enabled", enabled))
+	}
+	if enabled {
+		metrics.MaintenanceMode.Set(1)
+	} else {
+		metrics.MaintenanceMode.Set(0)
+	}
+}
+
+// Reject answers 503 while maintenance mode is enabled.
+func (m *Maintenance) Reject() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.Enabled() {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service under maintenance, retry later"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestMaintenanceReject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewMaintenance(zap.NewNop(), false, 30*time.Second)
+	r := gin.New()
+	r.POST("/webhook", m.Reject(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+
+	m.SetEnabled(true)
+	w := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	m.SetEnabled(false)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"webhook-processor/api/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestID gives every request an ID, taken from the X-Request-ID header
+// or generated, and echoes it on the response. Handlers read it with
+// apierror.RequestID, and webhook handlers pass it on to the worker with the
+// published event.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apierror.RequestID(c)
+		c.Next()
+	}
+}
@@ -1,10 +1,20 @@
 package middleware
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/metrics"
 
 	"github.com/gin-gonic/gin"
@@ -12,26 +22,73 @@ import (
 )
 
 type SecurityMiddleware struct {
-	logger       *zap.Logger
-	apiKeys      map[string]string // clientID -> apiKey
-	apiKeyHeader string
+	logger          *zap.Logger
+	apiKeys         map[string]string // clientID -> apiKey
+	apiKeyHeader    string
+	signatureHeader string
+	clock           clock.Clock
+
+	// adminClients may use the operator endpoints behind RequireAdmin
+	adminClients map[string]bool
+
+	// Replay protection; a zero tolerance disables it
+	timestampHeader    string
+	timestampTolerance time.Duration
+
+	// Request rate buckets for RateLimit, keyed by client
+	bucketsMu sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
 }
 
-func NewSecurityMiddleware(logger *zap.Logger, apiKeys map[string]string, apiKeyHeader string) *SecurityMiddleware {
+type rateBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// staleBucketAge is how long a request rate bucket may go unused before it
+// is evicted. It has long since refilled, so forgetting it changes nothing.
+const staleBucketAge = 48 * time.Hour
+
+// bucketSweepInterval is how often RateLimit looks for stale buckets.
+const bucketSweepInterval = time.Hour
+
+func NewSecurityMiddleware(logger *zap.Logger, apiKeys map[string]string, apiKeyHeader, signatureHeader string) *SecurityMiddleware {
 	return &SecurityMiddleware{
-		logger:       logger,
-		apiKeys:      apiKeys,
-		apiKeyHeader: apiKeyHeader,
+		logger:          logger,
+		apiKeys:         apiKeys,
+		apiKeyHeader:    apiKeyHeader,
+		signatureHeader: signatureHeader,
+		clock:           clock.New(),
+		buckets:         make(map[string]*rateBucket),
 	}
 }
 
+// EnableReplayProtection makes VerifySignature require a Unix timestamp in
+// header, within tolerance of the current time. The signature must then
+// cover the timestamp as well as the body ("<timestamp>.<body>"), so a
+// captured request can't be replayed later with a fresh timestamp.
+func (m *SecurityMiddleware) EnableReplayProtection(header string, tolerance time.Duration) {
+	m.timestampHeader = header
+	m.timestampTolerance = tolerance
+}
+
+// EnableAdmin lets the keys of clients through RequireAdmin.
+func (m *SecurityMiddleware) EnableAdmin(clients []string) {
+	m.adminClients = make(map[string]bool, len(clients))
+	for _, clientID := range clients {
+		m.adminClients[clientID] = true
+	}
+}
+
+// Authenticate identifies the client by its API key, setting "clientID" and
+// "admin" (whether it may use the operator endpoints) on the context.
 func (m *SecurityMiddleware) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader(m.apiKeyHeader)
 		if apiKey == "" {
-			m.logger.Warn("Missing API key", zap.String("ip", c.ClientIP()))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing API key"})
-			c.Abort()
+			m.logger.Warn("Missing API key", zap.String("ip", c.ClientIP()), zap.String("request_id", apierror.RequestID(c)))
+			apierror.Respond(c, http.StatusUnauthorized, apierror.CodeMissingAPIKey, "Missing API key")
 			return
 		}
 
@@ -41,24 +98,44 @@ func (m *SecurityMiddleware) Authenticate() gin.HandlerFunc {
 			if prefixLen > 8 {
 				prefixLen = 8
 			}
-			m.logger.Warn("Invalid API key", zap.String("ip", c.ClientIP()), zap.String("api_key_prefix", apiKey[:prefixLen]))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
-			c.Abort()
+			m.logger.Warn("Invalid API key",
+				zap.String("ip", c.ClientIP()),
+				zap.String("api_key_prefix", apiKey[:prefixLen]),
+				zap.String("request_id", apierror.RequestID(c)))
+			apierror.Respond(c, http.StatusUnauthorized, apierror.CodeInvalidAPIKey, "Invalid API key")
 			return
 		}
 
 		// Set client ID for later use
 		c.Set("clientID", clientID)
+		c.Set("admin", m.adminClients[clientID])
 		m.logger.Debug("Successfully authenticated client", zap.String("client_id", clientID))
 		c.Next()
 	}
 }
 
+// RequireAdmin rejects requests from clients that aren't admins. It must run
+// after Authenticate.
+func (m *SecurityMiddleware) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			m.logger.Warn("Non-admin client denied an operator endpoint",
+				zap.String("client_id", c.GetString("clientID")),
+				zap.String("path", c.FullPath()),
+				zap.String("request_id", apierror.RequestID(c)))
+			apierror.Respond(c, http.StatusForbidden, apierror.CodeForbidden, "API key is not authorized for operator endpoints")
+			return
+		}
+		c.Next()
+	}
+}
+
 func (m *SecurityMiddleware) CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, "+m.apiKeyHeader)
+		c.Header("Access-Control-Allow-Headers", "Content-Type, "+apierror.RequestIDHeader+", "+m.apiKeyHeader)
+		c.Header("Access-Control-Expose-Headers", apierror.RequestIDHeader)
 		c.Header("Access-Control-Max-Age", "3600")
 
 		if c.Request.Method == http.MethodOptions {
@@ -72,13 +149,6 @@ func (m *SecurityMiddleware) CORS() gin.HandlerFunc {
 
 func (m *SecurityMiddleware) RateLimit() gin.HandlerFunc {
 	// Simple token bucket implementation
-	type bucket struct {
-		tokens     float64
-		lastRefill time.Time
-	}
-
-	buckets := make(map[string]*bucket)
-
 	return func(c *gin.Context) {
 		clientID, exists := c.Get("clientID")
 		if !exists {
@@ -87,17 +157,24 @@ func (m *SecurityMiddleware) RateLimit() gin.HandlerFunc {
 		}
 
 		id := clientID.(string)
-		b, exists := buckets[id]
+		now := m.clock.Now()
+
+		m.bucketsMu.Lock()
+		if now.Sub(m.lastSweep) >= bucketSweepInterval {
+			m.lastSweep = now
+			m.evictStaleBuckets(now)
+		}
+		b, exists := m.buckets[id]
 		if !exists {
-			b = &bucket{
+			b = &rateBucket{
 				tokens:     10, // Initial tokens
-				lastRefill: time.Now(),
+				lastRefill: now,
 			}
-			buckets[id] = b
+			m.buckets[id] = b
+			metrics.RateLimitTrackedClients.WithLabelValues("request_rate").Set(float64(len(m.buckets)))
 		}
 
 		// Refill tokens
-		now := time.Now()
 		duration := now.Sub(b.lastRefill).Seconds()
 		maxTokens := 10.0
 		if b.tokens+duration > maxTokens {
@@ -107,31 +184,48 @@ func (m *SecurityMiddleware) RateLimit() gin.HandlerFunc {
 		}
 		b.lastRefill = now
 
-		if b.tokens < 1 {
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		m.bucketsMu.Unlock()
+
+		if !allowed {
 			metrics.RateLimitExceeded.WithLabelValues(id, "request_rate").Inc()
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
-			c.Abort()
+			apierror.Respond(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "Rate limit exceeded")
 			return
 		}
-
-		b.tokens--
 		c.Next()
 	}
 }
 
-func (m *SecurityMiddleware) ValidatePayload() gin.HandlerFunc {
+// evictStaleBuckets drops buckets unused for staleBucketAge. m.bucketsMu
+// must be held.
+func (m *SecurityMiddleware) evictStaleBuckets(now time.Time) {
+	for id, b := range m.buckets {
+		if now.Sub(b.lastRefill) > staleBucketAge {
+			delete(m.buckets, id)
+		}
+	}
+	metrics.RateLimitTrackedClients.WithLabelValues("request_rate").Set(float64(len(m.buckets)))
+}
+
+// ValidatePayload requires a JSON body. A request without a Content-Type is
+// let through or rejected according to missingContentType, matching the
+// webhook handlers.
+func (m *SecurityMiddleware) ValidatePayload(missingContentType string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Validate content type
-		if !strings.HasPrefix(c.GetHeader("Content-Type"), "application/json") {
-			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/json"})
-			c.Abort()
+		contentType := c.GetHeader("Content-Type")
+		if contentType == "" && missingContentType == config.MissingContentTypeReject ||
+			contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+			apierror.Respond(c, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, "Content-Type must be application/json")
 			return
 		}
 
 		// Continue only if there's a body
 		if c.Request.Body == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Empty request body"})
-			c.Abort()
+			apierror.Respond(c, http.StatusBadRequest, apierror.CodeEmptyBody, "Empty request body")
 			return
 		}
 
@@ -139,6 +233,81 @@ func (m *SecurityMiddleware) ValidatePayload() gin.HandlerFunc {
 	}
 }
 
+// VerifySignature rejects requests whose signature header isn't the
+// hex-encoded HMAC-SHA256 of the raw body under secret, optionally prefixed
+// with "sha256=". With replay protection enabled the timestamp is checked
+// first and included in the signed content. The body is reset afterwards so
+// handlers can read it. Failures are counted per client, taken from the
+// "clientID" context key if an earlier step identified one.
+func (m *SecurityMiddleware) VerifySignature(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to read request body")
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		clientID := c.GetString("clientID")
+		if clientID == "" {
+			clientID = "unknown"
+		}
+
+		signed := body
+		if m.timestampTolerance > 0 {
+			timestamp := c.GetHeader(m.timestampHeader)
+			if !m.freshTimestamp(timestamp) {
+				metrics.WebhookStaleSignatures.WithLabelValues(clientID).Inc()
+				m.logger.Warn("Rejecting webhook with stale or missing signature timestamp",
+					zap.String("client_id", clientID),
+					zap.String("timestamp", timestamp),
+					zap.String("ip", c.ClientIP()))
+				apierror.Respond(c, http.StatusUnauthorized, apierror.CodeStaleSignature, "Stale or missing signature timestamp")
+				return
+			}
+			signed = append([]byte(timestamp+"."), body...)
+		}
+
+		if !validSignature(signed, secret, c.GetHeader(m.signatureHeader)) {
+			metrics.WebhookSignatureFailures.WithLabelValues(clientID).Inc()
+			m.logger.Warn("Invalid webhook signature",
+				zap.String("client_id", clientID),
+				zap.String("ip", c.ClientIP()))
+			apierror.Respond(c, http.StatusUnauthorized, apierror.CodeInvalidSignature, "Invalid signature")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// freshTimestamp reports whether timestamp is a Unix time within the
+// tolerance of now, in either direction to allow for clock skew.
+func (m *SecurityMiddleware) freshTimestamp(timestamp string) bool {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := m.clock.Now().Sub(time.Unix(secs, 0))
+	return age <= m.timestampTolerance && age >= -m.timestampTolerance
+}
+
+// validSignature compares signature against the HMAC-SHA256 of body in
+// constant time.
+func validSignature(body []byte, secret, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
 func (m *SecurityMiddleware) validateAPIKey(apiKey string) string {
 	// Find client ID by API key
 	for clientID, key := range m.apiKeys {
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"webhook-processor/api/apierror"
+	"webhook-processor/config"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestValidatePayloadContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		policy      string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "json", policy: config.MissingContentTypeReject, contentType: "application/json; charset=utf-8", wantStatus: http.StatusOK},
+		{name: "other type", policy: config.MissingContentTypeAssumeJSON, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "missing, assume json", policy: config.MissingContentTypeAssumeJSON, wantStatus: http.StatusOK},
+		{name: "missing, reject", policy: config.MissingContentTypeReject, wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSecurityMiddleware(zap.NewNop(), nil, "X-API-Key", "X-MailerCloud-Signature")
+			r := gin.New()
+			r.POST("/webhook", m.ValidatePayload(tt.policy), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened"}`))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, apierror.CodeUnsupportedMediaType, errorCode(t, w))
+			}
+		})
+	}
+}
+
+func TestValidatePayloadEmptyBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewSecurityMiddleware(zap.NewNop(), nil, "X-API-Key", "X-MailerCloud-Signature")
+	r := gin.New()
+	r.POST("/webhook", m.ValidatePayload(config.MissingContentTypeReject), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Body = nil
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, apierror.CodeEmptyBody, errorCode(t, w))
+}
+
+// errorCode returns the code of the structured error response in w.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) apierror.Code {
+	t.Helper()
+	var body apierror.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Error.Code
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const body = `{"event":"opened","email":"a@example.com"}`
+
+	tests := []struct {
+		name       string
+		signature  string
+		wantStatus int
+	}{
+		{name: "valid", signature: sign("s3cret", body), wantStatus: http.StatusOK},
+		{name: "valid with prefix", signature: "sha256=" + sign("s3cret", body), wantStatus: http.StatusOK},
+		{name: "wrong secret", signature: sign("other", body), wantStatus: http.StatusUnauthorized},
+		{name: "not hex", signature: "not-a-signature", wantStatus: http.StatusUnauthorized},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := metrics.WebhookSignatureFailures.WithLabelValues("client-a")
+			before := testutil.ToFloat64(failures)
+
+			m := NewSecurityMiddleware(zap.NewNop(), nil, "X-API-Key", "X-MailerCloud-Signature")
+			r := gin.New()
+			var seen string
+			r.POST("/webhook",
+				func(c *gin.Context) { c.Set("clientID", "client-a") },
+				m.VerifySignature("s3cret"),
+				func(c *gin.Context) {
+					b, _ := io.ReadAll(c.Request.Body)
+					seen = string(b)
+					c.Status(http.StatusOK)
+				})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			if tt.signature != "" {
+				req.Header.Set("X-MailerCloud-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, body, seen, "handler can still read the body")
+				assert.Equal(t, before, testutil.ToFloat64(failures))
+			} else {
+				assert.Empty(t, seen, "handler must not run")
+				assert.Equal(t, before+1, testutil.ToFloat64(failures))
+				assert.Equal(t, apierror.CodeInvalidSignature, errorCode(t, w))
+			}
+		})
+	}
+}
+
+func TestVerifySignatureReplayProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const body = `{"event":"opened","email":"a@example.com"}`
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(at time.Time) string { return strconv.FormatInt(at.Unix(), 10) }
+
+	tests := []struct {
+		name       string
+		timestamp  string
+		signature  string
+		wantStatus int
+		wantStale  bool
+	}{
+		{name: "in window", timestamp: ts(now.Add(-time.Minute)), signature: sign("s3cret", ts(now.Add(-time.Minute))+"."+body), wantStatus: http.StatusOK},
+		{name: "slightly ahead", timestamp: ts(now.Add(time.Minute)), signature: sign("s3cret", ts(now.Add(time.Minute))+"."+body), wantStatus: http.StatusOK},
+		{name: "stale", timestamp: ts(now.Add(-10 * time.Minute)), signature: sign("s3cret", ts(now.Add(-10*time.Minute))+"."+body), wantStatus: http.StatusUnauthorized, wantStale: true},
+		{name: "too far ahead", timestamp: ts(now.Add(10 * time.Minute)), signature: sign("s3cret", ts(now.Add(10*time.Minute))+"."+body), wantStatus: http.StatusUnauthorized, wantStale: true},
+		{name: "missing timestamp", signature: sign("s3cret", body), wantStatus: http.StatusUnauthorized, wantStale: true},
+		{name: "not a number", timestamp: "yesterday", signature: sign("s3cret", "yesterday."+body), wantStatus: http.StatusUnauthorized, wantStale: true},
+		{name: "timestamp not signed", timestamp: ts(now), signature: sign("s3cret", body), wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale := metrics.WebhookStaleSignatures.WithLabelValues("client-a")
+			before := testutil.ToFloat64(stale)
+
+			m := NewSecurityMiddleware(zap.NewNop(), nil, "X-API-Key", "X-MailerCloud-Signature")
+			m.clock = clock.NewMock(now)
+			m.EnableReplayProtection("X-MailerCloud-Timestamp", 5*time.Minute)
+			r := gin.New()
+			r.POST("/webhook",
+				func(c *gin.Context) { c.Set("clientID", "client-a") },
+				m.VerifySignature("s3cret"),
+				func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			req.Header.Set("X-MailerCloud-Signature", tt.signature)
+			if tt.timestamp != "" {
+				req.Header.Set("X-MailerCloud-Timestamp", tt.timestamp)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStale {
+				assert.Equal(t, before+1, testutil.ToFloat64(stale))
+				assert.Equal(t, apierror.CodeStaleSignature, errorCode(t, w))
+			} else {
+				assert.Equal(t, before, testutil.ToFloat64(stale))
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	m := NewSecurityMiddleware(zap.NewNop(), map[string]string{"ops": "ops-key", "client-a": "key-a"}, "X-API-Key", "X-Signature")
+	m.EnableAdmin([]string{"ops"})
+	r := gin.New()
+	r.GET("/admin/throughput", m.Authenticate(), m.RequireAdmin(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for key, want := range map[string]int{"ops-key": http.StatusOK, "key-a": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/throughput", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, key)
+	}
+}
+
+func TestRateLimitEvictsStaleBuckets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(now)
+	m := NewSecurityMiddleware(zap.NewNop(), nil, "X-API-Key", "X-Signature")
+	m.clock = clk
+	m.buckets["spoofed"] = &rateBucket{tokens: 10, lastRefill: now.Add(-72 * time.Hour)}
+	m.buckets["recent"] = &rateBucket{tokens: 10, lastRefill: now.Add(-time.Hour)}
+
+	r := gin.New()
+	r.POST("/webhook", func(c *gin.Context) { c.Set("clientID", "client-a") }, m.RateLimit(),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, m.buckets, "spoofed")
+	assert.Contains(t, m.buckets, "recent")
+	assert.Contains(t, m.buckets, "client-a")
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.RateLimitTrackedClients.WithLabelValues("request_rate")))
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Readiness reports whether the API has finished warming up.
+type Readiness interface {
+	Ready() bool
+}
+
+// RequireReady answers 503 with a Retry-After header until r is ready, so
+// requests that arrive during warmup are retried rather than handled with a
+// cold mapping and fresh rate limits.
+func RequireReady(r Readiness, retryAfter time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r.Ready() {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service is starting up, retry later"})
+		c.Abort()
+	}
+}
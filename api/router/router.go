@@ -1,12 +1,28 @@
 package router
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"webhook-processor/api/apierror"
 	"webhook-processor/api/handlers"
 	"webhook-processor/api/middleware"
 	"webhook-processor/config"
 	"webhook-processor/internal/mapping"
+	"webhook-processor/internal/provider"
+	"webhook-processor/internal/provider/mailercloud"
 	"webhook-processor/internal/queue"
+	"webhook-processor/internal/stats"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/warmup"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/logger"
 
 	"github.com/gin-gonic/gin"
@@ -19,21 +35,58 @@ type WebhookHandler interface {
 	HandleWebhook(c *gin.Context)
 }
 
-func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config) *gin.Engine {
+// warmupRetryAfter is the Retry-After sent with webhooks rejected during
+// warmup.
+const warmupRetryAfter = 5 * time.Second
+
+// rateLimitJanitorInterval is how often stale rate limiter clients are
+// evicted.
+const rateLimitJanitorInterval = time.Hour
+
+// Setup builds the HTTP router. store may be nil when MongoDB is not
+// configured for the API process. opts are passed to the webhook handlers.
+// Background jobs the router starts run until ctx is done.
+//
+// The webhook mapping and rate limit usage are loaded by warmer's steps,
+// and webhooks are rejected until it has run; the caller runs it once the
+// server is listening. With a nil warmer they are loaded before Setup
+// returns.
+func Setup(ctx context.Context, logger *logger.Logger, publisher queue.Publisher, store storage.EventStore, cfg *config.Config, warmer *warmup.Warmer, opts ...handlers.Option) *gin.Engine {
 	router := gin.Default()
 
+	runWarmup := warmer == nil
+	if runWarmup {
+		warmer = warmup.NewWarmer(logger.Desugar())
+	}
+
 	// Initialize webhook mapping service
 	webhookMapper := mapping.NewWebhookMappingService(logger.Desugar())
 	if webhookMapper == nil {
 		logger.Desugar().Error("Failed to initialize webhook mapping service")
 	} else {
-		// Load webhook mappings from environment
-		if err := webhookMapper.LoadMappingFromEnvironment(); err != nil {
-			logger.Desugar().Error("Failed to load webhook mappings", zap.Error(err))
-			// Continue without mappings - will fall back to domain-based identification
-		} else {
-			logger.Desugar().Info("Successfully loaded webhook mappings from environment")
+		// Keep the last good mapping in MongoDB, for when MailerCloud is
+		// down at startup
+		if cache, ok := store.(mapping.Cache); ok {
+			webhookMapper.EnableCache(cache)
 		}
+		// Webhooks mapped to a client by hand, ahead of MailerCloud's mapping
+		if overrides, ok := store.(mapping.OverrideStore); ok {
+			webhookMapper.EnableOverrides(overrides)
+		}
+		warmer.Add("mapping", func(ctx context.Context) error {
+			// Keep refreshing even if the first load fails, so the mapping
+			// recovers once MailerCloud is reachable.
+			if cfg.Webhook.MappingRefreshInterval > 0 {
+				webhookMapper.StartPeriodicRefresh(ctx, cfg.Webhook.MappingRefreshInterval)
+			}
+
+			// Load webhook mappings from MailerCloud, or the cache
+			if err := webhookMapper.Refresh(ctx); err != nil {
+				// Continue without mappings - will fall back to domain-based identification
+				return fmt.Errorf("failed to load webhook mappings: %v", err)
+			}
+			return nil
+		})
 	}
 
 	// Initialize security middleware
@@ -41,28 +94,133 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 		logger.Desugar(),
 		cfg.Security.APIKeys,
 		cfg.Security.APIKeyHeader,
+		cfg.Security.SignatureHeader,
 	)
+	if cfg.Security.SignatureTolerance > 0 {
+		security.EnableReplayProtection(cfg.Security.SignatureTimestampHeader, cfg.Security.SignatureTolerance)
+	}
+	security.EnableAdmin(cfg.Security.AdminClients)
 
 	// Apply global middleware
+	router.Use(middleware.RequestID())
 	router.Use(security.CORS())
 
+	// Maintenance mode only affects webhook ingestion; health and metrics stay up
+	maintenance := middleware.NewMaintenance(logger.Desugar(), cfg.Webhook.Maintenance, cfg.Webhook.MaintenanceRetryAfter)
+
 	// Health check endpoint (no authentication required)
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Kubernetes probes (no authentication required): live only reports the
+	// process is up, ready also checks warmup, the broker connection and
+	// MongoDB, as webhooks are only accepted once warmup has completed
+	healthHandler := handlers.NewHealthHandler(newReadinessChecker(publisher, store, warmer))
+	router.GET("/health/live", healthHandler.Live)
+	router.GET("/health/ready", healthHandler.Ready)
+
 	// Metrics endpoint for Prometheus (no authentication required)
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	if !cfg.Monitoring.DisablePrometheus {
+		router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	}
+
+	// Rolling per-client event counts for the admin throughput endpoint
+	throughput := stats.NewThroughputCounter(time.Minute, clock.New())
+	handlerOpts := append([]handlers.Option{
+		handlers.WithThroughputCounter(throughput),
+	}, opts...)
+
+	// Per-client rate limits shared by the webhook handlers, picking up
+	// today's usage from storage so a restart doesn't reset daily quotas
+	limiter := handlers.NewRateLimiter(clock.New(),
+		handlers.WithPlans(
+			handlers.Plan{
+				DailyLimit:       cfg.RateLimit.FreeDailyLimit,
+				WebhookLimit:     cfg.RateLimit.FreeWebhookLimit,
+				DailyStoredLimit: cfg.RateLimit.FreeDailyStoredLimit,
+			},
+			handlers.Plan{
+				DailyLimit:       cfg.RateLimit.PremiumDailyLimit,
+				WebhookLimit:     cfg.RateLimit.PremiumWebhookLimit,
+				DailyStoredLimit: cfg.RateLimit.PremiumDailyStoredLimit,
+			}),
+		handlers.WithPremiumClients(cfg.RateLimit.PremiumClients),
+		handlers.WithPerSecondLimit(cfg.Webhook.PerSecondLimit, cfg.Webhook.PerSecondBurst))
+	if counter, ok := store.(storage.UsageCounter); ok {
+		warmer.Add("rate-limits", func(ctx context.Context) error {
+			since := time.Now().UTC().Truncate(24 * time.Hour)
+			usage, err := counter.CountEventsByClientSince(ctx, since)
+			if err != nil {
+				return fmt.Errorf("failed to load today's usage: %v", err)
+			}
+			limiter.RestoreDailyUsage(usage, since)
+			return nil
+		})
+	}
+	// Forget clients not seen for days
+	go limiter.RunJanitor(ctx, rateLimitJanitorInterval)
+	if cfg.RateLimit.FreeDailyStoredLimit > 0 || cfg.RateLimit.PremiumDailyStoredLimit > 0 {
+		handlerOpts = append(handlerOpts, handlers.WithStorageQuota(limiter, cfg.RateLimit.OverQuota))
+	}
 
 	// Initialize webhook handler (debug or production based on environment)
 	var webhookHandler WebhookHandler
+	// streamBody is set for a handler that decodes the body as it's read,
+	// so the router must not buffer it first
+	streamBody := false
 	if os.Getenv("WEBHOOK_DEBUG") == "true" {
 		logger.Desugar().Info("Initializing DEBUG webhook handler")
-		webhookHandler = handlers.NewDebugMailerCloudWebhookHandler(logger.Desugar(), publisher, webhookMapper)
+		webhookHandler = handlers.NewDebugMailerCloudWebhookHandler(logger.Desugar(), publisher, webhookMapper, limiter, cfg.Webhook, handlerOpts...)
+		streamBody = true
+	} else if len(cfg.Webhook.DebugClients) > 0 {
+		logger.Desugar().Info("Initializing PRODUCTION webhook handler with per-client debug",
+			zap.Strings("debug_clients", cfg.Webhook.DebugClients))
+		webhookHandler = handlers.NewClientDebugWebhookHandler(logger.Desugar(), publisher, webhookMapper, limiter, cfg.Webhook, handlerOpts...)
 	} else {
 		logger.Desugar().Info("Initializing PRODUCTION webhook handler")
-		webhookHandler = handlers.NewMailerCloudWebhookHandler(logger.Desugar(), publisher, webhookMapper)
+		webhookHandler = handlers.NewMailerCloudWebhookHandler(logger.Desugar(), publisher, webhookMapper, limiter, cfg.Webhook, handlerOpts...)
+	}
+
+	// Admin endpoints (API key required). Stats, reprocess and replay are
+	// scoped to the caller's own client unless it is an admin; the rest
+	// act on every client and need an admin key.
+	adminHandler := handlers.NewAdminHandler(logger.Desugar(), throughput, publisher, store)
+	admin := router.Group("/admin", security.Authenticate())
+	admin.GET("/stats/:clientID", adminHandler.ClientStats)
+	admin.POST("/reprocess/:webhookID", adminHandler.Reprocess)
+	admin.POST("/replay", adminHandler.Replay)
+	admin = admin.Group("", security.RequireAdmin())
+	admin.GET("/throughput", adminHandler.Throughput)
+	statusHandler := handlers.NewStatusHandler(newStatusChecker(publisher, store, webhookMapper, cfg.Monitoring.Status))
+	admin.GET("/status", statusHandler.Status)
+	maintenanceHandler := handlers.NewMaintenanceHandler(maintenance)
+	admin.GET("/maintenance", maintenanceHandler.Status)
+	admin.PUT("/maintenance", maintenanceHandler.Set)
+	captureHandler := handlers.NewCaptureHandler(logger.Desugar(), cfg.Webhook.DebugCaptureDir)
+	admin.GET("/captures", captureHandler.List)
+	admin.GET("/captures/download", captureHandler.Download)
+	if webhookMapper != nil {
+		mappingHandler := handlers.NewMappingHandler(logger.Desugar(), webhookMapper)
+		admin.GET("/mappings", mappingHandler.Get)
+		admin.POST("/mappings/refresh", mappingHandler.Refresh)
+		admin.PUT("/mapping/override", mappingHandler.Override)
+	}
+
+	// Read API for dashboards; each API key only sees its own client's events
+	var querier storage.EventQuerier
+	if q, ok := store.(storage.EventQuerier); ok {
+		querier = q
+	}
+	eventsHandler := handlers.NewEventsHandler(logger.Desugar(), querier)
+	router.GET("/events", security.Authenticate(), eventsHandler.List)
+
+	var dashboardStore handlers.DashboardStore
+	if d, ok := store.(handlers.DashboardStore); ok {
+		dashboardStore = d
 	}
+	dashboardHandler := handlers.NewDashboardHandler(logger.Desugar(), dashboardStore, clock.New())
+	router.GET("/dashboard/:clientID", security.Authenticate(), dashboardHandler.Get)
 
 	// Public webhook validation endpoint for MailerCloud (no authentication required)
 	router.GET("/webhook", func(c *gin.Context) {
@@ -73,8 +231,38 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 		})
 	})
 
-	// Webhook POST endpoint with conditional authentication
-	router.POST("/webhook", func(c *gin.Context) {
+	// While in maintenance, warming up or overloaded, webhooks are turned
+	// away before reading the body at all.
+	webhookRoutes := router.Group("", maintenance.Reject(), middleware.RequireReady(warmer, warmupRetryAfter))
+	if cfg.Webhook.LoadShed.Enabled() {
+		shedder := middleware.NewLoadShedder(logger.Desugar(), cfg.Webhook.LoadShed, clock.New())
+		webhookRoutes.Use(shedder.Shed())
+	}
+
+	// Reject oversized and truncated bodies before anything tries to parse
+	// them. Both checks buffer the body, so they are skipped for a handler
+	// that streams it: it enforces the size limit as it reads, and a
+	// truncated body fails to decode.
+	var bodyChecks []gin.HandlerFunc
+	if cfg.Webhook.MaxBodyBytes > 0 {
+		bodyChecks = append(bodyChecks, middleware.LimitBodySize(logger.Desugar(), cfg.Webhook.MaxBodyBytes))
+	}
+	if cfg.Webhook.ValidateContentLength {
+		bodyChecks = append(bodyChecks, middleware.ValidateContentLength(logger.Desugar()))
+	}
+	checkBody := func(c *gin.Context) bool {
+		for _, check := range bodyChecks {
+			check(c)
+			if c.IsAborted() {
+				return false
+			}
+		}
+		return true
+	}
+
+	// MailerCloud webhooks with conditional authentication
+	validationUA := handlers.ValidationUserAgent(cfg.Webhook.ValidationUserAgent)
+	handleMailerCloud := func(c *gin.Context) {
 		// Check if this is a MailerCloud validation request
 		webhookId := c.GetHeader("Webhook-Id")
 		webhookType := c.GetHeader("Webhook-Type")
@@ -91,25 +279,36 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 
 		// MailerCloud validation scenarios:
 		// 1. Webhook-Id header with "WebhookID" value (classic validation)
-		// 2. User-Agent contains "MailerCloud" (test requests)
+		// 2. User-Agent matches the validation pattern (test requests)
 		// 3. Empty payload with specific headers (URL validation)
 		isMailerCloudValidation := false
 
-		if webhookId == "WebhookID" || userAgent == "MailerCloud" {
+		if webhookId == "WebhookID" || validationUA.MatchString(userAgent) {
 			isMailerCloudValidation = true
 		}
 
-		// Also check for empty or minimal payload which indicates validation
-		var requestBody map[string]interface{}
-		if err := c.ShouldBindJSON(&requestBody); err == nil {
-			// If payload is empty or minimal, it's likely a validation request
-			if len(requestBody) == 0 || (len(requestBody) == 1 && requestBody["test"] != nil) {
-				isMailerCloudValidation = true
+		// Also check for empty or minimal payload which indicates
+		// validation; a streaming handler checks the payload itself
+		if !streamBody {
+			if !checkBody(c) {
+				return
+			}
+			bodyBytes, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to read request body")
+				return
+			}
+			var requestBody map[string]interface{}
+			if err := json.Unmarshal(bodyBytes, &requestBody); err == nil {
+				// If payload is empty or minimal, it's likely a validation request
+				if len(requestBody) == 0 || (len(requestBody) == 1 && requestBody["test"] != nil) {
+					isMailerCloudValidation = true
+				}
 			}
-		}
 
-		// Reset the request body for further processing
-		c.Request.Body = c.Request.Body
+			// Reset the request body for further processing
+			c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
 
 		if isMailerCloudValidation {
 			// This is MailerCloud validation - return success
@@ -132,6 +331,15 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 			logger.Desugar().Info("Processing MailerCloud webhook",
 				zap.String("webhook_id", webhookId),
 				zap.String("webhook_type", webhookType))
+			// Clients with a signing secret must sign their webhooks
+			clientID := webhookClient(webhookMapper, webhookId)
+			if secret, ok := signingSecret(cfg, webhookMapper, clientID); ok {
+				c.Set("clientID", clientID)
+				security.VerifySignature(secret)(c)
+				if c.IsAborted() {
+					return
+				}
+			}
 			webhookHandler.HandleWebhook(c)
 			return
 		}
@@ -139,7 +347,7 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 		// For other webhooks (non-MailerCloud), require authentication
 		apiKey := c.GetHeader(cfg.Security.APIKeyHeader)
 		if apiKey == "" {
-			c.JSON(401, gin.H{"error": "Missing API key"})
+			apierror.Respond(c, http.StatusUnauthorized, apierror.CodeMissingAPIKey, "Missing API key")
 			return
 		}
 
@@ -153,18 +361,92 @@ func Setup(logger *logger.Logger, publisher queue.Publisher, cfg *config.Config)
 		}
 
 		if !validKey {
-			c.JSON(401, gin.H{"error": "Invalid API key"})
+			apierror.Respond(c, http.StatusUnauthorized, apierror.CodeInvalidAPIKey, "Invalid API key")
 			return
 		}
 
 		// Process authenticated webhook
 		webhookHandler.HandleWebhook(c)
+	}
+
+	// Other providers require an API key
+	providers := provider.NewRegistry(mailercloud.New(logger.Desugar(), webhookMapper,
+		mailercloud.WithDeliveryIDHeader(cfg.Webhook.IdempotencyHeader)))
+	providerHandler := handlers.NewProviderWebhookHandler(logger.Desugar(), publisher, providers, limiter, cfg.Webhook, handlerOpts...)
+	handleProvider := func(c *gin.Context) {
+		if !checkBody(c) {
+			return
+		}
+		security.Authenticate()(c)
+		if c.IsAborted() {
+			return
+		}
+		providerHandler.HandleWebhook(c)
+	}
+
+	// /webhook is MailerCloud's unless the Webhook-Provider header names
+	// another provider; /webhook/:provider names it in the path
+	webhookRoutes.POST("/webhook", func(c *gin.Context) {
+		if name := c.GetHeader(provider.Header); name != "" && !strings.EqualFold(name, mailercloud.Name) {
+			handleProvider(c)
+			return
+		}
+		handleMailerCloud(c)
 	})
+	webhookRoutes.POST("/webhook/:provider", func(c *gin.Context) {
+		if strings.EqualFold(c.Param("provider"), mailercloud.Name) {
+			handleMailerCloud(c)
+			return
+		}
+		handleProvider(c)
+	})
+
+	// Other methods get a 405 naming the allowed ones instead of gin's 404
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		router.Handle(method, "/webhook", methodNotAllowed(http.MethodGet, http.MethodPost))
+		router.Handle(method, "/webhook/:provider", methodNotAllowed(http.MethodPost))
+	}
 
 	logger.Desugar().Info("Router configured with security middleware",
 		zap.String("api_key_header", cfg.Security.APIKeyHeader),
 		zap.Int("configured_clients", len(cfg.Security.APIKeys)),
 	)
 
+	if runWarmup {
+		warmer.Run(ctx)
+	}
+
 	return router
 }
+
+// methodNotAllowed answers 405 with an Allow header listing allowed.
+func methodNotAllowed(allowed ...string) gin.HandlerFunc {
+	allow := strings.Join(allowed, ", ")
+	return func(c *gin.Context) {
+		c.Header("Allow", allow)
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
+
+// webhookClient resolves the client a MailerCloud webhook belongs to the same
+// way the webhook handlers do: via the mapping, falling back to the ID itself.
+func webhookClient(mapper *mapping.WebhookMappingService, webhookID string) string {
+	if mapper != nil {
+		if clientID, ok := mapper.GetClientForWebhook(webhookID); ok {
+			return clientID
+		}
+	}
+	return webhookID
+}
+
+// signingSecret returns the secret a client's webhooks are signed with: the
+// configured one, or else the one fetched from MailerCloud with the mapping.
+func signingSecret(cfg *config.Config, mapper *mapping.WebhookMappingService, clientID string) (string, bool) {
+	if secret, ok := cfg.Security.SigningSecrets[clientID]; ok {
+		return secret, true
+	}
+	if mapper != nil {
+		return mapper.GetSigningSecretForClient(clientID)
+	}
+	return "", false
+}
@@ -0,0 +1,347 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/internal/warmup"
+	"webhook-processor/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(event models.WebhookEvent) error { return nil }
+func (nopPublisher) Close() error                            { return nil }
+
+func TestMaintenanceModeRejectsOnlyWebhooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Security: config.SecurityConfig{
+			APIKeys:      map[string]string{"ops": "admin-key"},
+			APIKeyHeader: "X-API-Key",
+			AdminClients: []string{"ops"},
+		},
+		Webhook: config.WebhookConfig{Maintenance: true, MaintenanceRetryAfter: 2 * time.Minute},
+	}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Webhook-Id", "client-a")
+		req.Header.Set("X-API-Key", "admin-key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPost, "/webhook", `{"event":"opened","email":"a@example.com"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/health", "").Code, "health checks stay up")
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/metrics", "").Code, "metrics stay up")
+
+	w = serve(http.MethodPut, "/admin/maintenance", `{"enabled":false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"enabled":false}`, w.Body.String())
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/webhook", `{"event":"opened","email":"a@example.com"}`).Code,
+		"webhooks are accepted again once maintenance is disabled")
+}
+
+func TestDashboardIsScopedToTheAuthenticatedClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Security: config.SecurityConfig{
+			APIKeys:      map[string]string{"client-a": "key-a", "client-b": "key-b"},
+			APIKeyHeader: "X-API-Key",
+		},
+	}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	serve := func(apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/dashboard/client-a", nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusForbidden, serve("key-b"), "another client's key can't read the dashboard")
+	assert.Equal(t, http.StatusServiceUnavailable, serve("key-a"), "the owner gets past auth; there's no store configured")
+}
+
+func TestOperatorEndpointsRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Security: config.SecurityConfig{
+			APIKeys:      map[string]string{"ops": "admin-key", "client-a": "key-a"},
+			APIKeyHeader: "X-API-Key",
+			AdminClients: []string{"ops"},
+		},
+	}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	serve := func(method, path, apiKey, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodGet, "/admin/throughput", ""},
+		{http.MethodGet, "/admin/maintenance", ""},
+		{http.MethodPut, "/admin/maintenance", `{"enabled":true}`},
+		{http.MethodGet, "/admin/status", ""},
+		{http.MethodGet, "/admin/captures", ""},
+	} {
+		assert.Equal(t, http.StatusForbidden, serve(route.method, route.path, "key-a", route.body), "%s %s", route.method, route.path)
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/maintenance", "admin-key", ""))
+}
+
+func TestWebhooksWaitForWarmup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Security: config.SecurityConfig{APIKeys: map[string]string{"client-a": "key-a"}, APIKeyHeader: "X-API-Key"},
+	}
+	warmer := warmup.NewWarmer(zap.NewNop())
+	release := make(chan struct{})
+	warmer.Add("blocked", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, warmer)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "key-a")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	go warmer.Run(context.Background())
+
+	w := serve(http.MethodGet, "/health/ready", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "not ready until warmup completes")
+	assert.Contains(t, w.Body.String(), `"failed":["warmup"]`)
+	w = serve(http.MethodPost, "/webhook", `{"event":"opened","email":"a@example.com"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "webhooks are turned away during warmup")
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/health", "").Code, "liveness is unaffected")
+
+	close(release)
+	<-warmer.Done()
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/health/ready", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/webhook", `{"event":"opened","email":"a@example.com"}`).Code)
+}
+
+func TestWebhookProviderRouting(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Security: config.SecurityConfig{APIKeys: map[string]string{"client-a": "key-a"}, APIKeyHeader: "X-API-Key"},
+	}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	serve := func(path string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"event":"opened","email":"a@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	mailerCloud := map[string]string{"Webhook-Id": "wh-1"}
+
+	assert.Equal(t, http.StatusOK, serve("/webhook", mailerCloud), "/webhook stays MailerCloud's")
+	assert.Equal(t, http.StatusOK, serve("/webhook/mailercloud", mailerCloud), "MailerCloud by path")
+	assert.Equal(t, http.StatusOK, serve("/webhook", map[string]string{"Webhook-Id": "wh-1", "Webhook-Provider": "MailerCloud"}))
+
+	assert.Equal(t, http.StatusUnauthorized, serve("/webhook/sendgrid", mailerCloud), "other providers need an API key")
+	assert.Equal(t, http.StatusNotFound, serve("/webhook/sendgrid", map[string]string{"X-API-Key": "key-a"}), "no such provider")
+	assert.Equal(t, http.StatusNotFound, serve("/webhook", map[string]string{"X-API-Key": "key-a", "Webhook-Provider": "sendgrid"}))
+}
+
+func TestWebhookSignedWithFetchedSecret(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"id":"wh-1","name":"events","secret":"s3cret"}]}`))
+	}))
+	defer api.Close()
+	t.Setenv("MAILERCLOUD_API_URL", api.URL)
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+
+	cfg := &config.Config{Security: config.SecurityConfig{SignatureHeader: "X-Signature"}}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	body := `{"event":"opened","email":"a@example.com"}`
+	serve := func(webhookID, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Webhook-Id", webhookID)
+		if signature != "" {
+			req.Header.Set("X-Signature", signature)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, http.StatusOK, serve("wh-1", signature))
+	assert.Equal(t, http.StatusUnauthorized, serve("wh-1", ""), "client-a's webhooks must be signed")
+	assert.Equal(t, http.StatusUnauthorized, serve("wh-1", strings.Repeat("0", 64)))
+	assert.Equal(t, http.StatusOK, serve("wh-unmapped", ""), "clients without a secret aren't checked")
+}
+
+func TestWebhookRejectsOversizedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{Webhook: config.WebhookConfig{MaxBodyBytes: 1024, ValidateContentLength: true}}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+
+	body := `{"event":"opened","email":"a@example.com","padding":"` + strings.Repeat("x", 2048) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "wh-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "Request body too large")
+}
+
+// disconnectedPublisher is a publisher whose broker connection is down.
+type disconnectedPublisher struct{ nopPublisher }
+
+func (disconnectedPublisher) IsConnected() bool { return false }
+
+// unreachableStore is a store whose MongoDB ping fails.
+type unreachableStore struct{ *storagetest.FakeStore }
+
+func (unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestHealthProbesCheckDependencies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(r *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), disconnectedPublisher{}, unreachableStore{storagetest.NewFakeStore()}, &config.Config{}, nil)
+
+	assert.Equal(t, http.StatusOK, serve(r, "/health/live").Code, "liveness doesn't depend on RabbitMQ or MongoDB")
+	w := serve(r, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"failed":["mongodb","rabbitmq"]`)
+	assert.Contains(t, w.Body.String(), "server selection timeout")
+
+	r = Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, &config.Config{}, nil)
+	assert.Equal(t, http.StatusOK, serve(r, "/health/ready").Code, "dependencies the API wasn't given aren't checked")
+}
+
+func TestWebhookRejectsOtherMethods(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, &config.Config{}, nil)
+
+	tests := []struct {
+		method, path, allow string
+	}{
+		{http.MethodPut, "/webhook", "GET, POST"},
+		{http.MethodDelete, "/webhook", "GET, POST"},
+		{http.MethodPatch, "/webhook", "GET, POST"},
+		{http.MethodPut, "/webhook/mailercloud", "POST"},
+		{http.MethodDelete, "/webhook/mailercloud", "POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, tt.allow, w.Header().Get("Allow"))
+			assert.JSONEq(t, `{"error":"Method not allowed"}`, w.Body.String())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "GET still answers validation requests")
+}
+
+// recordingPublisher records the events published through it.
+type recordingPublisher struct {
+	nopPublisher
+	mu     sync.Mutex
+	events []models.WebhookEvent
+}
+
+func (p *recordingPublisher) Publish(event models.WebhookEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestRequestIDPassedToPublishedEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pub := &recordingPublisher{}
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), pub, nil, &config.Config{}, nil)
+
+	post := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"event":"opened","email":"a@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Webhook-Id", "client-a")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("req-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"))
+
+	w = post("")
+	require.Equal(t, http.StatusOK, w.Code)
+	generated := w.Header().Get("X-Request-ID")
+	assert.NotEmpty(t, generated)
+
+	require.Len(t, pub.events, 2)
+	assert.Equal(t, "req-1", pub.events[0].RequestID)
+	assert.Equal(t, generated, pub.events[1].RequestID)
+}
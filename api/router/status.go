@@ -0,0 +1,159 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/health"
+	"webhook-processor/internal/mapping"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/warmup"
+)
+
+// connectionReporter is implemented by publishers that reconnect to the
+// broker in the background.
+type connectionReporter interface {
+	IsConnected() bool
+}
+
+// pinger is implemented by stores that can check their connection.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// newStatusChecker registers a check for every subsystem available to the
+// API process. The worker runs separately, so its state is inferred from the
+// work queue's consumers.
+func newStatusChecker(publisher queue.Publisher, store storage.EventStore, mapper *mapping.WebhookMappingService, cfg config.StatusConfig) *health.Checker {
+	checker := health.NewChecker(5 * time.Second)
+
+	if inspector, ok := publisher.(queue.Inspector); ok {
+		reporter, _ := publisher.(connectionReporter)
+		checker.Add("rabbitmq", true, func(ctx context.Context) health.Result {
+			return rabbitMQResult(inspector, reporter)
+		})
+		checker.Add("queue", false, func(ctx context.Context) health.Result {
+			return queueDepthResult(inspector, cfg.MaxQueueDepth)
+		})
+		checker.Add("worker", false, func(ctx context.Context) health.Result {
+			return workerResult(inspector)
+		})
+	}
+
+	if p, ok := store.(pinger); ok {
+		checker.Add("mongodb", false, func(ctx context.Context) health.Result {
+			if err := p.Ping(ctx); err != nil {
+				return health.Result{Status: health.StatusDown, Detail: err.Error()}
+			}
+			return health.Result{Status: health.StatusOK}
+		})
+	}
+
+	checker.Add("mailercloud", false, health.HTTPReachable(&http.Client{}, mapping.MailerCloudAPIURL))
+
+	if mapper != nil {
+		checker.Add("mapping", false, func(ctx context.Context) health.Result {
+			return mappingResult(mapper.LastUpdated(), mapper.WebhookCount(), cfg.MappingMaxAge, time.Now())
+		})
+	}
+
+	return checker
+}
+
+// readinessTimeout bounds the dependency probes behind /health/ready, so a
+// hung dependency fails the probe instead of stalling it.
+const readinessTimeout = 2 * time.Second
+
+// newReadinessChecker checks what the API needs to accept webhooks: warmup
+// having completed, the publisher's broker connection and MongoDB. Every
+// check is critical. Dependencies the process wasn't given aren't checked.
+func newReadinessChecker(publisher queue.Publisher, store storage.EventStore, warmer *warmup.Warmer) *health.Checker {
+	checker := health.NewChecker(readinessTimeout)
+
+	checker.Add("warmup", true, func(ctx context.Context) health.Result {
+		if !warmer.Ready() {
+			return health.Result{Status: health.StatusDown, Detail: "warming up"}
+		}
+		return health.Result{Status: health.StatusOK}
+	})
+
+	if reporter, ok := publisher.(connectionReporter); ok {
+		checker.Add("rabbitmq", true, func(ctx context.Context) health.Result {
+			if !reporter.IsConnected() {
+				return health.Result{Status: health.StatusDown, Detail: "reconnecting"}
+			}
+			return health.Result{Status: health.StatusOK}
+		})
+	}
+
+	if p, ok := store.(pinger); ok {
+		checker.Add("mongodb", true, func(ctx context.Context) health.Result {
+			if err := p.Ping(ctx); err != nil {
+				return health.Result{Status: health.StatusDown, Detail: err.Error()}
+			}
+			return health.Result{Status: health.StatusOK}
+		})
+	}
+
+	return checker
+}
+
+// rabbitMQResult reports the broker down while the publisher is reconnecting
+// or the work queue can't be inspected. reporter may be nil.
+func rabbitMQResult(inspector queue.Inspector, reporter connectionReporter) health.Result {
+	if reporter != nil && !reporter.IsConnected() {
+		return health.Result{Status: health.StatusDown, Detail: "reconnecting"}
+	}
+	if _, err := inspector.QueueStats(); err != nil {
+		return health.Result{Status: health.StatusDown, Detail: err.Error()}
+	}
+	return health.Result{Status: health.StatusOK}
+}
+
+func queueDepthResult(inspector queue.Inspector, maxDepth int) health.Result {
+	stats, err := inspector.QueueStats()
+	if err != nil {
+		return health.Result{Status: health.StatusDown, Detail: err.Error()}
+	}
+	result := health.Result{Status: health.StatusOK, Data: map[string]interface{}{"messages": stats.Messages}}
+	if maxDepth > 0 && stats.Messages > maxDepth {
+		result.Status = health.StatusDegraded
+		result.Detail = fmt.Sprintf("%d messages waiting, above %d", stats.Messages, maxDepth)
+	}
+	return result
+}
+
+func workerResult(inspector queue.Inspector) health.Result {
+	stats, err := inspector.QueueStats()
+	if err != nil {
+		return health.Result{Status: health.StatusDown, Detail: err.Error()}
+	}
+	result := health.Result{Status: health.StatusOK, Data: map[string]interface{}{"consumers": stats.Consumers}}
+	if stats.Consumers == 0 {
+		result.Status = health.StatusDown
+		result.Detail = "no workers consuming the queue"
+	}
+	return result
+}
+
+func mappingResult(lastUpdated time.Time, webhooks int, maxAge time.Duration, now time.Time) health.Result {
+	age := now.Sub(lastUpdated)
+	result := health.Result{Status: health.StatusOK, Data: map[string]interface{}{
+		"webhooks":     webhooks,
+		"last_updated": lastUpdated,
+		"age_seconds":  int64(age.Seconds()),
+	}}
+	switch {
+	case webhooks == 0:
+		result.Status = health.StatusDegraded
+		result.Detail = "no webhooks mapped"
+	case maxAge > 0 && age > maxAge:
+		result.Status = health.StatusDegraded
+		result.Detail = fmt.Sprintf("mapping is %s old, above %s", age.Round(time.Second), maxAge)
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/health"
+	"webhook-processor/internal/queue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubInspector struct {
+	stats queue.QueueStats
+	err   error
+}
+
+func (s stubInspector) QueueStats() (queue.QueueStats, error) {
+	return s.stats, s.err
+}
+
+func TestQueueDepthResult(t *testing.T) {
+	assert.Equal(t, health.StatusOK, queueDepthResult(stubInspector{stats: queue.QueueStats{Messages: 10}}, 100).Status)
+	assert.Equal(t, health.StatusDegraded, queueDepthResult(stubInspector{stats: queue.QueueStats{Messages: 101}}, 100).Status)
+	assert.Equal(t, health.StatusOK, queueDepthResult(stubInspector{stats: queue.QueueStats{Messages: 101}}, 0).Status, "zero disables the threshold")
+	assert.Equal(t, health.StatusDown, queueDepthResult(stubInspector{err: errors.New("channel closed")}, 100).Status)
+}
+
+func TestWorkerResult(t *testing.T) {
+	assert.Equal(t, health.StatusOK, workerResult(stubInspector{stats: queue.QueueStats{Consumers: 2}}).Status)
+	assert.Equal(t, health.StatusDown, workerResult(stubInspector{stats: queue.QueueStats{Consumers: 0}}).Status)
+}
+
+func TestMappingResult(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, health.StatusOK, mappingResult(now.Add(-time.Hour), 3, 24*time.Hour, now).Status)
+	assert.Equal(t, health.StatusDegraded, mappingResult(now.Add(-25*time.Hour), 3, 24*time.Hour, now).Status)
+	assert.Equal(t, health.StatusDegraded, mappingResult(now, 0, 24*time.Hour, now).Status)
+}
+
+type stubReporter bool
+
+func (s stubReporter) IsConnected() bool { return bool(s) }
+
+func TestRabbitMQResult(t *testing.T) {
+	assert.Equal(t, health.StatusOK, rabbitMQResult(stubInspector{}, stubReporter(true)).Status)
+	assert.Equal(t, health.StatusOK, rabbitMQResult(stubInspector{}, nil).Status)
+	reconnecting := rabbitMQResult(stubInspector{}, stubReporter(false))
+	assert.Equal(t, health.StatusDown, reconnecting.Status)
+	assert.Equal(t, "reconnecting", reconnecting.Detail)
+	assert.Equal(t, health.StatusDown, rabbitMQResult(stubInspector{err: errors.New("channel closed")}, stubReporter(true)).Status)
+}
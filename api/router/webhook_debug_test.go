@@ -0,0 +1,96 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"webhook-processor/config"
+	"webhook-processor/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureCheckingBody notes whether the debug handler had already opened
+// its capture file when the body was read to the end, which it can't have
+// if something buffered the body first.
+type captureCheckingBody struct {
+	t          *testing.T
+	r          io.Reader
+	captureDir string
+	eofs       int
+	streamed   bool
+}
+
+func (b *captureCheckingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.eofs++
+		captures, globErr := filepath.Glob(filepath.Join(b.captureDir, "raw_webhook_data_*.json"))
+		require.NoError(b.t, globErr)
+		b.streamed = len(captures) == 1
+	}
+	return n, err
+}
+
+func (b *captureCheckingBody) Close() error { return nil }
+
+func serveDebugWebhook(t *testing.T, cfg *config.Config, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	r := Setup(t.Context(), logger.NewLogger("error", logger.BaseFields{}), nopPublisher{}, nil, cfg, nil)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", "wh-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDebugWebhookBodyIsStreamed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("WEBHOOK_DEBUG", "true")
+	captureDir := t.TempDir()
+	cfg := &config.Config{Webhook: config.WebhookConfig{
+		MaxBodyBytes:          8 << 20,
+		ValidateContentLength: true,
+		DebugCaptureDir:       captureDir,
+	}}
+
+	payload := `{"event":"opened","email":"a@example.com","html":"` + strings.Repeat("x", 4<<20) + `"}`
+	body := &captureCheckingBody{t: t, r: strings.NewReader(payload), captureDir: captureDir}
+	w := serveDebugWebhook(t, cfg, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, body.eofs, "body read once")
+	assert.True(t, body.streamed, "body streamed into the capture file rather than buffered first")
+
+	captures, err := filepath.Glob(filepath.Join(captureDir, "raw_webhook_data_*.json"))
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	raw, err := os.ReadFile(captures[0])
+	require.NoError(t, err)
+	var capture struct {
+		Body json.RawMessage `json:"body"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &capture))
+	assert.JSONEq(t, payload, string(capture.Body))
+}
+
+func TestDebugWebhookRejectsOversizedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("WEBHOOK_DEBUG", "true")
+	cfg := &config.Config{Webhook: config.WebhookConfig{MaxBodyBytes: 1024, DebugCaptureDir: t.TempDir()}}
+
+	body := `{"event":"opened","email":"a@example.com","padding":"` + strings.Repeat("x", 2048) + `"}`
+	w := serveDebugWebhook(t, cfg, strings.NewReader(body))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "Request body too large")
+}
@@ -19,7 +19,11 @@ func main() {
 	}
 
 	// Initialize logger
-	logger := logger.NewLogger(cfg.LogLevel)
+	logger := logger.NewLogger(cfg.LogLevel, logger.BaseFields{
+		Service:  cfg.Logging.ServiceName("webhook-api"),
+		Env:      cfg.Logging.Env,
+		Instance: cfg.Logging.Instance,
+	})
 
 	// Initialize server
 	srv := server.NewServer(cfg, logger)
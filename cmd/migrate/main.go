@@ -0,0 +1,87 @@
+// Command migrate re-derives stored events from their raw payloads using the
+// current parser, after a change to the WebhookEvent schema or field mapping.
+//
+// Progress is written to -cursor-file after every batch; running the command
+// again with the same file resumes where it stopped.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/migrate"
+	"webhook-processor/internal/provider/mailercloud"
+	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	cursorFile := flag.String("cursor-file", "reparse.cursor", "file the resume cursor is read from and saved to")
+	batchSize := flag.Int("batch-size", 500, "events re-parsed per batch")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := logger.NewLogger(cfg.LogLevel, logger.BaseFields{
+		Service:  cfg.Logging.ServiceName("webhook-migrate"),
+		Env:      cfg.Logging.Env,
+		Instance: cfg.Logging.Instance,
+	})
+
+	db, err := storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar(),
+		storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections))
+	if err != nil {
+		logger.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer db.Close(context.Background())
+
+	cursor, err := readCursor(*cursorFile)
+	if err != nil {
+		logger.Fatalf("Failed to read cursor: %v", err)
+	}
+	if cursor != "" {
+		logger.Infof("Resuming after cursor %s", cursor)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	reparser := migrate.NewReparser(db, mailercloud.ExtractEventFields, *batchSize, logger.Desugar(), func(p migrate.Progress) error {
+		return os.WriteFile(*cursorFile, []byte(p.Cursor+"\n"), 0644)
+	})
+	progress, err := reparser.Run(ctx, cursor)
+	if err != nil {
+		logger.Desugar().Fatal("Re-parse stopped; run again to resume",
+			zap.Error(err),
+			zap.Int("updated", progress.Updated),
+			zap.String("cursor", progress.Cursor))
+	}
+
+	logger.Desugar().Info("Re-parse complete",
+		zap.Int("updated", progress.Updated),
+		zap.String("cursor", progress.Cursor))
+}
+
+// readCursor returns the saved cursor, or "" if there is none yet.
+func readCursor(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
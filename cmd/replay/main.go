@@ -0,0 +1,141 @@
+// Command replay re-publishes events that ran out of retries and are stored
+// as failed, optionally only one client's or those received in a time
+// range, with their retry count reset. Each is marked pending once
+// published, so running it again only picks up what is still failed.
+//
+// With -dry-run it only counts the events it would replay, without
+// connecting to RabbitMQ.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/reprocess"
+	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	clientID := flag.String("client", "", "only replay this client's failed events")
+	last := flag.Duration("last", 0, "only replay events received in this long before now, e.g. 24h (instead of -from/-to)")
+	from := flag.String("from", "", "only replay events received at or after this time, RFC 3339")
+	to := flag.String("to", "", "only replay events received before this time, RFC 3339")
+	dryRun := flag.Bool("dry-run", false, "count the failed events without replaying them")
+	logEvery := flag.Int("log-every", 100, "log progress every this many events")
+	flag.Parse()
+
+	window, err := parseRange(*last, *from, *to, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("Invalid time range: %v", err)
+	}
+	window.ClientID = *clientID
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := logger.NewLogger(cfg.LogLevel, logger.BaseFields{
+		Service:  cfg.Logging.ServiceName("webhook-replay"),
+		Env:      cfg.Logging.Env,
+		Instance: cfg.Logging.Instance,
+	})
+
+	db, err := storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar(),
+		storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections))
+	if err != nil {
+		logger.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer db.Close(context.Background())
+
+	// Clients with their own store are read from it
+	var store reprocess.FailedStore = db
+	if len(cfg.MongoDB.ClientStores) > 0 {
+		byClient, clientDBs, err := storage.OpenClientStores(cfg.MongoDB, logger.Desugar(),
+			storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections))
+		if err != nil {
+			logger.Fatalf("Failed to connect to client stores: %v", err)
+		}
+		for _, clientDB := range clientDBs {
+			defer clientDB.Close(context.Background())
+		}
+		store = storage.NewClientRouter(db, byClient)
+	}
+
+	// A dry run never publishes
+	var publisher queue.Publisher
+	if !*dryRun {
+		queueArgs, err := queue.QueueArgs(cfg.RabbitMQ)
+		if err != nil {
+			logger.Fatalf("Invalid RabbitMQ queue settings: %v", err)
+		}
+		rabbit, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, cfg.RabbitMQ.Exchange, cfg.RabbitMQ.QueueName, queueArgs, logger.Desugar(),
+			queue.WithReconnect(cfg.RabbitMQ.ReconnectDelay, cfg.RabbitMQ.ReconnectMaxDelay, cfg.RabbitMQ.ReconnectTimeout),
+			queue.WithEventRoutingKeys(cfg.RabbitMQ.EventRoutingKeys))
+		if err != nil {
+			logger.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		}
+		defer rabbit.Close()
+		publisher = rabbit
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	replayer := reprocess.NewFailedReplayer(store, publisher, *logEvery, logger.Desugar())
+	result, err := replayer.Run(ctx, window, *dryRun)
+	fields := []zap.Field{
+		zap.String("client_id", window.ClientID),
+		zap.Time("from", window.From),
+		zap.Time("to", window.To),
+		zap.Int("found", result.Found),
+		zap.Int("republished", result.Republished),
+	}
+	if err != nil {
+		logger.Desugar().Fatal("Replay stopped; run again to replay the events still failed", append(fields, zap.Error(err))...)
+	}
+	if *dryRun {
+		logger.Desugar().Info("Dry run: failed events that would be replayed", fields...)
+		return
+	}
+	logger.Desugar().Info("Replay complete", fields...)
+}
+
+// parseRange builds the window from -last or -from/-to, leaving bounds that
+// aren't given open.
+func parseRange(last time.Duration, from, to string, now time.Time) (reprocess.Window, error) {
+	var window reprocess.Window
+	if last > 0 {
+		if from != "" || to != "" {
+			return window, errors.New("use either -last or -from/-to")
+		}
+		window.From = now.Add(-last)
+		return window, nil
+	}
+
+	var err error
+	if from != "" {
+		if window.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return window, err
+		}
+	}
+	if to != "" {
+		if window.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return window, err
+		}
+	}
+	if !window.From.IsZero() && !window.To.IsZero() && !window.To.After(window.From) {
+		return window, storage.ErrInvalidTimeRange
+	}
+	return window, nil
+}
@@ -0,0 +1,133 @@
+// Command reprocess re-publishes the stored events received in a time
+// window, e.g. the last hour, so the worker processes them again. RabbitMQ
+// can't rewind a queue, so Mongo is the source.
+//
+// The run's offset is saved in Mongo after every batch; running the command
+// again with the same window resumes where it stopped. A -last window moves
+// with the clock, so resume it with the -from/-to it logged.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/reprocess"
+	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	last := flag.Duration("last", 0, "reprocess events received in this long before now, e.g. 1h (instead of -from/-to)")
+	from := flag.String("from", "", "start of the window, RFC 3339 (inclusive)")
+	to := flag.String("to", "", "end of the window, RFC 3339 (exclusive); defaults to now")
+	clientID := flag.String("client", "", "only reprocess this client's events")
+	restart := flag.Bool("restart", false, "ignore the saved offset and start the window from the beginning")
+	batchSize := flag.Int("batch-size", 500, "events re-published per batch")
+	flag.Parse()
+
+	window, err := parseWindow(*last, *from, *to, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("Invalid window: %v", err)
+	}
+	window.ClientID = *clientID
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := logger.NewLogger(cfg.LogLevel, logger.BaseFields{
+		Service:  cfg.Logging.ServiceName("webhook-reprocess"),
+		Env:      cfg.Logging.Env,
+		Instance: cfg.Logging.Instance,
+	})
+
+	db, err := storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar(),
+		storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections))
+	if err != nil {
+		logger.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer db.Close(context.Background())
+
+	// Clients with their own store are read from it
+	var store reprocess.EventStore = db
+	if len(cfg.MongoDB.ClientStores) > 0 {
+		byClient, clientDBs, err := storage.OpenClientStores(cfg.MongoDB, logger.Desugar(),
+			storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections))
+		if err != nil {
+			logger.Fatalf("Failed to connect to client stores: %v", err)
+		}
+		for _, clientDB := range clientDBs {
+			defer clientDB.Close(context.Background())
+		}
+		store = storage.NewClientRouter(db, byClient)
+	}
+
+	queueArgs, err := queue.QueueArgs(cfg.RabbitMQ)
+	if err != nil {
+		logger.Fatalf("Invalid RabbitMQ queue settings: %v", err)
+	}
+	publisher, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, cfg.RabbitMQ.Exchange, cfg.RabbitMQ.QueueName, queueArgs, logger.Desugar(),
+		queue.WithReconnect(cfg.RabbitMQ.ReconnectDelay, cfg.RabbitMQ.ReconnectMaxDelay, cfg.RabbitMQ.ReconnectTimeout),
+		queue.WithEventRoutingKeys(cfg.RabbitMQ.EventRoutingKeys))
+	if err != nil {
+		logger.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer publisher.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	reprocessor := reprocess.NewReprocessor(store, publisher, *batchSize, logger.Desugar())
+	progress, err := reprocessor.Run(ctx, window, *restart)
+	if err != nil {
+		logger.Desugar().Fatal("Reprocess stopped; run again with the same window to resume",
+			zap.Error(err),
+			zap.Time("from", window.From),
+			zap.Time("to", window.To),
+			zap.String("window", window.Key()),
+			zap.Int("republished", progress.Republished))
+	}
+
+	logger.Desugar().Info("Reprocess complete",
+		zap.Time("from", window.From),
+		zap.Time("to", window.To),
+		zap.String("window", window.Key()),
+		zap.Int("republished", progress.Republished))
+}
+
+// parseWindow builds the window from either -last or -from/-to.
+func parseWindow(last time.Duration, from, to string, now time.Time) (reprocess.Window, error) {
+	if last > 0 {
+		if from != "" || to != "" {
+			return reprocess.Window{}, errors.New("use either -last or -from/-to")
+		}
+		now = now.Truncate(time.Second)
+		return reprocess.Window{From: now.Add(-last), To: now}, nil
+	}
+	if from == "" {
+		return reprocess.Window{}, errors.New("-last or -from is required")
+	}
+
+	var window reprocess.Window
+	var err error
+	if window.From, err = time.Parse(time.RFC3339, from); err != nil {
+		return window, err
+	}
+	window.To = now
+	if to != "" {
+		if window.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return window, err
+		}
+	}
+	return window, nil
+}
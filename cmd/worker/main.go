@@ -11,7 +11,11 @@ import (
 	"webhook-processor/internal/queue"
 	"webhook-processor/internal/storage"
 	"webhook-processor/internal/worker"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/logger"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
@@ -22,81 +26,208 @@ func main() {
 	}
 
 	// Initialize logger
-	logger := logger.NewLogger(cfg.LogLevel)
+	logger := logger.NewLogger(cfg.LogLevel, logger.BaseFields{
+		Service:  cfg.Logging.ServiceName("webhook-worker"),
+		Env:      cfg.Logging.Env,
+		Instance: cfg.Logging.Instance,
+	})
 
-	// Initialize RabbitMQ connection
-	amqpConn, err := queue.NewRabbitMQConnection(cfg.RabbitMQ.URL)
+	// Connect to RabbitMQ and declare the queue with the same arguments as
+	// the publisher
+	queueArgs, err := queue.QueueArgs(cfg.RabbitMQ)
+	if err != nil {
+		logger.Fatalf("Invalid queue configuration: %v", err)
+	}
+	amqpConn, err := queue.DialConsumer(cfg.RabbitMQ, queueArgs, queue.WithPrefetch(cfg.Worker.EffectivePrefetch()),
+		queue.WithGlobalPrefetch(cfg.Worker.GlobalPrefetch))
 	if err != nil {
 		logger.Fatalf("Failed to connect to RabbitMQ: %v", err)
 	}
 	defer amqpConn.Close()
 
-	// Create a channel
-	ch, err := amqpConn.Channel()
-	if err != nil {
-		logger.Fatalf("Failed to open channel: %v", err)
-	}
-	defer ch.Close()
-
-	// Declare exchange
-	err = ch.ExchangeDeclare(
-		cfg.RabbitMQ.Exchange, // name
-		"direct",              // type
-		true,                  // durable
-		false,                 // auto-deleted
-		false,                 // internal
-		false,                 // no-wait
-		nil,                   // arguments
-	)
-	if err != nil {
-		logger.Fatalf("Failed to declare exchange: %v", err)
-	}
-
-	// Declare queue
-	q, err := ch.QueueDeclare(
-		cfg.RabbitMQ.QueueName, // name
-		true,                   // durable
-		false,                  // delete when unused
-		false,                  // exclusive
-		false,                  // no-wait
-		nil,                    // arguments
-	)
+	// Initialize MongoDB connection
+	db, err := storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar(),
+		storage.WithSkipNoopStatusUpdates(cfg.MongoDB.SkipNoopStatusUpdates),
+		storage.WithIndexes(cfg.MongoDB.Indexes),
+		storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections),
+		storage.WithCompressedRawPayload(cfg.MongoDB.CompressRawPayload),
+		storage.WithRetention(cfg.MongoDB.Retention()),
+		storage.WithEventRetention(cfg.MongoDB.EventRetention()))
 	if err != nil {
-		logger.Fatalf("Failed to declare queue: %v", err)
+		logger.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 
-	// Bind queue to exchange
-	err = ch.QueueBind(
-		q.Name,                // queue name
-		"",                    // routing key
-		cfg.RabbitMQ.Exchange, // exchange
-		false,
-		nil,
-	)
-	if err != nil {
-		logger.Fatalf("Failed to bind queue: %v", err)
+	// Clients with a dedicated store have their events routed there
+	var store storage.EventStore = db
+	if len(cfg.MongoDB.ClientStores) > 0 {
+		byClient, clientDBs, err := storage.OpenClientStores(cfg.MongoDB, logger.Desugar(),
+			storage.WithSkipNoopStatusUpdates(cfg.MongoDB.SkipNoopStatusUpdates),
+			storage.WithIndexes(cfg.MongoDB.Indexes),
+			storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections),
+			storage.WithCompressedRawPayload(cfg.MongoDB.CompressRawPayload),
+			storage.WithRetention(cfg.MongoDB.Retention()),
+			storage.WithEventRetention(cfg.MongoDB.EventRetention()))
+		if err != nil {
+			logger.Fatalf("Failed to connect to client stores: %v", err)
+		}
+		for _, clientDB := range clientDBs {
+			defer clientDB.Close(context.Background())
+		}
+		store = storage.NewClientRouter(db, byClient)
 	}
 
-	// Initialize MongoDB connection
-	db, err := storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar())
+	// Initialize worker
+	var workerOpts []worker.Option
+	if cfg.Logging.OutcomeOutput != "" {
+		outcomeLogger, err := worker.NewOutcomeLogger(cfg.Logging.OutcomeOutput)
+		if err != nil {
+			logger.Fatalf("Failed to create outcome logger: %v", err)
+		}
+		defer outcomeLogger.Sync()
+		workerOpts = append(workerOpts, worker.WithOutcomeLogger(outcomeLogger))
+	}
+
+	if cfg.Worker.ForwardURL != "" {
+		forwarder := worker.NewHTTPForwarder(cfg.Worker.ForwardURL, cfg.Worker.ForwardTimeout)
+		workerOpts = append(workerOpts, worker.WithForwarder(forwarder, cfg.Worker.AckAfterForward))
+	}
+
+	if cfg.Alerting.WebhookURL != "" {
+		alerter := worker.NewAlerter(cfg.Alerting.WebhookURL, cfg.Alerting.Events, cfg.Alerting.MinInterval, logger.Desugar())
+		workerOpts = append(workerOpts, worker.WithAlerter(alerter))
+	}
+
+	if cfg.RabbitMQ.DeadLetterExchange != "" {
+		deadLetterer, err := queue.NewDeadLetterer(amqpConn, cfg.RabbitMQ.DeadLetterExchange, cfg.RabbitMQ.DeadLetterQueue)
+		if err != nil {
+			logger.Fatalf("Failed to set up dead-lettering: %v", err)
+		}
+		workerOpts = append(workerOpts, worker.WithDeadLetterer(deadLetterer))
+	}
+
+	if cfg.RabbitMQ.CallbackQueue != "" {
+		results, err := queue.NewResultPublisher(amqpConn, cfg.RabbitMQ.CallbackQueue)
+		if err != nil {
+			logger.Fatalf("Failed to set up result publishing: %v", err)
+		}
+		workerOpts = append(workerOpts, worker.WithResultPublisher(results, cfg.RabbitMQ.CallbackClients))
+	}
+
+	if cfg.Worker.DelayedRetry {
+		workerOpts = append(workerOpts, worker.WithDelayedRetry(queue.NewDelayedRetrier(amqpConn, cfg.RabbitMQ.QueueName)))
+	}
+
+	workerOpts = append(workerOpts, worker.WithPoisonThreshold(cfg.Worker.PoisonThreshold))
+	workerOpts = append(workerOpts, worker.WithRetryPolicy(cfg.Worker.MaxRetries, cfg.Worker.BaseDelay, cfg.Worker.MaxDelay))
+
+	workerOpts = append(workerOpts, worker.WithConcurrency(cfg.Worker.Concurrency))
+
+	// Further exchanges are consumed into the same pipeline, tagged by source
+	if len(cfg.RabbitMQ.Sources) > 0 {
+		workerOpts = append(workerOpts, worker.WithSources(cfg.RabbitMQ.Exchange, workerSources(cfg.RabbitMQ.Sources)...))
+	}
+
+	if cfg.Worker.ClientLanes > 0 {
+		workerOpts = append(workerOpts, worker.WithClientLanes(cfg.Worker.ClientLanes, cfg.Worker.ClientLaneBuffer))
+	}
+
+	if cfg.Worker.BatchSize > 1 {
+		workerOpts = append(workerOpts, worker.WithBatching(cfg.Worker.BatchSize, cfg.Worker.BatchWait, cfg.Worker.BatchOrdered))
+	}
+
+	processors, err := worker.NewProcessors(cfg.Worker.Processors)
 	if err != nil {
-		logger.Fatalf("Failed to connect to MongoDB: %v", err)
+		logger.Fatalf("Invalid worker processors: %v", err)
+	}
+	// Correlate after the configured processors so it sees normalized fields
+	if cfg.Worker.CorrelationWindow > 0 {
+		processors = append(processors, worker.NewCorrelator(cfg.Worker.CorrelationWindow, clock.New()))
+	}
+	if len(processors) > 0 {
+		workerOpts = append(workerOpts, worker.WithProcessors(processors...))
 	}
 
-	// Initialize worker
-	w := worker.NewWorker(ch, db, logger.Desugar())
+	// Resume consuming on a fresh connection if the broker drops this one
+	redial := func() (worker.Consumer, error) {
+		return amqpConn.Redial()
+	}
+	workerOpts = append(workerOpts, worker.WithRedial(redial, cfg.RabbitMQ.ReconnectDelay, cfg.RabbitMQ.ReconnectMaxDelay))
+
+	w := worker.NewWorker(amqpConn.Channel(), store, logger.Desugar(), workerOpts...)
+
+	// Reconcile events orphaned in retrying status by a previous run
+	if cfg.Worker.StaleRetryingAfter > 0 {
+		publisher, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, cfg.RabbitMQ.Exchange, cfg.RabbitMQ.QueueName, queueArgs, logger.Desugar(),
+			queue.WithReconnect(cfg.RabbitMQ.ReconnectDelay, cfg.RabbitMQ.ReconnectMaxDelay, cfg.RabbitMQ.ReconnectTimeout),
+			queue.WithEventRoutingKeys(cfg.RabbitMQ.EventRoutingKeys))
+		if err != nil {
+			logger.Fatalf("Failed to create publisher for reconciliation: %v", err)
+		}
+		if _, err := w.ReconcileStaleRetrying(context.Background(), publisher, cfg.Worker.StaleRetryingAfter, cfg.Worker.StaleRetryingAction); err != nil {
+			logger.Errorf("Failed to reconcile stale retrying events: %v", err)
+		}
+		publisher.Close()
+	}
+
+	// The worker has no scrape endpoint, so metrics are only visible via OTLP
+	exportCtx, stopExport := context.WithCancel(context.Background())
+	defer stopExport()
+	if cfg.Monitoring.OTLPEndpoint != "" {
+		exporter := metrics.NewOTLPExporter(cfg.Monitoring.OTLPEndpoint, "webhook-worker", cfg.Monitoring.OTLPInterval, prometheus.DefaultGatherer, logger.Desugar())
+		exporter.Start(exportCtx)
+		logger.Info("Exporting metrics via OTLP to " + exporter.URL())
+	}
 
 	// Start consuming messages
-	if err := w.Start(context.Background(), q.Name); err != nil {
+	consumeCtx, stopConsuming := context.WithCancel(context.Background())
+	defer stopConsuming()
+	if err := w.Start(consumeCtx, cfg.RabbitMQ.QueueName); err != nil {
 		logger.Fatalf("Failed to start worker: %v", err)
 	}
 
 	logger.Info("Worker started successfully")
 
+	// Apply concurrency and prefetch changes on SIGHUP by draining and
+	// restarting the consumer; other settings need a process restart
+	reload := func() (worker.Settings, error) {
+		cfg, err := config.Load()
+		if err != nil {
+			return worker.Settings{}, err
+		}
+		return reloadableSettings(cfg.Worker), nil
+	}
+	worker.NewReloader(w, cfg.RabbitMQ.QueueName, reloadableSettings(cfg.Worker), reload, amqpConn,
+		cfg.Worker.ShutdownTimeout, logger.Desugar()).Start(consumeCtx)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("Worker shutting down")
+	// Stops reloads too, so a SIGHUP can't restart the consumer mid-shutdown
+	stopConsuming()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Worker.ShutdownTimeout)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		logger.Errorf("Worker shutdown incomplete: %v", err)
+	}
+}
+
+// reloadableSettings returns the worker settings a SIGHUP can change.
+func reloadableSettings(cfg config.WorkerConfig) worker.Settings {
+	return worker.Settings{
+		Concurrency:    cfg.Concurrency,
+		Prefetch:       cfg.EffectivePrefetch(),
+		GlobalPrefetch: cfg.GlobalPrefetch,
+	}
+}
+
+// workerSources returns the worker sources for the configured ones.
+func workerSources(cfgs []config.SourceConfig) []worker.Source {
+	sources := make([]worker.Source, 0, len(cfgs))
+	for _, s := range cfgs {
+		sources = append(sources, worker.Source{Name: s.Name, Queue: s.Queue})
+	}
+	return sources
 }
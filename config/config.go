@@ -1,9 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"webhook-processor/internal/models"
 
 	"github.com/spf13/viper"
 )
@@ -15,33 +20,456 @@ type Config struct {
 	MongoDB    MongoDBConfig    `mapstructure:"mongodb"`
 	Monitoring MonitoringConfig `mapstructure:"monitoring"`
 	Security   SecurityConfig   `mapstructure:"security"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	Worker     WorkerConfig     `mapstructure:"worker"`
+	Alerting   AlertingConfig   `mapstructure:"alerting"`
+	Webhook    WebhookConfig    `mapstructure:"webhook"`
+	RateLimit  RateLimitConfig  `mapstructure:"rateLimit"`
+}
+
+// WebhookConfig controls how incoming webhooks are parsed and accepted.
+type WebhookConfig struct {
+	// MaxTimestampSkew flags events whose client-supplied ts differs from the
+	// server receive time by more than this. Zero disables the check.
+	MaxTimestampSkew time.Duration `mapstructure:"maxTimestampSkew"`
+	// MaxEventAge discards events whose ts is older than this with a 200
+	// response so MailerCloud stops retrying them. Zero disables the check.
+	MaxEventAge time.Duration `mapstructure:"maxEventAge"`
+	// ValidateContentLength rejects requests whose body length differs from
+	// the declared Content-Length.
+	ValidateContentLength bool `mapstructure:"validateContentLength"`
+	// MaxBodyBytes rejects webhooks with a larger body with 413, before they
+	// are read into memory. Zero disables the limit.
+	MaxBodyBytes int64 `mapstructure:"maxBodyBytes"`
+	// AsyncPublish answers 202 as soon as an event is buffered in-process and
+	// publishes it in the background. Lower latency, but buffered events are
+	// lost if the process dies.
+	AsyncPublish    bool          `mapstructure:"asyncPublish"`
+	AsyncBufferSize int           `mapstructure:"asyncBufferSize"`
+	AsyncMaxRetries int           `mapstructure:"asyncMaxRetries"`
+	AsyncRetryDelay time.Duration `mapstructure:"asyncRetryDelay"`
+	// RetryBufferSize is how many events a synchronous publish holds in
+	// memory while the broker is unavailable, retrying them every
+	// RetryBufferInterval for up to RetryBufferMaxAge. Requests are shed with
+	// 503 once it is full. Zero disables buffering.
+	RetryBufferSize     int           `mapstructure:"retryBufferSize"`
+	RetryBufferMaxAge   time.Duration `mapstructure:"retryBufferMaxAge"`
+	RetryBufferInterval time.Duration `mapstructure:"retryBufferInterval"`
+	// DebugClients get the debug handler (raw payload capture and verbose
+	// logging) while every other client uses the production handler.
+	// WEBHOOK_DEBUG=true still enables debug mode for everyone.
+	DebugClients []string `mapstructure:"debugClients"`
+	// DebugCaptureDir is where the debug handler saves raw payloads, which
+	// can be downloaded from /admin/captures. Empty means the working
+	// directory.
+	DebugCaptureDir string `mapstructure:"debugCaptureDir"`
+	// MappingRefreshInterval is how often the webhook-to-client mapping is
+	// re-fetched from MailerCloud, so new webhooks are picked up without a
+	// restart. Zero loads it only at startup.
+	MappingRefreshInterval time.Duration `mapstructure:"mappingRefreshInterval"`
+	// QueryFields maps query parameters to payload fields, for senders that
+	// pass some fields in the URL (e.g. ?client=x). A parameter is only used
+	// when the body doesn't already have the field.
+	QueryFields map[string]string `mapstructure:"queryFields"`
+	// MissingContentType decides what happens to a request without a
+	// Content-Type header: MissingContentTypeAssumeJSON parses the body as
+	// JSON anyway, MissingContentTypeReject answers 415.
+	MissingContentType string `mapstructure:"missingContentType"`
+	// RequireEmailEvents lists event types (case-insensitive) that are
+	// rejected with 422 when they carry neither email nor emails. Empty
+	// accepts every event regardless. Campaign-level events (campaign_sent,
+	// campaign_error) have no recipient and are never rejected.
+	RequireEmailEvents []string `mapstructure:"requireEmailEvents"`
+	// PerSecondLimit caps each client's request rate, separately from the
+	// daily quota, allowing bursts of up to PerSecondBurst requests (0 =
+	// PerSecondLimit rounded up). Zero disables the limit.
+	PerSecondLimit float64 `mapstructure:"perSecondLimit"`
+	PerSecondBurst int     `mapstructure:"perSecondBurst"`
+	// StoreRawPayload keeps each event's original payload alongside the
+	// parsed fields, in the queued message and as raw_payload in MongoDB, so
+	// events can be re-parsed when the field mapping changes. It grows both.
+	StoreRawPayload bool `mapstructure:"storeRawPayload"`
+	// Maintenance starts the API rejecting webhooks with 503 and a
+	// Retry-After of MaintenanceRetryAfter, so MailerCloud retries them
+	// later. It can be toggled at runtime via /admin/maintenance.
+	Maintenance           bool          `mapstructure:"maintenance"`
+	MaintenanceRetryAfter time.Duration `mapstructure:"maintenanceRetryAfter"`
+	// ValidationUserAgent is a regular expression matching the User-Agent
+	// of MailerCloud's validation requests, which are answered without
+	// being queued. Empty uses the default, which also accepts versioned
+	// agents such as "MailerCloud/2.0".
+	ValidationUserAgent string `mapstructure:"validationUserAgent"`
+	// IdempotencyHeader names a request header whose value MailerCloud
+	// repeats when retrying a delivery, such as Webhook-Id where it is unique
+	// per delivery. Single-event webhooks without an ID in the payload are
+	// then deduplicated by it rather than by their fields. Empty disables it;
+	// leave it empty while Webhook-Id identifies the webhook rather than the
+	// delivery, or every event from a webhook would share one ID.
+	IdempotencyHeader string `mapstructure:"idempotencyHeader"`
+	// LoadShed turns away a share of webhooks with 503 while the process is
+	// under goroutine or memory pressure, rather than risk crashing.
+	LoadShed LoadShedConfig `mapstructure:"loadShed"`
+}
+
+// LoadShedConfig sets when webhooks are shed. Shedding starts once either
+// threshold is exceeded; the shed fraction grows with the overshoot, up to
+// MaxFraction, and drops back to zero once usage is under both thresholds.
+type LoadShedConfig struct {
+	// MaxGoroutines is the goroutine count above which requests are shed.
+	// Zero disables the check.
+	MaxGoroutines int `mapstructure:"maxGoroutines"`
+	// MaxHeapMB is the heap in use, in MiB, above which requests are shed.
+	// Zero disables the check.
+	MaxHeapMB int `mapstructure:"maxHeapMB"`
+	// MaxFraction caps the share of requests shed, so some traffic always
+	// gets through.
+	MaxFraction float64 `mapstructure:"maxFraction"`
+	// SampleInterval is how often goroutines and memory are measured.
+	SampleInterval time.Duration `mapstructure:"sampleInterval"`
+	// RetryAfter is sent with shed requests.
+	RetryAfter time.Duration `mapstructure:"retryAfter"`
+}
+
+// Enabled reports whether any load shedding threshold is set.
+func (c LoadShedConfig) Enabled() bool {
+	return c.MaxGoroutines > 0 || c.MaxHeapMB > 0
+}
+
+// Policies for requests without a Content-Type header.
+const (
+	MissingContentTypeAssumeJSON = "json"
+	MissingContentTypeReject     = "reject"
+)
+
+// What happens to events once a client's daily storage quota is used up.
+const (
+	OverQuotaDrop   = "drop"
+	OverQuotaReject = "reject"
+)
+
+// RateLimitConfig sets the per-client plan limits enforced on webhooks.
+type RateLimitConfig struct {
+	// PremiumClients are on the premium plan; every other client is on the
+	// free plan.
+	PremiumClients []string `mapstructure:"premiumClients"`
+	// Daily limits are events per day and webhook limits how many distinct
+	// webhooks a client may send through; 0 means unlimited.
+	FreeDailyLimit      int `mapstructure:"freeDailyLimit"`
+	FreeWebhookLimit    int `mapstructure:"freeWebhookLimit"`
+	PremiumDailyLimit   int `mapstructure:"premiumDailyLimit"`
+	PremiumWebhookLimit int `mapstructure:"premiumWebhookLimit"`
+	// Daily stored limits are how many events a day are stored for a
+	// client; 0 means unlimited. Events past the limit are handled as
+	// OverQuota says: "drop" answers 200 without storing them, "reject"
+	// answers 429.
+	FreeDailyStoredLimit    int    `mapstructure:"freeDailyStoredLimit"`
+	PremiumDailyStoredLimit int    `mapstructure:"premiumDailyStoredLimit"`
+	OverQuota               string `mapstructure:"overQuota"`
+}
+
+type AlertingConfig struct {
+	// WebhookURL is a Slack-compatible incoming webhook. Empty disables alerting.
+	WebhookURL  string        `mapstructure:"webhookURL"`
+	Events      []string      `mapstructure:"events"`
+	MinInterval time.Duration `mapstructure:"minInterval"`
+}
+
+type WorkerConfig struct {
+	// ForwardURL relays stored events to a downstream endpoint. Empty disables forwarding.
+	ForwardURL     string        `mapstructure:"forwardURL"`
+	ForwardTimeout time.Duration `mapstructure:"forwardTimeout"`
+	// AckAfterForward defers the ack until the downstream forward succeeds.
+	AckAfterForward bool `mapstructure:"ackAfterForward"`
+	// StaleRetryingAfter reconciles events stuck in retrying status for longer
+	// than this on startup. Zero disables reconciliation.
+	StaleRetryingAfter  time.Duration `mapstructure:"staleRetryingAfter"`
+	StaleRetryingAction string        `mapstructure:"staleRetryingAction"` // "republish" or "fail"
+	// Processors lists built-in pre-storage processors to run, in order
+	// ("normalize", "validate", "redact").
+	Processors []string `mapstructure:"processors"`
+	// CorrelationWindow links related events for the same message (by
+	// message_id, or email and campaign) received within this long of each
+	// other with a shared correlation_id. Zero disables correlation.
+	CorrelationWindow time.Duration `mapstructure:"correlationWindow"`
+	// ClientLanes processes each client on its own goroutine, capped at this
+	// many lanes. Zero processes all clients on the shared consumer.
+	ClientLanes      int `mapstructure:"clientLanes"`
+	ClientLaneBuffer int `mapstructure:"clientLaneBuffer"`
+	// PoisonThreshold rejects a message without requeue once its body has
+	// failed this many times. Zero disables the check.
+	PoisonThreshold int `mapstructure:"poisonThreshold"`
+	// MaxRetries failed attempts mark an event failed. Between attempts the
+	// worker backs off exponentially from BaseDelay, capped at MaxDelay.
+	MaxRetries int           `mapstructure:"maxRetries"`
+	BaseDelay  time.Duration `mapstructure:"baseDelay"`
+	MaxDelay   time.Duration `mapstructure:"maxDelay"`
+	// DelayedRetry parks failed messages in TTL retry queues for the backoff
+	// instead of sleeping in the consumer before requeueing them.
+	DelayedRetry bool `mapstructure:"delayedRetry"`
+	// Concurrency is how many deliveries are processed at once. Prefetch
+	// caps the unacked deliveries the broker sends ahead; zero means
+	// Concurrency, or unlimited when processing one at a time.
+	Concurrency int `mapstructure:"concurrency"`
+	Prefetch    int `mapstructure:"prefetch"`
+	// GlobalPrefetch caps the unacked deliveries across every consumer on
+	// the worker's channel (channel-global QoS). Zero leaves it unlimited.
+	GlobalPrefetch int `mapstructure:"globalPrefetch"`
+	// BatchSize stores up to this many deliveries' events with one bulk
+	// write, waiting at most BatchWait for a batch to fill. Only failed
+	// writes are retried; BatchOrdered stops a batch at its first failure,
+	// retrying the rest too. Below 2 stores each event on its own.
+	BatchSize    int           `mapstructure:"batchSize"`
+	BatchWait    time.Duration `mapstructure:"batchWait"`
+	BatchOrdered bool          `mapstructure:"batchOrdered"`
+	// ShutdownTimeout is how long the worker waits on shutdown for
+	// deliveries being processed to finish and be acked.
+	ShutdownTimeout time.Duration `mapstructure:"shutdownTimeout"`
+}
+
+// EffectivePrefetch returns the QoS prefetch count to apply, or 0 to leave
+// the channel unlimited.
+func (c WorkerConfig) EffectivePrefetch() int {
+	if c.Prefetch > 0 {
+		return c.Prefetch
+	}
+	if c.BatchSize > 1 {
+		// Enough for every consumer goroutine to fill a batch
+		return c.Concurrency * c.BatchSize
+	}
+	if c.Concurrency > 1 {
+		return c.Concurrency
+	}
+	return 0
+}
+
+type LoggingConfig struct {
+	// OutcomeOutput is an optional output path (file, "stdout" or "stderr")
+	// for the compact per-event outcome log. Empty disables it.
+	OutcomeOutput string `mapstructure:"outcomeOutput"`
+	// Service, Env and Instance are added to every log line. An empty
+	// Service falls back to the binary's own name and an empty Instance to
+	// the hostname.
+	Service  string `mapstructure:"service"`
+	Env      string `mapstructure:"env"`
+	Instance string `mapstructure:"instance"`
+}
+
+// ServiceName returns the configured service name, or fallback if none is set.
+func (c LoggingConfig) ServiceName(fallback string) string {
+	if c.Service != "" {
+		return c.Service
+	}
+	return fallback
 }
 
 type SecurityConfig struct {
 	APIKeyHeader string            `mapstructure:"apiKeyHeader"`
 	APIKeys      map[string]string `mapstructure:"apiKeys"`
+	// SigningSecrets maps client IDs to the secret their webhooks are signed
+	// with (HMAC-SHA256 of the body, sent in SignatureHeader). A client not
+	// listed uses the secret on its MailerCloud webhooks, if any; webhooks
+	// from clients with neither are not signature-checked.
+	SigningSecrets  map[string]string `mapstructure:"signingSecrets"`
+	SignatureHeader string            `mapstructure:"signatureHeader"`
+	// SignatureTolerance enables replay protection for signed webhooks: they
+	// must carry a Unix timestamp in SignatureTimestampHeader no further than
+	// this from the current time, and the signature covers
+	// "<timestamp>.<body>". Zero disables it.
+	SignatureTolerance       time.Duration `mapstructure:"signatureTolerance"`
+	SignatureTimestampHeader string        `mapstructure:"signatureTimestampHeader"`
+	// AdminClients are the clients in APIKeys whose keys may use the
+	// operator endpoints under /admin. Other keys only reach the admin
+	// endpoints scoped to their own client.
+	AdminClients []string `mapstructure:"adminClients"`
+}
+
+// ReconcileConfig compares published and stored event counts to detect loss.
+type ReconcileConfig struct {
+	// Window is the reconciliation bucket size. Zero disables reconciliation.
+	Window time.Duration `mapstructure:"window"`
+	// Lag is how long after a window closes before it is checked, to allow
+	// for queue latency and retries.
+	Lag time.Duration `mapstructure:"lag"`
+}
+
+// StatusConfig sets the thresholds GET /admin/status reports against.
+type StatusConfig struct {
+	// MaxQueueDepth degrades the queue once more messages than this are
+	// waiting. Zero disables the check.
+	MaxQueueDepth int `mapstructure:"maxQueueDepth"`
+	// MappingMaxAge degrades the webhook mapping once it was last loaded
+	// longer ago than this. Zero disables the check.
+	MappingMaxAge time.Duration `mapstructure:"mappingMaxAge"`
 }
 
 type MonitoringConfig struct {
 	PrometheusPort int    `mapstructure:"prometheusPort"`
 	MetricsPath    string `mapstructure:"metricsPath"`
+	// RequireMetricsPort fails startup if the metrics port can't be bound.
+	// Otherwise metrics fall back to the main port's /metrics route.
+	RequireMetricsPort bool            `mapstructure:"requireMetricsPort"`
+	Reconcile          ReconcileConfig `mapstructure:"reconcile"`
+	Status             StatusConfig    `mapstructure:"status"`
+	// OTLPEndpoint pushes the metrics to an OpenTelemetry collector's
+	// OTLP/HTTP endpoint (e.g. "http://otel-collector:4318"). Empty disables.
+	OTLPEndpoint string        `mapstructure:"otlpEndpoint"`
+	OTLPInterval time.Duration `mapstructure:"otlpInterval"`
+	// DisablePrometheus turns off the Prometheus scrape endpoints, for
+	// deployments that only export via OTLP.
+	DisablePrometheus bool `mapstructure:"disablePrometheus"`
 }
 
 type MongoDBConfig struct {
 	URI        string `mapstructure:"uri"`
 	Database   string `mapstructure:"database"`
 	Collection string `mapstructure:"collection"`
+	// SkipNoopStatusUpdates avoids rewriting events whose status and retry
+	// count are already the target values.
+	SkipNoopStatusUpdates bool `mapstructure:"skipNoopStatusUpdates"`
+	// Indexes are created on the events collection alongside the built-in
+	// ones, for query patterns the code doesn't know about.
+	Indexes []IndexConfig `mapstructure:"indexes"`
+	// ClientStores keeps the listed clients' events in a separate MongoDB
+	// deployment, e.g. a dedicated cluster for compliance. Every other
+	// client uses the shared store above.
+	ClientStores []ClientStoreConfig `mapstructure:"clientStores"`
+	// MonthlyCollections stores events in per-month collections named
+	// <collection>_YYYY_MM by received_at instead of a single collection.
+	// Switching it on doesn't move events already stored.
+	MonthlyCollections bool `mapstructure:"monthlyCollections"`
+	// CompressRawPayload stores raw payloads gzip-compressed as BSON binary
+	// instead of as documents. Both forms are read back the same way, so it
+	// can be switched either way without migrating stored events.
+	CompressRawPayload bool `mapstructure:"compressRawPayload"`
+	// RetentionDays expires events this many days after received_at through
+	// a TTL index. Zero keeps events indefinitely.
+	RetentionDays int `mapstructure:"retentionDays"`
+	// RetentionByEvent overrides RetentionDays for the listed event types,
+	// e.g. keeping bounces for a year for suppression while delivered events
+	// go after a week. A type can be named by any of its names, so
+	// "hard_bounce" covers every bounce. Zero keeps that type indefinitely.
+	RetentionByEvent map[string]int `mapstructure:"retentionByEvent"`
+}
+
+// Retention returns RetentionDays as a duration.
+func (c MongoDBConfig) Retention() time.Duration {
+	return days(c.RetentionDays)
+}
+
+// EventRetention returns RetentionByEvent as durations keyed by canonical
+// event type.
+func (c MongoDBConfig) EventRetention() map[string]time.Duration {
+	if len(c.RetentionByEvent) == 0 {
+		return nil
+	}
+	retention := make(map[string]time.Duration, len(c.RetentionByEvent))
+	for eventType, n := range c.RetentionByEvent {
+		retention[string(models.NormalizeEvent(eventType))] = days(n)
+	}
+	return retention
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// ClientStoreConfig is an alternate MongoDB connection for specific clients.
+// Database and Collection default to the shared store's.
+type ClientStoreConfig struct {
+	Name       string   `mapstructure:"name"`
+	Clients    []string `mapstructure:"clients"`
+	URI        string   `mapstructure:"uri"`
+	Database   string   `mapstructure:"database"`
+	Collection string   `mapstructure:"collection"`
+}
+
+// IndexConfig declares a supplementary index on the events collection.
+type IndexConfig struct {
+	// Name defaults to MongoDB's generated name, e.g. "email_1_event_-1".
+	Name   string           `mapstructure:"name"`
+	Keys   []IndexKeyConfig `mapstructure:"keys"`
+	Unique bool             `mapstructure:"unique"`
+	Sparse bool             `mapstructure:"sparse"`
+}
+
+// IndexKeyConfig is one field of an index; Direction is 1 or -1.
+type IndexKeyConfig struct {
+	Field     string `mapstructure:"field"`
+	Direction int    `mapstructure:"direction"`
 }
 
 type RabbitMQConfig struct {
 	URL       string `mapstructure:"url"`
 	Exchange  string `mapstructure:"exchange"`
 	QueueName string `mapstructure:"queueName"`
+	// Optional queue arguments. Changing them for an existing queue requires
+	// deleting it first; RabbitMQ rejects inequivalent redeclarations.
+	QueueMode string `mapstructure:"queueMode"` // x-queue-mode: "lazy" or "default"
+	MaxLength int    `mapstructure:"maxLength"` // x-max-length; 0 means unbounded
+	Overflow  string `mapstructure:"overflow"`  // x-overflow: "drop-head", "reject-publish" or "reject-publish-dlx"
+	// Events that exhaust their retries are published by the worker to
+	// DeadLetterExchange, which is bound to DeadLetterQueue. An empty
+	// exchange disables dead-lettering.
+	DeadLetterExchange string `mapstructure:"deadLetterExchange"`
+	DeadLetterQueue    string `mapstructure:"deadLetterQueue"`
+	// CallbackQueue receives a {webhook_id, status, stored_at} result from
+	// the worker for each stored event of the CallbackClients. An empty
+	// queue disables results.
+	CallbackQueue   string   `mapstructure:"callbackQueue"`
+	CallbackClients []string `mapstructure:"callbackClients"`
+	// EventRoutingKeys publishes events to a topic exchange with the routing
+	// key "<event>.<client_id>" so consumers can bind selectively. Switching
+	// an existing direct exchange requires deleting it first.
+	EventRoutingKeys bool `mapstructure:"eventRoutingKeys"`
+	// When the connection drops the publisher and worker redial after
+	// ReconnectDelay, doubling up to ReconnectMaxDelay. Publishes wait up
+	// to ReconnectTimeout for the connection before failing.
+	ReconnectDelay    time.Duration `mapstructure:"reconnectDelay"`
+	ReconnectMaxDelay time.Duration `mapstructure:"reconnectMaxDelay"`
+	ReconnectTimeout  time.Duration `mapstructure:"reconnectTimeout"`
+	// Destinations receive every published event on their own exchange,
+	// reshaped for consumers that need a different contract.
+	Destinations []DestinationConfig `mapstructure:"destinations"`
+	// Sources are further exchanges the worker consumes, each through its
+	// own queue, into the same pipeline. Events are tagged with the source
+	// name, or the exchange name for the main queue, once any are set.
+	Sources []SourceConfig `mapstructure:"sources"`
+}
+
+// SourceConfig is an exchange the worker consumes alongside the main one.
+type SourceConfig struct {
+	Name     string `mapstructure:"name"`
+	Exchange string `mapstructure:"exchange"`
+	// ExchangeType is the kind the exchange is declared as; empty means
+	// "direct".
+	ExchangeType string `mapstructure:"exchangeType"`
+	// Queue is bound to Exchange with RoutingKey and declared with the
+	// main queue's arguments.
+	Queue      string `mapstructure:"queue"`
+	RoutingKey string `mapstructure:"routingKey"`
+}
+
+// DestinationConfig is an extra exchange fed with a templated copy of each
+// event.
+type DestinationConfig struct {
+	Name     string `mapstructure:"name"`
+	Exchange string `mapstructure:"exchange"`
+	// Template is a Go text/template rendering the message body as JSON. It
+	// is executed with the models.WebhookEvent; use {{json .Field}} to
+	// quote values.
+	Template string `mapstructure:"template"`
 }
 
 type ServerConfig struct {
-	Port int
-	Host string
+	Port              int
+	Host              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is the grace period for in-flight requests and for
+	// flushing buffered events to the broker on shutdown.
+	ShutdownTimeout time.Duration
 }
 
 func Load() (*Config, error) {
@@ -53,9 +481,58 @@ func Load() (*Config, error) {
 	// Set defaults
 	viper.SetDefault("server.port", 8080)
 	viper.SetDefault("server.host", "0.0.0.0")
+	viper.SetDefault("server.readTimeout", "5s")
+	viper.SetDefault("server.readHeaderTimeout", "2s")
+	viper.SetDefault("server.writeTimeout", "10s")
+	viper.SetDefault("server.idleTimeout", "60s")
+	viper.SetDefault("server.shutdownTimeout", "5s")
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("rabbitmq.deadLetterExchange", "webhook_dlx")
+	viper.SetDefault("rabbitmq.deadLetterQueue", "webhook_dlq")
+	viper.SetDefault("rabbitmq.reconnectDelay", "1s")
+	viper.SetDefault("rabbitmq.reconnectMaxDelay", "30s")
+	viper.SetDefault("rabbitmq.reconnectTimeout", "5s")
+	viper.SetDefault("mongodb.skipNoopStatusUpdates", true)
 	viper.SetDefault("monitoring.prometheusPort", 9090)
 	viper.SetDefault("monitoring.metricsPath", "/metrics")
+	viper.SetDefault("monitoring.reconcile.lag", "5m")
+	viper.SetDefault("monitoring.otlpInterval", "30s")
+	viper.SetDefault("monitoring.status.maxQueueDepth", 10000)
+	viper.SetDefault("monitoring.status.mappingMaxAge", "24h")
+	viper.SetDefault("logging.env", "production")
+	viper.SetDefault("security.signatureHeader", "X-MailerCloud-Signature")
+	viper.SetDefault("security.signatureTimestampHeader", "X-MailerCloud-Timestamp")
+	viper.SetDefault("webhook.validateContentLength", true)
+	viper.SetDefault("webhook.maxBodyBytes", 1<<20)
+	viper.SetDefault("webhook.missingContentType", MissingContentTypeAssumeJSON)
+	viper.SetDefault("webhook.asyncBufferSize", 1000)
+	viper.SetDefault("webhook.asyncMaxRetries", 5)
+	viper.SetDefault("webhook.asyncRetryDelay", "1s")
+	viper.SetDefault("webhook.maintenanceRetryAfter", "60s")
+	viper.SetDefault("webhook.retryBufferSize", 500)
+	viper.SetDefault("webhook.retryBufferMaxAge", "30s")
+	viper.SetDefault("webhook.retryBufferInterval", "1s")
+	viper.SetDefault("webhook.mappingRefreshInterval", "15m")
+	viper.SetDefault("rateLimit.freeDailyLimit", 10000)
+	viper.SetDefault("rateLimit.freeWebhookLimit", 20)
+	viper.SetDefault("rateLimit.premiumWebhookLimit", 50)
+	viper.SetDefault("rateLimit.overQuota", OverQuotaDrop)
+	viper.SetDefault("webhook.loadShed.maxFraction", 0.9)
+	viper.SetDefault("webhook.loadShed.sampleInterval", "1s")
+	viper.SetDefault("webhook.loadShed.retryAfter", "5s")
+	viper.SetDefault("worker.forwardTimeout", "10s")
+	viper.SetDefault("worker.staleRetryingAction", "republish")
+	viper.SetDefault("worker.clientLaneBuffer", 10)
+	viper.SetDefault("worker.poisonThreshold", 5)
+	viper.SetDefault("worker.maxRetries", 3)
+	viper.SetDefault("worker.baseDelay", "10s")
+	viper.SetDefault("worker.maxDelay", "5m")
+	viper.SetDefault("worker.delayedRetry", true)
+	viper.SetDefault("worker.concurrency", 1)
+	viper.SetDefault("worker.shutdownTimeout", "30s")
+	viper.SetDefault("worker.batchWait", "100ms")
+	viper.SetDefault("alerting.events", []string{"spam"})
+	viper.SetDefault("alerting.minInterval", "5m")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
@@ -79,6 +556,10 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if otlp := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); otlp != "" {
+		cfg.Monitoring.OTLPEndpoint = otlp
+	}
+
 	if uri := os.Getenv("MONGODB_URI"); uri != "" {
 		cfg.MongoDB.URI = uri
 	}
@@ -103,20 +584,312 @@ func Load() (*Config, error) {
 		cfg.RabbitMQ.QueueName = queue
 	}
 
+	if forwardURL := os.Getenv("WORKER_FORWARD_URL"); forwardURL != "" {
+		cfg.Worker.ForwardURL = forwardURL
+	}
+	if ackAfter := os.Getenv("WORKER_ACK_AFTER_FORWARD"); ackAfter != "" {
+		if b, err := strconv.ParseBool(ackAfter); err == nil {
+			cfg.Worker.AckAfterForward = b
+		}
+	}
+
+	if debugClients := os.Getenv("WEBHOOK_DEBUG_CLIENTS"); debugClients != "" {
+		cfg.Webhook.DebugClients = strings.Split(debugClients, ",")
+	}
+
+	if admins := os.Getenv("ADMIN_CLIENTS"); admins != "" {
+		cfg.Security.AdminClients = strings.Split(admins, ",")
+	}
+
+	if premium := os.Getenv("RATE_LIMIT_PREMIUM_CLIENTS"); premium != "" {
+		cfg.RateLimit.PremiumClients = strings.Split(premium, ",")
+	}
+
+	if alertURL := os.Getenv("ALERT_WEBHOOK_URL"); alertURL != "" {
+		cfg.Alerting.WebhookURL = alertURL
+	}
+
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		cfg.LogLevel = level
 	}
 
+	if outcome := os.Getenv("OUTCOME_LOG_OUTPUT"); outcome != "" {
+		cfg.Logging.OutcomeOutput = outcome
+	}
+
+	if service := os.Getenv("SERVICE_NAME"); service != "" {
+		cfg.Logging.Service = service
+	}
+	if env := os.Getenv("APP_ENV"); env != "" {
+		cfg.Logging.Env = env
+	}
+	if instance := os.Getenv("INSTANCE_ID"); instance != "" {
+		cfg.Logging.Instance = instance
+	}
+
 	if header := os.Getenv("API_KEY_HEADER"); header != "" {
 		cfg.Security.APIKeyHeader = header
 	}
 
 	// Load API keys from environment
 	cfg.Security.APIKeys = loadAPIKeysFromEnv()
+	cfg.Security.SigningSecrets = loadSigningSecretsFromEnv(cfg.Security.SigningSecrets)
+
+	switch cfg.Webhook.MissingContentType {
+	case MissingContentTypeAssumeJSON, MissingContentTypeReject:
+	default:
+		return nil, fmt.Errorf("invalid webhook.missingContentType %q, want %q or %q",
+			cfg.Webhook.MissingContentType, MissingContentTypeAssumeJSON, MissingContentTypeReject)
+	}
+
+	if _, err := regexp.Compile(cfg.Webhook.ValidationUserAgent); err != nil {
+		return nil, fmt.Errorf("invalid webhook.validationUserAgent: %v", err)
+	}
+
+	if cfg.Webhook.PerSecondLimit < 0 || cfg.Webhook.PerSecondBurst < 0 {
+		return nil, fmt.Errorf("invalid webhook.perSecondLimit %v or webhook.perSecondBurst %d, want at least 0",
+			cfg.Webhook.PerSecondLimit, cfg.Webhook.PerSecondBurst)
+	}
+
+	if cfg.Webhook.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("invalid webhook.maxBodyBytes %d, want at least 0", cfg.Webhook.MaxBodyBytes)
+	}
+
+	if cfg.Webhook.MappingRefreshInterval < 0 {
+		return nil, fmt.Errorf("invalid webhook.mappingRefreshInterval %v, want at least 0", cfg.Webhook.MappingRefreshInterval)
+	}
+
+	if err := validateRateLimit(cfg.RateLimit); err != nil {
+		return nil, err
+	}
+
+	if err := validateLoadShed(cfg.Webhook.LoadShed); err != nil {
+		return nil, err
+	}
+
+	if err := validateSources(cfg.RabbitMQ); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.RabbitMQ.CallbackClients) > 0 && cfg.RabbitMQ.CallbackQueue == "" {
+		return nil, fmt.Errorf("rabbitmq.callbackClients is set but rabbitmq.callbackQueue is empty")
+	}
+
+	if err := validateWorkerConcurrency(cfg.Worker); err != nil {
+		return nil, err
+	}
+
+	if err := validateRetention(cfg.MongoDB); err != nil {
+		return nil, err
+	}
+
+	if err := validateClientStores(cfg.MongoDB.ClientStores); err != nil {
+		return nil, err
+	}
+
+	if err := validateIndexes(cfg.MongoDB.Indexes); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// validateSources rejects worker sources that are incomplete or would
+// collide with each other or the main queue.
+func validateSources(r RabbitMQConfig) error {
+	names := map[string]bool{r.Exchange: true}
+	queues := map[string]bool{r.QueueName: true}
+	for i, s := range r.Sources {
+		if s.Name == "" || s.Exchange == "" || s.Queue == "" {
+			return fmt.Errorf("rabbitmq.sources[%d] needs a name, exchange and queue", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("rabbitmq.sources[%d]: duplicate source name %q", i, s.Name)
+		}
+		if queues[s.Queue] {
+			return fmt.Errorf("rabbitmq.sources[%d]: queue %q is already consumed", i, s.Queue)
+		}
+		names[s.Name], queues[s.Queue] = true, true
+	}
+	return nil
+}
+
+// validateRateLimit rejects negative plan limits and unknown over-quota
+// policies.
+func validateRateLimit(rl RateLimitConfig) error {
+	for name, limit := range map[string]int{
+		"freeDailyLimit":          rl.FreeDailyLimit,
+		"freeWebhookLimit":        rl.FreeWebhookLimit,
+		"premiumDailyLimit":       rl.PremiumDailyLimit,
+		"premiumWebhookLimit":     rl.PremiumWebhookLimit,
+		"freeDailyStoredLimit":    rl.FreeDailyStoredLimit,
+		"premiumDailyStoredLimit": rl.PremiumDailyStoredLimit,
+	} {
+		if limit < 0 {
+			return fmt.Errorf("invalid rateLimit.%s %d, want at least 0 (unlimited)", name, limit)
+		}
+	}
+	switch rl.OverQuota {
+	case "", OverQuotaDrop, OverQuotaReject:
+	default:
+		return fmt.Errorf("invalid rateLimit.overQuota %q, want %q or %q", rl.OverQuota, OverQuotaDrop, OverQuotaReject)
+	}
+	return nil
+}
+
+// validateLoadShed rejects load shedding settings that can't be applied.
+func validateLoadShed(ls LoadShedConfig) error {
+	if ls.MaxGoroutines < 0 || ls.MaxHeapMB < 0 {
+		return fmt.Errorf("invalid webhook.loadShed thresholds, want at least 0")
+	}
+	if !ls.Enabled() {
+		return nil
+	}
+	if ls.MaxFraction <= 0 || ls.MaxFraction > 1 {
+		return fmt.Errorf("invalid webhook.loadShed.maxFraction %v, want above 0 and at most 1", ls.MaxFraction)
+	}
+	if ls.SampleInterval <= 0 {
+		return fmt.Errorf("invalid webhook.loadShed.sampleInterval %v", ls.SampleInterval)
+	}
+	return nil
+}
+
+// validateWorkerConcurrency rejects concurrency settings the worker can't
+// apply.
+func validateWorkerConcurrency(w WorkerConfig) error {
+	if w.Concurrency < 1 {
+		return fmt.Errorf("invalid worker.concurrency %d, want at least 1", w.Concurrency)
+	}
+	if w.Prefetch < 0 {
+		return fmt.Errorf("invalid worker.prefetch %d", w.Prefetch)
+	}
+	if w.Concurrency > 1 && w.ClientLanes > 0 {
+		return fmt.Errorf("worker.concurrency and worker.clientLanes can't both be set; client lanes already process in parallel")
+	}
+	if w.BatchSize < 0 {
+		return fmt.Errorf("invalid worker.batchSize %d", w.BatchSize)
+	}
+	if w.BatchSize > 1 {
+		if w.ClientLanes > 0 {
+			return fmt.Errorf("worker.batchSize and worker.clientLanes can't both be set; client lanes process one delivery at a time")
+		}
+		if w.BatchWait <= 0 {
+			return fmt.Errorf("invalid worker.batchWait %s, want more than 0 when batching", w.BatchWait)
+		}
+	}
+	if w.GlobalPrefetch < 0 {
+		return fmt.Errorf("invalid worker.globalPrefetch %d", w.GlobalPrefetch)
+	}
+	if w.GlobalPrefetch > 0 {
+		// A smaller channel limit would leave workers or consumer credit idle
+		if w.GlobalPrefetch < w.Concurrency {
+			return fmt.Errorf("worker.globalPrefetch %d is below worker.concurrency %d", w.GlobalPrefetch, w.Concurrency)
+		}
+		if prefetch := w.EffectivePrefetch(); w.GlobalPrefetch < prefetch {
+			return fmt.Errorf("worker.globalPrefetch %d is below the per-consumer prefetch %d", w.GlobalPrefetch, prefetch)
+		}
+	}
+	return nil
+}
+
+// validateRetention rejects negative retention periods, unknown event
+// types, and two names for the same type with different periods.
+func validateRetention(c MongoDBConfig) error {
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid mongodb.retentionDays %d", c.RetentionDays)
+	}
+	byType := make(map[models.EventType]int, len(c.RetentionByEvent))
+	for eventType, n := range c.RetentionByEvent {
+		if n < 0 {
+			return fmt.Errorf("invalid mongodb.retentionByEvent.%s %d", eventType, n)
+		}
+		canonical := models.NormalizeEvent(eventType)
+		if canonical == models.EventTypeUnknown {
+			return fmt.Errorf("unknown event type in mongodb.retentionByEvent: %q", eventType)
+		}
+		if prev, ok := byType[canonical]; ok && prev != n {
+			return fmt.Errorf("conflicting mongodb.retentionByEvent for %s events: %d and %d days", canonical, prev, n)
+		}
+		byType[canonical] = n
+	}
+	return nil
+}
+
+// validateIndexes rejects index definitions MongoDB would refuse, so a bad
+// config fails at startup rather than when the indexes are created.
+func validateIndexes(indexes []IndexConfig) error {
+	names := make(map[string]bool, len(indexes))
+	for i, index := range indexes {
+		if len(index.Keys) == 0 {
+			return fmt.Errorf("mongodb index %d has no keys", i)
+		}
+		fields := make(map[string]bool, len(index.Keys))
+		for _, key := range index.Keys {
+			if key.Field == "" {
+				return fmt.Errorf("mongodb index %d has a key without a field", i)
+			}
+			if fields[key.Field] {
+				return fmt.Errorf("mongodb index %d lists field %q twice", i, key.Field)
+			}
+			fields[key.Field] = true
+			if key.Direction != 1 && key.Direction != -1 {
+				return fmt.Errorf("mongodb index %d field %q has invalid direction %d, want 1 or -1", i, key.Field, key.Direction)
+			}
+		}
+		if index.Name != "" {
+			if names[index.Name] {
+				return fmt.Errorf("duplicate mongodb index name %q", index.Name)
+			}
+			names[index.Name] = true
+		}
+	}
+	return nil
+}
+
+// validateClientStores requires every client store to have a connection and
+// each client to be assigned to at most one of them.
+func validateClientStores(stores []ClientStoreConfig) error {
+	owner := make(map[string]string)
+	for i, store := range stores {
+		if store.Name == "" {
+			return fmt.Errorf("mongodb client store %d has no name", i)
+		}
+		if store.URI == "" {
+			return fmt.Errorf("mongodb client store %q has no uri", store.Name)
+		}
+		if len(store.Clients) == 0 {
+			return fmt.Errorf("mongodb client store %q has no clients", store.Name)
+		}
+		for _, client := range store.Clients {
+			if other, ok := owner[client]; ok {
+				return fmt.Errorf("client %q is assigned to mongodb client stores %q and %q", client, other, store.Name)
+			}
+			owner[client] = store.Name
+		}
+	}
+	return nil
+}
+
+// loadSigningSecretsFromEnv adds secrets from CLIENT_NAME_SIGNING_SECRET
+// variables to the configured ones, overriding them per client.
+func loadSigningSecretsFromEnv(secrets map[string]string) map[string]string {
+	merged := make(map[string]string, len(secrets))
+	for clientID, secret := range secrets {
+		merged[clientID] = secret
+	}
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasSuffix(parts[0], "_SIGNING_SECRET") {
+			continue
+		}
+		clientName := strings.ToLower(strings.TrimSuffix(parts[0], "_SIGNING_SECRET"))
+		merged[clientName] = parts[1]
+	}
+
+	return merged
+}
+
 func loadAPIKeysFromEnv() map[string]string {
 	apiKeys := make(map[string]string)
 
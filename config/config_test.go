@@ -0,0 +1,240 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateIndexes(t *testing.T) {
+	key := func(field string, direction int) IndexKeyConfig {
+		return IndexKeyConfig{Field: field, Direction: direction}
+	}
+
+	tests := []struct {
+		name    string
+		indexes []IndexConfig
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", indexes: []IndexConfig{
+			{Name: "email_event", Keys: []IndexKeyConfig{key("email", 1), key("event", -1)}},
+			{Keys: []IndexKeyConfig{key("tag_name", 1)}, Sparse: true},
+		}},
+		{name: "no keys", indexes: []IndexConfig{{Name: "empty"}}, wantErr: true},
+		{name: "missing field", indexes: []IndexConfig{{Keys: []IndexKeyConfig{key("", 1)}}}, wantErr: true},
+		{name: "bad direction", indexes: []IndexConfig{{Keys: []IndexKeyConfig{key("email", 0)}}}, wantErr: true},
+		{name: "repeated field", indexes: []IndexConfig{{Keys: []IndexKeyConfig{key("email", 1), key("email", -1)}}}, wantErr: true},
+		{name: "duplicate name", indexes: []IndexConfig{
+			{Name: "dup", Keys: []IndexKeyConfig{key("email", 1)}},
+			{Name: "dup", Keys: []IndexKeyConfig{key("event", 1)}},
+		}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIndexes(tt.indexes)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWorkerConcurrency(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          WorkerConfig
+		wantErr      bool
+		wantPrefetch int
+	}{
+		{name: "sequential is unlimited", cfg: WorkerConfig{Concurrency: 1}},
+		{name: "prefetch follows concurrency", cfg: WorkerConfig{Concurrency: 8}, wantPrefetch: 8},
+		{name: "explicit prefetch", cfg: WorkerConfig{Concurrency: 8, Prefetch: 32}, wantPrefetch: 32},
+		{name: "sequential with prefetch", cfg: WorkerConfig{Concurrency: 1, Prefetch: 10}, wantPrefetch: 10},
+		{name: "zero concurrency", cfg: WorkerConfig{}, wantErr: true},
+		{name: "negative prefetch", cfg: WorkerConfig{Concurrency: 1, Prefetch: -1}, wantErr: true},
+		{name: "with client lanes", cfg: WorkerConfig{Concurrency: 4, ClientLanes: 4}, wantErr: true},
+		{name: "global prefetch", cfg: WorkerConfig{Concurrency: 4, Prefetch: 8, GlobalPrefetch: 16}, wantPrefetch: 8},
+		{name: "global prefetch only", cfg: WorkerConfig{Concurrency: 1, GlobalPrefetch: 16}},
+		{name: "negative global prefetch", cfg: WorkerConfig{Concurrency: 1, GlobalPrefetch: -1}, wantErr: true},
+		{name: "global prefetch below concurrency", cfg: WorkerConfig{Concurrency: 8, Prefetch: 2, GlobalPrefetch: 4}, wantErr: true},
+		{name: "global prefetch below per-consumer prefetch", cfg: WorkerConfig{Concurrency: 4, Prefetch: 32, GlobalPrefetch: 16}, wantErr: true},
+		{name: "batching", cfg: WorkerConfig{Concurrency: 2, BatchSize: 50, BatchWait: time.Second}, wantPrefetch: 100},
+		{name: "batching with explicit prefetch", cfg: WorkerConfig{Concurrency: 1, Prefetch: 20, BatchSize: 50, BatchWait: time.Second}, wantPrefetch: 20},
+		{name: "batch size of one", cfg: WorkerConfig{Concurrency: 1, BatchSize: 1}},
+		{name: "negative batch size", cfg: WorkerConfig{Concurrency: 1, BatchSize: -1}, wantErr: true},
+		{name: "batching without wait", cfg: WorkerConfig{Concurrency: 1, BatchSize: 50}, wantErr: true},
+		{name: "batching with client lanes", cfg: WorkerConfig{Concurrency: 1, ClientLanes: 4, BatchSize: 50, BatchWait: time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWorkerConcurrency(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPrefetch, tt.cfg.EffectivePrefetch())
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     MongoDBConfig
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "global", cfg: MongoDBConfig{RetentionDays: 30}},
+		{name: "per event type", cfg: MongoDBConfig{RetentionDays: 30, RetentionByEvent: map[string]int{"bounce": 365, "spam": 0}}},
+		{name: "negative global", cfg: MongoDBConfig{RetentionDays: -1}, wantErr: true},
+		{name: "negative per event type", cfg: MongoDBConfig{RetentionByEvent: map[string]int{"bounce": -1}}, wantErr: true},
+		{name: "variant names", cfg: MongoDBConfig{RetentionByEvent: map[string]int{"Hard Bounce": 365, "complaint": 0}}},
+		{name: "variants agree", cfg: MongoDBConfig{RetentionByEvent: map[string]int{"bounced": 365, "hard_bounce": 365}}},
+		{name: "variants conflict", cfg: MongoDBConfig{RetentionByEvent: map[string]int{"bounced": 30, "hard_bounce": 365}}, wantErr: true},
+		{name: "unknown event type", cfg: MongoDBConfig{RetentionByEvent: map[string]int{"bouncy": 365}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRetention(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	cfg := MongoDBConfig{RetentionDays: 30, RetentionByEvent: map[string]int{"Bounce": 365}}
+	assert.Equal(t, 30*24*time.Hour, cfg.Retention())
+	assert.Equal(t, map[string]time.Duration{"bounce": 365 * 24 * time.Hour}, cfg.EventRetention())
+
+	eventRetention := []struct {
+		name string
+		want string
+	}{
+		{name: "hard_bounce", want: "bounce"},
+		{name: "Soft Bounce", want: "bounce"},
+		{name: "bounced", want: "bounce"},
+		{name: "spam_complaint", want: "spam"},
+		{name: "Delivery", want: "delivered"},
+		{name: "Campaign Sent", want: "campaign_sent"},
+	}
+	for _, tt := range eventRetention {
+		t.Run("key "+tt.name, func(t *testing.T) {
+			cfg := MongoDBConfig{RetentionByEvent: map[string]int{tt.name: 7}}
+			assert.Equal(t, map[string]time.Duration{tt.want: 7 * 24 * time.Hour}, cfg.EventRetention(), "keyed by canonical type")
+		})
+	}
+	assert.Nil(t, MongoDBConfig{}.EventRetention())
+}
+
+func TestValidateSources(t *testing.T) {
+	base := RabbitMQConfig{Exchange: "webhook_events", QueueName: "webhook_queue"}
+	withSources := func(sources ...SourceConfig) RabbitMQConfig {
+		cfg := base
+		cfg.Sources = sources
+		return cfg
+	}
+	sendgrid := SourceConfig{Name: "sendgrid", Exchange: "sendgrid_events", Queue: "sendgrid_webhooks"}
+
+	assert.NoError(t, validateSources(base))
+	assert.NoError(t, validateSources(withSources(sendgrid)))
+	assert.Error(t, validateSources(withSources(SourceConfig{Name: "sendgrid", Exchange: "sendgrid_events"})), "no queue")
+	assert.Error(t, validateSources(withSources(sendgrid, sendgrid)), "duplicate name")
+	assert.Error(t, validateSources(withSources(SourceConfig{Name: "webhook_events", Exchange: "x", Queue: "y"})),
+		"name taken by the main exchange")
+	assert.Error(t, validateSources(withSources(SourceConfig{Name: "other", Exchange: "x", Queue: "webhook_queue"})),
+		"main queue consumed twice")
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	assert.NoError(t, validateRateLimit(RateLimitConfig{}))
+	assert.NoError(t, validateRateLimit(RateLimitConfig{FreeDailyLimit: 10000, FreeWebhookLimit: 20, PremiumWebhookLimit: 50}))
+	assert.Error(t, validateRateLimit(RateLimitConfig{FreeDailyLimit: -1}))
+	assert.Error(t, validateRateLimit(RateLimitConfig{PremiumWebhookLimit: -1}))
+	assert.Error(t, validateRateLimit(RateLimitConfig{FreeDailyStoredLimit: -1}))
+	assert.NoError(t, validateRateLimit(RateLimitConfig{FreeDailyStoredLimit: 500, OverQuota: OverQuotaReject}))
+	assert.Error(t, validateRateLimit(RateLimitConfig{OverQuota: "block"}))
+}
+
+func TestValidateLoadShed(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     LoadShedConfig
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "goroutines", cfg: LoadShedConfig{MaxGoroutines: 10000, MaxFraction: 0.9, SampleInterval: time.Second}},
+		{name: "heap", cfg: LoadShedConfig{MaxHeapMB: 512, MaxFraction: 1, SampleInterval: time.Second}},
+		{name: "negative threshold", cfg: LoadShedConfig{MaxHeapMB: -1}, wantErr: true},
+		{name: "zero fraction", cfg: LoadShedConfig{MaxGoroutines: 10000, SampleInterval: time.Second}, wantErr: true},
+		{name: "fraction above 1", cfg: LoadShedConfig{MaxGoroutines: 10000, MaxFraction: 1.5, SampleInterval: time.Second}, wantErr: true},
+		{name: "no sample interval", cfg: LoadShedConfig{MaxGoroutines: 10000, MaxFraction: 0.9}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLoadShed(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateClientStores(t *testing.T) {
+	store := func(name, uri string, clients ...string) ClientStoreConfig {
+		return ClientStoreConfig{Name: name, URI: uri, Clients: clients}
+	}
+
+	tests := []struct {
+		name    string
+		stores  []ClientStoreConfig
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", stores: []ClientStoreConfig{
+			store("eu", "mongodb://eu", "client-a", "client-b"),
+			store("us", "mongodb://us", "client-c"),
+		}},
+		{name: "no name", stores: []ClientStoreConfig{store("", "mongodb://eu", "client-a")}, wantErr: true},
+		{name: "no uri", stores: []ClientStoreConfig{store("eu", "", "client-a")}, wantErr: true},
+		{name: "no clients", stores: []ClientStoreConfig{store("eu", "mongodb://eu")}, wantErr: true},
+		{name: "client in two stores", stores: []ClientStoreConfig{
+			store("eu", "mongodb://eu", "client-a"),
+			store("us", "mongodb://us", "client-a"),
+		}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateClientStores(tt.stores)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadSigningSecretsFromEnv(t *testing.T) {
+	t.Setenv("ACME_SIGNING_SECRET", "from-env")
+	t.Setenv("ACME_API_KEY", "not-a-secret")
+
+	secrets := loadSigningSecretsFromEnv(map[string]string{"acme": "from-config", "globex": "configured"})
+
+	assert.Equal(t, "from-env", secrets["acme"], "environment overrides config")
+	assert.Equal(t, "configured", secrets["globex"])
+	assert.Len(t, secrets, 2)
+}
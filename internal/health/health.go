@@ -0,0 +1,135 @@
+// Package health aggregates the state of the subsystems the service depends
+// on into a single report for operators.
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Status is the state of a subsystem or of the service overall.
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+// Result is the outcome of a single subsystem check.
+type Result struct {
+	Status Status `json:"status"`
+	// Detail explains a non-ok status.
+	Detail string `json:"detail,omitempty"`
+	// Data carries subsystem specifics such as queue depth.
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// CheckFunc reports the state of one subsystem. It should return promptly
+// once ctx is done.
+type CheckFunc func(ctx context.Context) Result
+
+// Report is the aggregated state of all subsystems.
+type Report struct {
+	Status     Status            `json:"status"`
+	Subsystems map[string]Result `json:"subsystems"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Checker runs the registered subsystem checks. The overall status is down
+// if any critical subsystem is down, degraded if any subsystem is not ok,
+// and ok otherwise.
+type Checker struct {
+	timeout time.Duration
+	checks  []check
+}
+
+// NewChecker creates a checker that gives each run timeout to complete.
+// Checks still running at the deadline are reported down.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a subsystem check. A critical subsystem being down takes
+// the whole service down; others only degrade it.
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+// Run runs every check concurrently and aggregates the results.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	done := make([]chan struct{}, len(c.checks))
+	for i, ch := range c.checks {
+		done[i] = make(chan struct{})
+		go func(i int, ch check) {
+			defer close(done[i])
+			results[i] = ch.fn(ctx)
+		}(i, ch)
+	}
+
+	report := Report{
+		Status:     StatusOK,
+		Subsystems: make(map[string]Result, len(c.checks)),
+		CheckedAt:  time.Now().UTC(),
+	}
+	for i, ch := range c.checks {
+		var r Result
+		select {
+		case <-done[i]:
+			r = results[i]
+		case <-ctx.Done():
+			r = Result{Status: StatusDown, Detail: "check timed out"}
+		}
+		report.Subsystems[ch.name] = r
+		report.Status = worse(report.Status, verdict(r.Status, ch.critical))
+	}
+	return report
+}
+
+// verdict is the effect of a subsystem's status on the overall status.
+func verdict(status Status, critical bool) Status {
+	switch {
+	case status == StatusOK:
+		return StatusOK
+	case status == StatusDown && critical:
+		return StatusDown
+	default:
+		return StatusDegraded
+	}
+}
+
+func worse(a, b Status) Status {
+	rank := map[Status]int{StatusOK: 0, StatusDegraded: 1, StatusDown: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// HTTPReachable reports a remote API down if url can't be reached. Any HTTP
+// response counts as reachable, since the endpoint may require auth.
+func HTTPReachable(client *http.Client, url string) CheckFunc {
+	return func(ctx context.Context) Result {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return Result{Status: StatusDown, Detail: err.Error()}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return Result{Status: StatusDown, Detail: err.Error()}
+		}
+		resp.Body.Close()
+		return Result{Status: StatusOK, Data: map[string]interface{}{"http_status": resp.StatusCode}}
+	}
+}
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixed(status Status) CheckFunc {
+	return func(ctx context.Context) Result {
+		return Result{Status: status}
+	}
+}
+
+func TestCheckerVerdict(t *testing.T) {
+	tests := []struct {
+		name     string
+		critical Status
+		optional Status
+		want     Status
+	}{
+		{name: "all ok", critical: StatusOK, optional: StatusOK, want: StatusOK},
+		{name: "optional down degrades", critical: StatusOK, optional: StatusDown, want: StatusDegraded},
+		{name: "critical degraded", critical: StatusDegraded, optional: StatusOK, want: StatusDegraded},
+		{name: "critical down", critical: StatusDown, optional: StatusOK, want: StatusDown},
+		{name: "down outranks degraded", critical: StatusDown, optional: StatusDegraded, want: StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(time.Second)
+			checker.Add("rabbitmq", true, fixed(tt.critical))
+			checker.Add("mongodb", false, fixed(tt.optional))
+
+			report := checker.Run(context.Background())
+
+			assert.Equal(t, tt.want, report.Status)
+			assert.Equal(t, tt.critical, report.Subsystems["rabbitmq"].Status)
+			assert.Equal(t, tt.optional, report.Subsystems["mongodb"].Status)
+		})
+	}
+}
+
+func TestCheckerTimesOutSlowChecks(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	checker := NewChecker(10 * time.Millisecond)
+	checker.Add("slow", true, func(ctx context.Context) Result {
+		<-release
+		return Result{Status: StatusOK}
+	})
+	checker.Add("fast", false, fixed(StatusOK))
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, "check timed out", report.Subsystems["slow"].Detail)
+	assert.Equal(t, StatusOK, report.Subsystems["fast"].Status)
+}
+
+func TestHTTPReachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	result := HTTPReachable(server.Client(), server.URL)(context.Background())
+	assert.Equal(t, StatusOK, result.Status, "any response means reachable")
+
+	server.Close()
+	result = HTTPReachable(server.Client(), server.URL)(context.Background())
+	assert.Equal(t, StatusDown, result.Status)
+	assert.NotEmpty(t, result.Detail)
+}
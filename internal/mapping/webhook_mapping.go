@@ -1,28 +1,68 @@
 package mapping
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// MailerCloudAPIURL is the base URL of the MailerCloud API.
+// The MAILERCLOUD_API_URL environment variable overrides it.
+const MailerCloudAPIURL = "https://cloudapi.mailercloud.com/v1"
+
 // WebhookMapping represents the mapping between webhook IDs and clients
 type WebhookMapping struct {
 	WebhookToClient map[string]string `json:"webhook_to_client"`
 	ClientToAPIKey  map[string]string `json:"client_to_api_key"`
-	LastUpdated     time.Time         `json:"last_updated"`
+	// ClientToSecret holds the signing secret configured on each client's
+	// MailerCloud webhooks, for clients that have one.
+	ClientToSecret map[string]string `json:"-"`
+	LastUpdated    time.Time         `json:"last_updated"`
+}
+
+// Cache keeps the last good webhook-to-client mapping, so it survives a
+// restart while MailerCloud is unreachable. storage.MongoDB implements it.
+type Cache interface {
+	SaveWebhookMapping(ctx context.Context, webhookToClient map[string]string, updatedAt time.Time) error
+	// LoadWebhookMapping returns a nil mapping if none has been saved
+	LoadWebhookMapping(ctx context.Context) (map[string]string, time.Time, error)
 }
 
+// OverrideStore keeps the webhooks mapped to a client by hand, so they
+// survive a restart. storage.MongoDB implements it.
+type OverrideStore interface {
+	SaveWebhookOverride(ctx context.Context, webhookID, clientID string, at time.Time) error
+	LoadWebhookOverrides(ctx context.Context) (map[string]string, error)
+}
+
+// ErrNoOverrideStore is returned by SetOverride when EnableOverrides hasn't
+// been called, as an override that is lost on restart would be misleading.
+var ErrNoOverrideStore = errors.New("no webhook mapping override store configured")
+
 // WebhookMappingService handles webhook ID to client ID mapping
 type WebhookMappingService struct {
+	// mu guards mapping and overrides, which are replaced wholesale; they
+	// are never modified once swapped in.
+	mu      sync.RWMutex
 	mapping *WebhookMapping
-	logger  *zap.Logger
+	// overrides maps webhooks to a client by hand, ahead of mapping
+	overrides map[string]string
+	logger    *zap.Logger
+	// apiURL is the MailerCloud API base URL
+	apiURL string
+	// cache is nil unless EnableCache is called
+	cache Cache
+	// overrideStore is nil unless EnableOverrides is called
+	overrideStore OverrideStore
 }
 
 // MailerCloudWebhook represents webhook data from MailerCloud API
@@ -30,6 +70,8 @@ type MailerCloudWebhook struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 	URL  string `json:"url"`
+	// Secret signs the webhook's requests; empty when signing is off
+	Secret string `json:"secret"`
 }
 
 // MailerCloudWebhookList represents the response from MailerCloud webhook search
@@ -48,17 +90,168 @@ type SearchWebhooksRequest struct {
 
 // NewWebhookMappingService creates a new webhook mapping service
 func NewWebhookMappingService(logger *zap.Logger) *WebhookMappingService {
+	apiURL := os.Getenv("MAILERCLOUD_API_URL")
+	if apiURL == "" {
+		apiURL = MailerCloudAPIURL
+	}
 	return &WebhookMappingService{
 		mapping: &WebhookMapping{
 			WebhookToClient: make(map[string]string),
 			ClientToAPIKey:  make(map[string]string),
+			ClientToSecret:  make(map[string]string),
 			LastUpdated:     time.Now(),
 		},
 		logger: logger,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+	}
+}
+
+// EnableCache makes Refresh save each mapping loaded from MailerCloud to
+// cache, and fall back to it when MailerCloud can't be reached. It must be
+// called before the mapping is first loaded.
+func (wms *WebhookMappingService) EnableCache(cache Cache) {
+	wms.cache = cache
+}
+
+// EnableOverrides makes SetOverride save overrides to store, and Refresh
+// reload them from it, so overrides set on another instance are picked up
+// too. It must be called before the mapping is first loaded.
+func (wms *WebhookMappingService) EnableOverrides(store OverrideStore) {
+	wms.overrideStore = store
+}
+
+// SetOverride maps webhookID to clientID ahead of the mapping loaded from
+// MailerCloud, which reloads don't change. It is saved before it takes
+// effect, and returns the client the webhook mapped to before.
+func (wms *WebhookMappingService) SetOverride(ctx context.Context, webhookID, clientID string) (string, error) {
+	if wms.overrideStore == nil {
+		return "", ErrNoOverrideStore
+	}
+	if err := wms.overrideStore.SaveWebhookOverride(ctx, webhookID, clientID, time.Now().UTC()); err != nil {
+		return "", err
+	}
+
+	wms.mu.Lock()
+	previous, ok := wms.overrides[webhookID]
+	if !ok {
+		previous = wms.mapping.WebhookToClient[webhookID]
 	}
+	next := make(map[string]string, len(wms.overrides)+1)
+	for id, client := range wms.overrides {
+		next[id] = client
+	}
+	next[webhookID] = clientID
+	wms.overrides = next
+	wms.mu.Unlock()
+
+	wms.logger.Info("Webhook mapping overridden",
+		zap.String("webhook_id", webhookID),
+		zap.String("from_client_id", previous),
+		zap.String("client_id", clientID))
+	return previous, nil
 }
 
-// LoadMappingFromEnvironment loads the webhook-to-client mapping on startup
+// loadOverrides replaces the overrides with the saved ones, if there is an
+// override store.
+func (wms *WebhookMappingService) loadOverrides(ctx context.Context) error {
+	if wms.overrideStore == nil {
+		return nil
+	}
+	overrides, err := wms.overrideStore.LoadWebhookOverrides(ctx)
+	if err != nil {
+		return err
+	}
+	wms.mu.Lock()
+	wms.overrides = overrides
+	wms.mu.Unlock()
+	return nil
+}
+
+// Refresh loads the mapping from MailerCloud and saves it to the cache. If
+// some client's webhooks can't be fetched, webhooks missing from the mapping
+// are filled in from the cache instead; an error is only returned if that
+// isn't possible either. Overrides are reloaded first, and kept if that
+// fails.
+func (wms *WebhookMappingService) Refresh(ctx context.Context) error {
+	if err := wms.loadOverrides(ctx); err != nil {
+		wms.logger.Warn("Failed to load webhook mapping overrides, keeping the current ones", zap.Error(err))
+	}
+
+	err := wms.LoadMappingFromEnvironment()
+	if err == nil {
+		if err := wms.SaveMapping(ctx); err != nil {
+			wms.logger.Warn("Failed to cache webhook mapping", zap.Error(err))
+		}
+		return nil
+	}
+	if wms.cache == nil {
+		return err
+	}
+
+	if cacheErr := wms.LoadCachedMapping(ctx); cacheErr != nil {
+		return fmt.Errorf("%v; falling back to the cached mapping failed: %v", err, cacheErr)
+	}
+	wms.logger.Warn("MailerCloud API unavailable, using cached webhook mapping", zap.Error(err))
+	return nil
+}
+
+// SaveMapping saves the current webhook-to-client mapping to the cache, if
+// there is one.
+func (wms *WebhookMappingService) SaveMapping(ctx context.Context) error {
+	if wms.cache == nil {
+		return nil
+	}
+	mapping := wms.Mapping()
+	return wms.cache.SaveWebhookMapping(ctx, mapping.WebhookToClient, mapping.LastUpdated)
+}
+
+// LoadCachedMapping adds the cached webhooks missing from the current
+// mapping. Webhooks already mapped keep their live client.
+func (wms *WebhookMappingService) LoadCachedMapping(ctx context.Context) error {
+	if wms.cache == nil {
+		return fmt.Errorf("no webhook mapping cache configured")
+	}
+	cached, cachedAt, err := wms.cache.LoadWebhookMapping(ctx)
+	if err != nil {
+		return err
+	}
+	if len(cached) == 0 {
+		return fmt.Errorf("no cached webhook mapping")
+	}
+
+	wms.mu.Lock()
+	previous := wms.mapping
+	next := &WebhookMapping{
+		WebhookToClient: make(map[string]string, len(cached)),
+		ClientToAPIKey:  previous.ClientToAPIKey,
+		ClientToSecret:  previous.ClientToSecret,
+		LastUpdated:     previous.LastUpdated,
+	}
+	for webhookID, clientID := range cached {
+		next.WebhookToClient[webhookID] = clientID
+	}
+	for webhookID, clientID := range previous.WebhookToClient {
+		next.WebhookToClient[webhookID] = clientID
+	}
+	// A mapping that is all cache is as old as the cache
+	if len(previous.WebhookToClient) == 0 {
+		next.LastUpdated = cachedAt
+	}
+	wms.mapping = next
+	wms.mu.Unlock()
+
+	wms.logChanges(previous, next)
+	wms.logger.Info("Webhook mapping loaded from cache",
+		zap.Int("cached_webhooks", len(cached)),
+		zap.Int("total_webhooks", len(next.WebhookToClient)),
+		zap.Time("cached_at", cachedAt))
+	return nil
+}
+
+// LoadMappingFromEnvironment fetches the webhook-to-client mapping for the
+// clients in MAILERCLOUD_API_KEYS, with their signing secrets, and swaps it
+// in. A client whose webhooks can't be fetched keeps the ones it had, and
+// an error is returned once the rest are swapped in.
 func (wms *WebhookMappingService) LoadMappingFromEnvironment() error {
 	wms.logger.Info("Loading webhook-to-client mapping from MailerCloud API")
 
@@ -68,46 +261,161 @@ func (wms *WebhookMappingService) LoadMappingFromEnvironment() error {
 		return fmt.Errorf("MAILERCLOUD_API_KEYS environment variable is not set")
 	}
 
-	clients := make(map[string]string) // client -> apiKey
+	next := &WebhookMapping{
+		WebhookToClient: make(map[string]string),
+		ClientToAPIKey:  make(map[string]string),
+		ClientToSecret:  make(map[string]string),
+	}
 	for _, config := range strings.Split(apiKeysEnv, ",") {
 		parts := strings.Split(config, ":")
 		if len(parts) != 2 {
 			wms.logger.Warn("Invalid client config format", zap.String("config", config))
 			continue
 		}
-		clientID, apiKey := parts[0], parts[1]
-		clients[clientID] = apiKey
-		wms.mapping.ClientToAPIKey[clientID] = apiKey
+		next.ClientToAPIKey[parts[0]] = parts[1]
 	}
 
+	wms.mu.RLock()
+	previous := wms.mapping
+	wms.mu.RUnlock()
+
 	// For each client, fetch their webhooks from MailerCloud
-	for clientID, apiKey := range clients {
+	failed := 0
+	for clientID, apiKey := range next.ClientToAPIKey {
 		webhooks, err := wms.fetchWebhooksForClient(clientID, apiKey)
 		if err != nil {
-			wms.logger.Error("Failed to fetch webhooks for client",
+			failed++
+			wms.logger.Error("Failed to fetch webhooks for client, keeping its previous webhooks",
 				zap.String("client", clientID),
 				zap.Error(err))
+			for webhookID, owner := range previous.WebhookToClient {
+				if owner == clientID {
+					next.WebhookToClient[webhookID] = clientID
+				}
+			}
+			if secret, ok := previous.ClientToSecret[clientID]; ok {
+				next.ClientToSecret[clientID] = secret
+			}
 			continue
 		}
 
 		// Map webhook IDs to client
 		for _, webhook := range webhooks {
-			wms.mapping.WebhookToClient[webhook.ID] = clientID
-			wms.logger.Info("Mapped webhook to client",
+			next.WebhookToClient[webhook.ID] = clientID
+			wms.logger.Debug("Mapped webhook to client",
 				zap.String("webhook_id", webhook.ID),
 				zap.String("client_id", clientID),
 				zap.String("webhook_name", webhook.Name))
+
+			if webhook.Secret == "" {
+				continue
+			}
+			if secret, ok := next.ClientToSecret[clientID]; ok && secret != webhook.Secret {
+				wms.logger.Warn("Client's webhooks have different signing secrets, using the first",
+					zap.String("client_id", clientID),
+					zap.String("webhook_id", webhook.ID))
+				continue
+			}
+			next.ClientToSecret[clientID] = webhook.Secret
 		}
 	}
 
-	wms.mapping.LastUpdated = time.Now()
-	wms.logger.Info("Webhook mapping loaded successfully",
-		zap.Int("total_webhooks", len(wms.mapping.WebhookToClient)),
-		zap.Int("total_clients", len(wms.mapping.ClientToAPIKey)))
+	next.LastUpdated = time.Now()
+	wms.mu.Lock()
+	previous = wms.mapping
+	wms.mapping = next
+	wms.mu.Unlock()
+
+	wms.logChanges(previous, next)
+	if failed > 0 {
+		return fmt.Errorf("failed to fetch webhooks for %d of %d clients", failed, len(next.ClientToAPIKey))
+	}
+	wms.logger.Info("Webhook mapping loaded from MailerCloud API",
+		zap.Int("total_webhooks", len(next.WebhookToClient)),
+		zap.Int("total_clients", len(next.ClientToAPIKey)))
 
 	return nil
 }
 
+// StartPeriodicRefresh reloads the mapping every interval until ctx is
+// done, so webhooks added in MailerCloud are recognized without a restart.
+// A failed reload is logged and the current mapping kept, topped up from
+// the cache.
+func (wms *WebhookMappingService) StartPeriodicRefresh(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := wms.Refresh(ctx); err != nil {
+					wms.logger.Error("Failed to refresh webhook mapping", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Delta lists the webhooks that changed between two mappings, keyed by
+// webhook ID.
+type Delta struct {
+	// Added maps new webhooks to their client
+	Added map[string]string `json:"added"`
+	// Removed maps dropped webhooks to the client they had
+	Removed map[string]string `json:"removed"`
+	// Moved maps webhooks that changed client to the new one
+	Moved map[string]string `json:"moved"`
+}
+
+// Diff returns the changes from previous to next.
+func Diff(previous, next *WebhookMapping) Delta {
+	delta := Delta{
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Moved:   make(map[string]string),
+	}
+	for webhookID, clientID := range next.WebhookToClient {
+		before, existed := previous.WebhookToClient[webhookID]
+		switch {
+		case !existed:
+			delta.Added[webhookID] = clientID
+		case before != clientID:
+			delta.Moved[webhookID] = clientID
+		}
+	}
+	for webhookID, clientID := range previous.WebhookToClient {
+		if _, exists := next.WebhookToClient[webhookID]; !exists {
+			delta.Removed[webhookID] = clientID
+		}
+	}
+	return delta
+}
+
+// logChanges logs the webhooks added, removed or moved to another client
+// between two mappings.
+func (wms *WebhookMappingService) logChanges(previous, next *WebhookMapping) {
+	delta := Diff(previous, next)
+	for webhookID, clientID := range delta.Added {
+		wms.logger.Info("Webhook added to mapping",
+			zap.String("webhook_id", webhookID),
+			zap.String("client_id", clientID))
+	}
+	for webhookID, clientID := range delta.Moved {
+		wms.logger.Warn("Webhook moved to another client",
+			zap.String("webhook_id", webhookID),
+			zap.String("from_client_id", previous.WebhookToClient[webhookID]),
+			zap.String("client_id", clientID))
+	}
+	for webhookID, clientID := range delta.Removed {
+		wms.logger.Info("Webhook removed from mapping",
+			zap.String("webhook_id", webhookID),
+			zap.String("client_id", clientID))
+	}
+}
+
 // fetchWebhooksForClient fetches webhooks for a specific client using MailerCloud API
 func (wms *WebhookMappingService) fetchWebhooksForClient(clientID, apiKey string) ([]MailerCloudWebhook, error) {
 	searchReq := SearchWebhooksRequest{
@@ -123,7 +431,7 @@ func (wms *WebhookMappingService) fetchWebhooksForClient(clientID, apiKey string
 		return nil, fmt.Errorf("error marshaling search request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://cloudapi.mailercloud.com/v1/webhooks/search", strings.NewReader(string(jsonData)))
+	req, err := http.NewRequest("POST", wms.apiURL+"/webhooks/search", strings.NewReader(string(jsonData)))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -152,24 +460,67 @@ func (wms *WebhookMappingService) fetchWebhooksForClient(clientID, apiKey string
 	return webhookList.Data, nil
 }
 
-// GetClientForWebhook returns the client ID for a given webhook ID
+// Mapping returns the mapping in use, which a later load replaces rather
+// than changes. It must not be modified, and holds the clients' API keys.
+func (wms *WebhookMappingService) Mapping() *WebhookMapping {
+	wms.mu.RLock()
+	defer wms.mu.RUnlock()
+	return wms.mapping
+}
+
+// Overrides returns the webhooks mapped to a client by hand. It must not be
+// modified.
+func (wms *WebhookMappingService) Overrides() map[string]string {
+	wms.mu.RLock()
+	defer wms.mu.RUnlock()
+	return wms.overrides
+}
+
+// GetClientForWebhook returns the client ID for a given webhook ID, from
+// its override if it has one
 func (wms *WebhookMappingService) GetClientForWebhook(webhookID string) (string, bool) {
-	clientID, exists := wms.mapping.WebhookToClient[webhookID]
+	wms.mu.RLock()
+	clientID, exists := wms.overrides[webhookID]
+	mapping := wms.mapping
+	wms.mu.RUnlock()
+	if exists {
+		return clientID, true
+	}
+	clientID, exists = mapping.WebhookToClient[webhookID]
 	return clientID, exists
 }
 
 // GetAPIKeyForClient returns the API key for a given client ID
 func (wms *WebhookMappingService) GetAPIKeyForClient(clientID string) (string, bool) {
-	apiKey, exists := wms.mapping.ClientToAPIKey[clientID]
+	apiKey, exists := wms.Mapping().ClientToAPIKey[clientID]
 	return apiKey, exists
 }
 
+// GetSigningSecretForClient returns the signing secret configured on a
+// client's MailerCloud webhooks. It reports false for clients that don't
+// sign their webhooks.
+func (wms *WebhookMappingService) GetSigningSecretForClient(clientID string) (string, bool) {
+	secret, exists := wms.Mapping().ClientToSecret[clientID]
+	return secret, exists
+}
+
+// LastUpdated returns when the mapping was last loaded.
+func (wms *WebhookMappingService) LastUpdated() time.Time {
+	return wms.Mapping().LastUpdated
+}
+
+// WebhookCount returns the number of mapped webhook IDs.
+func (wms *WebhookMappingService) WebhookCount() int {
+	return len(wms.Mapping().WebhookToClient)
+}
+
 // GetMappingStats returns statistics about the current mapping
 func (wms *WebhookMappingService) GetMappingStats() map[string]interface{} {
+	mapping := wms.Mapping()
 	return map[string]interface{}{
-		"total_webhooks":    len(wms.mapping.WebhookToClient),
-		"total_clients":     len(wms.mapping.ClientToAPIKey),
-		"last_updated":      wms.mapping.LastUpdated,
-		"webhook_to_client": wms.mapping.WebhookToClient,
+		"total_webhooks":    len(mapping.WebhookToClient),
+		"total_clients":     len(mapping.ClientToAPIKey),
+		"last_updated":      mapping.LastUpdated,
+		"webhook_to_client": mapping.WebhookToClient,
 	}
 }
@@ -0,0 +1,377 @@
+package mapping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeMailerCloud serves /webhooks/search from a per-API-key webhook list
+// that tests can change between loads.
+type fakeMailerCloud struct {
+	mu       sync.Mutex
+	webhooks map[string][]string // api key -> webhook IDs
+	secrets  map[string]string   // api key -> signing secret
+	failing  map[string]bool
+}
+
+func newFakeMailerCloud(t *testing.T, webhooks map[string][]string) (*fakeMailerCloud, *httptest.Server) {
+	t.Helper()
+	f := &fakeMailerCloud{webhooks: webhooks, secrets: map[string]string{}, failing: map[string]bool{}}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeMailerCloud) set(apiKey string, ids ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.webhooks[apiKey] = ids
+}
+
+func (f *fakeMailerCloud) setSecret(apiKey, secret string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[apiKey] = secret
+}
+
+func (f *fakeMailerCloud) fail(apiKey string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing[apiKey] = true
+}
+
+func (f *fakeMailerCloud) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	apiKey := r.Header.Get("Authorization")
+	if f.failing[apiKey] {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var resp MailerCloudWebhookList
+	for _, id := range f.webhooks[apiKey] {
+		resp.Data = append(resp.Data, MailerCloudWebhook{ID: id, Name: "hook " + id, Secret: f.secrets[apiKey]})
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestService(t *testing.T, apiURL string) (*WebhookMappingService, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	wms := NewWebhookMappingService(zap.New(core))
+	wms.apiURL = apiURL
+	return wms, logs
+}
+
+func TestLoadMappingSwapsMapping(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a,client-b:key-b")
+	api, srv := newFakeMailerCloud(t, map[string][]string{
+		"key-a": {"wh-1", "wh-2"},
+		"key-b": {"wh-3"},
+	})
+	wms, logs := newTestService(t, srv.URL)
+
+	require.NoError(t, wms.LoadMappingFromEnvironment())
+	assert.Equal(t, 3, wms.WebhookCount())
+	assert.Equal(t, 3, logs.FilterMessage("Webhook added to mapping").Len())
+
+	api.set("key-a", "wh-1", "wh-4")
+	logs.TakeAll()
+	require.NoError(t, wms.LoadMappingFromEnvironment())
+
+	clientID, ok := wms.GetClientForWebhook("wh-4")
+	assert.True(t, ok)
+	assert.Equal(t, "client-a", clientID)
+	_, ok = wms.GetClientForWebhook("wh-2")
+	assert.False(t, ok, "removed webhook should no longer be mapped")
+
+	added := logs.FilterMessage("Webhook added to mapping").All()
+	require.Len(t, added, 1)
+	assert.Equal(t, "wh-4", added[0].ContextMap()["webhook_id"])
+	removed := logs.FilterMessage("Webhook removed from mapping").All()
+	require.Len(t, removed, 1)
+	assert.Equal(t, "wh-2", removed[0].ContextMap()["webhook_id"])
+}
+
+func TestLoadMappingKeepsWebhooksOfFailedClient(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a,client-b:key-b")
+	api, srv := newFakeMailerCloud(t, map[string][]string{
+		"key-a": {"wh-1"},
+		"key-b": {"wh-2"},
+	})
+	wms, _ := newTestService(t, srv.URL)
+	require.NoError(t, wms.LoadMappingFromEnvironment())
+
+	api.fail("key-b")
+	api.set("key-a", "wh-3")
+	assert.EqualError(t, wms.LoadMappingFromEnvironment(), "failed to fetch webhooks for 1 of 2 clients")
+
+	clientID, ok := wms.GetClientForWebhook("wh-2")
+	assert.True(t, ok, "webhooks of a client that failed to load should be kept")
+	assert.Equal(t, "client-b", clientID)
+	_, ok = wms.GetClientForWebhook("wh-1")
+	assert.False(t, ok)
+}
+
+func TestLoadMappingFetchesSigningSecrets(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a,client-b:key-b")
+	api, srv := newFakeMailerCloud(t, map[string][]string{
+		"key-a": {"wh-1"},
+		"key-b": {"wh-2"},
+	})
+	api.setSecret("key-a", "secret-a")
+	wms, _ := newTestService(t, srv.URL)
+	require.NoError(t, wms.LoadMappingFromEnvironment())
+
+	secret, ok := wms.GetSigningSecretForClient("client-a")
+	assert.True(t, ok)
+	assert.Equal(t, "secret-a", secret)
+	_, ok = wms.GetSigningSecretForClient("client-b")
+	assert.False(t, ok, "clients without a secret don't sign their webhooks")
+
+	api.fail("key-a")
+	assert.Error(t, wms.LoadMappingFromEnvironment())
+	secret, ok = wms.GetSigningSecretForClient("client-a")
+	assert.True(t, ok, "a client that failed to load keeps its secret")
+	assert.Equal(t, "secret-a", secret)
+}
+
+// fakeCache is an in-memory Cache.
+type fakeCache struct {
+	mu              sync.Mutex
+	webhookToClient map[string]string
+	updatedAt       time.Time
+	saves           int
+}
+
+func (c *fakeCache) SaveWebhookMapping(ctx context.Context, webhookToClient map[string]string, updatedAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.webhookToClient = webhookToClient
+	c.updatedAt = updatedAt
+	c.saves++
+	return nil
+}
+
+func (c *fakeCache) LoadWebhookMapping(ctx context.Context) (map[string]string, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.webhookToClient, c.updatedAt, nil
+}
+
+func TestRefreshSavesMappingToCache(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+	_, srv := newFakeMailerCloud(t, map[string][]string{"key-a": {"wh-1"}})
+	wms, logs := newTestService(t, srv.URL)
+	cache := &fakeCache{}
+	wms.EnableCache(cache)
+
+	require.NoError(t, wms.Refresh(context.Background()))
+
+	assert.Equal(t, 1, cache.saves)
+	assert.Equal(t, map[string]string{"wh-1": "client-a"}, cache.webhookToClient)
+	assert.Equal(t, 1, logs.FilterMessage("Webhook mapping loaded from MailerCloud API").Len())
+}
+
+func TestRefreshFallsBackToCache(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a,client-b:key-b")
+	api, srv := newFakeMailerCloud(t, map[string][]string{"key-b": {"wh-2"}})
+	api.fail("key-a")
+	wms, logs := newTestService(t, srv.URL)
+	cachedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := &fakeCache{
+		webhookToClient: map[string]string{"wh-1": "client-a", "wh-2": "client-stale"},
+		updatedAt:       cachedAt,
+	}
+	wms.EnableCache(cache)
+
+	require.NoError(t, wms.Refresh(context.Background()))
+
+	clientID, ok := wms.GetClientForWebhook("wh-1")
+	assert.True(t, ok, "webhooks of clients that failed to load come from the cache")
+	assert.Equal(t, "client-a", clientID)
+	clientID, _ = wms.GetClientForWebhook("wh-2")
+	assert.Equal(t, "client-b", clientID, "live webhooks win over cached ones")
+	assert.Equal(t, 0, cache.saves, "a partial load isn't cached")
+	assert.Equal(t, 1, logs.FilterMessage("Webhook mapping loaded from cache").Len())
+	assert.Equal(t, 1, logs.FilterMessage("MailerCloud API unavailable, using cached webhook mapping").Len())
+}
+
+func TestRefreshWithoutCache(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+	api, srv := newFakeMailerCloud(t, map[string][]string{})
+	api.fail("key-a")
+
+	wms, _ := newTestService(t, srv.URL)
+	assert.Error(t, wms.Refresh(context.Background()))
+
+	wms, _ = newTestService(t, srv.URL)
+	wms.EnableCache(&fakeCache{})
+	assert.ErrorContains(t, wms.Refresh(context.Background()), "no cached webhook mapping")
+	assert.Zero(t, wms.WebhookCount())
+}
+
+// fakeOverrideStore is an in-memory OverrideStore.
+type fakeOverrideStore struct {
+	mu        sync.Mutex
+	overrides map[string]string
+	err       error
+}
+
+func (s *fakeOverrideStore) SaveWebhookOverride(ctx context.Context, webhookID, clientID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.overrides == nil {
+		s.overrides = make(map[string]string)
+	}
+	s.overrides[webhookID] = clientID
+	return nil
+}
+
+func (s *fakeOverrideStore) LoadWebhookOverrides(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	overrides := make(map[string]string, len(s.overrides))
+	for webhookID, clientID := range s.overrides {
+		overrides[webhookID] = clientID
+	}
+	return overrides, nil
+}
+
+func TestOverrideTakesPrecedence(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a,client-b:key-b")
+	_, srv := newFakeMailerCloud(t, map[string][]string{"key-a": {"wh-1", "wh-2"}, "key-b": {"wh-3"}})
+	wms, _ := newTestService(t, srv.URL)
+	store := &fakeOverrideStore{}
+	wms.EnableOverrides(store)
+	require.NoError(t, wms.Refresh(context.Background()))
+
+	previous, err := wms.SetOverride(context.Background(), "wh-1", "client-b")
+	require.NoError(t, err)
+	assert.Equal(t, "client-a", previous)
+	assert.Equal(t, map[string]string{"wh-1": "client-b"}, store.overrides, "overrides are saved")
+
+	clientID, ok := wms.GetClientForWebhook("wh-1")
+	assert.True(t, ok)
+	assert.Equal(t, "client-b", clientID)
+	clientID, _ = wms.GetClientForWebhook("wh-2")
+	assert.Equal(t, "client-a", clientID, "other webhooks keep the loaded mapping")
+
+	require.NoError(t, wms.Refresh(context.Background()))
+	clientID, _ = wms.GetClientForWebhook("wh-1")
+	assert.Equal(t, "client-b", clientID, "the override survives a refresh")
+	assert.Equal(t, "client-a", wms.Mapping().WebhookToClient["wh-1"], "the loaded mapping is unchanged")
+
+	// A restarted instance loads the saved override with the mapping
+	restarted, _ := newTestService(t, srv.URL)
+	restarted.EnableOverrides(store)
+	require.NoError(t, restarted.Refresh(context.Background()))
+	clientID, _ = restarted.GetClientForWebhook("wh-1")
+	assert.Equal(t, "client-b", clientID)
+}
+
+func TestOverrideOfUnmappedWebhook(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+	_, srv := newFakeMailerCloud(t, map[string][]string{"key-a": {"wh-1"}})
+	wms, _ := newTestService(t, srv.URL)
+	store := &fakeOverrideStore{}
+	wms.EnableOverrides(store)
+	require.NoError(t, wms.Refresh(context.Background()))
+
+	previous, err := wms.SetOverride(context.Background(), "wh-9", "client-a")
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+	clientID, ok := wms.GetClientForWebhook("wh-9")
+	assert.True(t, ok)
+	assert.Equal(t, "client-a", clientID)
+
+	// Failing to reload the overrides keeps the current ones
+	store.err = errors.New("mongo unavailable")
+	require.NoError(t, wms.Refresh(context.Background()))
+	_, ok = wms.GetClientForWebhook("wh-9")
+	assert.True(t, ok)
+}
+
+func TestSetOverrideFailures(t *testing.T) {
+	wms, _ := newTestService(t, "")
+	_, err := wms.SetOverride(context.Background(), "wh-1", "client-a")
+	assert.ErrorIs(t, err, ErrNoOverrideStore)
+
+	wms.EnableOverrides(&fakeOverrideStore{err: errors.New("mongo unavailable")})
+	_, err = wms.SetOverride(context.Background(), "wh-1", "client-a")
+	assert.Error(t, err)
+	_, ok := wms.GetClientForWebhook("wh-1")
+	assert.False(t, ok, "an override that wasn't saved doesn't take effect")
+}
+
+func TestStartPeriodicRefresh(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+	api, srv := newFakeMailerCloud(t, map[string][]string{"key-a": {"wh-1"}})
+	wms, _ := newTestService(t, srv.URL)
+	require.NoError(t, wms.LoadMappingFromEnvironment())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wms.StartPeriodicRefresh(ctx, 10*time.Millisecond)
+
+	api.set("key-a", "wh-1", "wh-2")
+	assert.Eventually(t, func() bool {
+		_, ok := wms.GetClientForWebhook("wh-2")
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// Run with -race: lookups run concurrently with loads that replace the
+// mapping.
+func TestConcurrentLookupsDuringLoad(t *testing.T) {
+	t.Setenv("MAILERCLOUD_API_KEYS", "client-a:key-a")
+	_, srv := newFakeMailerCloud(t, map[string][]string{"key-a": {"wh-1", "wh-2"}})
+	wms, _ := newTestService(t, srv.URL)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if clientID, ok := wms.GetClientForWebhook("wh-1"); ok {
+					assert.Equal(t, "client-a", clientID)
+				}
+				wms.GetAPIKeyForClient("client-a")
+				wms.GetMappingStats()
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, wms.LoadMappingFromEnvironment())
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, 2, wms.WebhookCount())
+}
@@ -0,0 +1,98 @@
+// Package migrate holds bulk data migrations run by cmd/migrate.
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// RawPayloadStore is the storage used to re-parse events.
+type RawPayloadStore interface {
+	ScanRawPayloads(ctx context.Context, cursor string, limit int) ([]storage.RawEvent, error)
+	UpdateParsedFields(ctx context.Context, cursor string, event *models.WebhookEvent) error
+}
+
+// Parser fills event's payload-derived fields from data.
+type Parser func(event *models.WebhookEvent, data map[string]interface{})
+
+// Progress reports how far a re-parse has got. Cursor is the last event
+// written; passing it to Run resumes after it.
+type Progress struct {
+	Updated int
+	Cursor  string
+}
+
+// Reparser re-derives stored events from their raw payloads with the
+// current parser, in batches.
+type Reparser struct {
+	store     RawPayloadStore
+	parse     Parser
+	batchSize int
+	logger    *zap.Logger
+	// onBatch, if set, is called after every batch, e.g. to persist the
+	// cursor. An error stops the run.
+	onBatch func(Progress) error
+}
+
+func NewReparser(store RawPayloadStore, parse Parser, batchSize int, logger *zap.Logger, onBatch func(Progress) error) *Reparser {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &Reparser{
+		store:     store,
+		parse:     parse,
+		batchSize: batchSize,
+		logger:    logger,
+		onBatch:   onBatch,
+	}
+}
+
+// Run re-parses every event after cursor. On error the returned progress
+// holds the cursor of the last event written, so the run can be resumed
+// without skipping anything.
+func (r *Reparser) Run(ctx context.Context, cursor string) (Progress, error) {
+	progress := Progress{Cursor: cursor}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		batch, err := r.store.ScanRawPayloads(ctx, progress.Cursor, r.batchSize)
+		if err != nil {
+			return progress, fmt.Errorf("failed to scan raw payloads: %v", err)
+		}
+		if len(batch) == 0 {
+			return progress, nil
+		}
+
+		for _, raw := range batch {
+			event := &models.WebhookEvent{WebhookID: raw.WebhookID, ClientID: raw.ClientID}
+			r.parse(event, raw.Payload)
+			if err := r.store.UpdateParsedFields(ctx, raw.Cursor, event); err != nil {
+				return progress, fmt.Errorf("failed to update event %s: %v", raw.WebhookID, err)
+			}
+			progress.Updated++
+			progress.Cursor = raw.Cursor
+		}
+
+		r.logger.Info("Re-parsed batch",
+			zap.Int("updated", progress.Updated),
+			zap.String("cursor", progress.Cursor))
+
+		if r.onBatch != nil {
+			if err := r.onBatch(progress); err != nil {
+				return progress, err
+			}
+		}
+
+		if len(batch) < r.batchSize {
+			return progress, nil
+		}
+	}
+}
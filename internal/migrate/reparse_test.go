@@ -0,0 +1,106 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider/mailercloud"
+	"webhook-processor/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryRawStore serves events in slice order, using their index as cursor.
+type memoryRawStore struct {
+	events  []storage.RawEvent
+	updated map[string]models.WebhookEvent
+	scans   []string
+	failOn  string
+}
+
+func newMemoryRawStore(n int) *memoryRawStore {
+	s := &memoryRawStore{updated: make(map[string]models.WebhookEvent)}
+	for i := 0; i < n; i++ {
+		s.events = append(s.events, storage.RawEvent{
+			Cursor:    fmt.Sprintf("%03d", i),
+			WebhookID: fmt.Sprintf("wh-%d", i),
+			ClientID:  "client-a",
+			Payload: map[string]interface{}{
+				"event":     "clicked",
+				"camp_id":   fmt.Sprintf("camp-%d", i),
+				"click_url": "https://example.com",
+				"ts":        float64(1717200000 + i),
+				"new_field": "kept",
+			},
+		})
+	}
+	return s
+}
+
+func (s *memoryRawStore) ScanRawPayloads(ctx context.Context, cursor string, limit int) ([]storage.RawEvent, error) {
+	s.scans = append(s.scans, cursor)
+	var batch []storage.RawEvent
+	for _, e := range s.events {
+		if e.Cursor > cursor && len(batch) < limit {
+			batch = append(batch, e)
+		}
+	}
+	return batch, nil
+}
+
+func (s *memoryRawStore) UpdateParsedFields(ctx context.Context, cursor string, event *models.WebhookEvent) error {
+	if cursor == s.failOn {
+		return errors.New("write failed")
+	}
+	s.updated[cursor] = *event
+	return nil
+}
+
+func TestReparseUpdatesFields(t *testing.T) {
+	store := newMemoryRawStore(3)
+	var batches []Progress
+	r := NewReparser(store, mailercloud.ExtractEventFields, 2, zap.NewNop(), func(p Progress) error {
+		batches = append(batches, p)
+		return nil
+	})
+
+	progress, err := r.Run(context.Background(), "")
+	require.NoError(t, err)
+
+	assert.Equal(t, Progress{Updated: 3, Cursor: "002"}, progress)
+	assert.Equal(t, []Progress{{Updated: 2, Cursor: "001"}, {Updated: 3, Cursor: "002"}}, batches)
+
+	event := store.updated["001"]
+	assert.Equal(t, "wh-1", event.WebhookID)
+	assert.Equal(t, "client-a", event.ClientID)
+	assert.Equal(t, "clicked", event.Event)
+	assert.Equal(t, "camp-1", event.CampaignID)
+	assert.Equal(t, "https://example.com", event.URL)
+	assert.Equal(t, map[string]interface{}{"new_field": "kept"}, event.CustomFields)
+}
+
+func TestReparseResumesFromCursor(t *testing.T) {
+	store := newMemoryRawStore(5)
+	store.failOn = "003"
+	r := NewReparser(store, mailercloud.ExtractEventFields, 10, zap.NewNop(), nil)
+
+	progress, err := r.Run(context.Background(), "")
+	require.Error(t, err)
+	assert.Equal(t, Progress{Updated: 3, Cursor: "002"}, progress, "cursor stops at the last event written")
+
+	store.failOn = ""
+	store.updated = make(map[string]models.WebhookEvent)
+	progress, err = r.Run(context.Background(), progress.Cursor)
+	require.NoError(t, err)
+
+	assert.Equal(t, "002", store.scans[len(store.scans)-1])
+	assert.Len(t, store.updated, 2, "only the remaining events are re-parsed")
+	assert.Contains(t, store.updated, "003")
+	assert.Contains(t, store.updated, "004")
+	assert.Equal(t, Progress{Updated: 2, Cursor: "004"}, progress)
+}
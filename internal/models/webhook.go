@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -23,12 +24,127 @@ type WebhookEvent struct {
 	ListID any      `json:"list_id,omitempty" bson:"list_id,omitempty"` // Can be string or array
 	Reason string   `json:"reason,omitempty" bson:"reason,omitempty"`
 
+	// Entries of the emails array that aren't valid addresses, kept as sent
+	InvalidEmails []interface{} `json:"invalid_emails,omitempty" bson:"invalid_emails,omitempty"`
+
+	// Top-level payload keys not mapped to a field above
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty" bson:"custom_fields,omitempty"`
+
+	// Set when the client-supplied ts diverges from the server receive time
+	TimestampSkewed      bool  `json:"ts_skewed,omitempty" bson:"ts_skewed,omitempty"`
+	TimestampSkewSeconds int64 `json:"ts_skew_seconds,omitempty" bson:"ts_skew_seconds,omitempty"`
+
+	// Shared by related events for the same message, e.g. a bounce and a
+	// later spam complaint; set by the worker's correlator
+	CorrelationID string `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+
+	// ID of the API request the event arrived in, carried to the worker in
+	// the request_id message header so both sides' logs can be correlated
+	RequestID string `json:"-" bson:"-"`
+
+	// The payload the fields above were parsed from, kept when
+	// webhook.storeRawPayload is enabled so events can be re-parsed
+	RawPayload map[string]interface{} `json:"raw_payload,omitempty" bson:"raw_payload,omitempty"`
+
 	// Metadata
 	ClientID   string    `json:"-" bson:"client_id"`
 	ReceivedAt time.Time `json:"-" bson:"received_at"`
 	UpdatedAt  time.Time `json:"-" bson:"updated_at"`
+	// Set when the event's type has its own retention; MongoDB deletes the
+	// event once it passes
+	ExpiresAt  time.Time `json:"-" bson:"expires_at,omitempty"`
 	RetryCount int       `json:"-" bson:"retry_count"`
 	Status     string    `json:"-" bson:"status"`
+	// Source names the worker source the event was consumed from, when the
+	// worker consumes more than one
+	Source string `json:"-" bson:"source,omitempty"`
+}
+
+// EventLevel says whether an event concerns a whole campaign or a single
+// subscriber.
+type EventLevel string
+
+const (
+	// EventLevelCampaign events, such as campaign_sent, carry no recipient
+	// email and are looked up by campaign.
+	EventLevelCampaign EventLevel = "campaign"
+	// EventLevelSubscriber events, such as open or bounce, concern one
+	// recipient.
+	EventLevelSubscriber EventLevel = "subscriber"
+)
+
+// Level returns the event's level from its type, so "Campaign Sent" and
+// "campaign_sent" are both campaign-level. Unknown types are subscriber-level.
+func (e *WebhookEvent) Level() EventLevel {
+	switch e.Type() {
+	case EventTypeCampaignSent, EventTypeCampaignError:
+		return EventLevelCampaign
+	}
+	return EventLevelSubscriber
+}
+
+// Type returns the canonical type of the event's raw Event string.
+func (e *WebhookEvent) Type() EventType {
+	return NormalizeEvent(e.Event)
+}
+
+// EventType is the canonical name of an event type. MailerCloud reports the
+// same type under several names, such as "clicked" and "click"; metrics
+// labels and the stored event_type field use the canonical one, while the
+// raw name is kept in Event.
+type EventType string
+
+const (
+	EventTypeOpen          EventType = "open"
+	EventTypeClick         EventType = "click"
+	EventTypeBounce        EventType = "bounce"
+	EventTypeSpam          EventType = "spam"
+	EventTypeUnsubscribe   EventType = "unsubscribe"
+	EventTypeDelivered     EventType = "delivered"
+	EventTypeSent          EventType = "sent"
+	EventTypeCampaignSent  EventType = "campaign_sent"
+	EventTypeCampaignError EventType = "campaign_error"
+	// EventTypeUnknown is any name not in eventTypes, so unexpected names
+	// can't grow metrics label cardinality.
+	EventTypeUnknown EventType = "unknown"
+)
+
+// eventTypes maps each known event name, as cleaned up by NormalizeEvent,
+// to its canonical type.
+var eventTypes = map[string]EventType{
+	"open":           EventTypeOpen,
+	"opened":         EventTypeOpen,
+	"email_opened":   EventTypeOpen,
+	"click":          EventTypeClick,
+	"clicked":        EventTypeClick,
+	"email_clicked":  EventTypeClick,
+	"bounce":         EventTypeBounce,
+	"bounced":        EventTypeBounce,
+	"hard_bounce":    EventTypeBounce,
+	"soft_bounce":    EventTypeBounce,
+	"spam":           EventTypeSpam,
+	"spam_report":    EventTypeSpam,
+	"spam_complaint": EventTypeSpam,
+	"complaint":      EventTypeSpam,
+	"unsubscribe":    EventTypeUnsubscribe,
+	"unsubscribed":   EventTypeUnsubscribe,
+	"delivered":      EventTypeDelivered,
+	"delivery":       EventTypeDelivered,
+	"sent":           EventTypeSent,
+	"campaign_sent":  EventTypeCampaignSent,
+	"campaign_error": EventTypeCampaignError,
+}
+
+// NormalizeEvent returns the canonical type for a raw event name. Case,
+// surrounding space and the separator used between words are ignored, so
+// "Hard Bounce" and "hard-bounce" are both EventTypeBounce.
+func NormalizeEvent(raw string) EventType {
+	name := strings.ToLower(strings.TrimSpace(raw))
+	name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+	if eventType, ok := eventTypes[name]; ok {
+		return eventType
+	}
+	return EventTypeUnknown
 }
 
 // EventStatus represents the possible states of a webhook event
@@ -40,3 +156,12 @@ const (
 	EventStatusFailed    EventStatus = "failed"
 	EventStatusRetrying  EventStatus = "retrying"
 )
+
+// ClientError records the most recent processing failure for a client
+type ClientError struct {
+	ClientID    string    `json:"client_id" bson:"client_id"`
+	LastError   string    `json:"last_error" bson:"last_error"`
+	LastErrorAt time.Time `json:"last_error_at" bson:"last_error_at"`
+	WebhookID   string    `json:"webhook_id,omitempty" bson:"webhook_id,omitempty"`
+	Event       string    `json:"event,omitempty" bson:"event,omitempty"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEvent(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want EventType
+	}{
+		{raw: "open", want: EventTypeOpen},
+		{raw: "opened", want: EventTypeOpen},
+		{raw: "email_opened", want: EventTypeOpen},
+		{raw: "click", want: EventTypeClick},
+		{raw: "clicked", want: EventTypeClick},
+		{raw: "email_clicked", want: EventTypeClick},
+		{raw: "bounce", want: EventTypeBounce},
+		{raw: "Bounce", want: EventTypeBounce},
+		{raw: "bounced", want: EventTypeBounce},
+		{raw: "hard_bounce", want: EventTypeBounce},
+		{raw: "Hard Bounce", want: EventTypeBounce},
+		{raw: "soft-bounce", want: EventTypeBounce},
+		{raw: "spam", want: EventTypeSpam},
+		{raw: "spam_report", want: EventTypeSpam},
+		{raw: "spam_complaint", want: EventTypeSpam},
+		{raw: "complaint", want: EventTypeSpam},
+		{raw: "unsubscribe", want: EventTypeUnsubscribe},
+		{raw: "unsubscribed", want: EventTypeUnsubscribe},
+		{raw: "delivered", want: EventTypeDelivered},
+		{raw: "delivery", want: EventTypeDelivered},
+		{raw: "sent", want: EventTypeSent},
+		{raw: "campaign_sent", want: EventTypeCampaignSent},
+		{raw: "Campaign Sent", want: EventTypeCampaignSent},
+		{raw: " campaign_error ", want: EventTypeCampaignError},
+		{raw: "Campaign Error", want: EventTypeCampaignError},
+		{raw: "test", want: EventTypeUnknown},
+		{raw: "", want: EventTypeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			assert.Equal(t, tt.want, NormalizeEvent(tt.raw))
+		})
+	}
+}
+
+func TestEventLevel(t *testing.T) {
+	assert.Equal(t, EventLevelCampaign, (&WebhookEvent{Event: "Campaign Sent"}).Level())
+	assert.Equal(t, EventLevelCampaign, (&WebhookEvent{Event: "campaign_error"}).Level())
+	assert.Equal(t, EventLevelSubscriber, (&WebhookEvent{Event: "opened"}).Level())
+	assert.Equal(t, EventLevelSubscriber, (&WebhookEvent{Event: "test"}).Level())
+}
@@ -0,0 +1,145 @@
+// Package mailercloud is the MailerCloud webhook provider.
+package mailercloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"webhook-processor/internal/mapping"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/provider"
+	"webhook-processor/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Name is the MailerCloud provider's name.
+const Name = "mailercloud"
+
+// Provider parses MailerCloud webhooks. Clients are identified by the
+// Webhook-Id header, via the webhook mapping.
+type Provider struct {
+	logger *zap.Logger
+	mapper *mapping.WebhookMappingService
+	// deliveryIDHeader carries the ID retries of a delivery share; empty
+	// when there is none
+	deliveryIDHeader string
+}
+
+var _ provider.Provider = (*Provider)(nil)
+
+// Option configures optional Provider behaviour.
+type Option func(*Provider)
+
+// WithDeliveryIDHeader keys single-event webhooks by header, which
+// MailerCloud repeats when retrying a delivery, instead of by their fields.
+// See DeliveryWebhookID. An empty header disables it.
+func WithDeliveryIDHeader(header string) Option {
+	return func(p *Provider) {
+		p.deliveryIDHeader = header
+	}
+}
+
+// New creates the MailerCloud provider. mapper may be nil, in which case
+// webhooks are attributed to their Webhook-Id.
+func New(logger *zap.Logger, mapper *mapping.WebhookMappingService, opts ...Option) *Provider {
+	p := &Provider{logger: logger, mapper: mapper}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// ValidateRequest accepts every request: MailerCloud doesn't sign its
+// webhooks, so they are authenticated by the security middleware instead.
+func (p *Provider) ValidateRequest(headers http.Header, body []byte) error {
+	return nil
+}
+
+// Parse builds the event in a single MailerCloud payload object.
+func (p *Provider) Parse(headers http.Header, body []byte) (models.WebhookEvent, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return models.WebhookEvent{}, fmt.Errorf("%w: %v", provider.ErrInvalidPayload, err)
+	}
+	return p.DeliveryEvent(headers, p.Identify(headers, body), data), nil
+}
+
+// DeliveryEvent is Event for a webhook posted on its own with headers. It
+// is keyed by the delivery ID header, if one is configured and present.
+func (p *Provider) DeliveryEvent(headers http.Header, clientID string, data map[string]interface{}) models.WebhookEvent {
+	event := p.Event(clientID, data)
+	if p.deliveryIDHeader != "" {
+		event.WebhookID = DeliveryWebhookID(clientID, headers.Get(p.deliveryIDHeader), data)
+	}
+	return event
+}
+
+// Event builds the event for clientID from a decoded payload object. It is
+// the same whether the object was posted on its own or as an element of a
+// batch, so either form of a redelivery is deduplicated.
+func (p *Provider) Event(clientID string, data map[string]interface{}) models.WebhookEvent {
+	event := models.WebhookEvent{
+		WebhookID:   WebhookID(clientID, data),
+		WebhookType: "email_event",
+	}
+	ExtractEventFields(&event, data)
+	return event
+}
+
+// Identify looks the Webhook-Id header up in the mapping. Unmapped webhooks
+// are attributed to the Webhook-Id itself, and to "unknown" without one;
+// both are counted in the unknown client metric.
+func (p *Provider) Identify(headers http.Header, body []byte) string {
+	// Primary Strategy: Use Webhook-Id header to lookup client via mapping service
+	webhookID := headers.Get("Webhook-Id")
+	if webhookID != "" && p.mapper != nil {
+		p.logger.Info("Attempting to lookup client via webhook ID", zap.String("webhook_id", webhookID))
+
+		if clientID, found := p.mapper.GetClientForWebhook(webhookID); found {
+			p.logger.Info("Successfully mapped webhook ID to client",
+				zap.String("webhook_id", webhookID),
+				zap.String("client_id", clientID))
+			return clientID
+		}
+
+		p.logger.Warn("Webhook ID not found in mapping, falling back to webhook ID",
+			zap.String("webhook_id", webhookID),
+			zap.String("webhook_bucket", RecordUnknownClient(webhookID)))
+	}
+
+	// Fallback: Use webhook ID as client identifier if available
+	if webhookID != "" {
+		return webhookID
+	}
+
+	// Final fallback: Unknown client
+	RecordUnknownClient("")
+	return "unknown"
+}
+
+// RecordUnknownClient counts a webhook that couldn't be attributed to a
+// client, and returns the bucket its Webhook-Id was counted under.
+func RecordUnknownClient(webhookID string) string {
+	bucket := WebhookBucket(webhookID)
+	metrics.UnknownClients.WithLabelValues(bucket).Inc()
+	return bucket
+}
+
+// WebhookBucket hashes a Webhook-Id into one of 256 buckets, the label of
+// the unknown client metric, so that unmapped webhooks can be told apart
+// without a label per webhook. It is "none" for an empty ID.
+func WebhookBucket(webhookID string) string {
+	if webhookID == "" {
+		return "none"
+	}
+	sum := sha256.Sum256([]byte(webhookID))
+	return hex.EncodeToString(sum[:1])
+}
@@ -0,0 +1,101 @@
+package mailercloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"webhook-processor/internal/mapping"
+	"webhook-processor/internal/provider"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParse(t *testing.T) {
+	p := New(zap.NewNop(), nil)
+	headers := http.Header{"Webhook-Id": []string{"wh-abc"}}
+	body := []byte(`{"event":"bounced","campaign name":"Launch","camp_id":"c-1","email":"a@example.com","reason":"mailbox full","ts":1717243200}`)
+
+	event, err := p.Parse(headers, body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "bounced", event.Event)
+	assert.Equal(t, "Launch", event.CampaignName)
+	assert.Equal(t, "c-1", event.CampaignID)
+	assert.Equal(t, "a@example.com", event.Email)
+	assert.Equal(t, "mailbox full", event.Reason)
+	assert.Equal(t, int64(1717243200), event.Timestamp)
+	assert.Equal(t, "email_event", event.WebhookType)
+	assert.Empty(t, event.ClientID, "the caller sets the client")
+
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &data))
+	assert.Equal(t, WebhookID("wh-abc", data), event.WebhookID, "the ID is scoped to the identified client")
+}
+
+func TestParseKeysByDeliveryIDHeader(t *testing.T) {
+	body := []byte(`{"event":"opened","email":"a@example.com"}`)
+	retry := http.Header{"Webhook-Id": []string{"wh-abc"}, "X-Delivery-Id": []string{"d-1"}}
+	other := http.Header{"Webhook-Id": []string{"wh-abc"}, "X-Delivery-Id": []string{"d-2"}}
+
+	p := New(zap.NewNop(), nil, WithDeliveryIDHeader("X-Delivery-Id"))
+	first, err := p.Parse(retry, body)
+	require.NoError(t, err)
+	again, err := p.Parse(retry, body)
+	require.NoError(t, err)
+	distinct, err := p.Parse(other, body)
+	require.NoError(t, err)
+	assert.Equal(t, first.WebhookID, again.WebhookID)
+	assert.NotEqual(t, first.WebhookID, distinct.WebhookID)
+
+	plain, err := New(zap.NewNop(), nil).Parse(other, body)
+	require.NoError(t, err)
+	unkeyed, err := New(zap.NewNop(), nil).Parse(retry, body)
+	require.NoError(t, err)
+	assert.Equal(t, plain.WebhookID, unkeyed.WebhookID, "without the option the header is ignored")
+}
+
+func TestParseRejectsNonObjects(t *testing.T) {
+	p := New(zap.NewNop(), nil)
+
+	for _, body := range []string{`not json`, `[{"event":"opened"}]`, `"opened"`} {
+		_, err := p.Parse(http.Header{}, []byte(body))
+		assert.ErrorIs(t, err, provider.ErrInvalidPayload, body)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	for _, p := range []*Provider{
+		New(zap.NewNop(), nil),
+		New(zap.NewNop(), mapping.NewWebhookMappingService(zap.NewNop())),
+	} {
+		assert.Equal(t, "wh-unmapped", p.Identify(http.Header{"Webhook-Id": []string{"wh-unmapped"}}, nil),
+			"unmapped webhooks are attributed to their ID")
+		assert.Equal(t, "unknown", p.Identify(http.Header{}, nil))
+	}
+}
+
+func TestIdentifyCountsUnknownClients(t *testing.T) {
+	unmapped := metrics.UnknownClients.WithLabelValues(WebhookBucket("wh-unmapped"))
+	missing := metrics.UnknownClients.WithLabelValues("none")
+	beforeUnmapped, beforeMissing := testutil.ToFloat64(unmapped), testutil.ToFloat64(missing)
+
+	New(zap.NewNop(), nil).Identify(http.Header{"Webhook-Id": []string{"wh-unmapped"}}, nil)
+	assert.Equal(t, beforeUnmapped, testutil.ToFloat64(unmapped), "without a mapping webhooks aren't unknown")
+
+	p := New(zap.NewNop(), mapping.NewWebhookMappingService(zap.NewNop()))
+	p.Identify(http.Header{"Webhook-Id": []string{"wh-unmapped"}}, nil)
+	p.Identify(http.Header{}, nil)
+	assert.Equal(t, beforeUnmapped+1, testutil.ToFloat64(unmapped))
+	assert.Equal(t, beforeMissing+1, testutil.ToFloat64(missing))
+}
+
+func TestWebhookBucket(t *testing.T) {
+	assert.Equal(t, "none", WebhookBucket(""))
+	assert.Len(t, WebhookBucket("wh-1"), 2)
+	assert.Equal(t, WebhookBucket("wh-1"), WebhookBucket("wh-1"))
+}
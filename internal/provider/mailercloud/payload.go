@@ -0,0 +1,204 @@
+package mailercloud
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/metrics"
+)
+
+// knownFields are the payload keys ExtractEventFields maps onto the event.
+// Anything else is kept in CustomFields.
+var knownFields = map[string]bool{
+	"webhook_id": true, "event": true,
+	"campaign_name": true, "campaign name": true,
+	"campaign_id": true, "camp_id": true,
+	"tag_name": true, "tag": true,
+	"date_event": true, "ts": true, "ts_event": true,
+	"email": true, "emails": true,
+	"URL": true, "url": true, "click_url": true,
+	"reason": true, "list_id": true,
+}
+
+// ExtractEventFields copies the known MailerCloud payload fields onto event,
+// accepting the field-name variations MailerCloud uses across event types.
+// Unmapped top-level keys are collected in event.CustomFields. Tools that
+// re-derive stored events from their raw payloads use it too.
+func ExtractEventFields(event *models.WebhookEvent, data map[string]interface{}) {
+	// Extract standard fields with type assertions and error handling
+	if val, ok := data["event"].(string); ok {
+		event.Event = val
+	}
+
+	// Campaign name variations
+	if val, ok := data["campaign_name"].(string); ok {
+		event.CampaignName = val
+	} else if val, ok := data["campaign name"].(string); ok {
+		event.CampaignName = val
+	}
+
+	// Campaign ID variations
+	if val, ok := data["campaign_id"].(string); ok {
+		event.CampaignID = val
+	} else if val, ok := data["camp_id"].(string); ok {
+		event.CampaignID = val
+	}
+
+	// Tag name variations
+	if val, ok := data["tag_name"].(string); ok {
+		event.TagName = val
+	} else if val, ok := data["tag"].(string); ok {
+		event.TagName = val
+	}
+
+	if val, ok := data["date_event"].(string); ok {
+		event.DateEvent = val
+	}
+	if val, ok := data["ts"].(float64); ok {
+		event.Timestamp = int64(val)
+	}
+	if val, ok := data["ts_event"].(float64); ok {
+		event.TimestampEvent = int64(val)
+	}
+	if val, ok := data["email"].(string); ok {
+		event.Email = val
+	}
+
+	// URL field variations (for click events)
+	if val, ok := data["URL"].(string); ok {
+		event.URL = val
+	} else if val, ok := data["url"].(string); ok {
+		event.URL = val
+	} else if val, ok := data["click_url"].(string); ok {
+		event.URL = val
+	}
+
+	// Reason field (for bounce, spam, campaign_error events)
+	if val, ok := data["reason"].(string); ok {
+		event.Reason = val
+	}
+
+	// Handle list_id which can be string, number, or array (for unsubscribe events)
+	if val, exists := data["list_id"]; exists {
+		event.ListID = val
+	}
+
+	// Handle emails array
+	if val, ok := data["emails"].([]interface{}); ok {
+		event.Emails, event.InvalidEmails = splitEmails(val)
+	}
+
+	for key, val := range data {
+		if knownFields[key] {
+			continue
+		}
+		if event.CustomFields == nil {
+			event.CustomFields = make(map[string]interface{})
+		}
+		event.CustomFields[customFieldKey(key)] = val
+	}
+}
+
+// splitEmails separates the valid addresses in an emails array from the
+// other entries, which are kept as sent and counted by reason.
+func splitEmails(entries []interface{}) ([]string, []interface{}) {
+	emails := make([]string, 0, len(entries))
+	var invalid []interface{}
+	for _, entry := range entries {
+		email, ok := entry.(string)
+		if !ok {
+			metrics.InvalidEmails.WithLabelValues("not_string").Inc()
+			invalid = append(invalid, entry)
+			continue
+		}
+		if !validEmail(email) {
+			metrics.InvalidEmails.WithLabelValues("invalid_address").Inc()
+			invalid = append(invalid, entry)
+			continue
+		}
+		emails = append(emails, strings.TrimSpace(email))
+	}
+	return emails, invalid
+}
+
+// validEmail reports whether s is a bare address such as a@example.com,
+// ignoring surrounding whitespace. Display-name forms are not accepted.
+func validEmail(s string) bool {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// customFieldKey makes key safe to store as a MongoDB field name, which can't
+// contain dots or start with "$".
+func customFieldKey(key string) string {
+	key = strings.ReplaceAll(key, ".", "_")
+	if strings.HasPrefix(key, "$") {
+		key = "_" + key[1:]
+	}
+	return key
+}
+
+// WebhookID returns the provider-supplied ID for the event if the
+// payload carries one. Otherwise it derives an ID from the payload fields,
+// scoped to clientID so that identical payloads from different clients never
+// share an ID. The ID depends only on clientID and data, so it is the
+// deduplication key for redeliveries however they arrive.
+func WebhookID(clientID string, data map[string]interface{}) string {
+	// Strategy 1: Use existing webhook/message ID if available
+	if id := payloadID(data); id != "" {
+		return id
+	}
+
+	// Strategy 2: Generate based on combination of fields for uniqueness
+	components := []string{clientID}
+
+	if val, ok := data["campaign_id"].(string); ok && val != "" {
+		components = append(components, val)
+	}
+	if val, ok := data["email"].(string); ok && val != "" {
+		components = append(components, val)
+	}
+	if val, ok := data["ts"].(float64); ok {
+		components = append(components, fmt.Sprintf("%.0f", val))
+	}
+	if val, ok := data["event"].(string); ok && val != "" {
+		components = append(components, val)
+	}
+
+	// Strategy 3: Fallback to a digest of the whole payload. Map keys are
+	// encoded in sorted order, so equal payloads get equal digests.
+	if len(components) == 1 {
+		encoded, _ := json.Marshal(data)
+		components = append(components, fmt.Sprintf("%x", sha256.Sum256(encoded)))
+	}
+
+	return fmt.Sprintf("mc_%x", components)
+}
+
+// DeliveryWebhookID is WebhookID for a webhook that arrived with deliveryID,
+// an ID the sender repeats when it retries the delivery. Unless the payload
+// carries its own ID, the event is keyed by the delivery rather than its
+// fields, so a retry is deduplicated while two distinct events with the
+// same fields are not. Without a deliveryID it is WebhookID.
+func DeliveryWebhookID(clientID, deliveryID string, data map[string]interface{}) string {
+	if id := payloadID(data); id != "" || deliveryID == "" {
+		return WebhookID(clientID, data)
+	}
+	return fmt.Sprintf("mcd_%x", []string{clientID, deliveryID})
+}
+
+// payloadID returns the ID MailerCloud put in the payload, if any.
+func payloadID(data map[string]interface{}) string {
+	idFields := []string{"webhook_id", "message_id", "event_id", "delivery_id", "tracking_id"}
+	for _, field := range idFields {
+		if val, ok := data[field].(string); ok && val != "" {
+			return val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package mailercloud
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookIDScopedToClient(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	payload := map[string]interface{}{
+		"event":       "opened",
+		"email":       "user@example.com",
+		"campaign_id": "camp-1",
+		"ts":          float64(now.Unix()),
+	}
+
+	idA := WebhookID("client-a", payload)
+	idB := WebhookID("client-b", payload)
+	assert.NotEqual(t, idA, idB, "identical payloads from different clients must get distinct IDs")
+	assert.Equal(t, idA, WebhookID("client-a", payload), "IDs are stable for redeliveries")
+
+	empty := map[string]interface{}{}
+	assert.NotEqual(t, WebhookID("client-a", empty), WebhookID("client-b", empty))
+}
+
+func TestWebhookIDWithoutIdentifyingFields(t *testing.T) {
+	var payload, reordered map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"status":"delivered","list_id":"l-9"}`), &payload))
+	require.NoError(t, json.Unmarshal([]byte(`{"list_id":"l-9","status":"delivered"}`), &reordered))
+
+	id := WebhookID("client-a", payload)
+	assert.Equal(t, id, WebhookID("client-a", reordered), "redeliveries get the same ID")
+	assert.NotEqual(t, id, WebhookID("client-a", map[string]interface{}{"status": "bounced", "list_id": "l-9"}))
+	assert.NotEqual(t, id, WebhookID("client-b", payload))
+}
+
+func TestWebhookIDKeepsProviderID(t *testing.T) {
+	payload := map[string]interface{}{"message_id": "msg-123", "event": "opened"}
+
+	assert.Equal(t, "msg-123", WebhookID("client-a", payload))
+}
+
+func TestDeliveryWebhookID(t *testing.T) {
+	payload := map[string]interface{}{"event": "opened", "email": "user@example.com"}
+
+	id := DeliveryWebhookID("client-a", "delivery-1", payload)
+	assert.Equal(t, id, DeliveryWebhookID("client-a", "delivery-1", payload), "a retry gets the same ID")
+	assert.NotEqual(t, id, DeliveryWebhookID("client-a", "delivery-2", payload), "distinct deliveries with the same fields get distinct IDs")
+	assert.NotEqual(t, id, DeliveryWebhookID("client-b", "delivery-1", payload), "IDs are scoped to the client")
+	assert.NotEqual(t, id, WebhookID("client-a", payload))
+
+	assert.Equal(t, WebhookID("client-a", payload), DeliveryWebhookID("client-a", "", payload), "without a delivery ID the fields are the key")
+	withID := map[string]interface{}{"message_id": "msg-123", "event": "opened"}
+	assert.Equal(t, "msg-123", DeliveryWebhookID("client-a", "delivery-1", withID), "the payload's own ID wins")
+}
+
+func TestExtractEventFieldsCollectsCustomFields(t *testing.T) {
+	var event models.WebhookEvent
+	ExtractEventFields(&event, map[string]interface{}{
+		"event":       "opened",
+		"email":       "user@example.com",
+		"ip_address":  "203.0.113.7",
+		"device":      map[string]interface{}{"os": "ios"},
+		"geo.country": "DE",
+		"$internal":   true,
+	})
+
+	assert.Equal(t, "opened", event.Event)
+	assert.Equal(t, map[string]interface{}{
+		"ip_address":  "203.0.113.7",
+		"device":      map[string]interface{}{"os": "ios"},
+		"geo_country": "DE",
+		"_internal":   true,
+	}, event.CustomFields)
+}
+
+func TestExtractEventFieldsWithoutCustomFields(t *testing.T) {
+	var event models.WebhookEvent
+	ExtractEventFields(&event, map[string]interface{}{"event": "opened", "campaign name": "Launch"})
+
+	assert.Nil(t, event.CustomFields)
+}
+
+func TestExtractEventFieldsSplitsInvalidEmails(t *testing.T) {
+	tests := []struct {
+		name        string
+		emails      []interface{}
+		wantValid   []string
+		wantInvalid []interface{}
+	}{
+		{
+			name:      "all valid",
+			emails:    []interface{}{"a@example.com", " b@example.com "},
+			wantValid: []string{"a@example.com", "b@example.com"},
+		},
+		{
+			name:        "mixed",
+			emails:      []interface{}{"a@example.com", "not-an-email", float64(42), "Bob <b@example.com>", nil, "c@example.com"},
+			wantValid:   []string{"a@example.com", "c@example.com"},
+			wantInvalid: []interface{}{"not-an-email", float64(42), "Bob <b@example.com>", nil},
+		},
+		{
+			name:        "only non-strings",
+			emails:      []interface{}{true, map[string]interface{}{"email": "a@example.com"}},
+			wantValid:   []string{},
+			wantInvalid: []interface{}{true, map[string]interface{}{"email": "a@example.com"}},
+		},
+		{
+			name:      "empty",
+			emails:    []interface{}{},
+			wantValid: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notString := testutil.ToFloat64(metrics.InvalidEmails.WithLabelValues("not_string"))
+			invalidAddress := testutil.ToFloat64(metrics.InvalidEmails.WithLabelValues("invalid_address"))
+
+			event := &models.WebhookEvent{}
+			ExtractEventFields(event, map[string]interface{}{"event": "sent", "emails": tt.emails})
+
+			assert.Equal(t, tt.wantValid, event.Emails)
+			assert.Equal(t, tt.wantInvalid, event.InvalidEmails)
+			assert.Nil(t, event.CustomFields["emails"], "emails are not duplicated into custom fields")
+
+			var wantNotString, wantInvalidAddress float64
+			for _, entry := range tt.wantInvalid {
+				if _, ok := entry.(string); ok {
+					wantInvalidAddress++
+				} else {
+					wantNotString++
+				}
+			}
+			assert.Equal(t, notString+wantNotString, testutil.ToFloat64(metrics.InvalidEmails.WithLabelValues("not_string")))
+			assert.Equal(t, invalidAddress+wantInvalidAddress, testutil.ToFloat64(metrics.InvalidEmails.WithLabelValues("invalid_address")))
+		})
+	}
+}
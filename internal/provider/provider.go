@@ -0,0 +1,72 @@
+// Package provider abstracts the email service providers that send us
+// webhooks. Each provider turns its own payload shape into a
+// models.WebhookEvent and works out which client a webhook belongs to, so
+// the ingestion pipeline doesn't depend on any one provider's format.
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"webhook-processor/internal/models"
+)
+
+// Header selects the provider for requests to the plain /webhook path.
+const Header = "Webhook-Provider"
+
+// ErrUnknownProvider is returned when no provider is registered under a name.
+var ErrUnknownProvider = errors.New("unknown webhook provider")
+
+// ErrInvalidRequest is wrapped by ValidateRequest errors.
+var ErrInvalidRequest = errors.New("invalid webhook request")
+
+// ErrInvalidPayload is wrapped by Parse errors caused by the request rather
+// than the provider.
+var ErrInvalidPayload = errors.New("invalid webhook payload")
+
+// Provider parses one email service provider's webhooks.
+type Provider interface {
+	// Name is the provider's path segment, e.g. "mailercloud".
+	Name() string
+	// ValidateRequest checks that a webhook really comes from the provider,
+	// e.g. by its signature, before anything else is done with it.
+	ValidateRequest(headers http.Header, body []byte) error
+	// Parse builds the event described by a single-event webhook. The
+	// caller sets the client, receive time and status.
+	Parse(headers http.Header, body []byte) (models.WebhookEvent, error)
+	// Identify returns the client the webhook belongs to.
+	Identify(headers http.Header, body []byte) (clientID string)
+}
+
+// Registry holds the providers by name.
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider, len(providers))}
+	for _, p := range providers {
+		r.providers[strings.ToLower(p.Name())] = p
+	}
+	return r
+}
+
+// Get returns the provider registered as name, case-insensitively.
+func (r *Registry) Get(name string) (Provider, error) {
+	if p, ok := r.providers[strings.ToLower(name)]; ok {
+		return p, nil
+	}
+	return nil, ErrUnknownProvider
+}
+
+// Names lists the registered providers in alphabetical order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type namedProvider string
+
+func (p namedProvider) Name() string { return string(p) }
+
+func (p namedProvider) ValidateRequest(headers http.Header, body []byte) error { return nil }
+
+func (p namedProvider) Parse(headers http.Header, body []byte) (models.WebhookEvent, error) {
+	return models.WebhookEvent{}, nil
+}
+
+func (p namedProvider) Identify(headers http.Header, body []byte) string { return "" }
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(namedProvider("mailercloud"), namedProvider("SendGrid"))
+
+	p, err := r.Get("MailerCloud")
+	require.NoError(t, err)
+	assert.Equal(t, "mailercloud", p.Name(), "names match case-insensitively")
+
+	p, err = r.Get("sendgrid")
+	require.NoError(t, err)
+	assert.Equal(t, "SendGrid", p.Name())
+
+	_, err = r.Get("mailgun")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+	_, err = r.Get("")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	assert.Equal(t, []string{"mailercloud", "sendgrid"}, r.Names())
+}
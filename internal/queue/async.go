@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// ErrPublishBufferFull is returned by AsyncPublisher and RetryBuffer when the
+// in-process buffer can't take another event.
+var ErrPublishBufferFull = errors.New("publish buffer full")
+
+// ErrPublisherClosed is returned by AsyncPublisher and RetryBuffer after
+// Close or Drain, and by RabbitMQ after Close.
+var ErrPublisherClosed = errors.New("publisher closed")
+
+// AsyncPublisher accepts events into a bounded in-process buffer and publishes
+// them in the background, retrying failures with exponential backoff. It
+// trades durability for latency: buffered events are lost if the process
+// dies, and events that exhaust their retries are dropped.
+type AsyncPublisher struct {
+	next       Publisher
+	maxRetries int
+	retryDelay time.Duration
+	clock      clock.Clock
+	logger     *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	buffer chan models.WebhookEvent
+	done   chan struct{}
+
+	// abort cuts Drain short; events not yet published are then collected
+	// in undelivered instead
+	abort       chan struct{}
+	abortOnce   sync.Once
+	undelivered []models.WebhookEvent
+}
+
+func NewAsyncPublisher(next Publisher, bufferSize, maxRetries int, retryDelay time.Duration, clk clock.Clock, logger *zap.Logger) *AsyncPublisher {
+	p := &AsyncPublisher{
+		next:       next,
+		maxRetries: maxRetries,
+		retryDelay: retryDelay,
+		clock:      clk,
+		logger:     logger,
+		buffer:     make(chan models.WebhookEvent, bufferSize),
+		done:       make(chan struct{}),
+		abort:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish buffers event for background publishing without blocking.
+func (p *AsyncPublisher) Publish(event models.WebhookEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	select {
+	case p.buffer <- event:
+		metrics.AsyncPublishBuffered.Set(float64(len(p.buffer)))
+		return nil
+	default:
+		return ErrPublishBufferFull
+	}
+}
+
+// Close stops accepting events, publishes everything still buffered and then
+// closes the underlying publisher.
+func (p *AsyncPublisher) Close() error {
+	p.Drain(context.Background())
+	return p.next.Close()
+}
+
+// Drain stops accepting events and publishes everything still buffered,
+// giving up when ctx is done. It returns the events that weren't published.
+func (p *AsyncPublisher) Drain(ctx context.Context) []models.WebhookEvent {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.buffer)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.abortOnce.Do(func() { close(p.abort) })
+		<-p.done
+	}
+
+	undelivered := p.undelivered
+	p.undelivered = nil
+	return undelivered
+}
+
+func (p *AsyncPublisher) run() {
+	defer close(p.done)
+
+	for event := range p.buffer {
+		metrics.AsyncPublishBuffered.Set(float64(len(p.buffer)))
+		if p.aborted() || !p.publish(event) {
+			p.undelivered = append(p.undelivered, event)
+		}
+	}
+}
+
+func (p *AsyncPublisher) aborted() bool {
+	select {
+	case <-p.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// publish publishes event, retrying with backoff. It returns false if it gave
+// up because Drain was aborted; events that exhaust their retries are dropped.
+func (p *AsyncPublisher) publish(event models.WebhookEvent) bool {
+	delay := p.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := p.next.Publish(event)
+		if err == nil {
+			return true
+		}
+
+		if attempt >= p.maxRetries {
+			metrics.AsyncPublishDropped.Inc()
+			p.logger.Error("Dropping event after exhausting async publish retries",
+				zap.Error(err),
+				zap.String("webhook_id", event.WebhookID),
+				zap.String("client_id", event.ClientID),
+				zap.Int("attempts", attempt+1))
+			return true
+		}
+
+		p.logger.Warn("Async publish failed, retrying",
+			zap.Error(err),
+			zap.String("webhook_id", event.WebhookID),
+			zap.Duration("delay", delay))
+		if !p.sleep(delay) {
+			return false
+		}
+		delay *= 2
+	}
+}
+
+// sleep waits for d on the clock, returning false if Drain is aborted first.
+func (p *AsyncPublisher) sleep(d time.Duration) bool {
+	slept := make(chan struct{})
+	go func() {
+		p.clock.Sleep(d)
+		close(slept)
+	}()
+
+	select {
+	case <-slept:
+		return true
+	case <-p.abort:
+		return false
+	}
+}
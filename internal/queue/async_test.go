@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// flakyPublisher fails the first failures publishes.
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	published []models.WebhookEvent
+	block     chan struct{}
+	closed    bool
+}
+
+func (p *flakyPublisher) Publish(event models.WebhookEvent) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *flakyPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func newTestAsyncPublisher(next Publisher, bufferSize, maxRetries int) *AsyncPublisher {
+	return NewAsyncPublisher(next, bufferSize, maxRetries, time.Second, clock.NewMock(time.Now()), zap.NewNop())
+}
+
+func TestAsyncPublisherRetriesUntilPublished(t *testing.T) {
+	next := &flakyPublisher{failures: 2}
+	p := newTestAsyncPublisher(next, 10, 3)
+
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.NoError(t, p.Close())
+
+	assert.Equal(t, 3, next.attempts)
+	require.Len(t, next.published, 1)
+	assert.Equal(t, "wh-1", next.published[0].WebhookID)
+	assert.True(t, next.closed)
+}
+
+func TestAsyncPublisherDropsAfterRetries(t *testing.T) {
+	next := &flakyPublisher{failures: 10}
+	p := newTestAsyncPublisher(next, 10, 2)
+
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.NoError(t, p.Close())
+
+	assert.Equal(t, 3, next.attempts)
+	assert.Empty(t, next.published)
+}
+
+func TestAsyncPublisherBufferFull(t *testing.T) {
+	next := &flakyPublisher{block: make(chan struct{})}
+	p := newTestAsyncPublisher(next, 1, 0)
+
+	// The first event is taken by the background publisher, which blocks;
+	// the second fills the buffer
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.Eventually(t, func() bool { return len(p.buffer) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-2"}))
+
+	assert.ErrorIs(t, p.Publish(models.WebhookEvent{WebhookID: "wh-3"}), ErrPublishBufferFull)
+
+	close(next.block)
+	require.NoError(t, p.Close())
+	assert.Len(t, next.published, 2, "buffered events are drained on close")
+	assert.ErrorIs(t, p.Publish(models.WebhookEvent{WebhookID: "wh-4"}), ErrPublisherClosed)
+}
+
+func TestAsyncPublisherDrainGivesUpAtDeadline(t *testing.T) {
+	next := &flakyPublisher{failures: 100}
+	// A real clock keeps the retry backoff sleeping past the deadline
+	p := NewAsyncPublisher(next, 10, 5, time.Minute, clock.New(), zap.NewNop())
+
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.NoError(t, p.Publish(models.WebhookEvent{WebhookID: "wh-2"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	undelivered := p.Drain(ctx)
+
+	require.Len(t, undelivered, 2, "events still retrying or queued are handed back")
+	assert.Equal(t, "wh-1", undelivered[0].WebhookID)
+	assert.Equal(t, "wh-2", undelivered[1].WebhookID)
+	assert.ErrorIs(t, p.Publish(models.WebhookEvent{WebhookID: "wh-3"}), ErrPublisherClosed)
+}
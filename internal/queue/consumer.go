@@ -0,0 +1,257 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"webhook-processor/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ConsumerConnection owns the worker's broker connection. Redial replaces
+// it after a disconnect, and the channel methods always use the current
+// channel, so helpers such as the DeadLetterer built on it follow reconnects.
+type ConsumerConnection struct {
+	cfg       config.RabbitMQConfig
+	queueArgs amqp.Table
+	prefetch  int
+	// globalPrefetch is shared by every consumer on the channel
+	globalPrefetch int
+
+	mu   sync.RWMutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// ConsumerOption configures optional ConsumerConnection behaviour.
+type ConsumerOption func(*ConsumerConnection)
+
+// WithPrefetch limits each consumer to count unacked deliveries. Zero
+// leaves it unlimited.
+func WithPrefetch(count int) ConsumerOption {
+	return func(c *ConsumerConnection) {
+		c.prefetch = count
+	}
+}
+
+// WithGlobalPrefetch limits all the consumers on the channel together to
+// count unacked deliveries, on top of any per-consumer prefetch. Zero leaves
+// the channel unlimited.
+func WithGlobalPrefetch(count int) ConsumerOption {
+	return func(c *ConsumerConnection) {
+		c.globalPrefetch = count
+	}
+}
+
+// qosSetter is the part of *amqp.Channel that sets QoS, so tests can record
+// it.
+type qosSetter interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+}
+
+// sourceDeclarer is the part of *amqp.Channel that declares a worker
+// source's topology.
+type sourceDeclarer interface {
+	queueDeclarer
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// DialConsumer connects and declares the exchange and the work queue bound
+// to it. queueArgs should come from QueueArgs so the declaration matches the
+// publisher.
+func DialConsumer(cfg config.RabbitMQConfig, queueArgs amqp.Table, opts ...ConsumerOption) (*ConsumerConnection, error) {
+	c := &ConsumerConnection{cfg: cfg, queueArgs: queueArgs}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if _, err := c.Redial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Redial closes the current connection, if any, and connects again,
+// re-declaring the exchange, queue and binding and reapplying the QoS.
+func (c *ConsumerConnection) Redial() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.ch = nil, nil
+	}
+
+	conn, err := NewRabbitMQConnection(c.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+	if err := c.declare(ch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// QoS is per channel, so it is set again on every new one
+	if err := c.applyQos(ch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.conn, c.ch = conn, ch
+	return ch, nil
+}
+
+// SetPrefetch changes the per-consumer and channel-global prefetch limits
+// applied by later Redials. The current channel keeps its QoS.
+func (c *ConsumerConnection) SetPrefetch(prefetch, global int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetch, c.globalPrefetch = prefetch, global
+}
+
+// applyQos sets the per-consumer and channel-global prefetch limits that
+// are configured. RabbitMQ enforces both when both are set. Consumers
+// started on the channel afterwards pick the per-consumer limit up.
+func (c *ConsumerConnection) applyQos(ch qosSetter) error {
+	if c.prefetch > 0 {
+		if err := ch.Qos(c.prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set prefetch: %v", err)
+		}
+	}
+	if c.globalPrefetch > 0 {
+		if err := ch.Qos(c.globalPrefetch, 0, true); err != nil {
+			return fmt.Errorf("failed to set global prefetch: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *ConsumerConnection) declare(ch *amqp.Channel) error {
+	kind, binding := exchangeKindDirect, ""
+	if c.cfg.EventRoutingKeys {
+		kind, binding = exchangeKindTopic, AllEventsBinding
+	}
+
+	// Declare exchange
+	err := ch.ExchangeDeclare(
+		c.cfg.Exchange, // name
+		kind,           // type
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange: %v", err)
+	}
+
+	// Declare queue with the same arguments as the publisher
+	q, err := DeclareQueue(ch, c.cfg.QueueName, c.queueArgs)
+	if err != nil {
+		return err
+	}
+
+	// Bind queue to exchange
+	err = ch.QueueBind(
+		q.Name,         // queue name
+		binding,        // routing key
+		c.cfg.Exchange, // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bind queue: %v", err)
+	}
+
+	for _, s := range c.cfg.Sources {
+		if err := declareSource(ch, s, c.queueArgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declareSource declares a worker source's exchange and its queue, bound
+// to it.
+func declareSource(ch sourceDeclarer, s config.SourceConfig, queueArgs amqp.Table) error {
+	kind := s.ExchangeType
+	if kind == "" {
+		kind = exchangeKindDirect
+	}
+	if err := ch.ExchangeDeclare(s.Exchange, kind, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange for source %s: %v", s.Name, err)
+	}
+	if _, err := DeclareQueue(ch, s.Queue, queueArgs); err != nil {
+		return fmt.Errorf("failed to declare queue for source %s: %v", s.Name, err)
+	}
+	if err := ch.QueueBind(s.Queue, s.RoutingKey, s.Exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue for source %s: %v", s.Name, err)
+	}
+	return nil
+}
+
+// Channel returns the current channel.
+func (c *ConsumerConnection) Channel() *amqp.Channel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ch
+}
+
+func (c *ConsumerConnection) current() (*amqp.Channel, error) {
+	ch := c.Channel()
+	if ch == nil {
+		return nil, ErrNotConnected
+	}
+	return ch, nil
+}
+
+func (c *ConsumerConnection) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args)
+}
+
+func (c *ConsumerConnection) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch, err := c.current()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	return ch.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+}
+
+func (c *ConsumerConnection) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(name, key, exchange, noWait, args)
+}
+
+func (c *ConsumerConnection) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// Close closes the current connection.
+func (c *ConsumerConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.ch = nil, nil
+	return err
+}
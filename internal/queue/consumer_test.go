@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"webhook-processor/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type qosCall struct {
+	count  int
+	global bool
+}
+
+// recordingQos records Qos calls, failing the global one if failGlobal.
+type recordingQos struct {
+	calls      []qosCall
+	failGlobal bool
+}
+
+func (q *recordingQos) Qos(prefetchCount, prefetchSize int, global bool) error {
+	if global && q.failGlobal {
+		return errors.New("not allowed")
+	}
+	q.calls = append(q.calls, qosCall{count: prefetchCount, global: global})
+	return nil
+}
+
+func TestApplyQos(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ConsumerOption
+		want []qosCall
+	}{
+		{name: "unlimited"},
+		{name: "per-consumer", opts: []ConsumerOption{WithPrefetch(8)}, want: []qosCall{{count: 8}}},
+		{name: "global", opts: []ConsumerOption{WithGlobalPrefetch(32)}, want: []qosCall{{count: 32, global: true}}},
+		{
+			name: "both",
+			opts: []ConsumerOption{WithPrefetch(8), WithGlobalPrefetch(32)},
+			want: []qosCall{{count: 8}, {count: 32, global: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ConsumerConnection{}
+			for _, opt := range tt.opts {
+				opt(c)
+			}
+			ch := &recordingQos{}
+			require.NoError(t, c.applyQos(ch))
+			assert.Equal(t, tt.want, ch.calls)
+		})
+	}
+}
+
+func TestApplyQosGlobalFailure(t *testing.T) {
+	c := &ConsumerConnection{}
+	WithGlobalPrefetch(32)(c)
+
+	err := c.applyQos(&recordingQos{failGlobal: true})
+	assert.ErrorContains(t, err, "failed to set global prefetch")
+}
+
+func TestSetPrefetchAppliesToLaterChannels(t *testing.T) {
+	c := &ConsumerConnection{}
+	WithPrefetch(8)(c)
+
+	c.SetPrefetch(16, 64)
+	ch := &recordingQos{}
+	require.NoError(t, c.applyQos(ch))
+	assert.Equal(t, []qosCall{{count: 16}, {count: 64, global: true}}, ch.calls)
+}
+
+func TestDeclareSource(t *testing.T) {
+	ch := &recordingChannel{}
+	err := declareSource(ch, config.SourceConfig{Name: "sendgrid", Exchange: "sendgrid_events", Queue: "sendgrid_webhooks"}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "sendgrid_events", ch.exchange)
+	assert.Equal(t, "direct", ch.exchangeKind, "direct unless configured")
+	assert.Equal(t, "sendgrid_webhooks", ch.name)
+	assert.Equal(t, "sendgrid_webhooks", ch.boundQueue)
+	assert.Equal(t, "sendgrid_events", ch.boundTo)
+
+	err = declareSource(ch, config.SourceConfig{Name: "s", Exchange: "s_events", ExchangeType: "topic", Queue: "s_q"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "topic", ch.exchangeKind)
+}
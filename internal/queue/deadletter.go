@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeathReasonHeader carries the last processing error of a dead-lettered
+// message.
+const DeathReasonHeader = "x-death-reason"
+
+// deadLetterChannel is the subset of *amqp.Channel used for dead-lettering.
+type deadLetterChannel interface {
+	queueDeclarer
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// DeadLetterer republishes deliveries that can't be processed to a
+// dead-letter exchange, so they stay on the broker for inspection or replay
+// instead of being dropped when acked.
+type DeadLetterer struct {
+	ch       deadLetterChannel
+	exchange string
+}
+
+// NewDeadLetterer declares the durable fanout exchange and the queue bound
+// to it.
+func NewDeadLetterer(ch deadLetterChannel, exchange, queueName string) (*DeadLetterer, error) {
+	err := ch.ExchangeDeclare(
+		exchange,
+		"fanout",
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter exchange: %v", err)
+	}
+
+	q, err := DeclareQueue(ch, queueName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter queue: %v", err)
+	}
+
+	if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to bind dead-letter queue: %v", err)
+	}
+
+	return &DeadLetterer{ch: ch, exchange: exchange}, nil
+}
+
+// DeadLetter publishes msg's original body and headers to the dead-letter
+// exchange, adding reason as the x-death-reason header.
+func (d *DeadLetterer) DeadLetter(ctx context.Context, msg amqp.Delivery, reason string) error {
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[DeathReasonHeader] = reason
+
+	err := d.ch.PublishWithContext(ctx,
+		d.exchange,
+		"",    // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			Headers:      headers,
+			Body:         msg.Body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish to dead-letter exchange: %v", err)
+	}
+	return nil
+}
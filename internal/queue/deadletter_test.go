@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingChannel struct {
+	recordingDeclarer
+	exchange, exchangeKind string
+	boundQueue, boundTo    string
+	published              []amqp.Publishing
+	publishedTo            []string
+	publishedKeys          []string
+}
+
+func (c *recordingChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.exchange = name
+	c.exchangeKind = kind
+	return nil
+}
+
+func (c *recordingChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.boundQueue = name
+	c.boundTo = exchange
+	return nil
+}
+
+func (c *recordingChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.publishedTo = append(c.publishedTo, exchange)
+	c.publishedKeys = append(c.publishedKeys, key)
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func TestDeadLettererDeclaresTopology(t *testing.T) {
+	ch := &recordingChannel{}
+	_, err := NewDeadLetterer(ch, "webhook_dlx", "webhook_dlq")
+	require.NoError(t, err)
+
+	assert.Equal(t, "webhook_dlx", ch.exchange)
+	assert.Equal(t, "fanout", ch.exchangeKind)
+	assert.Equal(t, "webhook_dlq", ch.name)
+	assert.Equal(t, "webhook_dlq", ch.boundQueue)
+	assert.Equal(t, "webhook_dlx", ch.boundTo)
+}
+
+func TestDeadLetterKeepsBodyAndHeaders(t *testing.T) {
+	ch := &recordingChannel{}
+	d, err := NewDeadLetterer(ch, "webhook_dlx", "webhook_dlq")
+	require.NoError(t, err)
+
+	msg := amqp.Delivery{
+		ContentType: "application/json",
+		MessageId:   "wh-1",
+		Headers:     amqp.Table{"webhook_id": "wh-1", "client_id": "client-a"},
+		Body:        []byte(`{"event":"opened"}`),
+	}
+	require.NoError(t, d.DeadLetter(context.Background(), msg, "mongo unavailable"))
+
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "webhook_dlx", ch.publishedTo[0])
+	pub := ch.published[0]
+	assert.Equal(t, msg.Body, pub.Body)
+	assert.Equal(t, "application/json", pub.ContentType)
+	assert.Equal(t, "wh-1", pub.MessageId)
+	assert.Equal(t, amqp.Persistent, pub.DeliveryMode)
+	assert.Equal(t, amqp.Table{
+		"webhook_id":     "wh-1",
+		"client_id":      "client-a",
+		"x-death-reason": "mongo unavailable",
+	}, pub.Headers)
+	assert.NotContains(t, msg.Headers, DeathReasonHeader, "the delivery's headers are not modified")
+}
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+
+	"webhook-processor/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// queueDeclarer is the subset of *amqp.Channel used to declare queues.
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+}
+
+// QueueArgs builds the optional queue declaration arguments from cfg. It
+// returns nil when none are configured so the declaration matches queues
+// created before arguments were supported.
+func QueueArgs(cfg config.RabbitMQConfig) (amqp.Table, error) {
+	args := amqp.Table{}
+
+	switch cfg.QueueMode {
+	case "":
+	case "lazy", "default":
+		args["x-queue-mode"] = cfg.QueueMode
+	default:
+		return nil, fmt.Errorf("invalid queue mode %q", cfg.QueueMode)
+	}
+
+	if cfg.MaxLength < 0 {
+		return nil, fmt.Errorf("invalid queue max length %d", cfg.MaxLength)
+	}
+	if cfg.MaxLength > 0 {
+		args["x-max-length"] = int64(cfg.MaxLength)
+	}
+
+	switch cfg.Overflow {
+	case "":
+	case "drop-head", "reject-publish", "reject-publish-dlx":
+		args["x-overflow"] = cfg.Overflow
+	default:
+		return nil, fmt.Errorf("invalid queue overflow behaviour %q", cfg.Overflow)
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return args, nil
+}
+
+// DeclareQueue declares the durable work queue with args. Publisher and
+// worker must both use it so their declarations agree; RabbitMQ refuses to
+// redeclare an existing queue with different arguments and closes the
+// channel, which is reported as a descriptive error.
+func DeclareQueue(ch queueDeclarer, name string, args amqp.Table) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+			return q, fmt.Errorf("queue %q already exists with different arguments (%s); delete it or align the rabbitmq queue settings", name, amqpErr.Reason)
+		}
+		return q, fmt.Errorf("failed to declare queue: %v", err)
+	}
+	return q, nil
+}
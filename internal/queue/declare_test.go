@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"testing"
+
+	"webhook-processor/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingDeclarer struct {
+	name string
+	args amqp.Table
+	err  error
+}
+
+func (d *recordingDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	d.name = name
+	d.args = args
+	return amqp.Queue{Name: name}, d.err
+}
+
+func TestQueueArgsPassedThrough(t *testing.T) {
+	args, err := QueueArgs(config.RabbitMQConfig{QueueMode: "lazy", MaxLength: 100000, Overflow: "reject-publish"})
+	require.NoError(t, err)
+
+	d := &recordingDeclarer{}
+	_, err = DeclareQueue(d, "webhook_queue", args)
+	require.NoError(t, err)
+
+	assert.Equal(t, "webhook_queue", d.name)
+	assert.Equal(t, amqp.Table{
+		"x-queue-mode": "lazy",
+		"x-max-length": int64(100000),
+		"x-overflow":   "reject-publish",
+	}, d.args)
+}
+
+func TestQueueArgsEmptyByDefault(t *testing.T) {
+	args, err := QueueArgs(config.RabbitMQConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, args, "unconfigured queues are declared without arguments")
+}
+
+func TestQueueArgsRejectsInvalidValues(t *testing.T) {
+	_, err := QueueArgs(config.RabbitMQConfig{QueueMode: "eager"})
+	assert.Error(t, err)
+	_, err = QueueArgs(config.RabbitMQConfig{Overflow: "drop-tail"})
+	assert.Error(t, err)
+	_, err = QueueArgs(config.RabbitMQConfig{MaxLength: -1})
+	assert.Error(t, err)
+}
+
+func TestDeclareQueueReportsArgumentConflict(t *testing.T) {
+	d := &recordingDeclarer{err: &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'x-queue-mode'"}}
+
+	_, err := DeclareQueue(d, "webhook_queue", amqp.Table{"x-queue-mode": "lazy"})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists with different arguments")
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+)
+
+// Destination is an extra exchange that receives every published event,
+// reshaped by a template into the body its consumers expect.
+type Destination struct {
+	Name     string
+	Exchange string
+	template *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, so strings are quoted and
+	// escaped correctly.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// NewDestinations compiles the configured destination templates.
+func NewDestinations(cfgs []config.DestinationConfig) ([]Destination, error) {
+	destinations := make([]Destination, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Exchange == "" {
+			return nil, fmt.Errorf("destination %q has no exchange", cfg.Name)
+		}
+		tmpl, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for destination %q: %v", cfg.Name, err)
+		}
+		destinations = append(destinations, Destination{Name: cfg.Name, Exchange: cfg.Exchange, template: tmpl})
+	}
+	return destinations, nil
+}
+
+// Render executes the destination's template for event. The output must be
+// valid JSON.
+func (d Destination) Render(event models.WebhookEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.template.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render destination %q: %v", d.Name, err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("destination %q template did not produce valid JSON", d.Name)
+	}
+	return buf.Bytes(), nil
+}
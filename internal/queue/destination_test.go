@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestinationTemplateReshapesEvent(t *testing.T) {
+	destinations, err := NewDestinations([]config.DestinationConfig{{
+		Name:     "crm",
+		Exchange: "crm_events",
+		Template: `{"type": {{json .Event}}, "recipient": {{json .Email}}, "client": {{json .ClientID}},` +
+			` "campaign": {"id": {{json .CampaignID}}, "name": {{json .CampaignName}}}}`,
+	}})
+	require.NoError(t, err)
+	require.Len(t, destinations, 1)
+
+	body, err := destinations[0].Render(models.WebhookEvent{
+		Event:        "clicked",
+		Email:        `quote"d@example.com`,
+		ClientID:     "client-a",
+		CampaignID:   "c-1",
+		CampaignName: "Launch",
+	})
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, map[string]interface{}{
+		"type":      "clicked",
+		"recipient": `quote"d@example.com`,
+		"client":    "client-a",
+		"campaign":  map[string]interface{}{"id": "c-1", "name": "Launch"},
+	}, got)
+}
+
+func TestDestinationTemplateErrors(t *testing.T) {
+	_, err := NewDestinations([]config.DestinationConfig{{Name: "bad", Exchange: "x", Template: "{{.Event"}})
+	assert.Error(t, err, "unparseable template")
+
+	_, err = NewDestinations([]config.DestinationConfig{{Name: "no-exchange", Template: "{}"}})
+	assert.Error(t, err)
+
+	destinations, err := NewDestinations([]config.DestinationConfig{{Name: "raw", Exchange: "x", Template: "{{.Event}}"}})
+	require.NoError(t, err)
+	_, err = destinations[0].Render(models.WebhookEvent{Event: "opened"})
+	assert.Error(t, err, "output must be JSON")
+
+	destinations, err = NewDestinations([]config.DestinationConfig{{Name: "typo", Exchange: "x", Template: "{{json .Evnt}}"}})
+	require.NoError(t, err)
+	_, err = destinations[0].Render(models.WebhookEvent{Event: "opened"})
+	assert.Error(t, err, "unknown fields fail at render")
+}
@@ -3,7 +3,9 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"webhook-processor/internal/models"
@@ -18,12 +20,145 @@ type Publisher interface {
 	Close() error
 }
 
+// DefaultPublishTimeout bounds Publish for callers without a deadline.
+const DefaultPublishTimeout = 5 * time.Second
+
+// ContextPublisher is a Publisher that can also publish under a caller's
+// context, stopping early when it is done.
+type ContextPublisher interface {
+	Publisher
+	PublishContext(ctx context.Context, event models.WebhookEvent) error
+}
+
+var (
+	_ ContextPublisher = (*RabbitMQ)(nil)
+	_ ContextPublisher = (*RetryBuffer)(nil)
+)
+
+// PublishContext publishes event through p under ctx if p supports it, and
+// with p's own timeout otherwise.
+func PublishContext(ctx context.Context, p Publisher, event models.WebhookEvent) error {
+	if cp, ok := p.(ContextPublisher); ok {
+		return cp.PublishContext(ctx, event)
+	}
+	return p.Publish(event)
+}
+
+// Drainer is a Publisher that holds events in memory, which must be flushed
+// before shutdown. Drain stops accepting events and publishes the held ones
+// until ctx is done, returning any it couldn't publish.
+type Drainer interface {
+	Publisher
+	Drain(ctx context.Context) []models.WebhookEvent
+}
+
+var (
+	_ Drainer = (*AsyncPublisher)(nil)
+	_ Drainer = (*RetryBuffer)(nil)
+)
+
+// ErrNotConnected is returned by RabbitMQ when the broker connection is down
+// and wasn't re-established within the reconnect timeout.
+var ErrNotConnected = errors.New("rabbitmq connection unavailable")
+
+// RabbitMQ publishes events to the exchange. When the connection or channel
+// closes it redials in the background with capped exponential backoff and
+// re-declares the exchange, queue and destinations; meanwhile Publish waits
+// up to reconnectTimeout for the connection to come back.
 type RabbitMQ struct {
-	conn         *amqp.Connection
-	ch           *amqp.Channel
+	url          string
 	exchangeName string
-	logger       *zap.Logger
 	queueName    string
+	queueArgs    amqp.Table
+	logger       *zap.Logger
+	// routingKeys publishes to a topic exchange keyed by event and client
+	routingKeys bool
+
+	reconnectDelay    time.Duration
+	reconnectMaxDelay time.Duration
+	reconnectTimeout  time.Duration
+
+	// mu guards the connection, which the reconnect loop replaces
+	mu           sync.RWMutex
+	conn         *amqp.Connection
+	ch           *amqp.Channel
+	connected    chan struct{} // closed while a channel is available
+	closed       bool
+	done         chan struct{} // closed by Close to stop reconnecting
+	destinations []Destination
+}
+
+// RabbitMQOption configures optional RabbitMQ behaviour.
+type RabbitMQOption func(*RabbitMQ)
+
+// WithReconnect sets the backoff between redial attempts, starting at delay
+// and doubling up to maxDelay, and how long Publish waits for a reconnect
+// before returning ErrNotConnected.
+func WithReconnect(delay, maxDelay, timeout time.Duration) RabbitMQOption {
+	return func(r *RabbitMQ) {
+		r.reconnectDelay = delay
+		r.reconnectMaxDelay = maxDelay
+		r.reconnectTimeout = timeout
+	}
+}
+
+// WithEventRoutingKeys publishes each event with the routing key
+// "<event>.<client_id>" to a topic exchange, so consumers can bind to the
+// events they need (see RoutingKey). The exchange type changes from direct
+// to topic, so an existing exchange must be deleted before enabling it.
+func WithEventRoutingKeys(enabled bool) RabbitMQOption {
+	return func(r *RabbitMQ) {
+		r.routingKeys = enabled
+	}
+}
+
+// exchangeKind returns the type the exchange is declared with.
+func (r *RabbitMQ) exchangeKind() string {
+	if r.routingKeys {
+		return exchangeKindTopic
+	}
+	return exchangeKindDirect
+}
+
+// bindingKey returns the key the work queue is bound with, matching every
+// event.
+func (r *RabbitMQ) bindingKey() string {
+	if r.routingKeys {
+		return AllEventsBinding
+	}
+	return ""
+}
+
+// routingKey returns the key event is published with.
+func (r *RabbitMQ) routingKey(event models.WebhookEvent) string {
+	if r.routingKeys {
+		return RoutingKey(event)
+	}
+	return ""
+}
+
+// QueueStats is a snapshot of the work queue.
+type QueueStats struct {
+	Messages  int
+	Consumers int
+}
+
+// Inspector is implemented by publishers that can report on the work queue.
+type Inspector interface {
+	QueueStats() (QueueStats, error)
+}
+
+// QueueStats returns the work queue's depth and number of consumers.
+func (r *RabbitMQ) QueueStats() (QueueStats, error) {
+	ch, err := r.currentChannel()
+	if err != nil {
+		return QueueStats{}, err
+	}
+	q, err := ch.QueueInspect(r.queueName)
+	if err != nil {
+		return QueueStats{}, err
+	}
+	return QueueStats{Messages: q.Messages, Consumers: q.Consumers}, nil
 }
 
 // StartMetricsUpdater starts a goroutine to periodically update queue metrics
@@ -37,7 +172,7 @@ func (r *RabbitMQ) StartMetricsUpdater(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if queue, err := r.ch.QueueInspect(r.queueName); err == nil {
+				if queue, err := r.QueueStats(); err == nil {
 					metrics.WebhookQueueSize.WithLabelValues("all").Set(float64(queue.Messages))
 				}
 			}
@@ -45,22 +180,65 @@ func (r *RabbitMQ) StartMetricsUpdater(ctx context.Context) {
 	}()
 }
 
-func NewRabbitMQ(url, exchangeName, queueName string, logger *zap.Logger) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(url)
+// NewRabbitMQ connects a publisher and declares the exchange and work queue.
+// queueArgs should come from QueueArgs so the declaration matches the worker.
+func NewRabbitMQ(url, exchangeName, queueName string, queueArgs amqp.Table, logger *zap.Logger, opts ...RabbitMQOption) (*RabbitMQ, error) {
+	r := newRabbitMQ(url, exchangeName, queueName, queueArgs, logger)
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	conn, ch, err := r.dial()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+		return nil, err
+	}
+	r.attach(conn, ch)
+	return r, nil
+}
+
+// newRabbitMQ returns a disconnected publisher.
+func newRabbitMQ(url, exchangeName, queueName string, queueArgs amqp.Table, logger *zap.Logger) *RabbitMQ {
+	return &RabbitMQ{
+		url:               url,
+		exchangeName:      exchangeName,
+		queueName:         queueName,
+		queueArgs:         queueArgs,
+		logger:            logger,
+		reconnectDelay:    time.Second,
+		reconnectMaxDelay: 30 * time.Second,
+		reconnectTimeout:  5 * time.Second,
+		connected:         make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+}
+
+// dial connects and declares the exchange, the work queue and its binding
+// and the destination exchanges.
+func (r *RabbitMQ) dial() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %v", err)
+		return nil, nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+
+	if err := r.declare(ch); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, nil, err
 	}
+	return conn, ch, nil
+}
 
+func (r *RabbitMQ) declare(ch *amqp.Channel) error {
 	// Declare exchange
-	err = ch.ExchangeDeclare(
-		exchangeName,
-		"direct",
+	err := ch.ExchangeDeclare(
+		r.exchangeName,
+		r.exchangeKind(),
 		true,  // durable
 		false, // auto-deleted
 		false, // internal
@@ -68,53 +246,202 @@ func NewRabbitMQ(url, exchangeName, queueName string, logger *zap.Logger) (*Rabb
 		nil,   // arguments
 	)
 	if err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare exchange: %v", err)
+		return fmt.Errorf("failed to declare exchange: %v", err)
 	}
 
 	// Declare queue
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	q, err := DeclareQueue(ch, r.queueName, r.queueArgs)
 	if err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %v", err)
+		return err
 	}
 
 	// Bind queue to exchange
 	err = ch.QueueBind(
-		q.Name,       // queue name
-		"",           // routing key
-		exchangeName, // exchange
+		q.Name,         // queue name
+		r.bindingKey(), // routing key
+		r.exchangeName, // exchange
 		false,
 		nil,
 	)
 	if err != nil {
+		return fmt.Errorf("failed to bind queue: %v", err)
+	}
+
+	r.mu.RLock()
+	destinations := r.destinations
+	r.mu.RUnlock()
+	for _, dest := range destinations {
+		if err := declareDestination(ch, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attach makes conn and ch the current connection and watches them for
+// closure. It reports false, closing them, if the publisher was closed.
+func (r *RabbitMQ) attach(conn *amqp.Connection, ch *amqp.Channel) bool {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
 		ch.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to bind queue: %v", err)
+		return false
 	}
+	r.conn, r.ch = conn, ch
+	close(r.connected)
+	r.mu.Unlock()
 
-	return &RabbitMQ{
-		conn:         conn,
-		ch:           ch,
-		exchangeName: exchangeName,
-		logger:       logger,
-		queueName:    queueName,
-	}, nil
+	go r.watch(conn, ch)
+	return true
+}
+
+// watch waits for conn or ch to close and then reconnects, unless the
+// publisher itself was closed.
+func (r *RabbitMQ) watch(conn *amqp.Connection, ch *amqp.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	var cause *amqp.Error
+	select {
+	case cause = <-connClosed:
+	case cause = <-chClosed:
+	case <-r.done:
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.conn, r.ch = nil, nil
+	r.connected = make(chan struct{})
+	r.mu.Unlock()
+
+	// A closed channel leaves the connection open
+	conn.Close()
+
+	fields := []zap.Field{zap.String("exchange", r.exchangeName)}
+	if cause != nil {
+		fields = append(fields, zap.String("reason", cause.Error()))
+	}
+	r.logger.Error("RabbitMQ connection lost, reconnecting", fields...)
+
+	r.reconnect()
+}
+
+// reconnect redials with capped exponential backoff until it succeeds or
+// the publisher is closed.
+func (r *RabbitMQ) reconnect() {
+	for attempt := 0; ; attempt++ {
+		delay := r.backoff(attempt)
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, ch, err := r.dial()
+		if err != nil {
+			r.logger.Warn("RabbitMQ reconnect failed",
+				zap.Error(err),
+				zap.Int("attempt", attempt+1),
+				zap.Duration("delay", delay))
+			continue
+		}
+		if r.attach(conn, ch) {
+			r.logger.Info("RabbitMQ reconnected", zap.Int("attempts", attempt+1))
+		}
+		return
+	}
+}
+
+func (r *RabbitMQ) backoff(attempt int) time.Duration {
+	delay := r.reconnectDelay
+	for i := 0; i < attempt && delay < r.reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.reconnectMaxDelay {
+		delay = r.reconnectMaxDelay
+	}
+	return delay
+}
+
+// IsConnected reports whether the publisher currently has a broker channel.
+func (r *RabbitMQ) IsConnected() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ch != nil
+}
+
+// channel returns the current channel, waiting up to reconnectTimeout for a
+// reconnect in progress, or until ctx is done.
+func (r *RabbitMQ) channel(ctx context.Context) (*amqp.Channel, error) {
+	r.mu.RLock()
+	ch, connected, closed := r.ch, r.connected, r.closed
+	r.mu.RUnlock()
+	if closed {
+		return nil, ErrPublisherClosed
+	}
+	if ch != nil {
+		return ch, nil
+	}
+
+	timer := time.NewTimer(r.reconnectTimeout)
+	defer timer.Stop()
+	select {
+	case <-connected:
+	case <-r.done:
+		return nil, ErrPublisherClosed
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: not reconnected within %s", ErrNotConnected, r.reconnectTimeout)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", ErrNotConnected, ctx.Err())
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.ch == nil {
+		return nil, ErrNotConnected
+	}
+	return r.ch, nil
+}
+
+// currentChannel returns the current channel without waiting.
+func (r *RabbitMQ) currentChannel() (*amqp.Channel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.ch == nil {
+		return nil, ErrNotConnected
+	}
+	return r.ch, nil
 }
 
+// Publish publishes event within DefaultPublishTimeout.
 func (r *RabbitMQ) Publish(event models.WebhookEvent) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPublishTimeout)
 	defer cancel()
 
+	return r.PublishContext(ctx, event)
+}
+
+// PublishContext publishes event, giving up when ctx is done, e.g. because
+// the HTTP client that sent it disconnected.
+func (r *RabbitMQ) PublishContext(ctx context.Context, event models.WebhookEvent) error {
+	ch, err := r.channel(ctx)
+	if err != nil {
+		return err
+	}
+	return r.publishEvent(ctx, ch, event)
+}
+
+// RequestIDHeader carries the ID of the API request an event arrived in, so
+// the worker can log it alongside the API's own log lines.
+const RequestIDHeader = "request_id"
+
+// publishEvent publishes event to the exchange and every destination.
+func (r *RabbitMQ) publishEvent(ctx context.Context, ch publishChannel, event models.WebhookEvent) error {
 	body, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal event: %v", err)
@@ -125,11 +452,46 @@ func (r *RabbitMQ) Publish(event models.WebhookEvent) error {
 	headers["webhook_id"] = event.WebhookID
 	headers["webhook_type"] = event.WebhookType
 	headers["client_id"] = event.ClientID
+	if !event.ReceivedAt.IsZero() {
+		headers["received_at"] = event.ReceivedAt
+	}
+	if event.RequestID != "" {
+		headers[RequestIDHeader] = event.RequestID
+	}
 
 	// Publish to all queues bound to this exchange
-	err = r.ch.PublishWithContext(ctx,
-		r.exchangeName,
-		"",    // routing key
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	if err := publish(ctx, ch, r.exchangeName, r.routingKey(event), headers, body); err != nil {
+		return fmt.Errorf("failed to publish message: %v", err)
+	}
+
+	r.mu.RLock()
+	destinations := r.destinations
+	r.mu.RUnlock()
+	for _, dest := range destinations {
+		destBody, err := dest.Render(event)
+		if err != nil {
+			return err
+		}
+		if err := publish(ctx, ch, dest.Exchange, "", headers, destBody); err != nil {
+			return fmt.Errorf("failed to publish message to destination %q: %v", dest.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// publishChannel is the subset of *amqp.Channel used to publish.
+type publishChannel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+func publish(ctx context.Context, ch publishChannel, exchange, key string, headers amqp.Table, body []byte) error {
+	return ch.PublishWithContext(ctx,
+		exchange,
+		key,
 		false, // mandatory
 		false, // immediate
 		amqp.Publishing{
@@ -137,29 +499,84 @@ func (r *RabbitMQ) Publish(event models.WebhookEvent) error {
 			Headers:      headers,
 			Body:         body,
 			DeliveryMode: amqp.Persistent,
+			// Lets the worker measure how long messages wait in the queue
+			Timestamp: time.Now().UTC(),
 		})
+}
 
+// AddDestinations declares each destination's exchange and publishes a
+// templated copy of every subsequent event to it. Binding queues to the
+// exchanges is left to the consumers.
+func (r *RabbitMQ) AddDestinations(destinations []Destination) error {
+	ch, err := r.currentChannel()
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %v", err)
+		return err
+	}
+	for _, dest := range destinations {
+		if err := declareDestination(ch, dest); err != nil {
+			return err
+		}
 	}
 
+	r.mu.Lock()
+	r.destinations = append(r.destinations, destinations...)
+	r.mu.Unlock()
+	return nil
+}
+
+func declareDestination(ch *amqp.Channel, dest Destination) error {
+	err := ch.ExchangeDeclare(
+		dest.Exchange,
+		"fanout",
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange for destination %q: %v", dest.Name, err)
+	}
 	return nil
 }
 
+// Close stops reconnecting and closes the current connection, if any.
 func (r *RabbitMQ) Close() error {
-	if err := r.ch.Close(); err != nil {
-		r.logger.Error("Failed to close channel", zap.Error(err))
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	conn, ch := r.conn, r.ch
+	r.conn, r.ch = nil, nil
+	r.mu.Unlock()
+
+	if ch != nil {
+		if err := ch.Close(); err != nil {
+			r.logger.Error("Failed to close channel", zap.Error(err))
+		}
 	}
-	if err := r.conn.Close(); err != nil {
-		r.logger.Error("Failed to close connection", zap.Error(err))
+	if conn != nil {
+		if err := conn.Close(); err != nil {
+			r.logger.Error("Failed to close connection", zap.Error(err))
+		}
 	}
 	return nil
 }
 
-func (r *RabbitMQ) DeclareClientQueue(clientID string) error {
+// DeclareClientQueue declares webhook_queue_<clientID> and binds it to the
+// client's events. With event routing keys it can be limited to the given
+// event types; otherwise it receives every one of the client's events.
+func (r *RabbitMQ) DeclareClientQueue(clientID string, eventTypes ...string) error {
+	ch, err := r.channel(context.Background())
+	if err != nil {
+		return err
+	}
 	queueName := fmt.Sprintf("webhook_queue_%s", clientID)
 
-	_, err := r.ch.QueueDeclare(
+	_, err = ch.QueueDeclare(
 		queueName,
 		true,  // durable
 		false, // auto-delete
@@ -171,16 +588,26 @@ func (r *RabbitMQ) DeclareClientQueue(clientID string) error {
 		return fmt.Errorf("failed to declare queue: %v", err)
 	}
 
-	err = r.ch.QueueBind(
-		queueName,
-		clientID, // routing key
-		r.exchangeName,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to bind queue: %v", err)
+	for _, key := range r.clientBindings(clientID, eventTypes) {
+		if err := ch.QueueBind(queueName, key, r.exchangeName, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue: %v", err)
+		}
 	}
 
 	return nil
 }
+
+// clientBindings returns the binding keys for a client queue.
+func (r *RabbitMQ) clientBindings(clientID string, eventTypes []string) []string {
+	if !r.routingKeys {
+		return []string{clientID}
+	}
+	if len(eventTypes) == 0 {
+		return []string{EventBinding("", clientID)}
+	}
+	keys := make([]string, len(eventTypes))
+	for i, eventType := range eventTypes {
+		keys[i] = EventBinding(eventType, clientID)
+	}
+	return keys
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReconnectBackoffIsCapped(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	WithReconnect(time.Second, 10*time.Second, time.Second)(r)
+
+	var delays []time.Duration
+	for attempt := 0; attempt < 6; attempt++ {
+		delays = append(delays, r.backoff(attempt))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, delays)
+}
+
+func TestPublishWhileDisconnectedTimesOut(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	WithReconnect(time.Second, time.Second, 20*time.Millisecond)(r)
+
+	assert.False(t, r.IsConnected())
+	start := time.Now()
+	err := r.Publish(models.WebhookEvent{WebhookID: "wh-1"})
+	assert.ErrorIs(t, err, ErrNotConnected)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "publish waits for a reconnect first")
+
+	_, err = r.QueueStats()
+	assert.ErrorIs(t, err, ErrNotConnected, "inspection doesn't wait")
+}
+
+func TestPublishContextStopsWaitingWhenCancelled(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	WithReconnect(time.Second, time.Second, time.Minute)(r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- r.PublishContext(ctx, models.WebhookEvent{WebhookID: "wh-1"}) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrNotConnected)
+		assert.ErrorContains(t, err, context.Canceled.Error())
+	case <-time.After(time.Second):
+		t.Fatal("publish still waiting after its context was cancelled")
+	}
+}
+
+func TestPublishContextHelper(t *testing.T) {
+	ctx := context.WithValue(context.Background(), struct{}{}, "request")
+
+	plain := &flakyPublisher{}
+	require.NoError(t, PublishContext(ctx, plain, models.WebhookEvent{WebhookID: "wh-1"}))
+	assert.Len(t, plain.published, 1, "publishers without PublishContext fall back to Publish")
+
+	aware := &contextPublisher{}
+	b, _ := newTestRetryBuffer(aware, 10)
+	require.NoError(t, PublishContext(ctx, b, models.WebhookEvent{WebhookID: "wh-2"}))
+	require.Len(t, aware.ctxs, 1)
+	assert.Equal(t, "request", aware.ctxs[0].Value(struct{}{}), "the retry buffer passes the caller's context on")
+}
+
+// contextPublisher records the context of each publish.
+type contextPublisher struct {
+	ctxs []context.Context
+}
+
+func (p *contextPublisher) Publish(event models.WebhookEvent) error {
+	return p.PublishContext(context.Background(), event)
+}
+
+func (p *contextPublisher) PublishContext(ctx context.Context, event models.WebhookEvent) error {
+	p.ctxs = append(p.ctxs, ctx)
+	return nil
+}
+
+func (p *contextPublisher) Close() error {
+	return nil
+}
+
+func TestCloseUnblocksWaitingPublish(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	WithReconnect(time.Second, time.Second, time.Minute)(r)
+
+	errs := make(chan error, 1)
+	go func() { errs <- r.Publish(models.WebhookEvent{WebhookID: "wh-1"}) }()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, r.Close())
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrPublisherClosed)
+	case <-time.After(time.Second):
+		t.Fatal("publish still waiting after Close")
+	}
+	assert.ErrorIs(t, r.Publish(models.WebhookEvent{WebhookID: "wh-2"}), ErrPublisherClosed)
+}
+
+func TestPublishCarriesRequestID(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	ch := &recordingChannel{}
+
+	require.NoError(t, r.publishEvent(context.Background(), ch, models.WebhookEvent{WebhookID: "wh-1", RequestID: "req-1"}))
+	require.NoError(t, r.publishEvent(context.Background(), ch, models.WebhookEvent{WebhookID: "wh-2"}))
+
+	require.Len(t, ch.published, 2)
+	assert.Equal(t, "req-1", ch.published[0].Headers[RequestIDHeader])
+	assert.NotContains(t, ch.published[1].Headers, RequestIDHeader)
+	assert.NotContains(t, string(ch.published[0].Body), "req-1", "the ID travels in the header only")
+}
+
+func TestPublishSetsTimestamp(t *testing.T) {
+	r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+	ch := &recordingChannel{}
+
+	before := time.Now()
+	require.NoError(t, r.publishEvent(context.Background(), ch, models.WebhookEvent{WebhookID: "wh-1"}))
+
+	require.Len(t, ch.published, 1)
+	assert.WithinDuration(t, before, ch.published[0].Timestamp, time.Second)
+}
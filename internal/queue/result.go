@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Result confirms to an integrator that one of their events was stored.
+type Result struct {
+	WebhookID string    `json:"webhook_id"`
+	Status    string    `json:"status"`
+	StoredAt  time.Time `json:"stored_at"`
+}
+
+// resultChannel is the subset of *amqp.Channel used to publish results.
+type resultChannel interface {
+	queueDeclarer
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// ResultPublisher publishes a Result for each stored event to a callback
+// queue, through the default exchange. The client is in the client_id
+// header so a shared queue can be split between integrators.
+type ResultPublisher struct {
+	ch        resultChannel
+	queueName string
+}
+
+// NewResultPublisher declares the durable callback queue.
+func NewResultPublisher(ch resultChannel, queueName string) (*ResultPublisher, error) {
+	if _, err := DeclareQueue(ch, queueName, nil); err != nil {
+		return nil, fmt.Errorf("failed to declare callback queue: %v", err)
+	}
+	return &ResultPublisher{ch: ch, queueName: queueName}, nil
+}
+
+// PublishResult publishes result for clientID to the callback queue.
+func (p *ResultPublisher) PublishResult(ctx context.Context, clientID string, result Result) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+
+	err = p.ch.PublishWithContext(ctx,
+		"",          // default exchange
+		p.queueName, // routing key
+		false,       // mandatory
+		false,       // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    result.WebhookID,
+			Timestamp:    result.StoredAt,
+			Headers:      amqp.Table{"client_id": clientID},
+			Body:         body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish result: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishResult(t *testing.T) {
+	ch := &recordingChannel{}
+	p, err := NewResultPublisher(ch, "webhook_results")
+	require.NoError(t, err)
+	assert.Equal(t, "webhook_results", ch.name, "the callback queue is declared")
+
+	storedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, p.PublishResult(context.Background(), "client-a", Result{WebhookID: "wh-1", Status: "processed", StoredAt: storedAt}))
+
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, []string{""}, ch.publishedTo, "published through the default exchange")
+	assert.Equal(t, []string{"webhook_results"}, ch.publishedKeys)
+	msg := ch.published[0]
+	assert.Equal(t, "wh-1", msg.MessageId)
+	assert.Equal(t, amqp.Table{"client_id": "client-a"}, msg.Headers)
+	assert.Equal(t, uint8(amqp.Persistent), msg.DeliveryMode)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(msg.Body, &body))
+	assert.Equal(t, map[string]interface{}{
+		"webhook_id": "wh-1",
+		"status":     "processed",
+		"stored_at":  "2024-06-01T12:00:00Z",
+	}, body)
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RetryCountHeader carries how many times a message has been retried, so
+// the count survives the trip through a retry queue.
+const RetryCountHeader = "x-retry-count"
+
+// retryQueueIdle is how long an unused retry queue lingers after its TTL
+// before the broker deletes it.
+const retryQueueIdle = time.Minute
+
+// retryChannel is the subset of *amqp.Channel used for delayed retries.
+type retryChannel interface {
+	queueDeclarer
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// DelayedRetrier schedules a retry by parking the message in a queue with
+// no consumers whose x-message-ttl is the delay. When the TTL expires the
+// broker dead-letters it back to the work queue, so the worker never sleeps
+// on a failing message.
+//
+// There is one retry queue per delay (rounded to the second), because
+// RabbitMQ only expires messages at the head of a queue. Idle retry queues
+// are deleted by the broker.
+type DelayedRetrier struct {
+	ch        retryChannel
+	workQueue string
+}
+
+// NewDelayedRetrier returns a retrier that delivers retries back to
+// workQueue through the default exchange.
+func NewDelayedRetrier(ch retryChannel, workQueue string) *DelayedRetrier {
+	return &DelayedRetrier{ch: ch, workQueue: workQueue}
+}
+
+// RetryQueueName returns the retry queue holding messages for delay.
+func (r *DelayedRetrier) RetryQueueName(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%ds", r.workQueue, retrySeconds(delay))
+}
+
+func retrySeconds(delay time.Duration) int64 {
+	secs := int64((delay + time.Second/2) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// Retry republishes msg to the retry queue for delay, setting the retry
+// count header to retryCount. The caller acks the original delivery once
+// Retry succeeds.
+func (r *DelayedRetrier) Retry(ctx context.Context, msg amqp.Delivery, retryCount int, delay time.Duration) error {
+	name, err := r.declare(delay)
+	if err != nil {
+		return err
+	}
+
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[RetryCountHeader] = int32(retryCount)
+
+	err = r.ch.PublishWithContext(ctx,
+		"",    // default exchange routes by queue name
+		name,  // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			Headers:      headers,
+			Body:         msg.Body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish to retry queue: %v", err)
+	}
+	return nil
+}
+
+// declare creates the retry queue for delay. It is redeclared on every
+// retry because publishing doesn't reset x-expires; redeclaring does, so
+// the queue outlives every message in it.
+func (r *DelayedRetrier) declare(delay time.Duration) (string, error) {
+	name := r.RetryQueueName(delay)
+	ttl := time.Duration(retrySeconds(delay)) * time.Second
+	args := amqp.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-expires":                 (ttl + retryQueueIdle).Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": r.workQueue,
+	}
+	if _, err := DeclareQueue(r.ch, name, args); err != nil {
+		return "", fmt.Errorf("failed to declare retry queue: %v", err)
+	}
+	return name, nil
+}
@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// RetryBuffer publishes synchronously and, when that fails, holds the event
+// in a bounded in-memory buffer instead of failing the request, so a brief
+// broker outage doesn't turn into a burst of 500s. A background flusher
+// retries buffered events in order until they are published or older than
+// maxAge, when they are dropped. Once the buffer is full further failures
+// return ErrPublishBufferFull.
+type RetryBuffer struct {
+	next     Publisher
+	size     int
+	maxAge   time.Duration
+	interval time.Duration
+	clock    clock.Clock
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	closed  bool
+	pending []bufferedEvent
+
+	// flushMu serialises flushes so events leave the buffer in order
+	flushMu sync.Mutex
+}
+
+type bufferedEvent struct {
+	event      models.WebhookEvent
+	bufferedAt time.Time
+}
+
+func NewRetryBuffer(next Publisher, size int, maxAge, interval time.Duration, clk clock.Clock, logger *zap.Logger) *RetryBuffer {
+	return &RetryBuffer{
+		next:     next,
+		size:     size,
+		maxAge:   maxAge,
+		interval: interval,
+		clock:    clk,
+		logger:   logger,
+	}
+}
+
+// Publish publishes event, buffering it for retry if the broker rejects it.
+// While earlier events are still buffered new ones queue behind them, so
+// they reach the broker in the order they were accepted.
+func (b *RetryBuffer) Publish(event models.WebhookEvent) error {
+	return b.publish(event, b.next.Publish)
+}
+
+// PublishContext is Publish with the first attempt made under ctx. An event
+// whose attempt is cut short by ctx is still buffered for retry.
+func (b *RetryBuffer) PublishContext(ctx context.Context, event models.WebhookEvent) error {
+	return b.publish(event, func(event models.WebhookEvent) error {
+		return PublishContext(ctx, b.next, event)
+	})
+}
+
+func (b *RetryBuffer) publish(event models.WebhookEvent, attempt func(models.WebhookEvent) error) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrPublisherClosed
+	}
+	backlog := len(b.pending) > 0
+	b.mu.Unlock()
+
+	if !backlog {
+		err := attempt(event)
+		if err == nil {
+			return nil
+		}
+		b.logger.Warn("Publish failed, buffering event for retry",
+			zap.Error(err),
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("client_id", event.ClientID))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) >= b.size {
+		return ErrPublishBufferFull
+	}
+	b.pending = append(b.pending, bufferedEvent{event: event, bufferedAt: b.clock.Now()})
+	metrics.PublishRetryBuffered.Set(float64(len(b.pending)))
+	return nil
+}
+
+// Len returns the number of events waiting to be retried.
+func (b *RetryBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Run flushes the buffer every interval until ctx is done.
+func (b *RetryBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
+
+// Flush publishes buffered events oldest first, stopping at the first
+// failure since the broker is evidently still unavailable. Events older than
+// maxAge are dropped rather than retried.
+func (b *RetryBuffer) Flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		next := b.pending[0]
+		b.mu.Unlock()
+
+		if age := b.clock.Now().Sub(next.bufferedAt); age > b.maxAge {
+			b.drop("expired", next)
+			continue
+		}
+
+		if err := b.next.Publish(next.event); err != nil {
+			b.logger.Debug("Retry buffer flush failed",
+				zap.Error(err),
+				zap.Int("buffered", b.Len()))
+			return
+		}
+		b.pop()
+	}
+}
+
+// Close stops accepting events, makes a last attempt to publish everything
+// still buffered and then closes the underlying publisher. Events that can't
+// be published are dropped.
+func (b *RetryBuffer) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, event := range b.Drain(ctx) {
+		metrics.PublishRetryDropped.WithLabelValues("shutdown").Inc()
+		b.logger.Error("Dropping buffered event",
+			zap.String("reason", "shutdown"),
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("client_id", event.ClientID))
+	}
+
+	return b.next.Close()
+}
+
+// Drain stops accepting events and retries the buffered ones every interval
+// until they are all published or ctx is done, making at least one attempt.
+// It returns the events that weren't published, removing them from the
+// buffer.
+func (b *RetryBuffer) Drain(ctx context.Context) []models.WebhookEvent {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		b.Flush()
+		if b.Len() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return b.take()
+		case <-ticker.C:
+		}
+	}
+}
+
+// take empties the buffer, returning what it held.
+func (b *RetryBuffer) take() []models.WebhookEvent {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make([]models.WebhookEvent, 0, len(b.pending))
+	for _, e := range b.pending {
+		events = append(events, e.event)
+	}
+	b.pending = nil
+	metrics.PublishRetryBuffered.Set(0)
+	return events
+}
+
+func (b *RetryBuffer) pop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = b.pending[1:]
+	metrics.PublishRetryBuffered.Set(float64(len(b.pending)))
+}
+
+func (b *RetryBuffer) drop(reason string, e bufferedEvent) {
+	b.pop()
+	metrics.PublishRetryDropped.WithLabelValues(reason).Inc()
+	b.logger.Error("Dropping buffered event",
+		zap.String("reason", reason),
+		zap.String("webhook_id", e.event.WebhookID),
+		zap.String("client_id", e.event.ClientID),
+		zap.Duration("buffered_for", b.clock.Now().Sub(e.bufferedAt)))
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestRetryBuffer(next Publisher, size int) (*RetryBuffer, *clock.Mock) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	return NewRetryBuffer(next, size, 30*time.Second, time.Second, clk, zap.NewNop()), clk
+}
+
+func TestRetryBufferPublishesDirectly(t *testing.T) {
+	next := &flakyPublisher{}
+	b, _ := newTestRetryBuffer(next, 10)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+
+	assert.Len(t, next.published, 1)
+	assert.Zero(t, b.Len())
+}
+
+func TestRetryBufferBuffersAndFlushes(t *testing.T) {
+	next := &flakyPublisher{failures: 2}
+	b, _ := newTestRetryBuffer(next, 10)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}), "a failed publish is buffered, not returned")
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-2"}))
+	assert.Equal(t, 2, b.Len())
+	assert.Equal(t, 1, next.attempts, "later events queue behind the backlog instead of publishing")
+
+	// The broker is still down for the first flush attempt
+	b.Flush()
+	assert.Equal(t, 2, b.Len())
+
+	b.Flush()
+	assert.Zero(t, b.Len())
+	require.Len(t, next.published, 2)
+	assert.Equal(t, "wh-1", next.published[0].WebhookID)
+	assert.Equal(t, "wh-2", next.published[1].WebhookID)
+}
+
+func TestRetryBufferDropsExpiredEvents(t *testing.T) {
+	next := &flakyPublisher{failures: 1}
+	b, clk := newTestRetryBuffer(next, 10)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	clk.Advance(31 * time.Second)
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-2"}))
+
+	b.Flush()
+
+	assert.Zero(t, b.Len())
+	require.Len(t, next.published, 1)
+	assert.Equal(t, "wh-2", next.published[0].WebhookID, "only the event within maxAge is retried")
+}
+
+func TestRetryBufferShedsWhenFull(t *testing.T) {
+	next := &flakyPublisher{failures: 10}
+	b, _ := newTestRetryBuffer(next, 2)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-2"}))
+	assert.ErrorIs(t, b.Publish(models.WebhookEvent{WebhookID: "wh-3"}), ErrPublishBufferFull)
+	assert.Equal(t, 2, b.Len())
+}
+
+func TestRetryBufferCloseDrains(t *testing.T) {
+	next := &flakyPublisher{failures: 1}
+	b, _ := newTestRetryBuffer(next, 10)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+	require.NoError(t, b.Close())
+
+	assert.Len(t, next.published, 1, "buffered events get a last attempt on close")
+	assert.Zero(t, b.Len())
+	assert.True(t, next.closed)
+	assert.ErrorIs(t, b.Publish(models.WebhookEvent{WebhookID: "wh-2"}), ErrPublisherClosed)
+}
+
+func TestRetryBufferDrainReturnsUndelivered(t *testing.T) {
+	next := &flakyPublisher{failures: 100}
+	b, _ := newTestRetryBuffer(next, 10)
+
+	require.NoError(t, b.Publish(models.WebhookEvent{WebhookID: "wh-1"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	undelivered := b.Drain(ctx)
+
+	require.Len(t, undelivered, 1)
+	assert.Equal(t, "wh-1", undelivered[0].WebhookID)
+	assert.Zero(t, b.Len())
+	assert.False(t, next.closed, "draining leaves the broker connection to Close")
+}
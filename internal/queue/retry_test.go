@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryQueueName(t *testing.T) {
+	r := NewDelayedRetrier(&recordingChannel{}, "webhook_queue")
+
+	assert.Equal(t, "webhook_queue.retry.1s", r.RetryQueueName(100*time.Millisecond), "sub-second delays wait a second")
+	assert.Equal(t, "webhook_queue.retry.8s", r.RetryQueueName(7600*time.Millisecond))
+	assert.Equal(t, "webhook_queue.retry.300s", r.RetryQueueName(5*time.Minute))
+}
+
+func TestRetryParksMessageInTTLQueue(t *testing.T) {
+	ch := &recordingChannel{}
+	r := NewDelayedRetrier(ch, "webhook_queue")
+
+	msg := amqp.Delivery{
+		ContentType: "application/json",
+		MessageId:   "wh-1",
+		Headers:     amqp.Table{"webhook_id": "wh-1"},
+		Body:        []byte(`{"event":"opened"}`),
+	}
+	require.NoError(t, r.Retry(context.Background(), msg, 2, 20*time.Second))
+
+	assert.Equal(t, "webhook_queue.retry.20s", ch.name)
+	assert.Equal(t, amqp.Table{
+		"x-message-ttl":             int64(20000),
+		"x-expires":                 int64(80000),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "webhook_queue",
+	}, ch.args)
+
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "", ch.publishedTo[0], "published through the default exchange")
+	pub := ch.published[0]
+	assert.Equal(t, msg.Body, pub.Body)
+	assert.Equal(t, "wh-1", pub.MessageId)
+	assert.Equal(t, amqp.Persistent, pub.DeliveryMode)
+	assert.Equal(t, amqp.Table{"webhook_id": "wh-1", RetryCountHeader: int32(2)}, pub.Headers)
+	assert.NotContains(t, msg.Headers, RetryCountHeader, "the delivery's headers are not modified")
+}
+
+func TestRetryDeclareFailure(t *testing.T) {
+	ch := &recordingChannel{}
+	ch.err = errors.New("channel closed")
+	r := NewDelayedRetrier(ch, "webhook_queue")
+
+	err := r.Retry(context.Background(), amqp.Delivery{}, 1, time.Second)
+	assert.ErrorContains(t, err, "failed to declare retry queue")
+	assert.Empty(t, ch.published)
+}
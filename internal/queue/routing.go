@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"strings"
+
+	"webhook-processor/internal/models"
+)
+
+// With event routing keys the exchange is a topic exchange and every event
+// is published with the routing key "<event>.<client_id>", both lowercased
+// with spaces and dots replaced by underscores, e.g.
+// "campaign_sent.client-a". Consumers bind with the usual topic patterns:
+//
+//	#                    every event (the work queue)
+//	*.client-a           all of client-a's events
+//	bounced.*            bounces from every client
+//	bounced.client-a     only client-a's bounces
+//
+// Routing keys use the raw event name as sent, not its canonical
+// models.EventType, so "hard_bounce" and "bounced" route apart and a
+// consumer of every bounce binds each name it expects. Names outside the
+// canonical set still get their own key rather than all sharing
+// "unknown".
+//
+// Without them the exchange is direct and events are published with an
+// empty routing key, so every queue bound with "" gets every event.
+const (
+	exchangeKindDirect = "direct"
+	exchangeKindTopic  = "topic"
+
+	// AllEventsBinding matches every routing key on a topic exchange.
+	AllEventsBinding = "#"
+)
+
+// RoutingKey returns the topic routing key for event, from its raw Event.
+func RoutingKey(event models.WebhookEvent) string {
+	return routingWord(event.Event) + "." + routingWord(event.ClientID)
+}
+
+// EventBinding returns the binding key matching eventType events from
+// clientID. An empty eventType or clientID matches any.
+func EventBinding(eventType, clientID string) string {
+	event, client := "*", "*"
+	if eventType != "" {
+		event = routingWord(eventType)
+	}
+	if clientID != "" {
+		client = routingWord(clientID)
+	}
+	return event + "." + client
+}
+
+// routingWord turns s into a single topic word: lowercase, with the word
+// separator and spaces replaced so "Campaign Sent" and "campaign_sent"
+// route alike.
+func routingWord(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(".", "_", " ", "_").Replace(strings.ToLower(s))
+}
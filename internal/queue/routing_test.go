@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRoutingKey(t *testing.T) {
+	tests := []struct {
+		event models.WebhookEvent
+		want  string
+	}{
+		{event: models.WebhookEvent{Event: "bounced", ClientID: "client-a"}, want: "bounced.client-a"},
+		{event: models.WebhookEvent{Event: "Campaign Sent", ClientID: "Client-A"}, want: "campaign_sent.client-a"},
+		{event: models.WebhookEvent{Event: "link.clicked", ClientID: "acme.io"}, want: "link_clicked.acme_io"},
+		{event: models.WebhookEvent{Event: "Hard Bounce", ClientID: "client-a"}, want: "hard_bounce.client-a"},
+		{event: models.WebhookEvent{}, want: "unknown.unknown"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, RoutingKey(tt.event), "routing keys use the raw event name, not the canonical type")
+	}
+}
+
+func TestEventBinding(t *testing.T) {
+	assert.Equal(t, "bounced.client-a", EventBinding("bounced", "client-a"))
+	assert.Equal(t, "bounced.*", EventBinding("Bounced", ""))
+	assert.Equal(t, "*.client-a", EventBinding("", "client-a"))
+	assert.Equal(t, "campaign_sent.*", EventBinding("Campaign Sent", ""), "bindings normalize like routing keys")
+}
+
+func TestPublishRoutingKey(t *testing.T) {
+	event := models.WebhookEvent{Event: "clicked", ClientID: "client-a", WebhookID: "wh-1"}
+
+	t.Run("event routing keys", func(t *testing.T) {
+		r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+		WithEventRoutingKeys(true)(r)
+		destinations, err := NewDestinations([]config.DestinationConfig{
+			{Name: "crm", Exchange: "crm_events", Template: `{"type": {{json .Event}}}`},
+		})
+		require.NoError(t, err)
+		r.destinations = destinations
+		ch := &recordingChannel{}
+
+		require.NoError(t, r.publishEvent(context.Background(), ch, event))
+
+		assert.Equal(t, []string{"webhook_events", "crm_events"}, ch.publishedTo)
+		assert.Equal(t, []string{"clicked.client-a", ""}, ch.publishedKeys, "destinations keep the empty key")
+		assert.Equal(t, "topic", r.exchangeKind())
+		assert.Equal(t, "#", r.bindingKey())
+		assert.Equal(t, []string{"bounced.client-a", "clicked.client-a"}, r.clientBindings("client-a", []string{"bounced", "clicked"}))
+		assert.Equal(t, []string{"*.client-a"}, r.clientBindings("client-a", nil))
+	})
+
+	t.Run("default", func(t *testing.T) {
+		r := newRabbitMQ("", "webhook_events", "webhook_queue", nil, zap.NewNop())
+		ch := &recordingChannel{}
+
+		require.NoError(t, r.publishEvent(context.Background(), ch, event))
+
+		assert.Equal(t, []string{""}, ch.publishedKeys)
+		assert.Equal(t, "direct", r.exchangeKind())
+		assert.Equal(t, "", r.bindingKey())
+	})
+}
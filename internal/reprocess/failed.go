@@ -0,0 +1,91 @@
+package reprocess
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+
+	"go.uber.org/zap"
+)
+
+// FailedStore is the storage used to replay failed events.
+type FailedStore interface {
+	GetFailedEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time) ([]*models.WebhookEvent, error)
+	UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error
+}
+
+// FailedReplayer re-publishes events that ran out of retries, as fresh
+// deliveries with their retry count reset, and marks them pending.
+type FailedReplayer struct {
+	store     FailedStore
+	publisher queue.Publisher
+	logEvery  int
+	logger    *zap.Logger
+}
+
+// NewFailedReplayer creates a replayer that logs its progress every
+// logEvery events.
+func NewFailedReplayer(store FailedStore, publisher queue.Publisher, logEvery int, logger *zap.Logger) *FailedReplayer {
+	if logEvery <= 0 {
+		logEvery = 100
+	}
+	return &FailedReplayer{
+		store:     store,
+		publisher: publisher,
+		logEvery:  logEvery,
+		logger:    logger,
+	}
+}
+
+// FailedReplay is the outcome of a FailedReplayer run.
+type FailedReplay struct {
+	Found       int
+	Republished int
+}
+
+// Run re-publishes the failed events received in window, where zero bounds
+// are open and an empty client matches every client. With dryRun it only
+// counts them. Events republished before an error stay republished, so a
+// rerun picks up the rest.
+func (r *FailedReplayer) Run(ctx context.Context, window Window, dryRun bool) (FailedReplay, error) {
+	var result FailedReplay
+	events, err := r.store.GetFailedEventsReceivedBetween(ctx, window.ClientID, window.From, window.To)
+	if err != nil {
+		return result, fmt.Errorf("failed to load failed events: %v", err)
+	}
+	result.Found = len(events)
+	if dryRun {
+		return result, nil
+	}
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		event.RetryCount = 0
+		event.Status = string(models.EventStatusPending)
+		if err := r.publisher.Publish(*event); err != nil {
+			return result, fmt.Errorf("failed to re-publish event %s: %v", event.WebhookID, err)
+		}
+		// Published already, so a failed update only risks a duplicate on
+		// a rerun
+		if err := r.store.UpdateEventStatus(ctx, event, models.EventStatusPending); err != nil {
+			r.logger.Warn("Failed to reset status of replayed event",
+				zap.Error(err),
+				zap.String("client_id", event.ClientID),
+				zap.String("webhook_id", event.WebhookID))
+		}
+		result.Republished++
+
+		if result.Republished%r.logEvery == 0 {
+			r.logger.Info("Replaying failed events",
+				zap.Int("republished", result.Republished),
+				zap.Int("found", result.Found))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package reprocess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryFailedStore serves the failed events in the fixture and records
+// status updates on them.
+type memoryFailedStore struct {
+	events []models.WebhookEvent
+}
+
+func (s *memoryFailedStore) GetFailedEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time) ([]*models.WebhookEvent, error) {
+	var failed []*models.WebhookEvent
+	for i := range s.events {
+		e := s.events[i]
+		if e.Status != string(models.EventStatusFailed) || (clientID != "" && e.ClientID != clientID) {
+			continue
+		}
+		if (!from.IsZero() && e.ReceivedAt.Before(from)) || (!to.IsZero() && !e.ReceivedAt.Before(to)) {
+			continue
+		}
+		failed = append(failed, &e)
+	}
+	return failed, nil
+}
+
+func (s *memoryFailedStore) UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error {
+	for i := range s.events {
+		if s.events[i].WebhookID == event.WebhookID && s.events[i].ClientID == event.ClientID {
+			s.events[i].Status = string(status)
+			s.events[i].RetryCount = event.RetryCount
+		}
+	}
+	return nil
+}
+
+// failedFixture marks every third event of fixture failed: wh-0, wh-3, ...
+func failedFixture() *memoryFailedStore {
+	s := &memoryFailedStore{events: fixture().events}
+	for i := 0; i < len(s.events); i += 3 {
+		s.events[i].Status = string(models.EventStatusFailed)
+		s.events[i].RetryCount = 3
+	}
+	return s
+}
+
+// routedFailedStore is a memoryFailedStore that a storage.ClientRouter can
+// hold.
+type routedFailedStore struct {
+	*storagetest.FakeStore
+	*memoryFailedStore
+}
+
+func (s routedFailedStore) UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error {
+	return s.memoryFailedStore.UpdateEventStatus(ctx, event, status)
+}
+
+func TestFailedReplayRoutedClient(t *testing.T) {
+	shared, dedicated := &memoryFailedStore{}, &memoryFailedStore{}
+	for _, e := range failedFixture().events {
+		if e.ClientID == "client-b" {
+			dedicated.events = append(dedicated.events, e)
+		} else {
+			shared.events = append(shared.events, e)
+		}
+	}
+	router := storage.NewClientRouter(
+		routedFailedStore{storagetest.NewFakeStore(), shared},
+		map[string]storage.EventStore{"client-b": routedFailedStore{storagetest.NewFakeStore(), dedicated}},
+	)
+	publisher := &recordingPublisher{}
+	r := NewFailedReplayer(router, publisher, 0, zap.NewNop())
+
+	result, err := r.Run(context.Background(), Window{ClientID: "client-b"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, FailedReplay{Found: 3, Republished: 3}, result)
+	assert.Equal(t, []string{"wh-3", "wh-9", "wh-15"}, webhookIDs(publisher.published), "a client with its own store is read from it")
+	assert.Equal(t, string(models.EventStatusPending), dedicated.events[1].Status, "and marked pending there")
+
+	publisher.published = nil
+	result, err = r.Run(context.Background(), Window{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wh-0", "wh-6", "wh-12"}, webhookIDs(publisher.published), "every store is read")
+}
+
+func TestFailedReplayRepublishesWindow(t *testing.T) {
+	store := failedFixture()
+	publisher := &recordingPublisher{}
+	r := NewFailedReplayer(store, publisher, 2, zap.NewNop())
+
+	result, err := r.Run(context.Background(), Window{From: base, To: base.Add(time.Hour)}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, FailedReplay{Found: 2, Republished: 2}, result)
+	assert.Equal(t, []string{"wh-6", "wh-9"}, webhookIDs(publisher.published))
+	for _, e := range publisher.published {
+		assert.Equal(t, string(models.EventStatusPending), e.Status)
+		assert.Zero(t, e.RetryCount)
+	}
+	assert.Equal(t, string(models.EventStatusPending), store.events[6].Status, "replayed events are marked pending")
+	assert.Equal(t, string(models.EventStatusFailed), store.events[12].Status, "events outside the window are left failed")
+
+	result, err = r.Run(context.Background(), Window{From: base, To: base.Add(time.Hour)}, false)
+	require.NoError(t, err)
+	assert.Zero(t, result.Found, "a rerun doesn't replay them again")
+}
+
+func TestFailedReplayOpenWindow(t *testing.T) {
+	publisher := &recordingPublisher{}
+	r := NewFailedReplayer(failedFixture(), publisher, 0, zap.NewNop())
+
+	result, err := r.Run(context.Background(), Window{ClientID: "client-b"}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, result.Republished)
+	assert.Equal(t, []string{"wh-3", "wh-9", "wh-15"}, webhookIDs(publisher.published))
+}
+
+func TestFailedReplayDryRun(t *testing.T) {
+	store := failedFixture()
+	publisher := &recordingPublisher{}
+	r := NewFailedReplayer(store, publisher, 0, zap.NewNop())
+
+	result, err := r.Run(context.Background(), Window{}, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, FailedReplay{Found: 6}, result)
+	assert.Empty(t, publisher.published)
+	assert.Equal(t, string(models.EventStatusFailed), store.events[0].Status)
+}
+
+func TestFailedReplayStopsOnPublishFailure(t *testing.T) {
+	store := failedFixture()
+	publisher := &recordingPublisher{failOn: "wh-6"}
+	r := NewFailedReplayer(store, publisher, 0, zap.NewNop())
+
+	result, err := r.Run(context.Background(), Window{}, false)
+	require.Error(t, err)
+	assert.Equal(t, FailedReplay{Found: 6, Republished: 2}, result)
+	assert.Equal(t, string(models.EventStatusFailed), store.events[6].Status)
+
+	publisher.failOn = ""
+	result, err = r.Run(context.Background(), Window{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Republished, "only the events left failed are replayed")
+}
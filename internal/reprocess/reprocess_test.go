@@ -0,0 +1,208 @@
+package reprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryEventStore serves events in slice order, which the fixtures keep
+// sorted by received_at; the index is the event id. Like Mongo, it
+// compares an offset by received_at first, so a client router can pass it
+// an offset from another store.
+type memoryEventStore struct {
+	events   []models.WebhookEvent
+	progress map[string]storage.ReplayProgress
+}
+
+func (s *memoryEventStore) ScanEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time, after storage.ReplayOffset, limit int) ([]storage.ReplayEvent, error) {
+	var batch []storage.ReplayEvent
+	for i := range s.events {
+		e := s.events[i]
+		id := fmt.Sprintf("%03d", i)
+		if e.ReceivedAt.Before(from) || !e.ReceivedAt.Before(to) || (clientID != "" && e.ClientID != clientID) {
+			continue
+		}
+		if after.EventID != "" && !(e.ReceivedAt.After(after.ReceivedAt) || (e.ReceivedAt.Equal(after.ReceivedAt) && id > after.EventID)) {
+			continue
+		}
+		if len(batch) == limit {
+			break
+		}
+		batch = append(batch, storage.ReplayEvent{Event: &e, Offset: storage.ReplayOffset{ReceivedAt: e.ReceivedAt, EventID: id}})
+	}
+	return batch, nil
+}
+
+func (s *memoryEventStore) LoadReplayProgress(ctx context.Context, key string) (storage.ReplayProgress, bool, error) {
+	p, ok := s.progress[key]
+	return p, ok, nil
+}
+
+func (s *memoryEventStore) SaveReplayProgress(ctx context.Context, key string, progress storage.ReplayProgress) error {
+	s.progress[key] = progress
+	return nil
+}
+
+type recordingPublisher struct {
+	published []models.WebhookEvent
+	failOn    string
+}
+
+func (p *recordingPublisher) Publish(event models.WebhookEvent) error {
+	if event.WebhookID == p.failOn {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+var base = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// fixture stores an event every 10 minutes from 11:00 to 13:50,
+// alternating between two clients.
+func fixture() *memoryEventStore {
+	s := &memoryEventStore{progress: make(map[string]storage.ReplayProgress)}
+	for i := 0; i < 18; i++ {
+		client := "client-a"
+		if i%2 == 1 {
+			client = "client-b"
+		}
+		s.events = append(s.events, models.WebhookEvent{
+			WebhookID:  fmt.Sprintf("wh-%d", i),
+			ClientID:   client,
+			Event:      "opened",
+			Status:     string(models.EventStatusProcessed),
+			RetryCount: 1,
+			ReceivedAt: base.Add(-time.Hour + time.Duration(i)*10*time.Minute),
+		})
+	}
+	return s
+}
+
+// routedStore is a memoryEventStore that a storage.ClientRouter can hold.
+type routedStore struct {
+	*storagetest.FakeStore
+	*memoryEventStore
+}
+
+// splitClient moves clientID's events out of s into a store of their own,
+// and returns a router over the two.
+func splitClient(s *memoryEventStore, clientID string) (*storage.ClientRouter, *memoryEventStore) {
+	shared := &memoryEventStore{progress: s.progress}
+	dedicated := &memoryEventStore{progress: make(map[string]storage.ReplayProgress)}
+	for _, e := range s.events {
+		if e.ClientID == clientID {
+			dedicated.events = append(dedicated.events, e)
+		} else {
+			shared.events = append(shared.events, e)
+		}
+	}
+	router := storage.NewClientRouter(
+		routedStore{storagetest.NewFakeStore(), shared},
+		map[string]storage.EventStore{clientID: routedStore{storagetest.NewFakeStore(), dedicated}},
+	)
+	return router, dedicated
+}
+
+func webhookIDs(events []models.WebhookEvent) []string {
+	var ids []string
+	for _, e := range events {
+		ids = append(ids, e.WebhookID)
+	}
+	return ids
+}
+
+func TestReprocessRepublishesWindow(t *testing.T) {
+	store := fixture()
+	publisher := &recordingPublisher{}
+	r := NewReprocessor(store, publisher, 4, zap.NewNop())
+
+	// The last hour: 12:00 up to, not including, 13:00
+	progress, err := r.Run(context.Background(), Window{From: base, To: base.Add(time.Hour)}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"wh-6", "wh-7", "wh-8", "wh-9", "wh-10", "wh-11"}, webhookIDs(publisher.published))
+	assert.Equal(t, 6, progress.Republished)
+	for _, e := range publisher.published {
+		assert.Equal(t, string(models.EventStatusPending), e.Status)
+		assert.Zero(t, e.RetryCount)
+	}
+}
+
+func TestReprocessFiltersByClient(t *testing.T) {
+	store := fixture()
+	publisher := &recordingPublisher{}
+	r := NewReprocessor(store, publisher, 500, zap.NewNop())
+
+	_, err := r.Run(context.Background(), Window{From: base, To: base.Add(time.Hour), ClientID: "client-b"}, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"wh-7", "wh-9", "wh-11"}, webhookIDs(publisher.published))
+}
+
+func TestReprocessRoutedClient(t *testing.T) {
+	store := fixture()
+	router, dedicated := splitClient(store, "client-b")
+	publisher := &recordingPublisher{}
+	r := NewReprocessor(router, publisher, 2, zap.NewNop())
+
+	window := Window{From: base, To: base.Add(time.Hour), ClientID: "client-b"}
+	progress, err := r.Run(context.Background(), window, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wh-7", "wh-9", "wh-11"}, webhookIDs(publisher.published), "a client with its own store is read from it")
+	assert.Equal(t, 3, progress.Republished)
+	assert.Contains(t, store.progress, window.Key(), "the offset is kept in the shared store")
+	assert.Empty(t, dedicated.progress)
+
+	publisher.published = nil
+	_, err = r.Run(context.Background(), Window{From: base, To: base.Add(time.Hour)}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wh-6", "wh-7", "wh-8", "wh-9", "wh-10", "wh-11"}, webhookIDs(publisher.published),
+		"every store is read, in received_at order")
+}
+
+func TestReprocessResumesFromSavedOffset(t *testing.T) {
+	store := fixture()
+	publisher := &recordingPublisher{failOn: "wh-9"}
+	r := NewReprocessor(store, publisher, 2, zap.NewNop())
+	window := Window{From: base, To: base.Add(time.Hour)}
+
+	progress, err := r.Run(context.Background(), window, false)
+	require.Error(t, err)
+	assert.Equal(t, 3, progress.Republished)
+	assert.Equal(t, progress.Offset, store.progress[window.Key()].Offset, "the offset is saved before failing")
+
+	publisher.failOn = ""
+	publisher.published = nil
+	progress, err = r.Run(context.Background(), window, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wh-9", "wh-10", "wh-11"}, webhookIDs(publisher.published), "only the remaining events are re-published")
+	assert.Equal(t, 6, progress.Republished)
+
+	publisher.published = nil
+	_, err = r.Run(context.Background(), window, true)
+	require.NoError(t, err)
+	assert.Len(t, publisher.published, 6, "restart ignores the saved offset")
+}
+
+func TestReprocessRejectsEmptyWindow(t *testing.T) {
+	r := NewReprocessor(fixture(), &recordingPublisher{}, 0, zap.NewNop())
+
+	_, err := r.Run(context.Background(), Window{From: base, To: base}, false)
+	assert.ErrorIs(t, err, storage.ErrInvalidTimeRange)
+}
@@ -3,14 +3,23 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"webhook-processor/api/handlers"
 	"webhook-processor/api/router"
 	"webhook-processor/config"
+	"webhook-processor/internal/models"
 	"webhook-processor/internal/queue"
+	"webhook-processor/internal/stats"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/warmup"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/logger"
+	"webhook-processor/pkg/metrics"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
@@ -18,56 +27,282 @@ import (
 type Server struct {
 	httpServer    *http.Server
 	metricsServer *http.Server
-	logger        *logger.Logger
-	publisher     queue.Publisher
+	// metricsRequired makes a failure to bind the metrics port fatal;
+	// otherwise metrics stay available on the main port at /metrics.
+	metricsRequired bool
+	shutdownTimeout time.Duration
+	logger          *logger.Logger
+	publisher       queue.Publisher
+	db              *storage.MongoDB
+	store           storage.EventStore
+	clientDBs       []*storage.MongoDB
+	reconciler      *stats.Reconciler
+	retryBuffer     *queue.RetryBuffer
+	otlpExporter    *metrics.OTLPExporter
+	warmer          *warmup.Warmer
+	// background jobs run until Shutdown cancels backgroundCtx
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
 }
 
 func NewServer(cfg *config.Config, logger *logger.Logger) *Server {
-	publisher, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, cfg.RabbitMQ.Exchange, cfg.RabbitMQ.QueueName, logger.Desugar())
+	queueArgs, err := queue.QueueArgs(cfg.RabbitMQ)
+	if err != nil {
+		logger.Fatalf("invalid queue configuration: %v", err)
+	}
+	publisher, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, cfg.RabbitMQ.Exchange, cfg.RabbitMQ.QueueName, queueArgs, logger.Desugar(),
+		queue.WithReconnect(cfg.RabbitMQ.ReconnectDelay, cfg.RabbitMQ.ReconnectMaxDelay, cfg.RabbitMQ.ReconnectTimeout),
+		queue.WithEventRoutingKeys(cfg.RabbitMQ.EventRoutingKeys))
 	if err != nil {
 		logger.Fatalf("failed to create rabbitmq publisher: %v", err)
 	}
+	destinations, err := queue.NewDestinations(cfg.RabbitMQ.Destinations)
+	if err != nil {
+		logger.Fatalf("invalid destination configuration: %v", err)
+	}
+	if err := publisher.AddDestinations(destinations); err != nil {
+		logger.Fatalf("failed to set up destinations: %v", err)
+	}
+
+	// MongoDB backs the admin storage endpoints; the API keeps running
+	// without them if it isn't reachable.
+	var db *storage.MongoDB
+	var store storage.EventStore
+	if cfg.MongoDB.URI != "" {
+		db, err = storage.NewMongoDB(cfg.MongoDB.URI, cfg.MongoDB.Database, cfg.MongoDB.Collection, logger.Desugar(),
+			storage.WithSkipNoopStatusUpdates(cfg.MongoDB.SkipNoopStatusUpdates),
+			storage.WithIndexes(cfg.MongoDB.Indexes),
+			storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections),
+			storage.WithCompressedRawPayload(cfg.MongoDB.CompressRawPayload),
+			storage.WithRetention(cfg.MongoDB.Retention()),
+			storage.WithEventRetention(cfg.MongoDB.EventRetention()))
+		if err != nil {
+			logger.Errorf("failed to connect to MongoDB, admin storage endpoints disabled: %v", err)
+			db = nil
+		} else {
+			store = db
+		}
+	}
+
+	// Admin lookups for clients with a dedicated store are routed there
+	var clientDBs []*storage.MongoDB
+	if db != nil && len(cfg.MongoDB.ClientStores) > 0 {
+		byClient, conns, err := storage.OpenClientStores(cfg.MongoDB, logger.Desugar(),
+			storage.WithSkipNoopStatusUpdates(cfg.MongoDB.SkipNoopStatusUpdates),
+			storage.WithMonthlyCollections(cfg.MongoDB.MonthlyCollections),
+			storage.WithCompressedRawPayload(cfg.MongoDB.CompressRawPayload),
+			storage.WithRetention(cfg.MongoDB.Retention()),
+			storage.WithEventRetention(cfg.MongoDB.EventRetention()))
+		if err != nil {
+			logger.Errorf("failed to connect to client stores, their events are looked up in the shared store: %v", err)
+		} else {
+			clientDBs = conns
+			store = storage.NewClientRouter(db, byClient)
+		}
+	}
+
+	// Published-vs-stored reconciliation needs storage to count against
+	var reconciler *stats.Reconciler
+	var handlerOpts []handlers.Option
+	if db != nil && cfg.Monitoring.Reconcile.Window > 0 {
+		reconciler = stats.NewReconciler(db, cfg.Monitoring.Reconcile.Window, cfg.Monitoring.Reconcile.Lag, clock.New(), logger.Desugar())
+		handlerOpts = append(handlerOpts, handlers.WithReconciler(reconciler))
+	}
+
+	// In async mode webhooks are answered before they reach the broker; the
+	// server owns the async publisher so Shutdown drains its buffer.
+	var serverPublisher queue.Publisher = publisher
+	if cfg.Webhook.AsyncPublish {
+		async := queue.NewAsyncPublisher(publisher, cfg.Webhook.AsyncBufferSize, cfg.Webhook.AsyncMaxRetries,
+			cfg.Webhook.AsyncRetryDelay, clock.New(), logger.Desugar())
+		handlerOpts = append(handlerOpts, handlers.WithAsyncPublisher(async))
+		serverPublisher = async
+	}
+
+	// Otherwise a bounded retry buffer rides out brief broker outages; it is
+	// flushed in the background and drained on Shutdown like the async buffer.
+	var retryBuffer *queue.RetryBuffer
+	if !cfg.Webhook.AsyncPublish && cfg.Webhook.RetryBufferSize > 0 {
+		retryBuffer = queue.NewRetryBuffer(publisher, cfg.Webhook.RetryBufferSize, cfg.Webhook.RetryBufferMaxAge,
+			cfg.Webhook.RetryBufferInterval, clock.New(), logger.Desugar())
+		handlerOpts = append(handlerOpts, handlers.WithRetryBuffer(retryBuffer))
+		serverPublisher = retryBuffer
+	}
 
-	r := router.Setup(logger, publisher, cfg)
+	// The mapping and rate limit usage are loaded once the server is up;
+	// webhooks are turned away until then
+	warmer := warmup.NewWarmer(logger.Desugar())
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	r := router.Setup(backgroundCtx, logger, publisher, store, cfg, warmer, handlerOpts...)
 
 	// Create metrics server
-	metricsAddr := fmt.Sprintf(":%d", cfg.Monitoring.PrometheusPort)
-	metricsServer := &http.Server{
-		Addr:    metricsAddr,
-		Handler: promhttp.Handler(),
+	var metricsServer *http.Server
+	if !cfg.Monitoring.DisablePrometheus {
+		metricsAddr := fmt.Sprintf(":%d", cfg.Monitoring.PrometheusPort)
+		metricsServer = newHTTPServer(metricsAddr, promhttp.Handler(), cfg.Server)
 	}
 
 	return &Server{
-		httpServer: &http.Server{
-			Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
-			Handler: r,
-		},
-		metricsServer: metricsServer,
-		logger:        logger,
-		publisher:     publisher,
+		httpServer:      newHTTPServer(fmt.Sprintf(":%d", cfg.Server.Port), r, cfg.Server),
+		metricsServer:   metricsServer,
+		metricsRequired: cfg.Monitoring.RequireMetricsPort,
+		shutdownTimeout: cfg.Server.ShutdownTimeout,
+		logger:          logger,
+		publisher:       serverPublisher,
+		db:              db,
+		store:           store,
+		clientDBs:       clientDBs,
+		reconciler:      reconciler,
+		retryBuffer:     retryBuffer,
+		otlpExporter:    newOTLPExporter(cfg.Monitoring, "webhook-api", logger),
+		warmer:          warmer,
+		backgroundCtx:   backgroundCtx,
+		stopBackground:  stopBackground,
 	}
 }
 
-func (s *Server) Start() error {
-	// Start metrics server in a goroutine
-	go func() {
-		s.logger.Info("Metrics server starting on port " + s.metricsServer.Addr)
-		if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			s.logger.Errorf("metrics server error: %v", err)
+// persistUndelivered stores events that couldn't be published before
+// shutdown in retrying status, so the worker's stale-retrying reconciliation
+// republishes them. Without MongoDB they are lost.
+func (s *Server) persistUndelivered(events []models.WebhookEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if s.store == nil {
+		s.logger.Errorf("dropping %d unpublished events on shutdown: MongoDB is not configured", len(events))
+		return
+	}
+
+	// The grace period is spent by now; allow the writes a moment of their own
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var persisted int
+	for i := range events {
+		event := &events[i]
+		if err := s.store.InsertEvent(ctx, event); err != nil {
+			s.logger.Errorf("failed to persist unpublished event %s: %v", event.WebhookID, err)
+			continue
 		}
-	}()
+		if err := s.store.UpdateEventStatus(ctx, event, models.EventStatusRetrying); err != nil {
+			s.logger.Errorf("failed to mark unpublished event %s for retry: %v", event.WebhookID, err)
+			continue
+		}
+		persisted++
+	}
+	s.logger.Warnf("persisted %d of %d unpublished events for republishing", persisted, len(events))
+}
+
+// newOTLPExporter returns an exporter pushing the registered metrics over
+// OTLP, or nil if no endpoint is configured.
+func newOTLPExporter(cfg config.MonitoringConfig, serviceName string, logger *logger.Logger) *metrics.OTLPExporter {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	return metrics.NewOTLPExporter(cfg.OTLPEndpoint, serviceName, cfg.OTLPInterval, prometheus.DefaultGatherer, logger.Desugar())
+}
+
+// newHTTPServer creates an http.Server with the configured timeouts so slow
+// clients can't hold connections open indefinitely.
+func newHTTPServer(addr string, handler http.Handler, cfg config.ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// Start serves until Shutdown is called, after which it returns nil so the
+// caller doesn't exit while Shutdown is still draining.
+func (s *Server) Start() error {
+	if err := s.startMetricsServer(); err != nil {
+		return err
+	}
+
+	if s.reconciler != nil {
+		go s.reconciler.Run(s.backgroundCtx)
+	}
+	if s.retryBuffer != nil {
+		go s.retryBuffer.Run(s.backgroundCtx)
+	}
+	if s.otlpExporter != nil {
+		s.logger.Info("Exporting metrics via OTLP to " + s.otlpExporter.URL())
+		s.otlpExporter.Start(s.backgroundCtx)
+	}
+
+	// Warm up while listening, so readiness probes are answered meanwhile
+	if s.warmer != nil {
+		go s.warmer.Run(s.backgroundCtx)
+	}
 
 	// Start main HTTP server
 	s.logger.Info("Server starting on port " + s.httpServer.Addr)
-	return s.httpServer.ListenAndServe()
+	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// startMetricsServer binds the metrics port before serving so that a port
+// conflict is detected at startup rather than in a background goroutine.
+func (s *Server) startMetricsServer() error {
+	if s.metricsServer == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.metricsServer.Addr)
+	if err != nil {
+		if s.metricsRequired {
+			return fmt.Errorf("failed to start metrics server on %s: %w", s.metricsServer.Addr, err)
+		}
+		s.logger.Warnf("metrics port %s unavailable (%v); metrics are only served on the main port at /metrics",
+			s.metricsServer.Addr, err)
+		s.metricsServer = nil
+		return nil
+	}
+
+	s.logger.Info("Metrics server starting on port " + s.metricsServer.Addr)
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorf("metrics server error: %v", err)
+		}
+	}(s.metricsServer)
+
+	return nil
 }
 
 func (s *Server) Shutdown() error {
 	s.logger.Info("Server shutting down")
-	if err := s.publisher.Close(); err != nil {
-		s.logger.Error("failed to close publisher", zap.Error(err))
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	s.stopBackground()
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	// Close the publisher only once in-flight requests are done, so they
+	// can still publish. Buffered events get the rest of the grace period to
+	// reach the broker; whatever doesn't is persisted.
+	if drainer, ok := s.publisher.(queue.Drainer); ok {
+		s.persistUndelivered(drainer.Drain(ctx))
+	}
+	if closeErr := s.publisher.Close(); closeErr != nil {
+		s.logger.Error("failed to close publisher", zap.Error(closeErr))
+	}
+	if s.metricsServer != nil {
+		if metricsErr := s.metricsServer.Shutdown(ctx); metricsErr != nil {
+			s.logger.Errorf("failed to shut down metrics server: %v", metricsErr)
+		}
+	}
+	if s.db != nil {
+		if closeErr := s.db.Close(ctx); closeErr != nil {
+			s.logger.Errorf("failed to close MongoDB connection: %v", closeErr)
+		}
+	}
+	for _, clientDB := range s.clientDBs {
+		if closeErr := clientDB.Close(ctx); closeErr != nil {
+			s.logger.Errorf("failed to close client store connection: %v", closeErr)
+		}
+	}
+	return err
 }
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewHTTPServerAppliesTimeouts(t *testing.T) {
+	cfg := config.ServerConfig{
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+
+	srv := newHTTPServer(":8080", http.NotFoundHandler(), cfg)
+
+	assert.Equal(t, ":8080", srv.Addr)
+	assert.Equal(t, 5*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 2*time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(t, 10*time.Second, srv.WriteTimeout)
+	assert.Equal(t, time.Minute, srv.IdleTimeout)
+}
+
+func newMetricsTestServer(addr string, required bool) *Server {
+	return &Server{
+		metricsServer:   newHTTPServer(addr, http.NotFoundHandler(), config.ServerConfig{}),
+		metricsRequired: required,
+		logger:          &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+	}
+}
+
+func TestMetricsServerFallsBackWhenPortInUse(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer occupied.Close()
+
+	s := newMetricsTestServer(occupied.Addr().String(), false)
+
+	require.NoError(t, s.startMetricsServer())
+	assert.Nil(t, s.metricsServer, "metrics are served only on the main port")
+}
+
+func TestMetricsServerFailsWhenPortRequired(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer occupied.Close()
+
+	s := newMetricsTestServer(occupied.Addr().String(), true)
+
+	assert.Error(t, s.startMetricsServer())
+}
+
+func TestMetricsServerStartsOnFreePort(t *testing.T) {
+	s := newMetricsTestServer("127.0.0.1:0", true)
+
+	require.NoError(t, s.startMetricsServer())
+	require.NotNil(t, s.metricsServer)
+	assert.NoError(t, s.metricsServer.Close())
+}
+
+func TestOTLPExporterWiredWhenConfigured(t *testing.T) {
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	assert.Nil(t, newOTLPExporter(config.MonitoringConfig{}, "webhook-api", log), "disabled without an endpoint")
+
+	exporter := newOTLPExporter(config.MonitoringConfig{
+		OTLPEndpoint: "http://otel-collector:4318",
+		OTLPInterval: 30 * time.Second,
+	}, "webhook-api", log)
+	require.NotNil(t, exporter)
+	assert.Equal(t, "http://otel-collector:4318/v1/metrics", exporter.URL())
+}
+
+// brokerStub fails publishes while down.
+type brokerStub struct {
+	mu        sync.Mutex
+	down      bool
+	published []models.WebhookEvent
+	closed    bool
+}
+
+func (b *brokerStub) Publish(event models.WebhookEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		return errors.New("broker unavailable")
+	}
+	b.published = append(b.published, event)
+	return nil
+}
+
+func (b *brokerStub) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *brokerStub) setDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down = down
+}
+
+func newShutdownTestServer(publisher queue.Publisher, store storage.EventStore) *Server {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Server{
+		httpServer:      newHTTPServer("127.0.0.1:0", http.NotFoundHandler(), config.ServerConfig{}),
+		logger:          &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		publisher:       publisher,
+		store:           store,
+		shutdownTimeout: 100 * time.Millisecond,
+		backgroundCtx:   ctx,
+		stopBackground:  stop,
+	}
+}
+
+func TestShutdownFlushesBufferedEvents(t *testing.T) {
+	broker := &brokerStub{down: true}
+	buffer := queue.NewRetryBuffer(broker, 10, time.Minute, 10*time.Millisecond, clock.New(), zap.NewNop())
+	store := storagetest.NewFakeStore()
+	s := newShutdownTestServer(buffer, store)
+
+	require.NoError(t, buffer.Publish(models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a"}))
+	require.Equal(t, 1, buffer.Len())
+	broker.setDown(false)
+
+	require.NoError(t, s.Shutdown())
+
+	require.Len(t, broker.published, 1, "buffered event reaches the broker before exit")
+	assert.Equal(t, "wh-1", broker.published[0].WebhookID)
+	assert.True(t, broker.closed)
+	assert.Empty(t, store.Inserts())
+}
+
+func TestShutdownPersistsUndeliveredEvents(t *testing.T) {
+	broker := &brokerStub{down: true}
+	buffer := queue.NewRetryBuffer(broker, 10, time.Minute, 10*time.Millisecond, clock.New(), zap.NewNop())
+	store := storagetest.NewFakeStore()
+	s := newShutdownTestServer(buffer, store)
+
+	require.NoError(t, buffer.Publish(models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a"}))
+
+	require.NoError(t, s.Shutdown())
+
+	assert.Empty(t, broker.published)
+	require.Len(t, store.Inserts(), 1)
+	status, ok := store.LastStatus("wh-1")
+	require.True(t, ok)
+	assert.Equal(t, models.EventStatusRetrying, status, "left for stale-retrying reconciliation to republish")
+}
+
+func TestStartReturnsOnceShutDown(t *testing.T) {
+	broker := &brokerStub{down: true}
+	buffer := queue.NewRetryBuffer(broker, 10, time.Minute, 10*time.Millisecond, clock.New(), zap.NewNop())
+	store := storagetest.NewFakeStore()
+	s := newShutdownTestServer(buffer, store)
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	require.NoError(t, buffer.Publish(models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a"}))
+
+	require.NoError(t, s.Shutdown())
+
+	select {
+	case err := <-started:
+		assert.NoError(t, err, "a shutdown isn't a start failure")
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+	assert.Len(t, store.Inserts(), 1, "Shutdown persisted the undelivered event")
+}
@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// ReceivedCounter counts stored events by their receive time.
+type ReceivedCounter interface {
+	CountReceivedBetween(ctx context.Context, from, to time.Time) (int64, error)
+}
+
+// WindowResult compares published and stored counts for one window.
+type WindowResult struct {
+	Start     time.Time
+	End       time.Time
+	Published int64
+	Stored    int64
+}
+
+// Missing returns how many published events have not been stored.
+func (r WindowResult) Missing() int64 {
+	return r.Published - r.Stored
+}
+
+// Reconciler detects silent event loss by comparing the number of events
+// published in each window against the number stored with a receive time in
+// that window. A window is checked once lag has passed since it closed, to
+// allow for queue latency and retries.
+//
+// Publish counts are kept in memory, so with several API instances each
+// instance only sees its own share of the stored events' publishes.
+type Reconciler struct {
+	counter ReceivedCounter
+	window  time.Duration
+	lag     time.Duration
+	clock   clock.Clock
+	logger  *zap.Logger
+
+	mu        sync.Mutex
+	published map[time.Time]int64 // window start -> published count
+}
+
+func NewReconciler(counter ReceivedCounter, window, lag time.Duration, clk clock.Clock, logger *zap.Logger) *Reconciler {
+	return &Reconciler{
+		counter:   counter,
+		window:    window,
+		lag:       lag,
+		clock:     clk,
+		logger:    logger,
+		published: make(map[time.Time]int64),
+	}
+}
+
+// RecordPublished counts an event published with the given receive time.
+func (r *Reconciler) RecordPublished(receivedAt time.Time) {
+	start := receivedAt.UTC().Truncate(r.window)
+
+	r.mu.Lock()
+	r.published[start]++
+	r.mu.Unlock()
+}
+
+// ReconcileDue checks every window that closed at least lag ago and reports
+// discrepancies. Windows whose storage count fails are retried on the next
+// call.
+func (r *Reconciler) ReconcileDue(ctx context.Context) []WindowResult {
+	cutoff := r.clock.Now().UTC().Add(-r.lag)
+
+	r.mu.Lock()
+	due := make(map[time.Time]int64)
+	for start, count := range r.published {
+		if !start.Add(r.window).After(cutoff) {
+			due[start] = count
+			delete(r.published, start)
+		}
+	}
+	r.mu.Unlock()
+
+	starts := make([]time.Time, 0, len(due))
+	for start := range due {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	var results []WindowResult
+	for _, start := range starts {
+		result := WindowResult{Start: start, End: start.Add(r.window), Published: due[start]}
+
+		stored, err := r.counter.CountReceivedBetween(ctx, result.Start, result.End)
+		if err != nil {
+			r.logger.Error("Failed to count stored events for reconciliation",
+				zap.Error(err),
+				zap.Time("window_start", result.Start))
+			r.mu.Lock()
+			r.published[start] += result.Published
+			r.mu.Unlock()
+			continue
+		}
+		result.Stored = stored
+
+		metrics.ReconcileMissing.Set(float64(result.Missing()))
+		if result.Missing() != 0 {
+			metrics.ReconcileDiscrepancies.Inc()
+			r.logger.Warn("Published and stored event counts differ",
+				zap.Time("window_start", result.Start),
+				zap.Time("window_end", result.End),
+				zap.Int64("published", result.Published),
+				zap.Int64("stored", result.Stored),
+				zap.Int64("missing", result.Missing()))
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Run reconciles due windows once per window until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.ReconcileDue(ctx)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type stubReceivedCounter struct {
+	stored map[time.Time]int64
+	err    error
+}
+
+func (s *stubReceivedCounter) CountReceivedBetween(ctx context.Context, from, to time.Time) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.stored[from], nil
+}
+
+func TestReconcilerReportsDiscrepancy(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	counter := &stubReceivedCounter{stored: map[time.Time]int64{start: 2}}
+	r := NewReconciler(counter, time.Minute, 5*time.Minute, clk, zap.NewNop())
+
+	for i := 0; i < 3; i++ {
+		r.RecordPublished(start.Add(time.Duration(i) * time.Second))
+	}
+
+	clk.Advance(time.Minute)
+	assert.Empty(t, r.ReconcileDue(context.Background()), "window isn't checked until the lag has passed")
+
+	clk.Advance(5 * time.Minute)
+	results := r.ReconcileDue(context.Background())
+
+	require.Len(t, results, 1)
+	assert.Equal(t, start, results[0].Start)
+	assert.Equal(t, int64(3), results[0].Published)
+	assert.Equal(t, int64(2), results[0].Stored)
+	assert.Equal(t, int64(1), results[0].Missing())
+
+	assert.Empty(t, r.ReconcileDue(context.Background()), "each window is reported once")
+}
+
+func TestReconcilerMatchingCounts(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	counter := &stubReceivedCounter{stored: map[time.Time]int64{start: 1}}
+	r := NewReconciler(counter, time.Minute, 0, clk, zap.NewNop())
+
+	r.RecordPublished(start)
+	clk.Advance(time.Minute)
+
+	results := r.ReconcileDue(context.Background())
+	require.Len(t, results, 1)
+	assert.Zero(t, results[0].Missing())
+}
+
+func TestReconcilerRetriesWindowOnCountError(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	counter := &stubReceivedCounter{err: errors.New("mongo unavailable")}
+	r := NewReconciler(counter, time.Minute, 0, clk, zap.NewNop())
+
+	r.RecordPublished(start)
+	clk.Advance(time.Minute)
+	assert.Empty(t, r.ReconcileDue(context.Background()))
+
+	counter.err = nil
+	counter.stored = map[time.Time]int64{start: 1}
+	results := r.ReconcileDue(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, int64(1), results[0].Published)
+}
@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"sync"
+	"time"
+
+	"webhook-processor/pkg/clock"
+)
+
+// ThroughputCounter keeps a rolling per-client count of events over a short
+// window using one-second buckets. It is safe for concurrent use.
+type ThroughputCounter struct {
+	mu        sync.Mutex
+	window    int // window length in seconds
+	clients   map[string][]bucket
+	lastEvict int64
+	clock     clock.Clock
+}
+
+type bucket struct {
+	second int64
+	count  int
+}
+
+func NewThroughputCounter(window time.Duration, clk clock.Clock) *ThroughputCounter {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &ThroughputCounter{
+		window:  seconds,
+		clients: make(map[string][]bucket),
+		clock:   clk,
+	}
+}
+
+// Window returns the rolling window length.
+func (t *ThroughputCounter) Window() time.Duration {
+	return time.Duration(t.window) * time.Second
+}
+
+// Record counts one event for clientID at the current time.
+func (t *ThroughputCounter) Record(clientID string) {
+	now := t.clock.Now().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buckets, ok := t.clients[clientID]
+	if !ok {
+		buckets = make([]bucket, t.window)
+		t.clients[clientID] = buckets
+	}
+
+	b := &buckets[now%int64(t.window)]
+	if b.second != now {
+		b.second = now
+		b.count = 0
+	}
+	b.count++
+
+	// Drop idle clients at most once per window
+	if now-t.lastEvict >= int64(t.window) {
+		t.evictIdle(now)
+		t.lastEvict = now
+	}
+}
+
+// Counts returns the number of events per client within the rolling window.
+// Clients with no events in the window are evicted.
+func (t *ThroughputCounter) Counts() map[string]int {
+	now := t.clock.Now().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[string]int, len(t.clients))
+	for clientID, buckets := range t.clients {
+		if total := t.sum(buckets, now); total > 0 {
+			counts[clientID] = total
+		} else {
+			delete(t.clients, clientID)
+		}
+	}
+	return counts
+}
+
+func (t *ThroughputCounter) sum(buckets []bucket, now int64) int {
+	total := 0
+	for _, b := range buckets {
+		if now-b.second < int64(t.window) {
+			total += b.count
+		}
+	}
+	return total
+}
+
+func (t *ThroughputCounter) evictIdle(now int64) {
+	for clientID, buckets := range t.clients {
+		if t.sum(buckets, now) == 0 {
+			delete(t.clients, clientID)
+		}
+	}
+}
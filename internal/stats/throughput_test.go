@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThroughputCounterRollingWindow(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	counter := NewThroughputCounter(time.Minute, clk)
+
+	for i := 0; i < 3; i++ {
+		counter.Record("client-a")
+	}
+	clk.Advance(30 * time.Second)
+	counter.Record("client-a")
+	counter.Record("client-b")
+
+	assert.Equal(t, map[string]int{"client-a": 4, "client-b": 1}, counter.Counts())
+
+	// The first three events fall out of the window after 60s
+	clk.Advance(30 * time.Second)
+	assert.Equal(t, map[string]int{"client-a": 1, "client-b": 1}, counter.Counts())
+
+	clk.Advance(29 * time.Second)
+	assert.Equal(t, map[string]int{"client-a": 1, "client-b": 1}, counter.Counts())
+
+	clk.Advance(time.Second)
+	assert.Empty(t, counter.Counts())
+}
+
+func TestThroughputCounterReusesExpiredBuckets(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	counter := NewThroughputCounter(time.Minute, clk)
+
+	counter.Record("client-a")
+	clk.Advance(time.Minute) // same bucket slot, one window later
+	counter.Record("client-a")
+
+	assert.Equal(t, map[string]int{"client-a": 1}, counter.Counts())
+}
+
+func TestThroughputCounterEvictsIdleClients(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	counter := NewThroughputCounter(time.Minute, clk)
+
+	counter.Record("idle")
+	clk.Advance(2 * time.Minute)
+	counter.Record("active")
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	assert.NotContains(t, counter.clients, "idle")
+	assert.Contains(t, counter.clients, "active")
+}
+
+func TestThroughputCounterConcurrentRecord(t *testing.T) {
+	counter := NewThroughputCounter(time.Minute, clock.New())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counter.Record("client-a")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1000, counter.Counts()["client-a"])
+}
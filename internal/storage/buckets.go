@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithMonthlyCollections stores events in one collection per month of
+// received_at, named <collection>_YYYY_MM, so old months can be dropped or
+// sharded independently. Reads span every bucket, or only those overlapping
+// the requested time range.
+func WithMonthlyCollections(enabled bool) Option {
+	return func(m *MongoDB) {
+		m.monthly = enabled
+	}
+}
+
+// bucketName returns the monthly collection for events received at t.
+func (m *MongoDB) bucketName(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%s_%04d_%02d", m.baseName, t.Year(), int(t.Month()))
+}
+
+// writeCollection returns the collection an event received at t is stored
+// in. A month's indexes are created the first time it is written to.
+func (m *MongoDB) writeCollection(ctx context.Context, t time.Time) (*mongo.Collection, error) {
+	if !m.monthly {
+		return m.collection, nil
+	}
+
+	name := m.bucketName(t)
+	coll := m.db.Collection(name)
+	if _, ok := m.indexedBuckets.Load(name); !ok {
+		if err := m.indexCollection(ctx, coll); err != nil {
+			return nil, fmt.Errorf("failed to create indexes for %s: %v", name, err)
+		}
+		m.indexedBuckets.Store(name, struct{}{})
+	}
+	return coll, nil
+}
+
+// readCollections returns the collections that may hold events received in
+// [from, to), newest first. Zero bounds are open.
+func (m *MongoDB) readCollections(ctx context.Context, from, to time.Time) ([]*mongo.Collection, error) {
+	if !m.monthly {
+		return []*mongo.Collection{m.collection}, nil
+	}
+
+	pattern := "^" + regexp.QuoteMeta(m.baseName) + `_\d{4}_\d{2}$`
+	names, err := m.db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": pattern}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list event collections: %v", err)
+	}
+	// Names are zero-padded, so they sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	var colls []*mongo.Collection
+	for _, name := range names {
+		if !from.IsZero() && name < m.bucketName(from) {
+			continue
+		}
+		if !to.IsZero() && name > m.bucketName(to.Add(-time.Nanosecond)) {
+			continue
+		}
+		colls = append(colls, m.db.Collection(name))
+	}
+	return colls, nil
+}
+
+// eventCollections returns the collections that may hold event: its month's
+// bucket if the receive time is known, otherwise every bucket.
+func (m *MongoDB) eventCollections(ctx context.Context, event *models.WebhookEvent) ([]*mongo.Collection, error) {
+	if m.monthly && !event.ReceivedAt.IsZero() {
+		return []*mongo.Collection{m.db.Collection(m.bucketName(event.ReceivedAt))}, nil
+	}
+	return m.readCollections(ctx, time.Time{}, time.Time{})
+}
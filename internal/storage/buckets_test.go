@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func newMonthlyMongoDB(mt *mtest.T) *MongoDB {
+	return &MongoDB{db: mt.DB, baseName: "events", monthly: true, logger: zap.NewNop()}
+}
+
+// mockBuckets queues the listCollections response naming the buckets.
+func mockBuckets(mt *mtest.T, names ...string) {
+	var docs []bson.D
+	for _, name := range names {
+		docs = append(docs, bson.D{{Key: "name", Value: name}})
+	}
+	mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".$cmd.listCollections", mtest.FirstBatch, docs...))
+}
+
+func TestInsertEventRoutesToMonthlyBucket(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("bucket by received_at", func(mt *mtest.T) {
+		m := newMonthlyMongoDB(mt)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(), // createIndexes
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		june := time.Date(2024, 6, 30, 23, 59, 0, 0, time.UTC)
+		require.NoError(mt, m.InsertEvent(context.Background(), &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", ReceivedAt: june}))
+		require.NoError(mt, m.InsertEvent(context.Background(), &models.WebhookEvent{WebhookID: "wh-2", ClientID: "client-a", ReceivedAt: june.Add(-time.Hour)}))
+
+		indexes := mt.GetStartedEvent()
+		require.Equal(mt, "createIndexes", indexes.CommandName)
+		assert.Equal(mt, "events_2024_06", indexes.Command.Lookup("createIndexes").StringValue())
+		for i := 0; i < 2; i++ {
+			update := mt.GetStartedEvent()
+			require.Equal(mt, "update", update.CommandName, "indexes are only created on a bucket's first write")
+			assert.Equal(mt, "events_2024_06", update.Command.Lookup("update").StringValue())
+		}
+	})
+
+	mt.Run("status update goes to the event's bucket", func(mt *mtest.T) {
+		m := newMonthlyMongoDB(mt)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", ReceivedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+		require.NoError(mt, m.UpdateEventStatus(context.Background(), event, models.EventStatusProcessed))
+
+		update := mt.GetStartedEvent()
+		assert.Equal(mt, "events_2024_05", update.Command.Lookup("update").StringValue())
+	})
+}
+
+func TestGetEventsByClientAcrossBuckets(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("page spans buckets", func(mt *mtest.T) {
+		m := newMonthlyMongoDB(mt)
+		mockBuckets(mt, "events_2024_04", "events_2024_06", "events_2024_05", "events_2024_07")
+		june := mt.DB.Name() + ".events_2024_06"
+		may := mt.DB.Name() + ".events_2024_05"
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, june, mtest.FirstBatch, bson.D{{Key: "n", Value: 2}}),
+			mtest.CreateCursorResponse(0, may, mtest.FirstBatch, bson.D{{Key: "n", Value: 3}}),
+			mtest.CreateCursorResponse(0, june, mtest.FirstBatch, bson.D{{Key: "webhook_id", Value: "jun-1"}}),
+			mtest.CreateCursorResponse(0, may, mtest.FirstBatch,
+				bson.D{{Key: "webhook_id", Value: "may-3"}},
+				bson.D{{Key: "webhook_id", Value: "may-2"}},
+			),
+		)
+
+		events, total, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{
+			From:   time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC),
+			To:     time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+			Limit:  3,
+			Offset: 1,
+		})
+		require.NoError(mt, err)
+		assert.Equal(mt, int64(5), total, "counts every bucket in range")
+		require.Len(mt, events, 3)
+		assert.Equal(mt, []string{"jun-1", "may-3", "may-2"}, []string{events[0].WebhookID, events[1].WebhookID, events[2].WebhookID})
+
+		require.Equal(mt, "listCollections", mt.GetStartedEvent().CommandName)
+		assert.Equal(mt, "events_2024_06", mt.GetStartedEvent().Command.Lookup("aggregate").StringValue(), "newest bucket first; July is out of range")
+		assert.Equal(mt, "events_2024_05", mt.GetStartedEvent().Command.Lookup("aggregate").StringValue(), "April is out of range")
+
+		find := mt.GetStartedEvent().Command
+		assert.Equal(mt, "events_2024_06", find.Lookup("find").StringValue())
+		assert.Equal(mt, int64(1), find.Lookup("skip").AsInt64())
+		assert.Equal(mt, int64(3), find.Lookup("limit").AsInt64())
+		find = mt.GetStartedEvent().Command
+		assert.Equal(mt, "events_2024_05", find.Lookup("find").StringValue())
+		assert.Zero(mt, find.Lookup("skip").AsInt64())
+		assert.Equal(mt, int64(2), find.Lookup("limit").AsInt64())
+		assert.Nil(mt, mt.GetStartedEvent())
+	})
+
+	mt.Run("lookup searches every bucket", func(mt *mtest.T) {
+		m := newMonthlyMongoDB(mt)
+		mockBuckets(mt, "events_2024_05", "events_2024_06")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".events_2024_06", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".events_2024_05", mtest.FirstBatch,
+				bson.D{{Key: "webhook_id", Value: "wh-1"}, {Key: "client_id", Value: "client-a"}}),
+		)
+
+		event, err := m.GetEventByWebhookID(context.Background(), "wh-1", "client-a")
+		require.NoError(mt, err)
+		assert.Equal(mt, "wh-1", event.WebhookID)
+	})
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// ErrNotAttempted is the error of events an ordered bulk write never got to,
+// because an earlier event in it failed.
+var ErrNotAttempted = errors.New("not attempted after an earlier event failed")
+
+// BulkInserter stores many events in one round trip.
+type BulkInserter interface {
+	// InsertEvents stores events as InsertEvent does, returning an error
+	// for each, nil for those stored. Unordered writes attempt every
+	// event; ordered ones stop at the first failure and fail the rest
+	// with ErrNotAttempted.
+	InsertEvents(ctx context.Context, events []*models.WebhookEvent, ordered bool) []error
+}
+
+var (
+	_ BulkInserter = (*MongoDB)(nil)
+	_ BulkInserter = (*ClientRouter)(nil)
+)
+
+// InsertEvents upserts events with a bulk write per collection they belong
+// in, mapping the indexes of a BulkWriteException back to the events that
+// failed. Ordered writes keep to the order of events, with a bulk write
+// per run of events in the same collection.
+func (m *MongoDB) InsertEvents(ctx context.Context, events []*models.WebhookEvent, ordered bool) []error {
+	errs := make([]error, len(events))
+
+	// Group the events by collection, or by run of the same collection
+	// when ordered, keeping their order, with each group's models and the
+	// index in events of each model
+	type group struct {
+		coll    *mongo.Collection
+		models  []mongo.WriteModel
+		indexes []int
+	}
+	var groups []*group
+	byName := make(map[string]*group)
+	docs := make([]bson.M, len(events))
+	for i, event := range events {
+		if ordered && i > 0 && errs[i-1] != nil {
+			errs[i] = ErrNotAttempted
+			continue
+		}
+		doc, err := m.eventDocument(event)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		coll, err := m.writeCollection(ctx, event.ReceivedAt)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		docs[i] = doc
+		g, ok := byName[coll.Name()]
+		if !ok || (ordered && g != groups[len(groups)-1]) {
+			g = &group{coll: coll}
+			byName[coll.Name()] = g
+			groups = append(groups, g)
+		}
+		g.models = append(g.models, mongo.NewReplaceOneModel().
+			SetFilter(eventFilter(event)).
+			SetReplacement(doc).
+			SetUpsert(true))
+		g.indexes = append(g.indexes, i)
+	}
+
+	failed := false
+	for _, g := range groups {
+		if ordered && failed {
+			for _, i := range g.indexes {
+				errs[i] = ErrNotAttempted
+			}
+			continue
+		}
+
+		_, err := g.coll.BulkWrite(ctx, g.models, options.BulkWrite().SetOrdered(ordered))
+		var bulkErr mongo.BulkWriteException
+		switch {
+		case err == nil:
+		case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+			// Only the writes listed failed, or with ordered writes
+			// also everything after the first of them
+			first := len(g.indexes)
+			for _, writeErr := range bulkErr.WriteErrors {
+				errs[g.indexes[writeErr.Index]] = writeErr
+				first = min(first, writeErr.Index)
+			}
+			if ordered {
+				for _, i := range g.indexes[first+1:] {
+					if errs[i] == nil {
+						errs[i] = ErrNotAttempted
+					}
+				}
+			}
+		default:
+			// Nothing in the group is known to be stored
+			for _, i := range g.indexes {
+				errs[i] = err
+			}
+		}
+		failed = err != nil
+	}
+
+	for i, event := range events {
+		if errs[i] != nil {
+			m.logger.Error("Failed to insert event",
+				zap.Error(errs[i]),
+				zap.String("client_id", event.ClientID),
+				zap.String("webhook_id", event.WebhookID))
+			continue
+		}
+		recordStoredBytes(string(event.Type()), docs[i])
+	}
+	return errs
+}
+
+// InsertEvents splits events by the store holding their client's events,
+// bulk writing to stores that can and inserting one at a time otherwise.
+// Ordered writes keep to the order of events, with a write per run of
+// events in the same store.
+func (r *ClientRouter) InsertEvents(ctx context.Context, events []*models.WebhookEvent, ordered bool) []error {
+	errs := make([]error, len(events))
+	type batch struct {
+		store   EventStore
+		indexes []int
+	}
+	var batches []*batch
+	byStore := make(map[EventStore]*batch)
+	for i, event := range events {
+		store := r.StoreFor(event.ClientID)
+		b, ok := byStore[store]
+		if !ok || (ordered && b != batches[len(batches)-1]) {
+			b = &batch{store: store}
+			byStore[store] = b
+			batches = append(batches, b)
+		}
+		b.indexes = append(b.indexes, i)
+	}
+
+	failed := false
+	for _, b := range batches {
+		if ordered && failed {
+			for _, i := range b.indexes {
+				errs[i] = ErrNotAttempted
+			}
+			continue
+		}
+		storeEvents := make([]*models.WebhookEvent, len(b.indexes))
+		for j, i := range b.indexes {
+			storeEvents[j] = events[i]
+		}
+		for j, err := range InsertEvents(ctx, b.store, storeEvents, ordered) {
+			errs[b.indexes[j]] = err
+			failed = failed || err != nil
+		}
+	}
+	return errs
+}
+
+// InsertEvents stores events in store, with a bulk write if it is a
+// BulkInserter and one at a time otherwise, returning an error for each as
+// BulkInserter does.
+func InsertEvents(ctx context.Context, store EventStore, events []*models.WebhookEvent, ordered bool) []error {
+	if bulk, ok := store.(BulkInserter); ok {
+		return bulk.InsertEvents(ctx, events, ordered)
+	}
+	errs := make([]error, len(events))
+	for i, event := range events {
+		if ordered && i > 0 && errs[i-1] != nil {
+			errs[i] = ErrNotAttempted
+			continue
+		}
+		errs[i] = store.InsertEvent(ctx, event)
+	}
+	return errs
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func bulkEvents() []*models.WebhookEvent {
+	return []*models.WebhookEvent{
+		{WebhookID: "wh-1", ClientID: "client-a", Event: "opened"},
+		{WebhookID: "wh-2", ClientID: "client-a", Event: "clicked"},
+		{WebhookID: "wh-3", ClientID: "client-a", Event: "bounced"},
+	}
+}
+
+func TestInsertEventsMapsWriteErrors(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unordered fails only the listed writes", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+
+		errs := m.InsertEvents(context.Background(), bulkEvents(), false)
+
+		require.Len(mt, errs, 3)
+		assert.NoError(mt, errs[0])
+		var writeErr mongo.BulkWriteError
+		require.ErrorAs(mt, errs[1], &writeErr)
+		assert.Equal(mt, 11000, writeErr.Code)
+		assert.NoError(mt, errs[2])
+
+		started := mt.GetStartedEvent()
+		require.NotNil(mt, started)
+		assert.Equal(mt, "update", started.CommandName)
+		assert.False(mt, started.Command.Lookup("ordered").Boolean())
+		updates, err := started.Command.Lookup("updates").Array().Values()
+		require.NoError(mt, err)
+		assert.Len(mt, updates, 3, "all events go in one round trip")
+	})
+
+	mt.Run("ordered stops at the first failure", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+
+		errs := m.InsertEvents(context.Background(), bulkEvents(), true)
+
+		require.Len(mt, errs, 3)
+		assert.NoError(mt, errs[0])
+		assert.Error(mt, errs[1])
+		assert.ErrorIs(mt, errs[2], ErrNotAttempted)
+		assert.True(mt, mt.GetStartedEvent().Command.Lookup("ordered").Boolean())
+	})
+
+	mt.Run("command failure fails every event", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "boom"}))
+
+		for i, err := range m.InsertEvents(context.Background(), bulkEvents(), false) {
+			assert.Error(mt, err, "event %d", i)
+		}
+	})
+
+	mt.Run("all stored", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}))
+
+		for i, err := range m.InsertEvents(context.Background(), bulkEvents(), false) {
+			assert.NoError(mt, err, "event %d", i)
+		}
+	})
+}
+
+func TestInsertEventsAcrossMonthlyBuckets(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	june := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	may := time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+	events := func() []*models.WebhookEvent {
+		return []*models.WebhookEvent{
+			{WebhookID: "wh-1", ClientID: "client-a", ReceivedAt: june},
+			{WebhookID: "wh-2", ClientID: "client-a", ReceivedAt: may},
+			{WebhookID: "wh-3", ClientID: "client-a", ReceivedAt: june},
+		}
+	}
+	indexed := func(m *MongoDB) *MongoDB {
+		m.indexedBuckets.Store("events_2024_05", struct{}{})
+		m.indexedBuckets.Store("events_2024_06", struct{}{})
+		return m
+	}
+	// writes returns the bucket and number of events of each bulk write
+	writes := func(mt *mtest.T) []string {
+		var got []string
+		for started := mt.GetStartedEvent(); started != nil; started = mt.GetStartedEvent() {
+			updates, err := started.Command.Lookup("updates").Array().Values()
+			require.NoError(mt, err)
+			got = append(got, fmt.Sprintf("%s:%d", started.Command.Lookup("update").StringValue(), len(updates)))
+		}
+		return got
+	}
+
+	mt.Run("ordered writes keep to the input order", func(mt *mtest.T) {
+		m := indexed(newMonthlyMongoDB(mt))
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		for i, err := range m.InsertEvents(context.Background(), events(), true) {
+			assert.NoError(mt, err, "event %d", i)
+		}
+		assert.Equal(mt, []string{"events_2024_06:1", "events_2024_05:1", "events_2024_06:1"}, writes(mt))
+	})
+
+	mt.Run("ordered failure stops later buckets", func(mt *mtest.T) {
+		m := indexed(newMonthlyMongoDB(mt))
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+		)
+
+		errs := m.InsertEvents(context.Background(), events(), true)
+		assert.NoError(mt, errs[0])
+		assert.Error(mt, errs[1])
+		assert.ErrorIs(mt, errs[2], ErrNotAttempted, "wh-3 comes after the failed event")
+		assert.Equal(mt, []string{"events_2024_06:1", "events_2024_05:1"}, writes(mt))
+	})
+
+	mt.Run("unordered writes a bucket at a time", func(mt *mtest.T) {
+		m := indexed(newMonthlyMongoDB(mt))
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		for i, err := range m.InsertEvents(context.Background(), events(), false) {
+			assert.NoError(mt, err, "event %d", i)
+		}
+		assert.Equal(mt, []string{"events_2024_06:2", "events_2024_05:1"}, writes(mt))
+	})
+}
+
+// singleStore is an EventStore without bulk writes, failing the webhook IDs
+// in fail.
+type singleStore struct {
+	EventStore
+	fail     map[string]bool
+	inserted []string
+}
+
+func (s *singleStore) InsertEvent(ctx context.Context, event *models.WebhookEvent) error {
+	if s.fail[event.WebhookID] {
+		return errors.New("insert failed")
+	}
+	s.inserted = append(s.inserted, event.WebhookID)
+	return nil
+}
+
+func TestInsertEventsWithoutBulkSupport(t *testing.T) {
+	store := &singleStore{fail: map[string]bool{"wh-2": true}}
+	errs := InsertEvents(context.Background(), store, bulkEvents(), false)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.NoError(t, errs[2])
+	assert.Equal(t, []string{"wh-1", "wh-3"}, store.inserted)
+
+	store = &singleStore{fail: map[string]bool{"wh-2": true}}
+	errs = InsertEvents(context.Background(), store, bulkEvents(), true)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.ErrorIs(t, errs[2], ErrNotAttempted)
+	assert.Equal(t, []string{"wh-1"}, store.inserted)
+}
+
+func TestClientRouterInsertEventsOrdered(t *testing.T) {
+	shared := &singleStore{fail: map[string]bool{"wh-2": true}}
+	dedicated := &singleStore{}
+	router := NewClientRouter(shared, map[string]EventStore{"client-a": dedicated})
+	events := []*models.WebhookEvent{
+		{WebhookID: "wh-1", ClientID: "client-a"},
+		{WebhookID: "wh-2", ClientID: "client-b"},
+		{WebhookID: "wh-3", ClientID: "client-a"},
+	}
+
+	errs := router.InsertEvents(context.Background(), events, true)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.ErrorIs(t, errs[2], ErrNotAttempted, "wh-3 comes after the failed event, though in another store")
+	assert.Equal(t, []string{"wh-1"}, dedicated.inserted)
+
+	dedicated = &singleStore{}
+	router = NewClientRouter(shared, map[string]EventStore{"client-a": dedicated})
+	errs = router.InsertEvents(context.Background(), events, false)
+	assert.NoError(t, errs[2])
+	assert.Equal(t, []string{"wh-1", "wh-3"}, dedicated.inserted)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BounceEvents are the raw event names counted as bounces in events stored
+// before event_type was; newer events are counted by event_type.
+var BounceEvents = []string{"bounced", "bounce", "hard_bounce", "soft_bounce"}
+
+// DashboardQuerier summarizes a client's recent events.
+type DashboardQuerier interface {
+	SummarizeClientEvents(ctx context.Context, clientID string, since time.Time, topCampaigns int) (*ClientSummary, error)
+}
+
+var (
+	_ DashboardQuerier = (*MongoDB)(nil)
+	_ DashboardQuerier = (*ClientRouter)(nil)
+)
+
+// CampaignCount is the number of events a campaign received.
+type CampaignCount struct {
+	CampaignID   string `json:"campaign_id"`
+	CampaignName string `json:"campaign_name"`
+	Events       int64  `json:"events"`
+}
+
+// ClientSummary aggregates a client's events received since a point in time.
+type ClientSummary struct {
+	Events  int64
+	Bounces int64
+	// TopCampaigns is ordered by event count, highest first. Events without
+	// a campaign count towards the totals but aren't listed.
+	TopCampaigns []CampaignCount
+}
+
+type campaignGroup struct {
+	CampaignID   string `bson:"_id"`
+	CampaignName string `bson:"name"`
+	Events       int64  `bson:"events"`
+	Bounces      int64  `bson:"bounces"`
+}
+
+// SummarizeClientEvents counts clientID's events and bounces received since
+// since, and returns its topCampaigns busiest campaigns. Events are grouped
+// by campaign in Mongo; the groups from each monthly bucket are merged here.
+func (m *MongoDB) SummarizeClientEvents(ctx context.Context, clientID string, since time.Time, topCampaigns int) (*ClientSummary, error) {
+	colls, err := m.readCollections(ctx, since, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"client_id":   clientID,
+			"received_at": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$campaign_id",
+			"name":   bson.M{"$max": "$campaign_name"},
+			"events": bson.M{"$sum": 1},
+			"bounces": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$or": bson.A{
+					bson.M{"$eq": bson.A{"$event_type", string(models.EventTypeBounce)}},
+					bson.M{"$in": bson.A{"$event", BounceEvents}},
+				}}, 1, 0,
+			}}},
+		}}},
+	}
+
+	summary := &ClientSummary{}
+	campaigns := make(map[string]*CampaignCount)
+	for _, coll := range colls {
+		cursor, err := coll.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("failed to aggregate events: %v", err)
+		}
+		var groups []campaignGroup
+		err = cursor.All(ctx, &groups)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, g := range groups {
+			summary.Events += g.Events
+			summary.Bounces += g.Bounces
+			if g.CampaignID == "" {
+				continue
+			}
+			campaign, ok := campaigns[g.CampaignID]
+			if !ok {
+				campaign = &CampaignCount{CampaignID: g.CampaignID}
+				campaigns[g.CampaignID] = campaign
+			}
+			campaign.Events += g.Events
+			if g.CampaignName != "" {
+				campaign.CampaignName = g.CampaignName
+			}
+		}
+	}
+
+	summary.TopCampaigns = make([]CampaignCount, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		summary.TopCampaigns = append(summary.TopCampaigns, *campaign)
+	}
+	sort.Slice(summary.TopCampaigns, func(i, j int) bool {
+		a, b := summary.TopCampaigns[i], summary.TopCampaigns[j]
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		return a.CampaignID < b.CampaignID
+	})
+	if topCampaigns >= 0 && len(summary.TopCampaigns) > topCampaigns {
+		summary.TopCampaigns = summary.TopCampaigns[:topCampaigns]
+	}
+	return summary, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func TestSummarizeClientEvents(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	since := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	mt.Run("totals and top campaigns", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "c-1"}, {Key: "name", Value: "Welcome"}, {Key: "events", Value: int32(40)}, {Key: "bounces", Value: int32(2)}},
+			bson.D{{Key: "_id", Value: ""}, {Key: "name", Value: ""}, {Key: "events", Value: int32(15)}, {Key: "bounces", Value: int32(5)}},
+			bson.D{{Key: "_id", Value: "c-2"}, {Key: "name", Value: "June newsletter"}, {Key: "events", Value: int32(90)}, {Key: "bounces", Value: int32(3)}},
+			bson.D{{Key: "_id", Value: "c-3"}, {Key: "name", Value: "Reminder"}, {Key: "events", Value: int32(40)}, {Key: "bounces", Value: int32(0)}},
+		))
+
+		summary, err := m.SummarizeClientEvents(context.Background(), "client-a", since, 2)
+		require.NoError(mt, err)
+		assert.Equal(mt, int64(185), summary.Events, "events without a campaign still count")
+		assert.Equal(mt, int64(10), summary.Bounces)
+		assert.Equal(mt, []CampaignCount{
+			{CampaignID: "c-2", CampaignName: "June newsletter", Events: 90},
+			{CampaignID: "c-1", CampaignName: "Welcome", Events: 40},
+		}, summary.TopCampaigns, "busiest first, ties by campaign ID")
+
+		pipeline, err := mt.GetStartedEvent().Command.Lookup("pipeline").Array().Values()
+		require.NoError(mt, err)
+		require.Len(mt, pipeline, 2)
+		match := pipeline[0].Document().Lookup("$match").Document()
+		assert.Equal(mt, "client-a", match.Lookup("client_id").StringValue())
+		assert.Equal(mt, since, match.Lookup("received_at", "$gte").Time().UTC())
+		assert.Equal(mt, "$campaign_id", pipeline[1].Document().Lookup("$group", "_id").StringValue())
+	})
+
+	mt.Run("no events", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+mt.Coll.Name(), mtest.FirstBatch))
+
+		summary, err := m.SummarizeClientEvents(context.Background(), "client-a", since, 5)
+		require.NoError(mt, err)
+		assert.Zero(mt, summary.Events)
+		assert.Empty(mt, summary.TopCampaigns)
+	})
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// webhookMappingsCollection holds the last webhook-to-client mapping loaded
+// from MailerCloud, as a single document
+const webhookMappingsCollection = "webhook_mappings"
+
+// webhookMappingID is the _id of the cached mapping document
+const webhookMappingID = "current"
+
+// webhookOverridesCollection holds webhooks manually mapped to a client, a
+// document per webhook
+const webhookOverridesCollection = "webhook_mapping_overrides"
+
+// MappingCache keeps the last good webhook-to-client mapping, so it survives
+// a restart while MailerCloud is unreachable.
+type MappingCache interface {
+	SaveWebhookMapping(ctx context.Context, webhookToClient map[string]string, updatedAt time.Time) error
+	LoadWebhookMapping(ctx context.Context) (map[string]string, time.Time, error)
+}
+
+// MappingOverrides keeps the webhooks mapped to a client by hand, which
+// take precedence over the mapping loaded from MailerCloud.
+type MappingOverrides interface {
+	SaveWebhookOverride(ctx context.Context, webhookID, clientID string, at time.Time) error
+	LoadWebhookOverrides(ctx context.Context) (map[string]string, error)
+}
+
+var (
+	_ MappingCache     = (*MongoDB)(nil)
+	_ MappingCache     = (*ClientRouter)(nil)
+	_ MappingOverrides = (*MongoDB)(nil)
+	_ MappingOverrides = (*ClientRouter)(nil)
+)
+
+// Webhook IDs are stored as values rather than keys, so any ID is a valid
+// document.
+type webhookMappingDoc struct {
+	Webhooks  []webhookMappingEntry `bson:"webhooks"`
+	UpdatedAt time.Time             `bson:"updated_at"`
+}
+
+type webhookMappingEntry struct {
+	WebhookID string `bson:"webhook_id"`
+	ClientID  string `bson:"client_id"`
+}
+
+// SaveWebhookMapping replaces the cached mapping.
+func (m *MongoDB) SaveWebhookMapping(ctx context.Context, webhookToClient map[string]string, updatedAt time.Time) error {
+	doc := webhookMappingDoc{
+		Webhooks:  make([]webhookMappingEntry, 0, len(webhookToClient)),
+		UpdatedAt: updatedAt,
+	}
+	for webhookID, clientID := range webhookToClient {
+		doc.Webhooks = append(doc.Webhooks, webhookMappingEntry{WebhookID: webhookID, ClientID: clientID})
+	}
+
+	_, err := m.db.Collection(webhookMappingsCollection).ReplaceOne(ctx,
+		bson.M{"_id": webhookMappingID},
+		doc,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook mapping: %v", err)
+	}
+	return nil
+}
+
+// LoadWebhookMapping returns the cached mapping and when it was loaded from
+// MailerCloud. The mapping is nil if none has been saved.
+func (m *MongoDB) LoadWebhookMapping(ctx context.Context) (map[string]string, time.Time, error) {
+	var doc webhookMappingDoc
+	err := m.db.Collection(webhookMappingsCollection).FindOne(ctx, bson.M{"_id": webhookMappingID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to load webhook mapping: %v", err)
+	}
+
+	webhookToClient := make(map[string]string, len(doc.Webhooks))
+	for _, entry := range doc.Webhooks {
+		webhookToClient[entry.WebhookID] = entry.ClientID
+	}
+	return webhookToClient, doc.UpdatedAt, nil
+}
+
+// SaveWebhookMapping caches the mapping in the shared store.
+func (r *ClientRouter) SaveWebhookMapping(ctx context.Context, webhookToClient map[string]string, updatedAt time.Time) error {
+	cache, ok := r.shared.(MappingCache)
+	if !ok {
+		return fmt.Errorf("shared store does not support caching the webhook mapping")
+	}
+	return cache.SaveWebhookMapping(ctx, webhookToClient, updatedAt)
+}
+
+// LoadWebhookMapping loads the mapping cached in the shared store.
+func (r *ClientRouter) LoadWebhookMapping(ctx context.Context) (map[string]string, time.Time, error) {
+	cache, ok := r.shared.(MappingCache)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("shared store does not support caching the webhook mapping")
+	}
+	return cache.LoadWebhookMapping(ctx)
+}
+
+type webhookOverrideDoc struct {
+	WebhookID string    `bson:"_id"`
+	ClientID  string    `bson:"client_id"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// SaveWebhookOverride maps webhookID to clientID, replacing any override it
+// had.
+func (m *MongoDB) SaveWebhookOverride(ctx context.Context, webhookID, clientID string, at time.Time) error {
+	_, err := m.db.Collection(webhookOverridesCollection).ReplaceOne(ctx,
+		bson.M{"_id": webhookID},
+		webhookOverrideDoc{WebhookID: webhookID, ClientID: clientID, UpdatedAt: at},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook mapping override: %v", err)
+	}
+	return nil
+}
+
+// LoadWebhookOverrides returns every override, keyed by webhook ID.
+func (m *MongoDB) LoadWebhookOverrides(ctx context.Context) (map[string]string, error) {
+	cursor, err := m.db.Collection(webhookOverridesCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook mapping overrides: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []webhookOverrideDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to load webhook mapping overrides: %v", err)
+	}
+	overrides := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		overrides[doc.WebhookID] = doc.ClientID
+	}
+	return overrides, nil
+}
+
+// SaveWebhookOverride saves the override in the shared store.
+func (r *ClientRouter) SaveWebhookOverride(ctx context.Context, webhookID, clientID string, at time.Time) error {
+	overrides, ok := r.shared.(MappingOverrides)
+	if !ok {
+		return fmt.Errorf("shared store does not support webhook mapping overrides")
+	}
+	return overrides.SaveWebhookOverride(ctx, webhookID, clientID, at)
+}
+
+// LoadWebhookOverrides loads the overrides saved in the shared store.
+func (r *ClientRouter) LoadWebhookOverrides(ctx context.Context) (map[string]string, error) {
+	overrides, ok := r.shared.(MappingOverrides)
+	if !ok {
+		return nil, fmt.Errorf("shared store does not support webhook mapping overrides")
+	}
+	return overrides.LoadWebhookOverrides(ctx)
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func TestWebhookMappingCache(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	updatedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	mt.Run("save replaces the cached document", func(mt *mtest.T) {
+		m := &MongoDB{db: mt.DB, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err := m.SaveWebhookMapping(context.Background(), map[string]string{"wh.1": "client-a"}, updatedAt)
+		require.NoError(mt, err)
+
+		cmd := mt.GetStartedEvent().Command
+		assert.Equal(mt, webhookMappingsCollection, cmd.Lookup("update").StringValue())
+		update := cmd.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, webhookMappingID, update.Lookup("q", "_id").StringValue())
+		assert.True(mt, update.Lookup("upsert").Boolean())
+		entry := update.Lookup("u", "webhooks").Array().Index(0).Value().Document()
+		assert.Equal(mt, "wh.1", entry.Lookup("webhook_id").StringValue())
+		assert.Equal(mt, "client-a", entry.Lookup("client_id").StringValue())
+	})
+
+	mt.Run("load", func(mt *mtest.T) {
+		m := &MongoDB{db: mt.DB, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+webhookMappingsCollection, mtest.FirstBatch,
+			bson.D{
+				{Key: "_id", Value: webhookMappingID},
+				{Key: "webhooks", Value: bson.A{
+					bson.D{{Key: "webhook_id", Value: "wh-1"}, {Key: "client_id", Value: "client-a"}},
+					bson.D{{Key: "webhook_id", Value: "wh-2"}, {Key: "client_id", Value: "client-b"}},
+				}},
+				{Key: "updated_at", Value: updatedAt},
+			},
+		))
+
+		webhookToClient, cachedAt, err := m.LoadWebhookMapping(context.Background())
+		require.NoError(mt, err)
+		assert.Equal(mt, map[string]string{"wh-1": "client-a", "wh-2": "client-b"}, webhookToClient)
+		assert.Equal(mt, updatedAt, cachedAt.UTC())
+	})
+
+	mt.Run("load without a cached mapping", func(mt *mtest.T) {
+		m := &MongoDB{db: mt.DB, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+webhookMappingsCollection, mtest.FirstBatch))
+
+		webhookToClient, _, err := m.LoadWebhookMapping(context.Background())
+		require.NoError(mt, err)
+		assert.Nil(mt, webhookToClient)
+	})
+}
+
+func TestWebhookMappingOverrides(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("save upserts the webhook's document", func(mt *mtest.T) {
+		m := &MongoDB{db: mt.DB, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(mt, m.SaveWebhookOverride(context.Background(), "wh.1", "client-b", at))
+
+		cmd := mt.GetStartedEvent().Command
+		assert.Equal(mt, webhookOverridesCollection, cmd.Lookup("update").StringValue())
+		update := cmd.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, "wh.1", update.Lookup("q", "_id").StringValue())
+		assert.True(mt, update.Lookup("upsert").Boolean())
+		assert.Equal(mt, "client-b", update.Lookup("u", "client_id").StringValue())
+		assert.Equal(mt, at, update.Lookup("u", "updated_at").Time().UTC())
+	})
+
+	mt.Run("load", func(mt *mtest.T) {
+		m := &MongoDB{db: mt.DB, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+webhookOverridesCollection, mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "wh-1"}, {Key: "client_id", Value: "client-b"}},
+			bson.D{{Key: "_id", Value: "wh-2"}, {Key: "client_id", Value: "client-c"}},
+		))
+
+		overrides, err := m.LoadWebhookOverrides(context.Background())
+		require.NoError(mt, err)
+		assert.Equal(mt, map[string]string{"wh-1": "client-b", "wh-2": "client-c"}, overrides)
+	})
+}
@@ -2,9 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"webhook-processor/config"
 	"webhook-processor/internal/models"
+	"webhook-processor/pkg/metrics"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,12 +18,97 @@ import (
 )
 
 type MongoDB struct {
-	client     *mongo.Client
-	collection *mongo.Collection
-	logger     *zap.Logger
+	client       *mongo.Client
+	collection   *mongo.Collection
+	clientErrors *mongo.Collection
+	logger       *zap.Logger
+	// skipNoopStatusUpdates leaves documents already in the target status
+	// (and retry count) untouched instead of rewriting them.
+	skipNoopStatusUpdates bool
+	// extraIndexes are created alongside the built-in indexes.
+	extraIndexes []mongo.IndexModel
+
+	// monthly replaces collection with per-month buckets of db named after
+	// baseName; indexedBuckets records those whose indexes exist.
+	monthly        bool
+	db             *mongo.Database
+	baseName       string
+	indexedBuckets sync.Map
+
+	// compressRawPayload stores raw payloads gzipped as binary.
+	compressRawPayload bool
+
+	// retention is the TTL on received_at; zero disables expiry.
+	// eventRetention overrides it per lowercased event type, through an
+	// expires_at field computed at insert instead.
+	retention      time.Duration
+	eventRetention map[string]time.Duration
+}
+
+// Option configures optional MongoDB behaviour.
+type Option func(*MongoDB)
+
+// WithSkipNoopStatusUpdates controls whether UpdateEventStatus skips
+// documents whose status and retry count already match. Enabled by default.
+func WithSkipNoopStatusUpdates(enabled bool) Option {
+	return func(m *MongoDB) {
+		m.skipNoopStatusUpdates = enabled
+	}
+}
+
+// WithIndexes adds config-declared indexes to the events collection. The
+// definitions are expected to have been validated by config.Load.
+func WithIndexes(defs []config.IndexConfig) Option {
+	return func(m *MongoDB) {
+		for _, def := range defs {
+			keys := bson.D{}
+			for _, key := range def.Keys {
+				keys = append(keys, bson.E{Key: key.Field, Value: key.Direction})
+			}
+			opts := options.Index()
+			if def.Name != "" {
+				opts.SetName(def.Name)
+			}
+			if def.Unique {
+				opts.SetUnique(true)
+			}
+			if def.Sparse {
+				opts.SetSparse(true)
+			}
+			m.extraIndexes = append(m.extraIndexes, mongo.IndexModel{Keys: keys, Options: opts})
+		}
+	}
 }
 
-func NewMongoDB(uri, database, collection string, logger *zap.Logger) (*MongoDB, error) {
+// WithCompressedRawPayload gzips raw payloads before storing them. Stored
+// payloads are decompressed transparently on read either way.
+func WithCompressedRawPayload(enabled bool) Option {
+	return func(m *MongoDB) {
+		m.compressRawPayload = enabled
+	}
+}
+
+// WithRetention expires events retention after their received_at via a TTL
+// index. Zero, the default, keeps events indefinitely.
+func WithRetention(retention time.Duration) Option {
+	return func(m *MongoDB) {
+		m.retention = retention
+	}
+}
+
+// WithEventRetention expires events of the given canonical types after
+// their own retention instead of the WithRetention one, which still applies
+// to other types. A zero retention keeps that type indefinitely.
+func WithEventRetention(retention map[string]time.Duration) Option {
+	return func(m *MongoDB) {
+		m.eventRetention = retention
+	}
+}
+
+// clientErrorsCollection holds one last-error document per client
+const clientErrorsCollection = "client_errors"
+
+func NewMongoDB(uri, database, collection string, logger *zap.Logger, opts ...Option) (*MongoDB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -46,11 +136,57 @@ func NewMongoDB(uri, database, collection string, logger *zap.Logger) (*MongoDB,
 	)
 
 	coll := client.Database(database).Collection(collection)
+	m := &MongoDB{
+		client:                client,
+		collection:            coll,
+		logger:                logger,
+		skipNoopStatusUpdates: true,
+		db:                    client.Database(database),
+		baseName:              collection,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	// Monthly buckets get their indexes when first written to; the current
+	// month's up front so index errors still surface at startup
+	if m.monthly {
+		if _, err := m.writeCollection(ctx, time.Now()); err != nil {
+			return nil, err
+		}
+	} else if err := m.createIndexes(ctx); err != nil {
+		return nil, err
+	}
 
-	// Create indexes
+	clientErrors := client.Database(database).Collection(clientErrorsCollection)
+	_, err = clientErrors.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.clientErrors = clientErrors
+
+	return m, nil
+}
+
+// createIndexes creates the built-in and config-declared indexes on the
+// events collection.
+func (m *MongoDB) createIndexes(ctx context.Context) error {
+	return m.indexCollection(ctx, m.collection)
+}
+
+// indexCollection creates the built-in and config-declared indexes on coll.
+func (m *MongoDB) indexCollection(ctx context.Context, coll *mongo.Collection) error {
 	indexes := []mongo.IndexModel{
 		{
-			Keys: bson.D{{Key: "webhook_id", Value: 1}},
+			// Matches the InsertEvent upsert key; webhook IDs are only
+			// unique per client.
+			Keys: bson.D{
+				{Key: "webhook_id", Value: 1},
+				{Key: "client_id", Value: 1},
+			},
 		},
 		{
 			Keys: bson.D{{Key: "client_id", Value: 1}},
@@ -77,21 +213,118 @@ func NewMongoDB(uri, database, collection string, logger *zap.Logger) (*MongoDB,
 				{Key: "campaign_id", Value: 1},
 			},
 		},
+		{
+			// Campaign-level events have no email, so they're found by
+			// campaign; the filter keeps subscriber events out of it.
+			Keys: bson.D{
+				{Key: "client_id", Value: 1},
+				{Key: "campaign_id", Value: 1},
+				{Key: "received_at", Value: -1},
+			},
+			Options: options.Index().
+				SetName("campaign_level_events").
+				SetPartialFilterExpression(bson.M{"level": string(models.EventLevelCampaign)}),
+		},
 	}
+	indexes = append(indexes, m.extraIndexes...)
 
-	_, err = coll.Indexes().CreateMany(ctx, indexes)
-	if err != nil {
-		return nil, err
+	if _, err := coll.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
 	}
+	return m.createRetentionIndex(ctx, coll)
+}
 
-	return &MongoDB{
-		client:     client,
-		collection: coll,
-		logger:     logger,
-	}, nil
+// Retention index names. received_at_ttl is descending so it doesn't clash
+// with the plain received_at index above.
+const (
+	retentionIndexName = "received_at_ttl"
+	expiryIndexName    = "expires_at_ttl"
+)
+
+// createRetentionIndex creates the TTL index for the configured retention:
+// on expires_at when retention varies by event type, otherwise on
+// received_at. MongoDB won't change expireAfterSeconds on an existing index
+// through createIndexes, so changing the global retention means dropping
+// received_at_ttl (or using collMod) first; until then startup fails with an
+// index options conflict. Likewise received_at_ttl must be dropped when
+// switching to per-type retention, or it keeps expiring every event after
+// the global retention. Disabling retention leaves an existing index in place.
+func (m *MongoDB) createRetentionIndex(ctx context.Context, coll *mongo.Collection) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "received_at", Value: -1}},
+		Options: options.Index().
+			SetName(retentionIndexName).
+			SetExpireAfterSeconds(int32(m.retention / time.Second)),
+	}
+	switch {
+	case len(m.eventRetention) > 0:
+		// Each document carries its own expiry, so the index expires
+		// them as soon as it passes
+		index = mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName(expiryIndexName).SetExpireAfterSeconds(0),
+		}
+	case m.retention <= 0:
+		return nil
+	}
+
+	if _, err := coll.Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create %s index: %v", *index.Options.Name, err)
+	}
+	return nil
+}
+
+// expiresAt returns when event expires under the per-event-type retention,
+// falling back to the global one for other types, or the zero time if it
+// is kept indefinitely or retention doesn't vary by type.
+func (m *MongoDB) expiresAt(event *models.WebhookEvent) time.Time {
+	if len(m.eventRetention) == 0 {
+		return time.Time{}
+	}
+	retention, ok := m.eventRetention[string(event.Type())]
+	if !ok {
+		retention = m.retention
+	}
+	if retention <= 0 {
+		return time.Time{}
+	}
+	return event.ReceivedAt.Add(retention)
 }
 
 func (m *MongoDB) InsertEvent(ctx context.Context, event *models.WebhookEvent) error {
+	doc, err := m.eventDocument(event)
+	if err != nil {
+		return err
+	}
+
+	// Upsert on (webhook_id, client_id) so redeliveries and re-published
+	// events don't create duplicate documents.
+	coll, err := m.writeCollection(ctx, event.ReceivedAt)
+	if err == nil {
+		_, err = coll.ReplaceOne(ctx, eventFilter(event), doc, options.Replace().SetUpsert(true))
+	}
+	if err != nil {
+		m.logger.Error("Failed to insert event",
+			zap.Error(err),
+			zap.String("client_id", event.ClientID),
+			zap.String("webhook_id", event.WebhookID))
+		return err
+	}
+	recordStoredBytes(string(event.Type()), doc)
+	return nil
+}
+
+// eventFilter matches event's document by (webhook_id, client_id).
+func eventFilter(event *models.WebhookEvent) bson.M {
+	return bson.M{
+		"webhook_id": event.WebhookID,
+		"client_id":  event.ClientID,
+	}
+}
+
+// eventDocument returns the document stored for event, defaulting its status
+// to pending.
+func (m *MongoDB) eventDocument(event *models.WebhookEvent) (bson.M, error) {
 	// Initialize event status if not set
 	if event.Status == "" {
 		event.Status = string(models.EventStatusPending)
@@ -102,54 +335,109 @@ func (m *MongoDB) InsertEvent(ctx context.Context, event *models.WebhookEvent) e
 		"webhook_type": event.WebhookType,
 		"client_id":    event.ClientID,
 		"event":        event.Event,
+		"event_type":   string(event.Type()),
+		"level":        string(event.Level()),
 		"received_at":  event.ReceivedAt,
 		"status":       event.Status,
 		"retry_count":  event.RetryCount,
 	}
 
-	// Add optional fields only if they have values
+	for key, value := range parsedFields(event) {
+		doc[key] = value
+	}
+	if event.TimestampSkewed {
+		doc["ts_skewed"] = true
+		doc["ts_skew_seconds"] = event.TimestampSkewSeconds
+	}
+	if event.CorrelationID != "" {
+		doc["correlation_id"] = event.CorrelationID
+	}
+	if event.Source != "" {
+		doc["source"] = event.Source
+	}
+	if expiresAt := m.expiresAt(event); !expiresAt.IsZero() {
+		doc["expires_at"] = expiresAt
+	}
+	if event.RawPayload != nil {
+		raw, err := m.rawPayloadValue(event.RawPayload)
+		if err != nil {
+			return nil, err
+		}
+		doc["raw_payload"] = raw
+	}
+	return doc, nil
+}
+
+// recordStoredBytes adds the BSON size of doc to the stored-bytes counter for
+// its event type. It ignores indexes and storage-engine overhead, and counts
+// redeliveries again, so it is a guide to relative growth only.
+func recordStoredBytes(eventType string, doc bson.M) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	metrics.StoredBytes.WithLabelValues(eventType).Add(float64(len(raw)))
+}
+
+// parsedFieldNames are the optional fields derived from the payload by the
+// parser, as written by parsedFields.
+var parsedFieldNames = []string{
+	"campaign_id", "campaign_name", "tag_name", "date_event", "url",
+	"email", "emails", "invalid_emails", "list_id", "reason", "custom_fields",
+}
+
+// parsedFields returns the optional payload-derived fields of event that
+// have values.
+func parsedFields(event *models.WebhookEvent) bson.M {
+	fields := bson.M{}
 	if event.CampaignID != "" {
-		doc["campaign_id"] = event.CampaignID
+		fields["campaign_id"] = event.CampaignID
 	}
 	if event.CampaignName != "" {
-		doc["campaign_name"] = event.CampaignName
+		fields["campaign_name"] = event.CampaignName
 	}
 	if event.TagName != "" {
-		doc["tag_name"] = event.TagName
+		fields["tag_name"] = event.TagName
 	}
 	if event.DateEvent != "" {
-		doc["date_event"] = event.DateEvent
+		fields["date_event"] = event.DateEvent
 	}
 	if event.URL != "" {
-		doc["url"] = event.URL
+		fields["url"] = event.URL
 	}
 	if event.Email != "" {
-		doc["email"] = event.Email
+		fields["email"] = event.Email
 	}
 	if len(event.Emails) > 0 {
-		doc["emails"] = event.Emails
+		fields["emails"] = event.Emails
+	}
+	if len(event.InvalidEmails) > 0 {
+		fields["invalid_emails"] = event.InvalidEmails
 	}
 	if event.ListID != nil {
-		doc["list_id"] = event.ListID
+		fields["list_id"] = event.ListID
 	}
 	if event.Reason != "" {
-		doc["reason"] = event.Reason
+		fields["reason"] = event.Reason
 	}
-
-	_, err := m.collection.InsertOne(ctx, doc)
-	if err != nil {
-		m.logger.Error("Failed to insert event",
-			zap.Error(err),
-			zap.String("client_id", event.ClientID),
-			zap.String("webhook_id", event.WebhookID))
-		return err
+	if len(event.CustomFields) > 0 {
+		fields["custom_fields"] = event.CustomFields
 	}
-	return nil
+	return fields
 }
 
 func (m *MongoDB) UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error {
 	filter := bson.M{
 		"webhook_id": event.WebhookID,
+		"client_id":  event.ClientID,
+	}
+	// Only match documents that would actually change, so repeated
+	// identical updates don't cost a write or bump updated_at
+	if m.skipNoopStatusUpdates {
+		filter["$or"] = bson.A{
+			bson.M{"status": bson.M{"$ne": status}},
+			bson.M{"retry_count": bson.M{"$ne": event.RetryCount}},
+		}
 	}
 
 	update := bson.M{
@@ -160,8 +448,26 @@ func (m *MongoDB) UpdateEventStatus(ctx context.Context, event *models.WebhookEv
 		},
 	}
 
-	_, err := m.collection.UpdateOne(ctx, filter, update)
-	return err
+	colls, err := m.eventCollections(ctx, event)
+	if err != nil {
+		return err
+	}
+	var matched int64
+	for _, coll := range colls {
+		result, err := coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if matched = result.MatchedCount; matched > 0 {
+			break
+		}
+	}
+	if matched == 0 {
+		m.logger.Debug("Status update skipped: unchanged or event not found",
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("status", string(status)))
+	}
+	return nil
 }
 
 func (m *MongoDB) GetFailedEvents(ctx context.Context, clientID string) ([]*models.WebhookEvent, error) {
@@ -169,21 +475,154 @@ func (m *MongoDB) GetFailedEvents(ctx context.Context, clientID string) ([]*mode
 		"client_id": clientID,
 		"status":    models.EventStatusFailed,
 	}
+	return m.findAll(ctx, filter)
+}
 
-	cursor, err := m.collection.Find(ctx, filter)
+// FailedEventFinder finds failed events by when they were received.
+type FailedEventFinder interface {
+	GetFailedEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time) ([]*models.WebhookEvent, error)
+}
+
+var (
+	_ FailedEventFinder = (*MongoDB)(nil)
+	_ FailedEventFinder = (*ClientRouter)(nil)
+)
+
+// GetFailedEventsReceivedBetween returns the failed events received in
+// [from, to). Zero bounds are open, and an empty clientID matches every
+// client.
+func (m *MongoDB) GetFailedEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time) ([]*models.WebhookEvent, error) {
+	opts := QueryOptions{From: from, To: to, Status: models.EventStatusFailed}
+	if _, err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	filter := opts.filter(clientID)
+	if clientID == "" {
+		delete(filter, "client_id")
+	}
+	return m.findReceivedBetween(ctx, filter, from, to)
+}
+
+// findAll returns every event matching filter across the event collections.
+func (m *MongoDB) findAll(ctx context.Context, filter bson.M) ([]*models.WebhookEvent, error) {
+	return m.findReceivedBetween(ctx, filter, time.Time{}, time.Time{})
+}
+
+// findReceivedBetween returns every event matching filter across the event
+// collections that may hold events received in [from, to).
+func (m *MongoDB) findReceivedBetween(ctx context.Context, filter bson.M, from, to time.Time) ([]*models.WebhookEvent, error) {
+	colls, err := m.readCollections(ctx, from, to)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
 	var events []*models.WebhookEvent
-	if err = cursor.All(ctx, &events); err != nil {
-		return nil, err
+	for _, coll := range colls {
+		cursor, err := coll.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		batch, err := decodeEvents(ctx, cursor)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, batch...)
 	}
 
 	return events, nil
 }
 
+// GetEventByWebhookID returns the stored event with the given webhook ID,
+// optionally scoped to a client. It returns ErrEventNotFound if none exists.
+func (m *MongoDB) GetEventByWebhookID(ctx context.Context, webhookID, clientID string) (*models.WebhookEvent, error) {
+	filter := bson.M{"webhook_id": webhookID}
+	if clientID != "" {
+		filter["client_id"] = clientID
+	}
+
+	colls, err := m.readCollections(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	for _, coll := range colls {
+		var doc storedEvent
+		if err := coll.FindOne(ctx, filter).Decode(&doc); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				continue
+			}
+			return nil, err
+		}
+		return doc.event()
+	}
+
+	return nil, ErrEventNotFound
+}
+
+// GetStaleRetryingEvents returns events that have been in retrying status
+// since before the given time.
+func (m *MongoDB) GetStaleRetryingEvents(ctx context.Context, before time.Time) ([]*models.WebhookEvent, error) {
+	filter := bson.M{
+		"status":     models.EventStatusRetrying,
+		"updated_at": bson.M{"$lt": before},
+	}
+	return m.findAll(ctx, filter)
+}
+
+// CountReceivedBetween counts stored events received in [from, to).
+func (m *MongoDB) CountReceivedBetween(ctx context.Context, from, to time.Time) (int64, error) {
+	colls, err := m.readCollections(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, coll := range colls {
+		n, err := coll.CountDocuments(ctx, bson.M{
+			"received_at": bson.M{"$gte": from, "$lt": to},
+		})
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// RecordClientError stores err as the client's most recent processing failure.
+func (m *MongoDB) RecordClientError(ctx context.Context, event *models.WebhookEvent, errMsg string, at time.Time) error {
+	filter := bson.M{"client_id": event.ClientID}
+	update := bson.M{
+		"$set": bson.M{
+			"last_error":    errMsg,
+			"last_error_at": at,
+			"webhook_id":    event.WebhookID,
+			"event":         event.Event,
+		},
+	}
+
+	_, err := m.clientErrors.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetClientLastError returns the client's most recent processing failure, or
+// nil if none has been recorded.
+func (m *MongoDB) GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error) {
+	var clientErr models.ClientError
+	if err := m.clientErrors.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&clientErr); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &clientErr, nil
+}
+
+// Ping checks that MongoDB is reachable.
+func (m *MongoDB) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, nil)
+}
+
 func (m *MongoDB) Close(ctx context.Context) error {
 	return m.client.Disconnect(ctx)
 }
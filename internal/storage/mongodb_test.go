@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+// updateFilter returns the filter of the single update statement sent.
+func updateFilter(mt *mtest.T) bson.Raw {
+	started := mt.GetStartedEvent()
+	require.NotNil(mt, started)
+	require.Equal(mt, "update", started.CommandName)
+	updates := started.Command.Lookup("updates").Array()
+	statement, err := updates.IndexErr(0)
+	require.NoError(mt, err)
+	return statement.Value().Document().Lookup("q").Document()
+}
+
+func TestUpdateEventStatusSkipsNoop(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unchanged status matches nothing", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop(), skipNoopStatusUpdates: true}
+		// The server finds no document needing a change, so nothing is written
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+
+		event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", RetryCount: 2}
+		require.NoError(mt, m.UpdateEventStatus(context.Background(), event, models.EventStatusRetrying))
+
+		filter := updateFilter(mt)
+		assert.Equal(mt, "wh-1", filter.Lookup("webhook_id").StringValue())
+		assert.Equal(mt, "client-a", filter.Lookup("client_id").StringValue())
+
+		conditions, err := filter.Lookup("$or").Array().Values()
+		require.NoError(mt, err)
+		require.Len(mt, conditions, 2)
+		assert.Equal(mt, "retrying", conditions[0].Document().Lookup("status", "$ne").StringValue())
+		assert.Equal(mt, int32(2), conditions[1].Document().Lookup("retry_count", "$ne").Int32())
+	})
+
+	mt.Run("disabled writes unconditionally", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a"}
+		require.NoError(mt, m.UpdateEventStatus(context.Background(), event, models.EventStatusProcessed))
+
+		_, err := updateFilter(mt).LookupErr("$or")
+		assert.Error(mt, err, "no status condition when dedup is disabled")
+	})
+}
+
+func TestCreateIndexesIncludesConfigured(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("configured index created", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		WithIndexes([]config.IndexConfig{{
+			Name:   "email_event",
+			Keys:   []config.IndexKeyConfig{{Field: "email", Direction: 1}, {Field: "event", Direction: -1}},
+			Sparse: true,
+		}})(m)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		require.NoError(mt, m.createIndexes(context.Background()))
+
+		started := mt.GetStartedEvent()
+		require.NotNil(mt, started)
+		require.Equal(mt, "createIndexes", started.CommandName)
+		indexes, err := started.Command.Lookup("indexes").Array().Values()
+		require.NoError(mt, err)
+
+		var configured bson.Raw
+		for _, index := range indexes {
+			if index.Document().Lookup("name").StringValue() == "email_event" {
+				configured = index.Document()
+			}
+		}
+		require.NotNil(mt, configured, "configured index sent with the built-in ones")
+		assert.Greater(mt, len(indexes), 1)
+		keys, err := configured.Lookup("key").Document().Elements()
+		require.NoError(mt, err)
+		require.Len(mt, keys, 2)
+		assert.Equal(mt, "email", keys[0].Key())
+		assert.Equal(mt, int32(1), keys[0].Value().Int32())
+		assert.Equal(mt, "event", keys[1].Key())
+		assert.Equal(mt, int32(-1), keys[1].Value().Int32())
+		assert.True(mt, configured.Lookup("sparse").Boolean())
+	})
+}
+
+func TestCreateIndexesRetention(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("ttl index created after the built-in ones", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		WithRetention(30 * 24 * time.Hour)(m)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		require.NoError(mt, m.createIndexes(context.Background()))
+
+		builtIn := mt.GetStartedEvent()
+		require.NotNil(mt, builtIn)
+		indexes, err := builtIn.Command.Lookup("indexes").Array().Values()
+		require.NoError(mt, err)
+		for _, index := range indexes {
+			_, err := index.Document().LookupErr("expireAfterSeconds")
+			assert.Error(mt, err, "built-in indexes are unchanged")
+		}
+
+		ttl := mt.GetStartedEvent()
+		require.NotNil(mt, ttl)
+		require.Equal(mt, "createIndexes", ttl.CommandName)
+		indexes, err = ttl.Command.Lookup("indexes").Array().Values()
+		require.NoError(mt, err)
+		require.Len(mt, indexes, 1)
+		index := indexes[0].Document()
+		assert.Equal(mt, "received_at_ttl", index.Lookup("name").StringValue())
+		assert.Equal(mt, int32(-1), index.Lookup("key", "received_at").Int32())
+		assert.Equal(mt, int32(30*24*60*60), index.Lookup("expireAfterSeconds").Int32())
+	})
+
+	mt.Run("no ttl index without retention", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		require.NoError(mt, m.createIndexes(context.Background()))
+
+		require.NotNil(mt, mt.GetStartedEvent())
+		assert.Nil(mt, mt.GetStartedEvent(), "only the built-in indexes are created")
+	})
+}
+
+func TestExpiresAtByEventType(t *testing.T) {
+	receivedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	m := &MongoDB{}
+	WithRetention(30 * day)(m)
+	WithEventRetention(map[string]time.Duration{"delivered": 7 * day, "bounce": 365 * day, "spam": 0})(m)
+
+	tests := []struct {
+		event string
+		want  time.Time
+	}{
+		{event: "delivered", want: receivedAt.Add(7 * day)},
+		{event: "Bounce", want: receivedAt.Add(365 * day)},
+		{event: "bounced", want: receivedAt.Add(365 * day)},
+		{event: "hard_bounce", want: receivedAt.Add(365 * day)},
+		{event: "Soft Bounce", want: receivedAt.Add(365 * day)},
+		{event: "delivery", want: receivedAt.Add(7 * day)},
+		{event: "opened", want: receivedAt.Add(30 * day)},
+		{event: "made_up", want: receivedAt.Add(30 * day)},
+		{event: "spam"},
+		{event: "complaint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.expiresAt(&models.WebhookEvent{Event: tt.event, ReceivedAt: receivedAt}))
+		})
+	}
+
+	global := &MongoDB{retention: 30 * day}
+	assert.True(t, global.expiresAt(&models.WebhookEvent{Event: "delivered", ReceivedAt: receivedAt}).IsZero(),
+		"a single retention is enforced on received_at instead")
+}
+
+func TestEventRetentionExpiresByField(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	retention := map[string]time.Duration{"delivered": 7 * 24 * time.Hour, "bounce": 365 * 24 * time.Hour}
+
+	mt.Run("ttl index on expires_at", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		WithRetention(30 * 24 * time.Hour)(m)
+		WithEventRetention(retention)(m)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		require.NoError(mt, m.createIndexes(context.Background()))
+
+		require.NotNil(mt, mt.GetStartedEvent())
+		indexes, err := mt.GetStartedEvent().Command.Lookup("indexes").Array().Values()
+		require.NoError(mt, err)
+		require.Len(mt, indexes, 1, "no received_at ttl index alongside it")
+		index := indexes[0].Document()
+		assert.Equal(mt, "expires_at_ttl", index.Lookup("name").StringValue())
+		assert.Equal(mt, int32(1), index.Lookup("key", "expires_at").Int32())
+		assert.Equal(mt, int32(0), index.Lookup("expireAfterSeconds").Int32())
+	})
+
+	mt.Run("expiry stored per event type", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		WithEventRetention(retention)(m)
+		receivedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := func(eventType string) time.Time {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+			require.NoError(mt, m.InsertEvent(context.Background(), &models.WebhookEvent{
+				WebhookID: "wh-" + eventType, ClientID: "client-a", Event: eventType, ReceivedAt: receivedAt,
+			}))
+			statement, err := mt.GetStartedEvent().Command.Lookup("updates").Array().IndexErr(0)
+			require.NoError(mt, err)
+			return statement.Value().Document().Lookup("u", "expires_at").Time().UTC()
+		}
+
+		assert.Equal(mt, receivedAt.AddDate(0, 0, 7), expiry("delivered"))
+		assert.Equal(mt, receivedAt.AddDate(0, 0, 365), expiry("bounce"))
+	})
+}
+
+func TestDecodeRawPayloadMatchesJSONTypes(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"event":  "opened",
+		"ts":     int64(1717200000),
+		"emails": bson.A{"a@example.com"},
+		"meta":   bson.M{"source": "api"},
+	})
+	require.NoError(t, err)
+
+	payload, err := decodeRawPayload(raw)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"event":  "opened",
+		"ts":     float64(1717200000),
+		"emails": []interface{}{"a@example.com"},
+		"meta":   map[string]interface{}{"source": "api"},
+	}, payload)
+}
+
+func TestInsertEventStoresLevel(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("campaign and subscriber events", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		level := func(event *models.WebhookEvent) string {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+			require.NoError(mt, m.InsertEvent(context.Background(), event))
+			statement, err := mt.GetStartedEvent().Command.Lookup("updates").Array().IndexErr(0)
+			require.NoError(mt, err)
+			return statement.Value().Document().Lookup("u", "level").StringValue()
+		}
+
+		assert.Equal(mt, "campaign", level(&models.WebhookEvent{WebhookID: "wh-1", Event: "Campaign Sent", CampaignID: "c-1"}))
+		assert.Equal(mt, "subscriber", level(&models.WebhookEvent{WebhookID: "wh-2", Event: "opened", Email: "a@example.com"}))
+	})
+
+	mt.Run("canonical event type alongside the raw one", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		require.NoError(mt, m.InsertEvent(context.Background(), &models.WebhookEvent{WebhookID: "wh-1", Event: "hard_bounce", Email: "a@example.com"}))
+		statement, err := mt.GetStartedEvent().Command.Lookup("updates").Array().IndexErr(0)
+		require.NoError(mt, err)
+		doc := statement.Value().Document()
+		assert.Equal(mt, "hard_bounce", doc.Lookup("u", "event").StringValue())
+		assert.Equal(mt, "bounce", doc.Lookup("u", "event_type").StringValue())
+	})
+
+	mt.Run("campaign index only covers campaign events", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		require.NoError(mt, m.createIndexes(context.Background()))
+
+		indexes, err := mt.GetStartedEvent().Command.Lookup("indexes").Array().Values()
+		require.NoError(mt, err)
+		var campaign bson.Raw
+		for _, index := range indexes {
+			if index.Document().Lookup("name").StringValue() == "campaign_level_events" {
+				campaign = index.Document()
+			}
+		}
+		require.NotNil(mt, campaign)
+		assert.Equal(mt, "campaign", campaign.Lookup("partialFilterExpression", "level").StringValue())
+	})
+}
+
+func TestInsertEventCountsStoredBytes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("counter grows by document size", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		counter := metrics.StoredBytes.WithLabelValues("bounce")
+
+		insert := func(event *models.WebhookEvent) (added float64, docSize int) {
+			before := testutil.ToFloat64(counter)
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+			require.NoError(mt, m.InsertEvent(context.Background(), event))
+
+			started := mt.GetStartedEvent()
+			require.NotNil(mt, started)
+			statement, err := started.Command.Lookup("updates").Array().IndexErr(0)
+			require.NoError(mt, err)
+			replacement := statement.Value().Document().Lookup("u").Document()
+			return testutil.ToFloat64(counter) - before, len(replacement)
+		}
+
+		smallAdded, smallSize := insert(&models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Event: "bounced"})
+		largeAdded, largeSize := insert(&models.WebhookEvent{
+			WebhookID: "wh-2", ClientID: "client-a", Event: "bounced",
+			Reason: strings.Repeat("mailbox full ", 100),
+		})
+
+		assert.Equal(mt, float64(smallSize), smallAdded)
+		assert.Equal(mt, float64(largeSize), largeAdded)
+		assert.Greater(mt, largeAdded-smallAdded, float64(1000), "a longer reason is counted")
+	})
+}
+
+func TestInsertEventStoresRawPayload(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("only when the event carries one", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+
+		replacement := func(event *models.WebhookEvent) bson.Raw {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+			require.NoError(mt, m.InsertEvent(context.Background(), event))
+			statement, err := mt.GetStartedEvent().Command.Lookup("updates").Array().IndexErr(0)
+			require.NoError(mt, err)
+			return statement.Value().Document().Lookup("u").Document()
+		}
+
+		doc := replacement(&models.WebhookEvent{
+			WebhookID: "wh-1", ClientID: "client-a", Event: "bounced",
+			RawPayload: map[string]interface{}{"event": "bounced", "x_new_field": "kept"},
+		})
+		raw := doc.Lookup("raw_payload").Document()
+		assert.Equal(mt, "bounced", raw.Lookup("event").StringValue())
+		assert.Equal(mt, "kept", raw.Lookup("x_new_field").StringValue())
+
+		doc = replacement(&models.WebhookEvent{WebhookID: "wh-2", ClientID: "client-a", Event: "bounced"})
+		_, err := doc.LookupErr("raw_payload")
+		assert.Error(mt, err, "no raw_payload field without a payload")
+	})
+}
+
+func TestCompressedRawPayloadRoundTrip(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	payload := map[string]interface{}{
+		"event":  "bounced",
+		"ts":     float64(1717200000),
+		"emails": []interface{}{"a@example.com"},
+		"reason": strings.Repeat("mailbox full ", 50),
+	}
+
+	// stored inserts an event and returns the document that was written,
+	// with an _id as MongoDB would assign.
+	stored := func(mt *mtest.T, m *MongoDB) bson.D {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		require.NoError(mt, m.InsertEvent(context.Background(), &models.WebhookEvent{
+			WebhookID: "wh-1", ClientID: "client-a", Event: "bounced", RawPayload: payload,
+		}))
+		statement, err := mt.GetStartedEvent().Command.Lookup("updates").Array().IndexErr(0)
+		require.NoError(mt, err)
+		var doc bson.D
+		require.NoError(mt, bson.Unmarshal(statement.Value().Document().Lookup("u").Document(), &doc))
+		return append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+	}
+
+	mt.Run("stored as gzipped binary", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop(), compressRawPayload: true}
+		doc := stored(mt, m)
+
+		raw, err := bson.Marshal(doc)
+		require.NoError(mt, err)
+		subtype, data := bson.Raw(raw).Lookup("raw_payload").Binary()
+		assert.Equal(mt, bsontype.BinaryGeneric, subtype)
+		encoded, err := json.Marshal(payload)
+		require.NoError(mt, err)
+		assert.Less(mt, len(data), len(encoded)/2, "the repetitive payload compresses")
+	})
+
+	mt.Run("decompressed when read back", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop(), compressRawPayload: true}
+		doc := stored(mt, m)
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc))
+		event, err := m.GetEventByWebhookID(context.Background(), "wh-1", "client-a")
+		require.NoError(mt, err)
+		assert.Equal(mt, "bounced", event.Event)
+		assert.Equal(mt, payload, event.RawPayload)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc))
+		events, err := m.ScanRawPayloads(context.Background(), "", 10)
+		require.NoError(mt, err)
+		require.Len(mt, events, 1)
+		assert.Equal(mt, payload, events[0].Payload, "reparsing sees the original payload")
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, doc))
+		window := time.Now().Add(-time.Hour)
+		replayed, err := m.ScanEventsReceivedBetween(context.Background(), "client-a", window, time.Now(), ReplayOffset{}, 10)
+		require.NoError(mt, err)
+		require.Len(mt, replayed, 1)
+		assert.Equal(mt, payload, replayed[0].Event.RawPayload, "replay republishes the original payload")
+	})
+
+	mt.Run("uncompressed payloads still read", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop(), compressRawPayload: true}
+		doc := stored(mt, &MongoDB{collection: mt.Coll, logger: zap.NewNop()})
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+mt.Coll.Name(), mtest.FirstBatch, doc))
+		events, err := m.GetFailedEvents(context.Background(), "client-a")
+		require.NoError(mt, err)
+		require.Len(mt, events, 1)
+		assert.Equal(mt, payload, events[0].RawPayload)
+	})
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page size bounds for GetEventsByClient.
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 500
+)
+
+// EventQuerier pages through a client's stored events.
+type EventQuerier interface {
+	GetEventsByClient(ctx context.Context, clientID string, opts QueryOptions) ([]*models.WebhookEvent, int64, error)
+}
+
+var (
+	_ EventQuerier = (*MongoDB)(nil)
+	_ EventQuerier = (*ClientRouter)(nil)
+)
+
+// ErrInvalidTimeRange is returned when a query's To is before its From.
+var ErrInvalidTimeRange = errors.New("invalid time range: to is before from")
+
+// QueryOptions filters and pages a client's events. Zero values mean no
+// filter; Limit defaults to DefaultQueryLimit and is capped at
+// MaxQueryLimit.
+type QueryOptions struct {
+	Limit  int
+	Offset int
+	// From (inclusive) and To (exclusive) bound received_at.
+	From time.Time
+	To   time.Time
+	// Event and Status match the event type and processing status exactly.
+	Event  string
+	Status models.EventStatus
+	// Ascending returns the oldest events first instead of the newest.
+	Ascending bool
+}
+
+// normalize applies the defaults and bounds to o.
+func (o QueryOptions) normalize() (QueryOptions, error) {
+	if !o.From.IsZero() && !o.To.IsZero() && o.To.Before(o.From) {
+		return o, ErrInvalidTimeRange
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultQueryLimit
+	}
+	if o.Limit > MaxQueryLimit {
+		o.Limit = MaxQueryLimit
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	return o, nil
+}
+
+// filter builds the query for clientID's events matching o.
+func (o QueryOptions) filter(clientID string) bson.M {
+	filter := bson.M{"client_id": clientID}
+
+	receivedAt := bson.M{}
+	if !o.From.IsZero() {
+		receivedAt["$gte"] = o.From
+	}
+	if !o.To.IsZero() {
+		receivedAt["$lt"] = o.To
+	}
+	if len(receivedAt) > 0 {
+		filter["received_at"] = receivedAt
+	}
+
+	if o.Event != "" {
+		filter["event"] = o.Event
+	}
+	if o.Status != "" {
+		filter["status"] = o.Status
+	}
+	return filter
+}
+
+// GetEventsByClient returns one page of clientID's events matching opts,
+// newest first unless opts.Ascending is set, along with the total number of
+// matching events for pagination. With monthly collections the page may
+// span several buckets; since each bucket covers a distinct month they are
+// read in order, skipping whole buckets that fall before the offset.
+func (m *MongoDB) GetEventsByClient(ctx context.Context, clientID string, opts QueryOptions) ([]*models.WebhookEvent, int64, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, 0, err
+	}
+	filter := opts.filter(clientID)
+
+	colls, err := m.readCollections(ctx, opts.From, opts.To)
+	if err != nil {
+		return nil, 0, err
+	}
+	order := -1
+	if opts.Ascending {
+		order = 1
+		for i, j := 0, len(colls)-1; i < j; i, j = i+1, j-1 {
+			colls[i], colls[j] = colls[j], colls[i]
+		}
+	}
+
+	counts := make([]int64, len(colls))
+	var total int64
+	for i, coll := range colls {
+		if counts[i], err = coll.CountDocuments(ctx, filter); err != nil {
+			return nil, 0, err
+		}
+		total += counts[i]
+	}
+
+	events := []*models.WebhookEvent{}
+	skip := int64(opts.Offset)
+	for i, coll := range colls {
+		remaining := int64(opts.Limit - len(events))
+		if remaining <= 0 {
+			break
+		}
+		if skip >= counts[i] {
+			skip -= counts[i]
+			continue
+		}
+
+		findOpts := options.Find().
+			SetSort(bson.D{{Key: "received_at", Value: order}}).
+			SetSkip(skip).
+			SetLimit(remaining)
+		skip = 0
+
+		cursor, err := coll.Find(ctx, filter, findOpts)
+		if err != nil {
+			return nil, 0, err
+		}
+		page, err := decodeEvents(ctx, cursor)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, page...)
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+// mockEventsPage queues the count and find responses for GetEventsByClient.
+func mockEventsPage(mt *mtest.T, total int64, docs ...bson.D) {
+	ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+	var countBatch []bson.D
+	if total > 0 {
+		countBatch = append(countBatch, bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: total}})
+	}
+	mt.AddMockResponses(
+		mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, countBatch...),
+		mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...),
+	)
+}
+
+// findCommand returns the find command sent after the count.
+func findCommand(mt *mtest.T) bson.Raw {
+	count := mt.GetStartedEvent()
+	require.NotNil(mt, count)
+	require.Equal(mt, "aggregate", count.CommandName)
+	find := mt.GetStartedEvent()
+	require.NotNil(mt, find)
+	require.Equal(mt, "find", find.CommandName)
+	return find.Command
+}
+
+func TestGetEventsByClient(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("defaults", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mockEventsPage(mt, 120,
+			bson.D{{Key: "webhook_id", Value: "wh-2"}, {Key: "client_id", Value: "client-a"}},
+			bson.D{{Key: "webhook_id", Value: "wh-1"}, {Key: "client_id", Value: "client-a"}},
+		)
+
+		events, total, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{})
+		require.NoError(mt, err)
+		assert.Equal(mt, int64(120), total)
+		require.Len(mt, events, 2)
+		assert.Equal(mt, "wh-2", events[0].WebhookID)
+
+		cmd := findCommand(mt)
+		filter := cmd.Lookup("filter").Document()
+		assert.Equal(mt, "client-a", filter.Lookup("client_id").StringValue())
+		_, err = filter.LookupErr("received_at")
+		assert.Error(mt, err, "no time filter by default")
+		assert.Equal(mt, int64(DefaultQueryLimit), cmd.Lookup("limit").AsInt64())
+		assert.Zero(mt, cmd.Lookup("skip").AsInt64())
+		assert.Equal(mt, int32(-1), cmd.Lookup("sort", "received_at").Int32(), "newest first")
+	})
+
+	mt.Run("limit capped and offset clamped", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mockEventsPage(mt, 1)
+
+		_, _, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{Limit: 10000, Offset: -5, Ascending: true})
+		require.NoError(mt, err)
+
+		cmd := findCommand(mt)
+		assert.Equal(mt, int64(MaxQueryLimit), cmd.Lookup("limit").AsInt64())
+		assert.Zero(mt, cmd.Lookup("skip").AsInt64(), "negative offset treated as zero")
+		assert.Equal(mt, int32(1), cmd.Lookup("sort", "received_at").Int32())
+	})
+
+	mt.Run("filters and offset", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mockEventsPage(mt, 200)
+		from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		to := from.Add(24 * time.Hour)
+
+		_, _, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{
+			Limit: MaxQueryLimit, Offset: 100, From: from, To: to, Event: "bounced", Status: models.EventStatusFailed,
+		})
+		require.NoError(mt, err)
+
+		cmd := findCommand(mt)
+		filter := cmd.Lookup("filter").Document()
+		assert.Equal(mt, from, filter.Lookup("received_at", "$gte").Time().UTC())
+		assert.Equal(mt, to, filter.Lookup("received_at", "$lt").Time().UTC())
+		assert.Equal(mt, "bounced", filter.Lookup("event").StringValue())
+		assert.Equal(mt, "failed", filter.Lookup("status").StringValue())
+		assert.Equal(mt, int64(MaxQueryLimit), cmd.Lookup("limit").AsInt64(), "the maximum itself is allowed")
+		assert.Equal(mt, int64(100), cmd.Lookup("skip").AsInt64())
+	})
+
+	mt.Run("no matches", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mockEventsPage(mt, 0)
+
+		events, total, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{})
+		require.NoError(mt, err)
+		assert.Zero(mt, total)
+		assert.NotNil(mt, events)
+		assert.Empty(mt, events)
+	})
+
+	mt.Run("inverted time range", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		from := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+
+		_, _, err := m.GetEventsByClient(context.Background(), "client-a", QueryOptions{From: from, To: from.Add(-time.Hour)})
+		assert.ErrorIs(mt, err, ErrInvalidTimeRange)
+		assert.Nil(mt, mt.GetStartedEvent(), "nothing is sent to MongoDB")
+	})
+}
+
+func TestGetFailedEventsReceivedBetween(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := func(mt *mtest.T) string { return mt.Coll.Database().Name() + "." + mt.Coll.Name() }
+
+	mt.Run("client and window", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns(mt), mtest.FirstBatch,
+			bson.D{{Key: "webhook_id", Value: "wh-1"}, {Key: "client_id", Value: "client-a"}, {Key: "status", Value: "failed"}},
+		))
+		from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		to := from.Add(24 * time.Hour)
+
+		events, err := m.GetFailedEventsReceivedBetween(context.Background(), "client-a", from, to)
+		require.NoError(mt, err)
+		require.Len(mt, events, 1)
+		assert.Equal(mt, "wh-1", events[0].WebhookID)
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		assert.Equal(mt, "client-a", filter.Lookup("client_id").StringValue())
+		assert.Equal(mt, "failed", filter.Lookup("status").StringValue())
+		assert.Equal(mt, from, filter.Lookup("received_at", "$gte").Time().UTC())
+		assert.Equal(mt, to, filter.Lookup("received_at", "$lt").Time().UTC())
+	})
+
+	mt.Run("every client, open window", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns(mt), mtest.FirstBatch))
+
+		_, err := m.GetFailedEventsReceivedBetween(context.Background(), "", time.Time{}, time.Time{})
+		require.NoError(mt, err)
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		_, err = filter.LookupErr("client_id")
+		assert.Error(mt, err, "no client filter")
+		_, err = filter.LookupErr("received_at")
+		assert.Error(mt, err, "no time filter")
+	})
+
+	mt.Run("inverted time range", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		from := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+
+		_, err := m.GetFailedEventsReceivedBetween(context.Background(), "", from, from.Add(-time.Hour))
+		assert.ErrorIs(mt, err, ErrInvalidTimeRange)
+	})
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RawEvent is a stored event together with the payload it was parsed from.
+type RawEvent struct {
+	// Cursor identifies the document; scans resume after it.
+	Cursor    string
+	WebhookID string
+	ClientID  string
+	// Payload is decoded the way the HTTP handler decodes JSON bodies, so
+	// the parser sees the same types (float64 numbers, []interface{}).
+	Payload map[string]interface{}
+}
+
+type rawEventDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	WebhookID  string             `bson:"webhook_id"`
+	ClientID   string             `bson:"client_id"`
+	RawPayload bson.RawValue      `bson:"raw_payload"`
+}
+
+// storedEvent decodes an event document. raw_payload is taken over from
+// the embedded event so a compressed payload can be decompressed.
+type storedEvent struct {
+	models.WebhookEvent `bson:",inline"`
+	RawPayload          bson.RawValue `bson:"raw_payload,omitempty"`
+}
+
+func (d *storedEvent) event() (*models.WebhookEvent, error) {
+	payload, err := storedRawPayload(d.RawPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raw payload of %s: %v", d.WebhookID, err)
+	}
+	event := d.WebhookEvent
+	event.RawPayload = payload
+	return &event, nil
+}
+
+// decodeEvents decodes every event document left in cursor.
+func decodeEvents(ctx context.Context, cursor *mongo.Cursor) ([]*models.WebhookEvent, error) {
+	var docs []*storedEvent
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	events := make([]*models.WebhookEvent, 0, len(docs))
+	for _, doc := range docs {
+		event, err := doc.event()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// ScanRawPayloads returns up to limit events that have a stored raw_payload,
+// in _id order, starting after cursor. An empty cursor starts at the
+// beginning. With monthly collections the buckets are scanned oldest first
+// and the cursor is prefixed with the bucket name ("events_2024_06/<id>").
+func (m *MongoDB) ScanRawPayloads(ctx context.Context, cursor string, limit int) ([]RawEvent, error) {
+	bucket, after, err := m.parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if !m.monthly {
+		return scanRawPayloads(ctx, m.collection, "", after, limit)
+	}
+
+	colls, err := m.readCollections(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var events []RawEvent
+	for i := len(colls) - 1; i >= 0 && len(events) < limit; i-- {
+		coll := colls[i]
+		if coll.Name() < bucket {
+			continue
+		}
+		if coll.Name() > bucket {
+			after = primitive.NilObjectID
+		}
+		batch, err := scanRawPayloads(ctx, coll, coll.Name()+"/", after, limit-len(events))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, batch...)
+	}
+	return events, nil
+}
+
+// parseCursor splits a ScanRawPayloads cursor into its bucket (empty
+// without monthly collections) and document ID. An empty cursor yields the
+// nil ID.
+func (m *MongoDB) parseCursor(cursor string) (string, primitive.ObjectID, error) {
+	if cursor == "" {
+		return "", primitive.NilObjectID, nil
+	}
+	bucket, hex := "", cursor
+	if m.monthly {
+		var ok bool
+		if bucket, hex, ok = strings.Cut(cursor, "/"); !ok {
+			return "", primitive.NilObjectID, fmt.Errorf("invalid cursor %q: missing collection", cursor)
+		}
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return "", primitive.NilObjectID, fmt.Errorf("invalid cursor %q: %v", cursor, err)
+	}
+	return bucket, id, nil
+}
+
+// scanRawPayloads scans coll for events with a raw_payload after the given
+// ID, prefixing their cursors with prefix.
+func scanRawPayloads(ctx context.Context, coll *mongo.Collection, prefix string, after primitive.ObjectID, limit int) ([]RawEvent, error) {
+	filter := bson.M{"raw_payload": bson.M{"$exists": true}}
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"webhook_id": 1, "client_id": 1, "raw_payload": 1})
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var events []RawEvent
+	for cur.Next(ctx) {
+		var doc rawEventDoc
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		payload, err := storedRawPayload(doc.RawPayload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode raw payload of %s: %v", doc.ID.Hex(), err)
+		}
+		events = append(events, RawEvent{
+			Cursor:    prefix + doc.ID.Hex(),
+			WebhookID: doc.WebhookID,
+			ClientID:  doc.ClientID,
+			Payload:   payload,
+		})
+	}
+	return events, cur.Err()
+}
+
+// rawPayloadValue is the value stored as raw_payload: the payload itself,
+// or its JSON encoding gzipped into a binary when compression is enabled.
+func (m *MongoDB) rawPayloadValue(payload map[string]interface{}) (interface{}, error) {
+	if !m.compressRawPayload {
+		return payload, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(payload); err != nil {
+		return nil, fmt.Errorf("failed to compress raw payload: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress raw payload: %v", err)
+	}
+	return primitive.Binary{Subtype: bsontype.BinaryGeneric, Data: buf.Bytes()}, nil
+}
+
+// storedRawPayload decodes a stored raw_payload in either form. It returns
+// nil if the event has none.
+func storedRawPayload(v bson.RawValue) (map[string]interface{}, error) {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		return decodeRawPayload(v.Document())
+	case bsontype.Binary:
+		_, data := v.Binary()
+		return decompressRawPayload(data)
+	case 0, bsontype.Null:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected BSON type %s", v.Type)
+	}
+}
+
+// decompressRawPayload decodes a payload stored by rawPayloadValue with
+// compression enabled.
+func decompressRawPayload(data []byte) (map[string]interface{}, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(decompressed, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// decodeRawPayload converts a stored payload document back into the shape
+// encoding/json produces for the original request body.
+func decodeRawPayload(raw bson.Raw) (map[string]interface{}, error) {
+	extJSON, err := bson.MarshalExtJSON(raw, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(extJSON, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// UpdateParsedFields rewrites the payload-derived fields of the document
+// identified by cursor from event, removing fields the parser no longer
+// produces.
+func (m *MongoDB) UpdateParsedFields(ctx context.Context, cursor string, event *models.WebhookEvent) error {
+	bucket, id, err := m.parseCursor(cursor)
+	if err != nil {
+		return err
+	}
+	coll := m.collection
+	if m.monthly {
+		coll = m.db.Collection(bucket)
+	}
+
+	set := parsedFields(event)
+	set["event"] = event.Event
+	set["event_type"] = string(event.Type())
+	set["level"] = string(event.Level())
+	unset := bson.M{}
+	for _, name := range parsedFieldNames {
+		if _, ok := set[name]; !ok {
+			unset[name] = ""
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err = coll.UpdateByID(ctx, id, update)
+	return err
+}
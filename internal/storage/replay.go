@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// replayOffsetsCollection holds the progress of each reprocess run
+const replayOffsetsCollection = "replay_offsets"
+
+// ReplayOffset is a position in the stream of stored events ordered by
+// received_at and then document id. The zero offset is before the first
+// event.
+type ReplayOffset struct {
+	ReceivedAt time.Time `bson:"received_at"`
+	EventID    string    `bson:"event_id"`
+}
+
+// ReplayEvent is a stored event with its position in the stream.
+type ReplayEvent struct {
+	Event  *models.WebhookEvent
+	Offset ReplayOffset
+}
+
+// ReplayProgress is the saved state of a reprocess run.
+type ReplayProgress struct {
+	Offset      ReplayOffset `bson:"offset"`
+	Republished int          `bson:"republished"`
+	UpdatedAt   time.Time    `bson:"updated_at"`
+}
+
+// ReplayStore scans the stored events in received_at order and logs how
+// far each reprocess run has got.
+type ReplayStore interface {
+	ScanEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time, after ReplayOffset, limit int) ([]ReplayEvent, error)
+	LoadReplayProgress(ctx context.Context, key string) (ReplayProgress, bool, error)
+	SaveReplayProgress(ctx context.Context, key string, progress ReplayProgress) error
+}
+
+var (
+	_ ReplayStore = (*MongoDB)(nil)
+	_ ReplayStore = (*ClientRouter)(nil)
+)
+
+// replayEventDoc is a storedEvent with its document id. The fields are
+// repeated because the decoder skips an unexported embedded struct.
+type replayEventDoc struct {
+	ID                  primitive.ObjectID `bson:"_id"`
+	models.WebhookEvent `bson:",inline"`
+	RawPayload          bson.RawValue `bson:"raw_payload,omitempty"`
+}
+
+// ScanEventsReceivedBetween returns up to limit events received in
+// [from, to) that come after offset, oldest first. An empty clientID
+// matches every client.
+func (m *MongoDB) ScanEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time, after ReplayOffset, limit int) ([]ReplayEvent, error) {
+	filter := bson.M{"received_at": bson.M{"$gte": from, "$lt": to}}
+	if clientID != "" {
+		filter["client_id"] = clientID
+	}
+	if after.EventID != "" {
+		id, err := primitive.ObjectIDFromHex(after.EventID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replay offset %q: %v", after.EventID, err)
+		}
+		filter["$or"] = bson.A{
+			bson.M{"received_at": bson.M{"$gt": after.ReceivedAt}},
+			bson.M{"received_at": after.ReceivedAt, "_id": bson.M{"$gt": id}},
+		}
+	}
+
+	colls, err := m.readCollections(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ReplayEvent
+	// Buckets are newest first; an offset rules out the months before it
+	for i := len(colls) - 1; i >= 0 && len(events) < limit; i-- {
+		coll := colls[i]
+		if m.monthly && after.EventID != "" && coll.Name() < m.bucketName(after.ReceivedAt) {
+			continue
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "received_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit - len(events)))
+		cursor, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		var docs []replayEventDoc
+		err = cursor.All(ctx, &docs)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range docs {
+			stored := storedEvent{WebhookEvent: docs[i].WebhookEvent, RawPayload: docs[i].RawPayload}
+			event, err := stored.event()
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, ReplayEvent{
+				Event:  event,
+				Offset: ReplayOffset{ReceivedAt: event.ReceivedAt, EventID: docs[i].ID.Hex()},
+			})
+		}
+	}
+	return events, nil
+}
+
+// LoadReplayProgress returns the saved progress of the reprocess run key,
+// and false if it hasn't saved any yet.
+func (m *MongoDB) LoadReplayProgress(ctx context.Context, key string) (ReplayProgress, bool, error) {
+	var progress ReplayProgress
+	err := m.db.Collection(replayOffsetsCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&progress)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return progress, false, nil
+	}
+	if err != nil {
+		return progress, false, err
+	}
+	return progress, true, nil
+}
+
+// SaveReplayProgress records how far the reprocess run key has got.
+func (m *MongoDB) SaveReplayProgress(ctx context.Context, key string, progress ReplayProgress) error {
+	_, err := m.db.Collection(replayOffsetsCollection).UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": progress},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// ScanEventsReceivedBetween scans the store holding clientID's events. An
+// empty clientID scans every store and merges their events in offset
+// order, which works because each store only returns events after the
+// offset, whichever store it came from.
+func (r *ClientRouter) ScanEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time, after ReplayOffset, limit int) ([]ReplayEvent, error) {
+	if clientID != "" {
+		scanner, err := replayStore(r.StoreFor(clientID))
+		if err != nil {
+			return nil, err
+		}
+		return scanner.ScanEventsReceivedBetween(ctx, clientID, from, to, after, limit)
+	}
+
+	var events []ReplayEvent
+	for _, store := range r.stores {
+		scanner, err := replayStore(store)
+		if err != nil {
+			return nil, err
+		}
+		found, err := scanner.ScanEventsReceivedBetween(ctx, "", from, to, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Offset.before(events[j].Offset)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// LoadReplayProgress loads from the shared store, which keeps the offset
+// log for every run.
+func (r *ClientRouter) LoadReplayProgress(ctx context.Context, key string) (ReplayProgress, bool, error) {
+	log, err := replayStore(r.shared)
+	if err != nil {
+		return ReplayProgress{}, false, err
+	}
+	return log.LoadReplayProgress(ctx, key)
+}
+
+// SaveReplayProgress saves to the shared store.
+func (r *ClientRouter) SaveReplayProgress(ctx context.Context, key string, progress ReplayProgress) error {
+	log, err := replayStore(r.shared)
+	if err != nil {
+		return err
+	}
+	return log.SaveReplayProgress(ctx, key, progress)
+}
+
+func replayStore(store EventStore) (ReplayStore, error) {
+	scanner, ok := store.(ReplayStore)
+	if !ok {
+		return nil, errors.New("store does not support reprocessing")
+	}
+	return scanner, nil
+}
+
+// before reports whether o comes before other in the event stream.
+func (o ReplayOffset) before(other ReplayOffset) bool {
+	if !o.ReceivedAt.Equal(other.ReceivedAt) {
+		return o.ReceivedAt.Before(other.ReceivedAt)
+	}
+	return o.EventID < other.EventID
+}
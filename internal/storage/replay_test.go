@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func TestScanEventsReceivedBetween(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	from := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	mt.Run("resumes after offset in time order", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		id := primitive.NewObjectID()
+		receivedAt := from.Add(5 * time.Minute)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+mt.Coll.Name(), mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: id}, {Key: "webhook_id", Value: "wh-1"}, {Key: "client_id", Value: "client-a"}, {Key: "received_at", Value: receivedAt}},
+		))
+
+		after := ReplayOffset{ReceivedAt: from, EventID: primitive.NewObjectID().Hex()}
+		events, err := m.ScanEventsReceivedBetween(context.Background(), "client-a", from, to, after, 100)
+		require.NoError(mt, err)
+		require.Len(mt, events, 1)
+		assert.Equal(mt, "wh-1", events[0].Event.WebhookID)
+		assert.Equal(mt, "client-a", events[0].Event.ClientID)
+		assert.Equal(mt, ReplayOffset{ReceivedAt: receivedAt, EventID: id.Hex()}, events[0].Offset)
+
+		find := mt.GetStartedEvent().Command
+		filter := find.Lookup("filter").Document()
+		assert.Equal(mt, "client-a", filter.Lookup("client_id").StringValue())
+		window := filter.Lookup("received_at").Document()
+		assert.Equal(mt, from, window.Lookup("$gte").Time().UTC())
+		assert.Equal(mt, to, window.Lookup("$lt").Time().UTC())
+		resume, err := filter.Lookup("$or").Array().Values()
+		require.NoError(mt, err)
+		assert.Len(mt, resume, 2, "later received_at, or the same one with a later _id")
+		sort := find.Lookup("sort").Document()
+		elems, err := sort.Elements()
+		require.NoError(mt, err)
+		require.Len(mt, elems, 2)
+		assert.Equal(mt, "received_at", elems[0].Key())
+		assert.Equal(mt, "_id", elems[1].Key())
+		assert.Equal(mt, int64(100), find.Lookup("limit").AsInt64())
+	})
+
+	mt.Run("invalid offset", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		_, err := m.ScanEventsReceivedBetween(context.Background(), "", from, to, ReplayOffset{EventID: "nope"}, 100)
+		assert.ErrorContains(mt, err, "invalid replay offset")
+	})
+}
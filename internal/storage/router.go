@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"webhook-processor/config"
+	"webhook-processor/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// ClientRouter is an EventStore that keeps some clients' events in their own
+// store, e.g. a dedicated cluster for compliance, and everyone else's in the
+// shared store. Events are routed by client ID.
+type ClientRouter struct {
+	shared   EventStore
+	byClient map[string]EventStore
+	// stores lists every distinct store once, shared first, for queries
+	// that aren't scoped to a client
+	stores []EventStore
+}
+
+var _ EventStore = (*ClientRouter)(nil)
+
+func NewClientRouter(shared EventStore, byClient map[string]EventStore) *ClientRouter {
+	r := &ClientRouter{
+		shared:   shared,
+		byClient: byClient,
+		stores:   []EventStore{shared},
+	}
+	seen := map[EventStore]bool{shared: true}
+	for _, store := range byClient {
+		if !seen[store] {
+			seen[store] = true
+			r.stores = append(r.stores, store)
+		}
+	}
+	return r
+}
+
+// StoreFor returns the store holding clientID's events.
+func (r *ClientRouter) StoreFor(clientID string) EventStore {
+	if store, ok := r.byClient[clientID]; ok {
+		return store
+	}
+	return r.shared
+}
+
+func (r *ClientRouter) InsertEvent(ctx context.Context, event *models.WebhookEvent) error {
+	return r.StoreFor(event.ClientID).InsertEvent(ctx, event)
+}
+
+func (r *ClientRouter) UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error {
+	return r.StoreFor(event.ClientID).UpdateEventStatus(ctx, event, status)
+}
+
+func (r *ClientRouter) GetFailedEvents(ctx context.Context, clientID string) ([]*models.WebhookEvent, error) {
+	return r.StoreFor(clientID).GetFailedEvents(ctx, clientID)
+}
+
+// GetStaleRetryingEvents collects stale events from every store.
+func (r *ClientRouter) GetStaleRetryingEvents(ctx context.Context, before time.Time) ([]*models.WebhookEvent, error) {
+	var events []*models.WebhookEvent
+	for _, store := range r.stores {
+		found, err := store.GetStaleRetryingEvents(ctx, before)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+	return events, nil
+}
+
+// GetFailedEventsReceivedBetween finds the failed events in the store
+// holding clientID's events, or in every store for an empty clientID.
+func (r *ClientRouter) GetFailedEventsReceivedBetween(ctx context.Context, clientID string, from, to time.Time) ([]*models.WebhookEvent, error) {
+	stores := r.stores
+	if clientID != "" {
+		stores = []EventStore{r.StoreFor(clientID)}
+	}
+
+	var events []*models.WebhookEvent
+	for _, store := range stores {
+		finder, ok := store.(FailedEventFinder)
+		if !ok {
+			return nil, fmt.Errorf("store for client %q does not support failed event queries", clientID)
+		}
+		found, err := finder.GetFailedEventsReceivedBetween(ctx, clientID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+	return events, nil
+}
+
+func (r *ClientRouter) GetEventByWebhookID(ctx context.Context, webhookID, clientID string) (*models.WebhookEvent, error) {
+	return r.StoreFor(clientID).GetEventByWebhookID(ctx, webhookID, clientID)
+}
+
+func (r *ClientRouter) RecordClientError(ctx context.Context, event *models.WebhookEvent, errMsg string, at time.Time) error {
+	return r.StoreFor(event.ClientID).RecordClientError(ctx, event, errMsg, at)
+}
+
+func (r *ClientRouter) GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error) {
+	return r.StoreFor(clientID).GetClientLastError(ctx, clientID)
+}
+
+// GetEventsByClient queries the store holding clientID's events.
+func (r *ClientRouter) GetEventsByClient(ctx context.Context, clientID string, opts QueryOptions) ([]*models.WebhookEvent, int64, error) {
+	querier, ok := r.StoreFor(clientID).(EventQuerier)
+	if !ok {
+		return nil, 0, fmt.Errorf("store for client %q does not support event queries", clientID)
+	}
+	return querier.GetEventsByClient(ctx, clientID, opts)
+}
+
+// SummarizeClientEvents summarizes from the store holding clientID's events.
+func (r *ClientRouter) SummarizeClientEvents(ctx context.Context, clientID string, since time.Time, topCampaigns int) (*ClientSummary, error) {
+	querier, ok := r.StoreFor(clientID).(DashboardQuerier)
+	if !ok {
+		return nil, fmt.Errorf("store for client %q does not support event summaries", clientID)
+	}
+	return querier.SummarizeClientEvents(ctx, clientID, since, topCampaigns)
+}
+
+// OpenClientStores connects to each of cfg's client stores and returns them
+// keyed by client ID, along with the connections for the caller to close.
+// A client store without a database or collection uses the shared one's.
+func OpenClientStores(cfg config.MongoDBConfig, logger *zap.Logger, opts ...Option) (map[string]EventStore, []*MongoDB, error) {
+	byClient := make(map[string]EventStore)
+	var conns []*MongoDB
+
+	for _, sc := range cfg.ClientStores {
+		database, collection := sc.Database, sc.Collection
+		if database == "" {
+			database = cfg.Database
+		}
+		if collection == "" {
+			collection = cfg.Collection
+		}
+
+		db, err := NewMongoDB(sc.URI, database, collection, logger.With(zap.String("store", sc.Name)), opts...)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close(context.Background())
+			}
+			return nil, nil, fmt.Errorf("failed to connect to client store %q: %v", sc.Name, err)
+		}
+		conns = append(conns, db)
+		for _, clientID := range sc.Clients {
+			byClient[clientID] = db
+		}
+	}
+
+	return byClient, conns, nil
+}
+
+// pinger is implemented by stores that can check their connection.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping checks every store that can check its connection, so readiness
+// reflects the dedicated stores as well as the shared one.
+func (r *ClientRouter) Ping(ctx context.Context) error {
+	for i, store := range r.stores {
+		p, ok := store.(pinger)
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil {
+			if i == 0 {
+				return fmt.Errorf("shared store: %w", err)
+			}
+			return fmt.Errorf("client store: %w", err)
+		}
+	}
+	return nil
+}
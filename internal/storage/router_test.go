@@ -0,0 +1,85 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRouterRoutesByClientID(t *testing.T) {
+	ctx := context.Background()
+	shared := storagetest.NewFakeStore()
+	dedicated := storagetest.NewFakeStore()
+	router := storage.NewClientRouter(shared, map[string]storage.EventStore{"big-client": dedicated})
+
+	require.NoError(t, router.InsertEvent(ctx, &models.WebhookEvent{WebhookID: "wh-1", ClientID: "big-client"}))
+	require.NoError(t, router.InsertEvent(ctx, &models.WebhookEvent{WebhookID: "wh-2", ClientID: "client-a"}))
+
+	require.Len(t, dedicated.Inserts(), 1)
+	assert.Equal(t, "wh-1", dedicated.Inserts()[0].WebhookID)
+	require.Len(t, shared.Inserts(), 1)
+	assert.Equal(t, "wh-2", shared.Inserts()[0].WebhookID, "other clients use the shared store")
+
+	require.NoError(t, router.UpdateEventStatus(ctx, &models.WebhookEvent{WebhookID: "wh-1", ClientID: "big-client"}, models.EventStatusProcessed))
+	status, ok := dedicated.LastStatus("wh-1")
+	require.True(t, ok)
+	assert.Equal(t, models.EventStatusProcessed, status)
+	assert.Empty(t, shared.StatusUpdates())
+
+	event, err := router.GetEventByWebhookID(ctx, "wh-1", "big-client")
+	require.NoError(t, err)
+	assert.Equal(t, "wh-1", event.WebhookID)
+	_, err = router.GetEventByWebhookID(ctx, "wh-1", "client-a")
+	assert.ErrorIs(t, err, storage.ErrEventNotFound)
+}
+
+func TestClientRouterStaleEventsFromAllStores(t *testing.T) {
+	updated := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	shared := storagetest.NewFakeStore(&models.WebhookEvent{
+		WebhookID: "wh-1", ClientID: "client-a", Status: string(models.EventStatusRetrying), UpdatedAt: updated,
+	})
+	dedicated := storagetest.NewFakeStore(&models.WebhookEvent{
+		WebhookID: "wh-2", ClientID: "big-client", Status: string(models.EventStatusRetrying), UpdatedAt: updated,
+	})
+	router := storage.NewClientRouter(shared, map[string]storage.EventStore{
+		"big-client":   dedicated,
+		"big-client-2": dedicated,
+	})
+
+	events, err := router.GetStaleRetryingEvents(context.Background(), updated.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, events, 2, "a store shared by several clients is only queried once")
+	assert.Equal(t, "wh-1", events[0].WebhookID)
+	assert.Equal(t, "wh-2", events[1].WebhookID)
+}
+
+// pingStore is a fake store whose connection check fails with err.
+type pingStore struct {
+	*storagetest.FakeStore
+	err error
+}
+
+func (s pingStore) Ping(ctx context.Context) error { return s.err }
+
+func TestClientRouterPingsEveryStore(t *testing.T) {
+	ctx := context.Background()
+	healthy := pingStore{FakeStore: storagetest.NewFakeStore()}
+	down := pingStore{FakeStore: storagetest.NewFakeStore(), err: errors.New("server selection timeout")}
+
+	assert.NoError(t, storage.NewClientRouter(healthy, map[string]storage.EventStore{"big-client": healthy}).Ping(ctx))
+
+	err := storage.NewClientRouter(healthy, map[string]storage.EventStore{"big-client": down}).Ping(ctx)
+	assert.ErrorIs(t, err, down.err, "a dedicated store being down fails the check")
+	assert.Contains(t, err.Error(), "client store")
+
+	err = storage.NewClientRouter(down, nil).Ping(ctx)
+	assert.Contains(t, err.Error(), "shared store")
+}
@@ -0,0 +1,259 @@
+// Package storagetest provides an in-memory storage.EventStore for tests.
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+)
+
+// Method names an EventStore method for error injection and call counts.
+type Method string
+
+const (
+	InsertEvent            Method = "InsertEvent"
+	UpdateEventStatus      Method = "UpdateEventStatus"
+	GetFailedEvents        Method = "GetFailedEvents"
+	GetStaleRetryingEvents Method = "GetStaleRetryingEvents"
+	GetEventByWebhookID    Method = "GetEventByWebhookID"
+	RecordClientError      Method = "RecordClientError"
+	GetClientLastError     Method = "GetClientLastError"
+)
+
+// StatusUpdate records a single UpdateEventStatus call.
+type StatusUpdate struct {
+	WebhookID  string
+	ClientID   string
+	Status     models.EventStatus
+	RetryCount int
+}
+
+// FakeStore is an in-memory EventStore that records every write and can be
+// told to fail any method. Events are keyed by (webhook ID, client ID) like
+// the MongoDB store. It is safe for concurrent use.
+type FakeStore struct {
+	mu           sync.Mutex
+	events       map[eventKey]*models.WebhookEvent
+	order        []eventKey
+	inserts      []models.WebhookEvent
+	updates      []StatusUpdate
+	clientErrors map[string]*models.ClientError
+	errs         map[Method]error
+	calls        map[Method]int
+}
+
+type eventKey struct {
+	webhookID string
+	clientID  string
+}
+
+var _ storage.EventStore = (*FakeStore)(nil)
+
+// NewFakeStore returns a store seeded with copies of events. Seeding is not
+// recorded as an insert.
+func NewFakeStore(events ...*models.WebhookEvent) *FakeStore {
+	s := &FakeStore{
+		events:       make(map[eventKey]*models.WebhookEvent),
+		clientErrors: make(map[string]*models.ClientError),
+		errs:         make(map[Method]error),
+		calls:        make(map[Method]int),
+	}
+	for _, e := range events {
+		s.put(e)
+	}
+	return s
+}
+
+// SetError makes every subsequent call to method return err. A nil err
+// clears the injected error.
+func (s *FakeStore) SetError(method Method, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, method)
+		return
+	}
+	s.errs[method] = err
+}
+
+// Calls returns how many times method has been called, including calls that
+// returned an injected error.
+func (s *FakeStore) Calls(method Method) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// Inserts returns copies of the events passed to successful InsertEvent
+// calls, in order.
+func (s *FakeStore) Inserts() []models.WebhookEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.WebhookEvent(nil), s.inserts...)
+}
+
+// StatusUpdates returns the successful UpdateEventStatus calls, in order.
+func (s *FakeStore) StatusUpdates() []StatusUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StatusUpdate(nil), s.updates...)
+}
+
+// LastStatus returns the status most recently written for webhookID, and
+// false if its status was never updated.
+func (s *FakeStore) LastStatus(webhookID string) (models.EventStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.updates) - 1; i >= 0; i-- {
+		if s.updates[i].WebhookID == webhookID {
+			return s.updates[i].Status, true
+		}
+	}
+	return "", false
+}
+
+// Event returns a copy of the stored event.
+func (s *FakeStore) Event(webhookID, clientID string) (models.WebhookEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.events[eventKey{webhookID, clientID}]
+	if !ok {
+		return models.WebhookEvent{}, false
+	}
+	return *e, true
+}
+
+// call counts a call to method and returns its injected error, if any.
+// The caller must hold s.mu.
+func (s *FakeStore) call(method Method) error {
+	s.calls[method]++
+	return s.errs[method]
+}
+
+// put stores a copy of event. The caller must hold s.mu or own s.
+func (s *FakeStore) put(event *models.WebhookEvent) {
+	key := eventKey{event.WebhookID, event.ClientID}
+	if _, ok := s.events[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	stored := *event
+	s.events[key] = &stored
+}
+
+func (s *FakeStore) InsertEvent(ctx context.Context, event *models.WebhookEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(InsertEvent); err != nil {
+		return err
+	}
+	if event.Status == "" {
+		event.Status = string(models.EventStatusPending)
+	}
+	s.inserts = append(s.inserts, *event)
+	s.put(event)
+	return nil
+}
+
+func (s *FakeStore) UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(UpdateEventStatus); err != nil {
+		return err
+	}
+	s.updates = append(s.updates, StatusUpdate{
+		WebhookID:  event.WebhookID,
+		ClientID:   event.ClientID,
+		Status:     status,
+		RetryCount: event.RetryCount,
+	})
+	// Like MongoDB, updating an event that isn't stored is not an error
+	if stored, ok := s.events[eventKey{event.WebhookID, event.ClientID}]; ok {
+		stored.Status = string(status)
+		stored.RetryCount = event.RetryCount
+		stored.UpdatedAt = time.Now().UTC()
+	}
+	return nil
+}
+
+func (s *FakeStore) GetFailedEvents(ctx context.Context, clientID string) ([]*models.WebhookEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(GetFailedEvents); err != nil {
+		return nil, err
+	}
+	return s.filter(func(e *models.WebhookEvent) bool {
+		return e.ClientID == clientID && e.Status == string(models.EventStatusFailed)
+	}), nil
+}
+
+func (s *FakeStore) GetStaleRetryingEvents(ctx context.Context, before time.Time) ([]*models.WebhookEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(GetStaleRetryingEvents); err != nil {
+		return nil, err
+	}
+	return s.filter(func(e *models.WebhookEvent) bool {
+		return e.Status == string(models.EventStatusRetrying) && e.UpdatedAt.Before(before)
+	}), nil
+}
+
+func (s *FakeStore) GetEventByWebhookID(ctx context.Context, webhookID, clientID string) (*models.WebhookEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(GetEventByWebhookID); err != nil {
+		return nil, err
+	}
+	matches := s.filter(func(e *models.WebhookEvent) bool {
+		return e.WebhookID == webhookID && (clientID == "" || e.ClientID == clientID)
+	})
+	if len(matches) == 0 {
+		return nil, storage.ErrEventNotFound
+	}
+	return matches[0], nil
+}
+
+func (s *FakeStore) RecordClientError(ctx context.Context, event *models.WebhookEvent, errMsg string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(RecordClientError); err != nil {
+		return err
+	}
+	s.clientErrors[event.ClientID] = &models.ClientError{
+		ClientID:    event.ClientID,
+		LastError:   errMsg,
+		LastErrorAt: at,
+		WebhookID:   event.WebhookID,
+		Event:       event.Event,
+	}
+	return nil
+}
+
+func (s *FakeStore) GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(GetClientLastError); err != nil {
+		return nil, err
+	}
+	clientErr, ok := s.clientErrors[clientID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *clientErr
+	return &copied, nil
+}
+
+// filter returns copies of the stored events matching keep, in insertion
+// order. The caller must hold s.mu.
+func (s *FakeStore) filter(keep func(*models.WebhookEvent) bool) []*models.WebhookEvent {
+	var matches []*models.WebhookEvent
+	for _, key := range s.order {
+		if e := s.events[key]; keep(e) {
+			copied := *e
+			matches = append(matches, &copied)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,98 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeStoreRecordsWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewFakeStore()
+
+	event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Event: "opened"}
+	require.NoError(t, s.InsertEvent(ctx, event))
+	event.Event = "mutated after insert"
+	event.RetryCount = 1
+	require.NoError(t, s.UpdateEventStatus(ctx, event, models.EventStatusRetrying))
+	require.NoError(t, s.UpdateEventStatus(ctx, event, models.EventStatusFailed))
+
+	inserts := s.Inserts()
+	require.Len(t, inserts, 1)
+	assert.Equal(t, "opened", inserts[0].Event, "inserts are recorded as they were at call time")
+	assert.Equal(t, string(models.EventStatusPending), inserts[0].Status)
+
+	assert.Equal(t, []StatusUpdate{
+		{WebhookID: "wh-1", ClientID: "client-a", Status: models.EventStatusRetrying, RetryCount: 1},
+		{WebhookID: "wh-1", ClientID: "client-a", Status: models.EventStatusFailed, RetryCount: 1},
+	}, s.StatusUpdates())
+
+	status, ok := s.LastStatus("wh-1")
+	assert.True(t, ok)
+	assert.Equal(t, models.EventStatusFailed, status)
+
+	stored, ok := s.Event("wh-1", "client-a")
+	require.True(t, ok)
+	assert.Equal(t, string(models.EventStatusFailed), stored.Status)
+
+	failed, err := s.GetFailedEvents(ctx, "client-a")
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "wh-1", failed[0].WebhookID)
+
+	assert.Equal(t, 1, s.Calls(InsertEvent))
+	assert.Equal(t, 2, s.Calls(UpdateEventStatus))
+}
+
+func TestFakeStoreErrorInjection(t *testing.T) {
+	ctx := context.Background()
+	s := NewFakeStore()
+	boom := errors.New("boom")
+
+	s.SetError(InsertEvent, boom)
+	assert.ErrorIs(t, s.InsertEvent(ctx, &models.WebhookEvent{WebhookID: "wh-1"}), boom)
+	assert.Empty(t, s.Inserts(), "failed calls are not recorded as writes")
+	assert.Equal(t, 1, s.Calls(InsertEvent))
+
+	// Other methods are unaffected
+	assert.NoError(t, s.UpdateEventStatus(ctx, &models.WebhookEvent{WebhookID: "wh-1"}, models.EventStatusFailed))
+
+	s.SetError(InsertEvent, nil)
+	assert.NoError(t, s.InsertEvent(ctx, &models.WebhookEvent{WebhookID: "wh-1"}))
+}
+
+func TestFakeStoreQueries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := NewFakeStore(
+		&models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Status: "retrying", UpdatedAt: now.Add(-2 * time.Hour)},
+		&models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-b", Status: "retrying", UpdatedAt: now},
+	)
+
+	stale, err := s.GetStaleRetryingEvents(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.Equal(t, "client-a", stale[0].ClientID)
+
+	event, err := s.GetEventByWebhookID(ctx, "wh-1", "client-b")
+	require.NoError(t, err)
+	assert.Equal(t, "client-b", event.ClientID)
+
+	_, err = s.GetEventByWebhookID(ctx, "missing", "")
+	assert.ErrorIs(t, err, storage.ErrEventNotFound)
+
+	lastErr, err := s.GetClientLastError(ctx, "client-a")
+	require.NoError(t, err)
+	assert.Nil(t, lastErr)
+	require.NoError(t, s.RecordClientError(ctx, &models.WebhookEvent{ClientID: "client-a"}, "insert failed", now))
+	lastErr, err = s.GetClientLastError(ctx, "client-a")
+	require.NoError(t, err)
+	assert.Equal(t, "insert failed", lastErr.LastError)
+}
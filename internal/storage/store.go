@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"webhook-processor/internal/models"
+)
+
+// ErrEventNotFound is returned when a requested event does not exist.
+var ErrEventNotFound = errors.New("event not found")
+
+// EventStore is the persistence interface for webhook events.
+type EventStore interface {
+	InsertEvent(ctx context.Context, event *models.WebhookEvent) error
+	UpdateEventStatus(ctx context.Context, event *models.WebhookEvent, status models.EventStatus) error
+	GetFailedEvents(ctx context.Context, clientID string) ([]*models.WebhookEvent, error)
+	GetStaleRetryingEvents(ctx context.Context, before time.Time) ([]*models.WebhookEvent, error)
+	GetEventByWebhookID(ctx context.Context, webhookID, clientID string) (*models.WebhookEvent, error)
+	RecordClientError(ctx context.Context, event *models.WebhookEvent, errMsg string, at time.Time) error
+	GetClientLastError(ctx context.Context, clientID string) (*models.ClientError, error)
+}
+
+var _ EventStore = (*MongoDB)(nil)
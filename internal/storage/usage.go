@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UsageCounter counts the events stored for each client.
+type UsageCounter interface {
+	CountEventsByClientSince(ctx context.Context, since time.Time) (map[string]int, error)
+}
+
+var (
+	_ UsageCounter = (*MongoDB)(nil)
+	_ UsageCounter = (*ClientRouter)(nil)
+)
+
+type clientCount struct {
+	ClientID string `bson:"_id"`
+	Events   int    `bson:"events"`
+}
+
+// CountEventsByClientSince returns the number of events received since
+// since, keyed by client ID. Clients without events are omitted.
+func (m *MongoDB) CountEventsByClientSince(ctx context.Context, since time.Time) (map[string]int, error) {
+	colls, err := m.readCollections(ctx, since, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"received_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$client_id", "events": bson.M{"$sum": 1}}}},
+	}
+
+	counts := make(map[string]int)
+	for _, coll := range colls {
+		cursor, err := coll.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count events: %v", err)
+		}
+		var groups []clientCount
+		err = cursor.All(ctx, &groups)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			counts[g.ClientID] += g.Events
+		}
+	}
+	return counts, nil
+}
+
+// CountEventsByClientSince adds up the counts from every store that can
+// count.
+func (r *ClientRouter) CountEventsByClientSince(ctx context.Context, since time.Time) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, store := range r.stores {
+		counter, ok := store.(UsageCounter)
+		if !ok {
+			continue
+		}
+		found, err := counter.CountEventsByClientSince(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		for clientID, n := range found {
+			counts[clientID] += n
+		}
+	}
+	return counts, nil
+}
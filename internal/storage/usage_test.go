@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+)
+
+func TestCountEventsByClientSince(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mt.Run("counts per client", func(mt *mtest.T) {
+		m := &MongoDB{collection: mt.Coll, logger: zap.NewNop()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+"."+mt.Coll.Name(), mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "client-a"}, {Key: "events", Value: int32(120)}},
+			bson.D{{Key: "_id", Value: "client-b"}, {Key: "events", Value: int32(7)}},
+		))
+
+		counts, err := m.CountEventsByClientSince(context.Background(), since)
+		require.NoError(mt, err)
+		assert.Equal(mt, map[string]int{"client-a": 120, "client-b": 7}, counts)
+
+		pipeline, err := mt.GetStartedEvent().Command.Lookup("pipeline").Array().Values()
+		require.NoError(mt, err)
+		require.Len(mt, pipeline, 2)
+		assert.Equal(mt, since, pipeline[0].Document().Lookup("$match", "received_at", "$gte").Time().UTC())
+		assert.Equal(mt, "$client_id", pipeline[1].Document().Lookup("$group", "_id").StringValue())
+	})
+}
@@ -0,0 +1,75 @@
+// Package warmup coordinates the startup work the API must finish before it
+// accepts webhooks, such as loading the webhook mapping and restoring
+// today's rate limit usage. Until then events would be attributed to the
+// wrong client or let through limits that had already been used up.
+package warmup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StepFunc performs one warmup step. It should return promptly once ctx is
+// done.
+type StepFunc func(ctx context.Context) error
+
+type step struct {
+	name string
+	fn   StepFunc
+}
+
+// Warmer runs the registered steps once and reports ready when they have all
+// finished. A failed step is logged and doesn't hold readiness back: the API
+// then runs degraded, as it would have without the warmup.
+type Warmer struct {
+	logger *zap.Logger
+	steps  []step
+
+	once  sync.Once
+	ready atomic.Bool
+	done  chan struct{}
+}
+
+func NewWarmer(logger *zap.Logger) *Warmer {
+	return &Warmer{logger: logger, done: make(chan struct{})}
+}
+
+// Add registers a step. Steps run in the order they were added, so later
+// steps can rely on earlier ones.
+func (w *Warmer) Add(name string, fn StepFunc) {
+	w.steps = append(w.steps, step{name: name, fn: fn})
+}
+
+// Run runs the steps and marks the warmer ready. Only the first call does
+// any work; later calls return once it has finished.
+func (w *Warmer) Run(ctx context.Context) {
+	w.once.Do(func() {
+		start := time.Now()
+		for _, s := range w.steps {
+			stepStart := time.Now()
+			if err := s.fn(ctx); err != nil {
+				w.logger.Error("Warmup step failed", zap.String("step", s.name), zap.Error(err))
+				continue
+			}
+			w.logger.Info("Warmup step complete", zap.String("step", s.name), zap.Duration("took", time.Since(stepStart)))
+		}
+		w.ready.Store(true)
+		close(w.done)
+		w.logger.Info("Warmup complete", zap.Int("steps", len(w.steps)), zap.Duration("took", time.Since(start)))
+	})
+	<-w.done
+}
+
+// Ready reports whether warmup has completed.
+func (w *Warmer) Ready() bool {
+	return w.ready.Load()
+}
+
+// Done is closed once warmup has completed.
+func (w *Warmer) Done() <-chan struct{} {
+	return w.done
+}
@@ -0,0 +1,74 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReadyOnlyOnceWarmupCompletes(t *testing.T) {
+	w := NewWarmer(zap.NewNop())
+	release := make(chan struct{})
+	var order []string
+	w.Add("mapping", func(ctx context.Context) error {
+		<-release
+		order = append(order, "mapping")
+		return nil
+	})
+	w.Add("rate-limits", func(ctx context.Context) error {
+		order = append(order, "rate-limits")
+		return nil
+	})
+
+	go w.Run(context.Background())
+
+	assert.False(t, w.Ready(), "not ready before any step has finished")
+	select {
+	case <-w.Done():
+		t.Fatal("warmup finished while a step was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.False(t, w.Ready(), "not ready while a step is running")
+
+	close(release)
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("warmup didn't finish")
+	}
+	assert.True(t, w.Ready())
+	assert.Equal(t, []string{"mapping", "rate-limits"}, order, "steps run in order")
+}
+
+func TestFailedStepDoesNotHoldReadinessBack(t *testing.T) {
+	w := NewWarmer(zap.NewNop())
+	ran := false
+	w.Add("mapping", func(ctx context.Context) error { return errors.New("mailercloud unavailable") })
+	w.Add("rate-limits", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	w.Run(context.Background())
+
+	assert.True(t, w.Ready())
+	assert.True(t, ran, "later steps still run")
+}
+
+func TestRunOnlyWarmsUpOnce(t *testing.T) {
+	w := NewWarmer(zap.NewNop())
+	runs := 0
+	w.Add("count", func(ctx context.Context) error {
+		runs++
+		return nil
+	})
+
+	w.Run(context.Background())
+	w.Run(context.Background())
+
+	assert.Equal(t, 1, runs)
+}
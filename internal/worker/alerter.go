@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"go.uber.org/zap"
+)
+
+// Alerter posts a Slack-compatible message when a critical event type is
+// processed. Alerts are best-effort and sent asynchronously; at most one
+// alert per client and event type is sent within minInterval.
+type Alerter struct {
+	url         string
+	events      map[string]bool
+	minInterval time.Duration
+	client      *http.Client
+	clock       clock.Clock
+	logger      *zap.Logger
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+func NewAlerter(url string, eventTypes []string, minInterval time.Duration, logger *zap.Logger) *Alerter {
+	events := make(map[string]bool, len(eventTypes))
+	for _, e := range eventTypes {
+		events[strings.ToLower(strings.TrimSpace(e))] = true
+	}
+
+	return &Alerter{
+		url:         url,
+		events:      events,
+		minInterval: minInterval,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		clock:       clock.New(),
+		logger:      logger,
+		lastSent:    make(map[string]time.Time),
+	}
+}
+
+// Notify sends an alert for event if its type is critical and the client
+// hasn't been alerted about it recently. It never blocks on the network.
+func (a *Alerter) Notify(event *models.WebhookEvent) {
+	if !a.shouldAlert(event) {
+		return
+	}
+
+	go func() {
+		if err := a.send(event); err != nil {
+			a.logger.Warn("Failed to send alert",
+				zap.Error(err),
+				zap.String("client_id", event.ClientID),
+				zap.String("event", event.Event))
+		}
+	}()
+}
+
+func (a *Alerter) shouldAlert(event *models.WebhookEvent) bool {
+	if !a.events[strings.ToLower(event.Event)] {
+		return false
+	}
+
+	key := event.ClientID + "|" + strings.ToLower(event.Event)
+	now := a.clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.lastSent[key]; ok && now.Sub(last) < a.minInterval {
+		return false
+	}
+	a.lastSent[key] = now
+	return true
+}
+
+func (a *Alerter) send(event *models.WebhookEvent) error {
+	text := fmt.Sprintf(":rotating_light: *%s* event for client `%s`", event.Event, event.ClientID)
+	if event.Email != "" {
+		text += fmt.Sprintf("\nEmail: %s", event.Email)
+	}
+	if event.CampaignName != "" || event.CampaignID != "" {
+		text += fmt.Sprintf("\nCampaign: %s (%s)", event.CampaignName, event.CampaignID)
+	}
+	if event.Reason != "" {
+		text += fmt.Sprintf("\nReason: %s", event.Reason)
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alert request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAlertServer(t *testing.T) (*httptest.Server, chan string) {
+	t.Helper()
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		received <- msg["text"]
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func TestCriticalEventTriggersAlert(t *testing.T) {
+	srv, received := newAlertServer(t)
+	alerter := NewAlerter(srv.URL, []string{"spam"}, time.Minute, zap.NewNop())
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithAlerter(alerter))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "spam", Email: "a@example.com"}))
+
+	select {
+	case text := <-received:
+		assert.Contains(t, text, "spam")
+		assert.Contains(t, text, "client-a")
+		assert.Contains(t, text, "a@example.com")
+	case <-time.After(time.Second):
+		t.Fatal("expected an alert for a critical event")
+	}
+}
+
+func TestNonCriticalEventDoesNotAlert(t *testing.T) {
+	srv, received := newAlertServer(t)
+	alerter := NewAlerter(srv.URL, []string{"spam"}, time.Minute, zap.NewNop())
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithAlerter(alerter))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	select {
+	case text := <-received:
+		t.Fatalf("unexpected alert: %s", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAlerterRateLimitsPerClient(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	alerter := NewAlerter("http://unused", []string{"Spam"}, 5*time.Minute, zap.NewNop())
+	alerter.clock = clk
+
+	event := &models.WebhookEvent{ClientID: "client-a", Event: "spam"}
+	require.True(t, alerter.shouldAlert(event))
+	assert.False(t, alerter.shouldAlert(event), "repeat alert within interval is suppressed")
+	assert.True(t, alerter.shouldAlert(&models.WebhookEvent{ClientID: "client-b", Event: "spam"}))
+
+	clk.Advance(5 * time.Minute)
+	assert.True(t, alerter.shouldAlert(event), "alert allowed again after interval")
+}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// WithBatching stores up to size deliveries' events with one bulk write,
+// waiting at most wait for a batch to fill. Each delivery is then settled on
+// its own: those whose writes succeeded are acked and the rest go through
+// the retry path. Unordered writes attempt every event; ordered ones stop at
+// the first failure, retrying every event after it too. A size below 2
+// disables batching, as do client lanes.
+func WithBatching(size int, wait time.Duration, ordered bool) Option {
+	return func(w *Worker) {
+		w.batchSize = size
+		w.batchWait = wait
+		w.batchOrdered = ordered
+	}
+}
+
+// batchedDelivery is a delivery in a batch, with its event once prepared.
+type batchedDelivery struct {
+	msg     amqp.Delivery
+	tracker *settleTracker
+	event   *models.WebhookEvent
+	start   time.Time
+}
+
+// consumeBatches collects deliveries from msgs into batches, handling each
+// under processCtx once it is full or batchWait has passed since its first
+// delivery, until msgs closes or ctx is done.
+func (w *Worker) consumeBatches(ctx, processCtx context.Context, msgs <-chan amqp.Delivery) {
+	for {
+		var batch []amqp.Delivery
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			batch = append(batch, msg)
+		}
+
+		closed := false
+		timer := time.NewTimer(w.batchWait)
+	fill:
+		for len(batch) < w.batchSize {
+			select {
+			case <-ctx.Done():
+				break fill
+			case <-timer.C:
+				break fill
+			case msg, ok := <-msgs:
+				if !ok {
+					closed = true
+					break fill
+				}
+				batch = append(batch, msg)
+			}
+		}
+		timer.Stop()
+
+		// Deliveries already received are handled even when stopping, as
+		// a single delivery would be
+		w.handleBatch(processCtx, batch)
+		if closed || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// handleBatch prepares each delivery as handleDelivery does, stores the
+// events that remain in one bulk write and settles each delivery by the
+// outcome of its own write.
+func (w *Worker) handleBatch(ctx context.Context, msgs []amqp.Delivery) {
+	batch := make([]*batchedDelivery, 0, len(msgs))
+	for _, msg := range msgs {
+		if !w.inflight.begin() {
+			continue
+		}
+		d := &batchedDelivery{msg: msg, tracker: &settleTracker{Acknowledger: msg.Acknowledger}}
+		d.msg.Acknowledger = d.tracker
+		if !w.prepareBatched(ctx, d) {
+			w.inflight.end()
+			continue
+		}
+		batch = append(batch, d)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	events := make([]*models.WebhookEvent, len(batch))
+	for i, d := range batch {
+		// Stored as processed straight away, saving the status update
+		// processEvent makes
+		d.event.Status = string(models.EventStatusProcessed)
+		events[i] = d.event
+	}
+	errs := w.storeBatch(ctx, events)
+
+	failed := 0
+	for i, d := range batch {
+		if errs[i] != nil {
+			d.event.Status = string(models.EventStatusPending)
+			failed++
+		}
+		w.completeBatched(ctx, d, errs[i])
+	}
+	if failed > 0 {
+		w.logger.Warn("Some events in a batch failed to store",
+			zap.Int("batch_size", len(batch)),
+			zap.Int("failed", failed),
+			zap.Bool("ordered", w.batchOrdered))
+	}
+}
+
+// prepareBatched runs prepareDelivery for a delivery in a batch, recovering
+// from a panic as handleDelivery does.
+func (w *Worker) prepareBatched(ctx context.Context, d *batchedDelivery) (ok bool) {
+	defer w.recoverDelivery(ctx, d.msg, d.tracker)
+	d.event, d.start, ok = w.prepareDelivery(ctx, d.msg)
+	return ok
+}
+
+// completeBatched runs completeDelivery for a delivery in a batch,
+// recovering from a panic as handleDelivery does.
+func (w *Worker) completeBatched(ctx context.Context, d *batchedDelivery, err error) {
+	defer w.inflight.end()
+	defer w.recoverDelivery(ctx, d.msg, d.tracker)
+	w.completeDelivery(ctx, d.msg, d.event, d.start, err)
+}
+
+// storeBatch bulk writes events. A panic fails every event in the batch,
+// sending each through the retry path, rather than the consumer.
+func (w *Worker) storeBatch(ctx context.Context, events []*models.WebhookEvent) (errs []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.logger.Error("Panic while storing batch", zap.Any("panic", r))
+			errs = make([]error, len(events))
+			for i := range errs {
+				errs[i] = fmt.Errorf("panic: %v", r)
+			}
+		}
+	}()
+	return storage.InsertEvents(ctx, w.db, events, w.batchOrdered)
+}
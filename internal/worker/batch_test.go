@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// bulkStore bulk writes events to a FakeStore, failing the webhook IDs in
+// fail.
+type bulkStore struct {
+	*storagetest.FakeStore
+	mu      sync.Mutex
+	fail    map[string]bool
+	batches [][]string
+}
+
+func (s *bulkStore) InsertEvents(ctx context.Context, events []*models.WebhookEvent, ordered bool) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, len(events))
+	var ids []string
+	for i, event := range events {
+		ids = append(ids, event.WebhookID)
+		if s.fail[event.WebhookID] {
+			errs[i] = errors.New("duplicate key")
+			continue
+		}
+		errs[i] = s.FakeStore.InsertEvent(ctx, event)
+	}
+	s.batches = append(s.batches, ids)
+	return errs
+}
+
+func batchDelivery(t *testing.T, ack amqp.Acknowledger, webhookID string) amqp.Delivery {
+	t.Helper()
+	msg := newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+	msg.Headers["webhook_id"] = webhookID
+	return msg
+}
+
+func TestHandleBatchSettlesEachDelivery(t *testing.T) {
+	store := &bulkStore{FakeStore: storagetest.NewFakeStore(), fail: map[string]bool{"wh-2": true}}
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clk), WithBatching(3, time.Second, false))
+
+	acks := []*fakeAcknowledger{newFakeAcknowledger(), newFakeAcknowledger(), newFakeAcknowledger()}
+	w.handleBatch(context.Background(), []amqp.Delivery{
+		batchDelivery(t, acks[0], "wh-1"),
+		batchDelivery(t, acks[1], "wh-2"),
+		batchDelivery(t, acks[2], "wh-3"),
+	})
+
+	require.Equal(t, [][]string{{"wh-1", "wh-2", "wh-3"}}, store.batches, "one bulk write for the batch")
+	for _, i := range []int{0, 2} {
+		acked, nacked := acks[i].counts()
+		assert.Equal(t, 1, acked, "delivery %d stored and acked", i)
+		assert.Zero(t, nacked)
+	}
+	acked, nacked := acks[1].counts()
+	assert.Zero(t, acked)
+	assert.Equal(t, 1, nacked, "only the failed write is retried")
+	assert.True(t, acks[1].requeue)
+
+	event, ok := store.Event("wh-1", "client-a")
+	require.True(t, ok)
+	assert.Equal(t, string(models.EventStatusProcessed), event.Status, "stored as processed in the bulk write")
+	status, _ := store.LastStatus("wh-2")
+	assert.Equal(t, models.EventStatusRetrying, status)
+	assert.Zero(t, w.inflight.count())
+}
+
+func TestConsumeBatchesFlushesOnWait(t *testing.T) {
+	store := &bulkStore{FakeStore: storagetest.NewFakeStore()}
+	w := NewWorker(nil, store, zap.NewNop(), WithBatching(10, 20*time.Millisecond, false))
+
+	msgs := make(chan amqp.Delivery, 3)
+	acks := make([]*fakeAcknowledger, 3)
+	for i, id := range []string{"wh-1", "wh-2", "wh-3"} {
+		acks[i] = newFakeAcknowledger()
+		msgs <- batchDelivery(t, acks[i], id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		w.consumeBatches(ctx, context.Background(), msgs)
+		close(done)
+	}()
+
+	for i, ack := range acks {
+		select {
+		case <-ack.done:
+		case <-time.After(time.Second):
+			t.Fatalf("delivery %d was not settled before the batch filled", i)
+		}
+	}
+	store.mu.Lock()
+	assert.Equal(t, [][]string{{"wh-1", "wh-2", "wh-3"}}, store.batches)
+	store.mu.Unlock()
+
+	close(msgs)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not stop when the channel closed")
+	}
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+)
+
+// Correlator is a Processor linking related events, e.g. a bounce followed
+// shortly by a spam complaint for the same message. Events for the same
+// client with the same message_id, or failing that the same email and
+// campaign, within window of the first one share a correlation ID: the
+// webhook ID of that first event.
+type Correlator struct {
+	window time.Duration
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	groups    map[string]correlationGroup
+	lastSweep time.Time
+}
+
+type correlationGroup struct {
+	id      string
+	startAt time.Time
+}
+
+func NewCorrelator(window time.Duration, clk clock.Clock) *Correlator {
+	return &Correlator{
+		window: window,
+		clock:  clk,
+		groups: make(map[string]correlationGroup),
+	}
+}
+
+// Process sets event.CorrelationID if the event can be correlated. Events
+// are placed in time by when the API received them, so redeliveries and
+// backlogs don't stretch the window.
+func (c *Correlator) Process(ctx context.Context, event *models.WebhookEvent) error {
+	key := correlationKey(event)
+	if key == "" {
+		return nil
+	}
+
+	at := event.ReceivedAt
+	if at.IsZero() {
+		at = c.clock.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+
+	if group, ok := c.groups[key]; ok && at.Sub(group.startAt) <= c.window && !at.Before(group.startAt) {
+		event.CorrelationID = group.id
+		return nil
+	}
+
+	c.groups[key] = correlationGroup{id: event.WebhookID, startAt: at}
+	event.CorrelationID = event.WebhookID
+	return nil
+}
+
+// sweep forgets groups whose window has closed, at most once per window.
+// Callers must hold c.mu.
+func (c *Correlator) sweep() {
+	now := c.clock.Now()
+	if now.Sub(c.lastSweep) < c.window {
+		return
+	}
+	c.lastSweep = now
+	for key, group := range c.groups {
+		if now.Sub(group.startAt) > c.window {
+			delete(c.groups, key)
+		}
+	}
+}
+
+// correlationKey identifies the message an event is about, or returns ""
+// if the event carries neither a message ID nor an email.
+func correlationKey(event *models.WebhookEvent) string {
+	if id, ok := event.CustomFields["message_id"].(string); ok && id != "" {
+		return event.ClientID + "|message|" + id
+	}
+	if event.Email == "" {
+		return ""
+	}
+	return event.ClientID + "|email|" + strings.ToLower(event.Email) + "|" + event.CampaignID
+}
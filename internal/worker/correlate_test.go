@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelatorLinksRelatedEvents(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	tests := []struct {
+		name   string
+		first  models.WebhookEvent
+		second models.WebhookEvent
+		linked bool
+	}{
+		{
+			name:   "same email and campaign within window",
+			first:  models.WebhookEvent{Event: "bounced", Email: "a@example.com", CampaignID: "c1", ReceivedAt: at(0)},
+			second: models.WebhookEvent{Event: "spam", Email: "A@example.com", CampaignID: "c1", ReceivedAt: at(2 * time.Minute)},
+			linked: true,
+		},
+		{
+			name:   "same message ID within window",
+			first:  models.WebhookEvent{Event: "bounced", Email: "a@example.com", CustomFields: map[string]interface{}{"message_id": "m1"}, ReceivedAt: at(0)},
+			second: models.WebhookEvent{Event: "spam", Email: "b@example.com", CustomFields: map[string]interface{}{"message_id": "m1"}, ReceivedAt: at(time.Minute)},
+			linked: true,
+		},
+		{
+			name:   "outside window",
+			first:  models.WebhookEvent{Event: "bounced", Email: "a@example.com", CampaignID: "c1", ReceivedAt: at(0)},
+			second: models.WebhookEvent{Event: "spam", Email: "a@example.com", CampaignID: "c1", ReceivedAt: at(6 * time.Minute)},
+		},
+		{
+			name:   "different campaign",
+			first:  models.WebhookEvent{Event: "bounced", Email: "a@example.com", CampaignID: "c1", ReceivedAt: at(0)},
+			second: models.WebhookEvent{Event: "spam", Email: "a@example.com", CampaignID: "c2", ReceivedAt: at(time.Minute)},
+		},
+		{
+			name:   "different client",
+			first:  models.WebhookEvent{ClientID: "client-b", Event: "bounced", Email: "a@example.com", ReceivedAt: at(0)},
+			second: models.WebhookEvent{Event: "spam", Email: "a@example.com", ReceivedAt: at(time.Minute)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCorrelator(5*time.Minute, clock.NewMock(start))
+			first, second := tt.first, tt.second
+			first.WebhookID, second.WebhookID = "wh-1", "wh-2"
+			if first.ClientID == "" {
+				first.ClientID = "client-a"
+			}
+			second.ClientID = "client-a"
+
+			require.NoError(t, c.Process(context.Background(), &first))
+			require.NoError(t, c.Process(context.Background(), &second))
+
+			assert.Equal(t, "wh-1", first.CorrelationID, "the first event starts its own group")
+			if tt.linked {
+				assert.Equal(t, "wh-1", second.CorrelationID)
+			} else {
+				assert.Equal(t, "wh-2", second.CorrelationID)
+			}
+		})
+	}
+}
+
+func TestCorrelatorSkipsUnidentifiableEvents(t *testing.T) {
+	c := NewCorrelator(time.Minute, clock.NewMock(time.Now()))
+	event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", Event: "opened"}
+
+	require.NoError(t, c.Process(context.Background(), event))
+	assert.Empty(t, event.CorrelationID)
+}
+
+func TestCorrelatorForgetsClosedGroups(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	c := NewCorrelator(time.Minute, clk)
+
+	require.NoError(t, c.Process(context.Background(), &models.WebhookEvent{
+		WebhookID: "wh-1", ClientID: "client-a", Email: "a@example.com", ReceivedAt: start,
+	}))
+	clk.Advance(2 * time.Minute)
+	require.NoError(t, c.Process(context.Background(), &models.WebhookEvent{
+		WebhookID: "wh-2", ClientID: "client-a", Email: "b@example.com", ReceivedAt: clk.Now(),
+	}))
+
+	assert.Len(t, c.groups, 1)
+}
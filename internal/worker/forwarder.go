@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"webhook-processor/internal/models"
+)
+
+// Forwarder relays a stored event to a downstream system.
+type Forwarder interface {
+	Forward(ctx context.Context, event *models.WebhookEvent) error
+}
+
+// HTTPForwarder forwards events as JSON POST requests.
+type HTTPForwarder struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPForwarder(url string, timeout time.Duration) *HTTPForwarder {
+	return &HTTPForwarder{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (f *HTTPForwarder) Forward(ctx context.Context, event *models.WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", event.ClientID)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to forward event: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("downstream returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// overflowLane is shared by clients that arrive once every dedicated lane is
+// taken. Client IDs are never empty, so it can't collide with a client.
+const overflowLane = ""
+
+// laneIdleTimeout is how long a lane goroutine waits for work before exiting
+// and freeing its slot.
+const laneIdleTimeout = time.Minute
+
+// clientLanes processes each client's deliveries on its own goroutine so a
+// client whose events are slow or stuck in retry backoff can't stall the
+// others. At most maxLanes goroutines run; one is reserved as an overflow
+// lane shared by clients beyond the cap. Each lane buffers up to buffer
+// deliveries before dispatch blocks.
+type clientLanes struct {
+	w           *Worker
+	maxLanes    int
+	buffer      int
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	lanes map[string]chan amqp.Delivery
+}
+
+func newClientLanes(w *Worker, maxLanes, buffer int) *clientLanes {
+	if maxLanes < 1 {
+		maxLanes = 1
+	}
+	return &clientLanes{
+		w:           w,
+		maxLanes:    maxLanes,
+		buffer:      buffer,
+		idleTimeout: laneIdleTimeout,
+		lanes:       make(map[string]chan amqp.Delivery),
+	}
+}
+
+// dispatch hands msg to its client's lane, starting the lane if needed.
+func (l *clientLanes) dispatch(ctx context.Context, msg amqp.Delivery) {
+	clientID, _ := msg.Headers["client_id"].(string)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := clientID
+	if _, ok := l.lanes[key]; !ok && (key == "" || l.dedicatedLanes() >= l.maxLanes-1) {
+		key = overflowLane
+	}
+
+	lane, ok := l.lanes[key]
+	if !ok {
+		lane = make(chan amqp.Delivery, l.buffer)
+		l.lanes[key] = lane
+		go l.run(ctx, key, lane)
+	}
+
+	// Sending under the lock keeps an idle lane from exiting between the
+	// lookup and the send; lanes never take the lock while processing.
+	lane <- msg
+}
+
+func (l *clientLanes) dedicatedLanes() int {
+	if _, ok := l.lanes[overflowLane]; ok {
+		return len(l.lanes) - 1
+	}
+	return len(l.lanes)
+}
+
+func (l *clientLanes) run(ctx context.Context, key string, lane chan amqp.Delivery) {
+	idle := time.NewTimer(l.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case msg := <-lane:
+			l.w.handleDelivery(ctx, msg)
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(l.idleTimeout)
+		case <-idle.C:
+			l.mu.Lock()
+			if len(lane) > 0 {
+				l.mu.Unlock()
+				idle.Reset(l.idleTimeout)
+				continue
+			}
+			delete(l.lanes, key)
+			l.mu.Unlock()
+			return
+		}
+	}
+}
+
+// active returns the number of running lanes.
+func (l *clientLanes) active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.lanes)
+}
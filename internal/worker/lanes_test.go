@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// blockingStore holds inserts for one client until released.
+type blockingStore struct {
+	*storagetest.FakeStore
+	slowClient string
+	release    chan struct{}
+}
+
+func (s *blockingStore) InsertEvent(ctx context.Context, event *models.WebhookEvent) error {
+	if event.ClientID == s.slowClient {
+		<-s.release
+	}
+	return s.FakeStore.InsertEvent(ctx, event)
+}
+
+func clientDelivery(t *testing.T, ack amqp.Acknowledger, clientID string) amqp.Delivery {
+	t.Helper()
+	msg := newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+	msg.Headers = amqp.Table{"webhook_id": "wh-" + clientID, "client_id": clientID}
+	return msg
+}
+
+func TestSlowClientDoesNotBlockFastClient(t *testing.T) {
+	store := &blockingStore{FakeStore: storagetest.NewFakeStore(), slowClient: "slow", release: make(chan struct{})}
+	w := NewWorker(nil, store, zap.NewNop(), WithClientLanes(4, 10))
+	ctx := context.Background()
+
+	slowAck := newFakeAcknowledger()
+	fastAck := newFakeAcknowledger()
+	w.lanes.dispatch(ctx, clientDelivery(t, slowAck, "slow"))
+	w.lanes.dispatch(ctx, clientDelivery(t, fastAck, "fast"))
+
+	select {
+	case <-fastAck.done:
+	case <-time.After(time.Second):
+		t.Fatal("fast client was blocked by slow client")
+	}
+	acks, _ := slowAck.counts()
+	assert.Zero(t, acks, "slow client is still in progress")
+
+	close(store.release)
+	select {
+	case <-slowAck.done:
+	case <-time.After(time.Second):
+		t.Fatal("slow client was never processed")
+	}
+}
+
+func TestClientLanesCapGoroutines(t *testing.T) {
+	store := &blockingStore{FakeStore: storagetest.NewFakeStore(), slowClient: "client-1", release: make(chan struct{})}
+	defer close(store.release)
+	w := NewWorker(nil, store, zap.NewNop(), WithClientLanes(2, 10))
+
+	for _, clientID := range []string{"client-1", "client-2", "client-3"} {
+		w.lanes.dispatch(context.Background(), clientDelivery(t, newFakeAcknowledger(), clientID))
+	}
+
+	assert.Equal(t, 2, w.lanes.active(), "clients beyond the cap share the overflow lane")
+}
+
+func TestIdleLaneExits(t *testing.T) {
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithClientLanes(2, 10))
+	w.lanes.idleTimeout = 10 * time.Millisecond
+
+	ack := newFakeAcknowledger()
+	w.lanes.dispatch(context.Background(), clientDelivery(t, ack, "client-a"))
+	<-ack.done
+
+	require.Eventually(t, func() bool { return w.lanes.active() == 0 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewOutcomeLogger builds the compact outcome logger: one JSON line per
+// processed event, written to outputPath and kept apart from the verbose
+// operational logs.
+func NewOutcomeLogger(outputPath string) (*zap.Logger, error) {
+	config := zap.Config{
+		Encoding:         "json",
+		Level:            zap.NewAtomicLevelAt(zapcore.InfoLevel),
+		OutputPaths:      []string{outputPath},
+		ErrorOutputPaths: []string{"stderr"},
+		EncoderConfig: zapcore.EncoderConfig{
+			TimeKey:    "time",
+			EncodeTime: zapcore.ISO8601TimeEncoder,
+		},
+	}
+
+	return config.Build()
+}
+
+// LogOutcome writes the outcome of a single event to the outcome log, if one
+// is configured.
+func (w *Worker) LogOutcome(event *models.WebhookEvent, duration time.Duration) {
+	if w.outcomeLogger == nil {
+		return
+	}
+
+	w.outcomeLogger.Info("",
+		zap.String("webhook_id", event.WebhookID),
+		zap.String("client_id", event.ClientID),
+		zap.String("event", event.Event),
+		zap.String("status", event.Status),
+		zap.Float64("duration_ms", float64(duration.Microseconds())/1000),
+	)
+}
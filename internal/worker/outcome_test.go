@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogOutcomeWritesToSeparateSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outcomes.log")
+	outcomeLogger, err := NewOutcomeLogger(path)
+	require.NoError(t, err)
+
+	core, mainLogs := observer.New(zap.DebugLevel)
+	w := NewWorker(nil, nil, zap.New(core), WithOutcomeLogger(outcomeLogger))
+
+	w.LogOutcome(&models.WebhookEvent{
+		WebhookID: "wh-1",
+		ClientID:  "client-a",
+		Event:     "bounced",
+		Status:    string(models.EventStatusProcessed),
+	}, 1500*time.Microsecond)
+	require.NoError(t, outcomeLogger.Sync())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "wh-1", line["webhook_id"])
+	assert.Equal(t, "client-a", line["client_id"])
+	assert.Equal(t, "bounced", line["event"])
+	assert.Equal(t, "processed", line["status"])
+	assert.Equal(t, 1.5, line["duration_ms"])
+
+	assert.Zero(t, mainLogs.Len(), "outcome lines must not reach the operational logger")
+}
+
+func TestLogOutcomeWithoutOutcomeLogger(t *testing.T) {
+	w := NewWorker(nil, nil, zap.NewNop())
+
+	assert.NotPanics(t, func() {
+		w.LogOutcome(&models.WebhookEvent{WebhookID: "wh-1"}, time.Millisecond)
+	})
+}
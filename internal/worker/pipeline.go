@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"webhook-processor/internal/models"
+)
+
+// ErrDropEvent may be returned by a Processor to discard an event: the
+// delivery is acked and the event is not stored.
+var ErrDropEvent = errors.New("event dropped by processor")
+
+// Processor transforms or validates an event before it is stored. Processors
+// run in order and may mutate the event. Returning ErrDropEvent discards the
+// event; any other error rejects the delivery without requeueing, so
+// processors should only fail on problems a retry cannot fix.
+type Processor interface {
+	Process(ctx context.Context, event *models.WebhookEvent) error
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(ctx context.Context, event *models.WebhookEvent) error
+
+func (f ProcessorFunc) Process(ctx context.Context, event *models.WebhookEvent) error {
+	return f(ctx, event)
+}
+
+// Built-in processor names accepted by NewProcessors.
+const (
+	ProcessorNormalize = "normalize"
+	ProcessorValidate  = "validate"
+	ProcessorRedact    = "redact"
+)
+
+var builtinProcessors = map[string]Processor{
+	ProcessorNormalize: ProcessorFunc(normalizeEvent),
+	ProcessorValidate:  ProcessorFunc(validateEvent),
+	ProcessorRedact:    ProcessorFunc(redactEvent),
+}
+
+// NewProcessors resolves built-in processors by name, preserving order.
+func NewProcessors(names []string) ([]Processor, error) {
+	processors := make([]Processor, 0, len(names))
+	for _, name := range names {
+		p, ok := builtinProcessors[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q", name)
+		}
+		processors = append(processors, p)
+	}
+	return processors, nil
+}
+
+// runProcessors applies the configured processors in order, stopping at the
+// first error.
+func (w *Worker) runProcessors(ctx context.Context, event *models.WebhookEvent) error {
+	for _, p := range w.processors {
+		if err := p.Process(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeEvent lowercases the event type and trims and lowercases the email.
+func normalizeEvent(ctx context.Context, event *models.WebhookEvent) error {
+	event.Event = strings.ToLower(strings.TrimSpace(event.Event))
+	event.Email = strings.ToLower(strings.TrimSpace(event.Email))
+	return nil
+}
+
+// validateEvent rejects events missing the fields every downstream consumer
+// relies on. Campaign-level events are looked up by campaign rather than
+// recipient, so they need a campaign ID and their email isn't checked.
+func validateEvent(ctx context.Context, event *models.WebhookEvent) error {
+	if event.Event == "" {
+		return errors.New("missing event type")
+	}
+	if event.ClientID == "" {
+		return errors.New("missing client ID")
+	}
+	if event.Level() == models.EventLevelCampaign {
+		if event.CampaignID == "" {
+			return fmt.Errorf("missing campaign ID for %s event", event.Event)
+		}
+		return nil
+	}
+	if event.Email != "" && !strings.Contains(event.Email, "@") {
+		return fmt.Errorf("invalid email %q", event.Email)
+	}
+	return nil
+}
+
+// redactEvent masks the local part of the recipient email, keeping the first
+// character and the domain.
+func redactEvent(ctx context.Context, event *models.WebhookEvent) error {
+	at := strings.LastIndex(event.Email, "@")
+	if at <= 0 {
+		return nil
+	}
+	event.Email = event.Email[:1] + "***" + event.Email[at:]
+	return nil
+}
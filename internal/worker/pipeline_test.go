@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNormalizeThenValidateChain(t *testing.T) {
+	processors, err := NewProcessors([]string{"normalize", "validate"})
+	require.NoError(t, err)
+
+	store := storagetest.NewFakeStore()
+	w := NewWorker(nil, store, zap.NewNop(), WithProcessors(processors...))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{
+		Event: " Opened ",
+		Email: "User@Example.COM",
+	}))
+
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks)
+	assert.Zero(t, nacks)
+	inserts := store.Inserts()
+	require.Len(t, inserts, 1)
+	assert.Equal(t, "opened", inserts[0].Event)
+	assert.Equal(t, "user@example.com", inserts[0].Email)
+}
+
+func TestValidateRejectsBeforeStorage(t *testing.T) {
+	processors, err := NewProcessors([]string{"normalize", "validate"})
+	require.NoError(t, err)
+
+	store := storagetest.NewFakeStore()
+	w := NewWorker(nil, store, zap.NewNop(), WithProcessors(processors...))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "  "}))
+
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks)
+	assert.Equal(t, 1, nacks)
+	assert.False(t, ack.requeue, "invalid events are not retried")
+	assert.Empty(t, store.Inserts())
+}
+
+func TestValidateByEventLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   models.WebhookEvent
+		wantErr string
+	}{
+		{
+			name:  "campaign event without email",
+			event: models.WebhookEvent{Event: "campaign_sent", ClientID: "client-a", CampaignID: "c-1"},
+		},
+		{
+			name:  "campaign event email isn't checked",
+			event: models.WebhookEvent{Event: "Campaign Error", ClientID: "client-a", CampaignID: "c-1", Email: "n/a"},
+		},
+		{
+			name:    "campaign event without campaign",
+			event:   models.WebhookEvent{Event: "campaign_sent", ClientID: "client-a"},
+			wantErr: "missing campaign ID",
+		},
+		{
+			name:  "subscriber event without campaign",
+			event: models.WebhookEvent{Event: "opened", ClientID: "client-a", Email: "a@example.com"},
+		},
+		{
+			name:    "subscriber event with invalid email",
+			event:   models.WebhookEvent{Event: "opened", ClientID: "client-a", CampaignID: "c-1", Email: "n/a"},
+			wantErr: "invalid email",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEvent(context.Background(), &tt.event)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestProcessorCanDropEvent(t *testing.T) {
+	drop := ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		if event.Event == "test" {
+			return ErrDropEvent
+		}
+		return nil
+	})
+
+	store := storagetest.NewFakeStore()
+	w := NewWorker(nil, store, zap.NewNop(), WithProcessors(drop))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "test"}))
+
+	acks, _ := ack.counts()
+	assert.Equal(t, 1, acks, "dropped events are acked")
+	assert.Empty(t, store.Inserts())
+}
+
+func TestRedactEvent(t *testing.T) {
+	event := &models.WebhookEvent{Email: "user@example.com"}
+	require.NoError(t, redactEvent(context.Background(), event))
+	assert.Equal(t, "u***@example.com", event.Email)
+}
+
+func TestNewProcessorsUnknownName(t *testing.T) {
+	_, err := NewProcessors([]string{"normalize", "enrich"})
+	assert.Error(t, err)
+}
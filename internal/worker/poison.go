@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// maxTrackedMessages bounds the poison detector's memory; the entries that
+// failed least recently are forgotten first.
+const maxTrackedMessages = 10000
+
+// poisonDetector counts processing failures per message body. Delivery tags
+// change on every redelivery, so the body hash is what identifies a message
+// that keeps coming back.
+type poisonDetector struct {
+	threshold int
+
+	mu       sync.Mutex
+	failures map[[sha256.Size]byte]*list.Element
+	// lru holds a *poisonEntry per tracked body, most recent failure first
+	lru *list.List
+}
+
+type poisonEntry struct {
+	key      [sha256.Size]byte
+	failures int
+}
+
+func newPoisonDetector(threshold int) *poisonDetector {
+	return &poisonDetector{
+		threshold: threshold,
+		failures:  make(map[[sha256.Size]byte]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// recordFailure counts a failure of body and reports whether it has now
+// failed threshold times. A poisoned body is forgotten so a later,
+// deliberate re-publish starts from zero.
+func (p *poisonDetector) recordFailure(body []byte) bool {
+	key := sha256.Sum256(body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.failures[key]
+	if ok {
+		p.lru.MoveToFront(elem)
+	} else {
+		if p.lru.Len() >= maxTrackedMessages {
+			p.forget(p.lru.Back())
+		}
+		elem = p.lru.PushFront(&poisonEntry{key: key})
+		p.failures[key] = elem
+	}
+	entry := elem.Value.(*poisonEntry)
+	entry.failures++
+
+	if entry.failures >= p.threshold {
+		p.forget(elem)
+		return true
+	}
+	return false
+}
+
+// clear forgets body after it has been processed successfully.
+func (p *poisonDetector) clear(body []byte) {
+	key := sha256.Sum256(body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem, ok := p.failures[key]; ok {
+		p.forget(elem)
+	}
+}
+
+// forget stops tracking elem's body. p.mu must be held.
+func (p *poisonDetector) forget(elem *list.Element) {
+	p.lru.Remove(elem)
+	delete(p.failures, elem.Value.(*poisonEntry).key)
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRepeatedlyFailingMessageIsQuarantined(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("document too large"))
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clk), WithPoisonThreshold(3))
+	before := testutil.ToFloat64(metrics.PoisonMessages.WithLabelValues("repeated_failure"))
+
+	// Each redelivery arrives with a fresh retry count, as in production
+	for i := 0; i < 2; i++ {
+		ack := newFakeAcknowledger()
+		w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+		assert.True(t, ack.requeue, "delivery %d is retried", i+1)
+	}
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	_, nacks := ack.counts()
+	assert.Equal(t, 1, nacks)
+	assert.False(t, ack.requeue, "poison message is rejected without requeue")
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusFailed, status)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.PoisonMessages.WithLabelValues("repeated_failure")))
+}
+
+func TestPoisonCountResetOnSuccess(t *testing.T) {
+	p := newPoisonDetector(2)
+	body := []byte(`{"event":"opened"}`)
+
+	assert.False(t, p.recordFailure(body))
+	p.clear(body)
+	assert.False(t, p.recordFailure(body), "count restarts after a success")
+	assert.True(t, p.recordFailure(body))
+}
+
+func TestPoisonDetectorBoundsMemory(t *testing.T) {
+	p := newPoisonDetector(2)
+	for i := 0; i < maxTrackedMessages+10; i++ {
+		p.recordFailure([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	}
+
+	assert.Len(t, p.failures, maxTrackedMessages)
+}
+
+func TestPoisonDetectorForgetsClearedBodies(t *testing.T) {
+	p := newPoisonDetector(2)
+	for i := 0; i < 2*maxTrackedMessages; i++ {
+		body := []byte{byte(i), byte(i >> 8), byte(i >> 16)}
+		p.recordFailure(body)
+		p.clear(body)
+	}
+	assert.Empty(t, p.failures)
+	assert.Zero(t, p.lru.Len(), "cleared bodies don't linger in the eviction order")
+
+	// Poisoned bodies are forgotten too
+	body := []byte(`{"event":"opened"}`)
+	p.recordFailure(body)
+	assert.True(t, p.recordFailure(body))
+	assert.Zero(t, p.lru.Len())
+}
+
+func TestPoisonDetectorEvictsLeastRecentFailure(t *testing.T) {
+	p := newPoisonDetector(3)
+	first := []byte("first")
+	p.recordFailure(first)
+	for i := 1; i < maxTrackedMessages; i++ {
+		p.recordFailure([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	}
+	// first failed again most recently, so the next new body evicts another
+	p.recordFailure(first)
+	p.recordFailure([]byte("new"))
+
+	assert.Len(t, p.failures, maxTrackedMessages)
+	assert.True(t, p.recordFailure(first), "first's count was kept")
+}
@@ -3,12 +3,17 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand/v2"
+	"sync"
 	"time"
 
 	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
 	"webhook-processor/internal/storage"
+	"webhook-processor/pkg/clock"
 	"webhook-processor/pkg/metrics"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -16,100 +21,525 @@ import (
 )
 
 type Worker struct {
-	channel    *amqp.Channel
-	db         *storage.MongoDB
-	logger     *zap.Logger
-	maxRetries int
-	baseDelay  time.Duration
+	// mu guards channel, which reconnect replaces, the cancel funcs Start
+	// leaves for Stop and concurrency, which Restart changes.
+	mu              sync.Mutex
+	channel         Consumer
+	consumerTag     string
+	stopConsuming   context.CancelFunc
+	abortProcessing context.CancelFunc
+	consumerDone    chan struct{}
+	inflight        inflightTracker
+	redial          func() (Consumer, error)
+	redialDelay     time.Duration
+	redialMaxDelay  time.Duration
+	db              storage.EventStore
+	logger          *zap.Logger
+	outcomeLogger   *zap.Logger
+	forwarder       Forwarder
+	ackAfterForward bool
+	clock           clock.Clock
+	alerter         *Alerter
+	processors      []Processor
+	lanes           *clientLanes
+	concurrency     int
+	poison          *poisonDetector
+	deadLetterer    DeadLetterer
+	retrier         Retrier
+	maxRetries      int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	results         ResultPublisher
+	resultClients   map[string]bool
+	primarySource   string
+	sources         []Source
+	batchSize       int
+	batchWait       time.Duration
+	batchOrdered    bool
 }
 
-func NewWorker(channel *amqp.Channel, db *storage.MongoDB, logger *zap.Logger) *Worker {
-	return &Worker{
-		channel:    channel,
-		db:         db,
-		logger:     logger,
-		maxRetries: 3,
-		baseDelay:  10 * time.Second,
+// Consumer opens a delivery stream on a queue; *amqp.Channel implements it.
+type Consumer interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+}
+
+// DeadLetterer receives deliveries whose events exhausted their retries.
+type DeadLetterer interface {
+	DeadLetter(ctx context.Context, msg amqp.Delivery, reason string) error
+}
+
+// ResultPublisher confirms stored events back to the client;
+// *queue.ResultPublisher implements it.
+type ResultPublisher interface {
+	PublishResult(ctx context.Context, clientID string, result queue.Result) error
+}
+
+// Retrier redelivers a message after delay without blocking the consumer.
+type Retrier interface {
+	Retry(ctx context.Context, msg amqp.Delivery, retryCount int, delay time.Duration) error
+}
+
+// Option configures optional Worker behaviour.
+type Option func(*Worker)
+
+// WithOutcomeLogger sets the logger used by LogOutcome.
+func WithOutcomeLogger(l *zap.Logger) Option {
+	return func(w *Worker) {
+		w.outcomeLogger = l
+	}
+}
+
+// WithClock sets the clock used for timestamps and retry backoff.
+func WithClock(c clock.Clock) Option {
+	return func(w *Worker) {
+		w.clock = c
+	}
+}
+
+// WithAlerter sends alerts for critical event types after storage.
+func WithAlerter(a *Alerter) Option {
+	return func(w *Worker) {
+		w.alerter = a
+	}
+}
+
+// WithProcessors runs processors in order on each event before it is stored.
+func WithProcessors(processors ...Processor) Option {
+	return func(w *Worker) {
+		w.processors = processors
+	}
+}
+
+// WithClientLanes processes each client's events on a separate goroutine,
+// running at most maxLanes at once, each buffering up to buffer deliveries.
+func WithClientLanes(maxLanes, buffer int) Option {
+	return func(w *Worker) {
+		w.lanes = newClientLanes(w, maxLanes, buffer)
+	}
+}
+
+// WithConcurrency processes up to n deliveries at once, each acked or
+// nacked on its own. The channel's prefetch should be at least n.
+func WithConcurrency(n int) Option {
+	return func(w *Worker) {
+		w.concurrency = n
+	}
+}
+
+// WithPoisonThreshold quarantines a message once its body has failed
+// processing threshold times, regardless of its retry count. Zero disables
+// the check.
+func WithPoisonThreshold(threshold int) Option {
+	return func(w *Worker) {
+		w.poison = nil
+		if threshold > 0 {
+			w.poison = newPoisonDetector(threshold)
+		}
+	}
+}
+
+// WithForwarder relays stored events downstream. When ackAfterForward is
+// set, the delivery is only acked after the forward succeeds.
+func WithForwarder(f Forwarder, ackAfterForward bool) Option {
+	return func(w *Worker) {
+		w.forwarder = f
+		w.ackAfterForward = ackAfterForward
+	}
+}
+
+// WithDeadLetterer hands events that exhaust their retries to d with the
+// last error, instead of only marking them failed and dropping the message.
+func WithDeadLetterer(d DeadLetterer) Option {
+	return func(w *Worker) {
+		w.deadLetterer = d
+	}
+}
+
+// WithRedial reconnects when the delivery channel closes, e.g. because the
+// broker connection dropped. redial must return a fresh channel with the
+// queue declared; failed attempts are retried after delay, doubling up to
+// maxDelay.
+func WithRedial(redial func() (Consumer, error), delay, maxDelay time.Duration) Option {
+	return func(w *Worker) {
+		w.redial = redial
+		w.redialDelay = delay
+		w.redialMaxDelay = maxDelay
+	}
+}
+
+// WithResultPublisher publishes a result through p once each event from
+// one of clients has been stored and acked. Failing to publish a result
+// doesn't fail the event.
+func WithResultPublisher(p ResultPublisher, clients []string) Option {
+	return func(w *Worker) {
+		w.results = p
+		w.resultClients = make(map[string]bool, len(clients))
+		for _, clientID := range clients {
+			w.resultClients[clientID] = true
+		}
 	}
 }
 
+// WithDelayedRetry schedules retries through r, acking the failed delivery,
+// instead of sleeping for the backoff before requeueing it.
+func WithDelayedRetry(r Retrier) Option {
+	return func(w *Worker) {
+		w.retrier = r
+	}
+}
+
+// WithRetryPolicy sets how many failed attempts mark an event failed and the
+// exponential backoff between them, starting at baseDelay and capped at
+// maxDelay. Zero values keep the defaults.
+func WithRetryPolicy(maxRetries int, baseDelay, maxDelay time.Duration) Option {
+	return func(w *Worker) {
+		if maxRetries > 0 {
+			w.maxRetries = maxRetries
+		}
+		if baseDelay > 0 {
+			w.baseDelay = baseDelay
+		}
+		if maxDelay > 0 {
+			w.maxDelay = maxDelay
+		}
+	}
+}
+
+func NewWorker(channel Consumer, db storage.EventStore, logger *zap.Logger, opts ...Option) *Worker {
+	w := &Worker{
+		channel:     channel,
+		consumerTag: newConsumerTag(),
+		db:          db,
+		logger:      logger,
+		maxRetries:  3,
+		baseDelay:   10 * time.Second,
+		maxDelay:    5 * time.Minute,
+		clock:       clock.New(),
+		poison:      newPoisonDetector(5),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Start consumes queueName, and any WithSources queues, until ctx is done or
+// Stop is called. Without WithRedial consuming stops for good if the
+// delivery channel closes. Deliveries already being processed aren't
+// cancelled with ctx, so they can finish and be acked; Stop waits for them.
 func (w *Worker) Start(ctx context.Context, queueName string) error {
-	msgs, err := w.channel.Consume(
+	streams, err := w.consumeSources(w.channel, queueName)
+	if err != nil {
+		return err
+	}
+	w.run(ctx, queueName, streams)
+	return nil
+}
+
+// run handles the streams' deliveries in the background, reconnecting when
+// the delivery channel closes, until ctx is done or Stop is called.
+func (w *Worker) run(ctx context.Context, queueName string, streams []sourceStream) {
+	processCtx, abortProcessing := context.WithCancel(context.WithoutCancel(ctx))
+	ctx, stopConsuming := context.WithCancel(ctx)
+	done := make(chan struct{})
+	w.mu.Lock()
+	w.stopConsuming, w.abortProcessing, w.consumerDone = stopConsuming, abortProcessing, done
+	w.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			w.consumeUntilClosed(ctx, processCtx, mergeStreams(ctx, streams))
+			if ctx.Err() != nil {
+				return
+			}
+			if w.redial == nil {
+				w.logger.Error("Delivery channel closed, no longer consuming", zap.String("queue", queueName))
+				return
+			}
+			w.logger.Warn("Delivery channel closed, reconnecting", zap.String("queue", queueName))
+			if streams = w.reconnect(ctx, queueName); streams == nil {
+				return
+			}
+		}
+	}()
+}
+
+func consume(ch Consumer, queueName, consumerTag string) (<-chan amqp.Delivery, error) {
+	return ch.Consume(
 		queueName,
-		"",    // consumer
+		consumerTag,
 		false, // auto-ack
 		false, // exclusive
 		false, // no-local
 		false, // no-wait
 		nil,   // args
 	)
-	if err != nil {
-		return err
+}
+
+// consumeUntilClosed handles deliveries under processCtx until msgs closes
+// or ctx is done, on as many goroutines as the configured concurrency, each
+// collecting its own batches if batching is enabled.
+func (w *Worker) consumeUntilClosed(ctx, processCtx context.Context, msgs <-chan amqp.Delivery) {
+	w.mu.Lock()
+	concurrency := w.concurrency
+	w.mu.Unlock()
+	loop := w.consumeLoop
+	if w.batchSize > 1 && w.lanes == nil {
+		loop = w.consumeBatches
+	}
+	if concurrency <= 1 {
+		loop(ctx, processCtx, msgs)
+		return
 	}
 
-	go func() {
-		for msg := range msgs {
-			// Process message
-			event := &models.WebhookEvent{
-				Status:     string(models.EventStatusPending),
-				ReceivedAt: time.Now().UTC(),
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(ctx, processCtx, msgs)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) consumeLoop(ctx, processCtx context.Context, msgs <-chan amqp.Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
 			}
-			if err := json.Unmarshal(msg.Body, event); err != nil {
-				w.logger.Error("Failed to unmarshal message",
-					zap.Error(err),
-					zap.String("body", string(msg.Body)))
-				msg.Nack(false, false)
+			if w.lanes != nil {
+				w.lanes.dispatch(processCtx, msg)
 				continue
 			}
+			w.handleDelivery(processCtx, msg)
+		}
+	}
+}
 
-			// Get metadata from headers
-			// Log raw headers for debugging
-			w.logger.Info("Processing message",
-				zap.Any("headers", msg.Headers),
-				zap.String("body", string(msg.Body)))
-
-			// Extract metadata from headers
-			if headers := msg.Headers; headers != nil {
-				// Convert interface values to strings if present
-				webhookID, _ := headers["webhook_id"].(string)
-				webhookType, _ := headers["webhook_type"].(string)
-				clientID, _ := headers["client_id"].(string)
-
-				// Log extracted values
-				w.logger.Info("Extracted metadata",
-					zap.String("webhook_id", webhookID),
-					zap.String("webhook_type", webhookType),
-					zap.String("client_id", clientID))
-
-				if webhookID != "" {
-					event.WebhookID = webhookID
-				}
-				if webhookType != "" {
-					event.WebhookType = webhookType
-				}
-				if clientID != "" {
-					event.ClientID = clientID
-				}
+// reconnect redials with capped exponential backoff until consuming resumes
+// or ctx is done, when it returns nil.
+func (w *Worker) reconnect(ctx context.Context, queueName string) []sourceStream {
+	delay := w.redialDelay
+	for attempt := 1; ; attempt++ {
+		ch, err := w.redial()
+		if err == nil {
+			var streams []sourceStream
+			if streams, err = w.consumeSources(ch, queueName); err == nil {
+				w.mu.Lock()
+				w.channel = ch
+				w.mu.Unlock()
+				w.logger.Info("Reconnected, consuming again",
+					zap.String("queue", queueName),
+					zap.Int("attempt", attempt))
+				return streams
 			}
+		}
+		w.logger.Warn("Reconnect attempt failed",
+			zap.Error(err),
+			zap.Int("attempt", attempt),
+			zap.Duration("retry_in", delay))
 
-			// Start processing timer
-			start := time.Now()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		if delay *= 2; delay > w.redialMaxDelay {
+			delay = w.redialMaxDelay
+		}
+	}
+}
 
-			// Process the event
-			if err := w.processEvent(ctx, event); err != nil {
-				w.handleError(ctx, event, msg, err)
-				continue
-			}
+// handleDelivery processes a single delivery and acks or nacks it. A panic
+// is recovered and the message dead-lettered. Once Stop has begun the
+// delivery is left unacked, for the broker to requeue when the channel
+// closes.
+func (w *Worker) handleDelivery(ctx context.Context, msg amqp.Delivery) {
+	if !w.inflight.begin() {
+		return
+	}
+	defer w.inflight.end()
+
+	tracker := &settleTracker{Acknowledger: msg.Acknowledger}
+	msg.Acknowledger = tracker
+	defer w.recoverDelivery(ctx, msg, tracker)
+
+	event, start, ok := w.prepareDelivery(ctx, msg)
+	if !ok {
+		return
+	}
+	w.completeDelivery(ctx, msg, event, start, w.processEvent(ctx, event))
+}
+
+// prepareDelivery decodes msg and runs the processors on its event,
+// returning it and when processing started. It returns false if msg has
+// already been settled, because it couldn't be decoded or a processor
+// dropped or rejected it.
+func (w *Worker) prepareDelivery(ctx context.Context, msg amqp.Delivery) (*models.WebhookEvent, time.Time, bool) {
+	// Process message
+	event := &models.WebhookEvent{
+		Status:     string(models.EventStatusPending),
+		ReceivedAt: w.clock.Now().UTC(),
+	}
+	if err := json.Unmarshal(msg.Body, event); err != nil {
+		w.logger.Error("Failed to unmarshal message",
+			zap.Error(err),
+			zap.String("body", string(msg.Body)))
+		metrics.PoisonMessages.WithLabelValues("unmarshal").Inc()
+		w.rejectPoison(ctx, msg, "unmarshal: "+err.Error())
+		return nil, time.Time{}, false
+	}
+
+	// Retries keep the original timestamp, so their wait counts too
+	if !msg.Timestamp.IsZero() {
+		metrics.WebhookMessageAge.Observe(w.clock.Now().Sub(msg.Timestamp).Seconds())
+	}
+
+	// Get metadata from headers
+	// Log raw headers for debugging
+	w.logger.Info("Processing message",
+		zap.Any("headers", msg.Headers),
+		zap.String("body", string(msg.Body)))
 
-			// Record metrics
-			metrics.WebhookProcessed.WithLabelValues(event.ClientID, event.Event, "success").Inc()
-			metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, event.Event).Observe(time.Since(start).Seconds())
+	// Extract metadata from headers
+	if headers := msg.Headers; headers != nil {
+		// Convert interface values to strings if present
+		webhookID, _ := headers["webhook_id"].(string)
+		webhookType, _ := headers["webhook_type"].(string)
+		clientID, _ := headers["client_id"].(string)
+		event.RequestID, _ = headers[queue.RequestIDHeader].(string)
+		event.Source, _ = headers[SourceHeader].(string)
 
+		// Log extracted values
+		w.logger.Info("Extracted metadata",
+			zap.String("webhook_id", webhookID),
+			zap.String("webhook_type", webhookType),
+			zap.String("client_id", clientID),
+			zap.String("request_id", event.RequestID),
+			zap.String("source", event.Source))
+
+		if webhookID != "" {
+			event.WebhookID = webhookID
+		}
+		if webhookType != "" {
+			event.WebhookType = webhookType
+		}
+		if clientID != "" {
+			event.ClientID = clientID
+		}
+		// Keep the API receive time so stored counts line up with publishes
+		if receivedAt, ok := headers["received_at"].(time.Time); ok && !receivedAt.IsZero() {
+			event.ReceivedAt = receivedAt.UTC()
+		}
+		// Set by the retry queue, which the body doesn't record
+		switch n := headers[queue.RetryCountHeader].(type) {
+		case int32:
+			event.RetryCount = int(n)
+		case int64:
+			event.RetryCount = int(n)
+		}
+	}
+
+	// Start processing timer
+	start := w.clock.Now()
+
+	if err := w.runProcessors(ctx, event); err != nil {
+		if errors.Is(err, ErrDropEvent) {
+			w.logger.Info("Event dropped by processor",
+				zap.String("client_id", event.ClientID),
+				zap.String("webhook_id", event.WebhookID),
+				zap.String("request_id", event.RequestID))
+			metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "dropped").Inc()
+			event.Status = "dropped"
+			w.LogOutcome(event, w.clock.Now().Sub(start))
 			msg.Ack(false)
+			return nil, time.Time{}, false
 		}
-	}()
 
-	return nil
+		w.logger.Warn("Event rejected by processor",
+			zap.Error(err),
+			zap.String("client_id", event.ClientID),
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("request_id", event.RequestID))
+		metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "rejected").Inc()
+		event.Status = "rejected"
+		w.LogOutcome(event, w.clock.Now().Sub(start))
+		msg.Nack(false, false)
+		return nil, time.Time{}, false
+	}
+	return event, start, true
+}
+
+// completeDelivery settles msg once its event has been stored, or failed to
+// be with err, and runs everything that follows storage.
+func (w *Worker) completeDelivery(ctx context.Context, msg amqp.Delivery, event *models.WebhookEvent, start time.Time, err error) {
+	if err != nil {
+		w.handleError(ctx, event, msg, err)
+		w.LogOutcome(event, w.clock.Now().Sub(start))
+		return
+	}
+
+	if w.alerter != nil {
+		w.alerter.Notify(event)
+	}
+
+	// In relay deployments the message is only acked once the downstream
+	// forward has been confirmed; failures go through the retry path.
+	if w.forwarder != nil && w.ackAfterForward {
+		if err := w.forwarder.Forward(ctx, event); err != nil {
+			w.handleError(ctx, event, msg, fmt.Errorf("forward failed: %w", err))
+			w.LogOutcome(event, w.clock.Now().Sub(start))
+			return
+		}
+	}
+
+	// Record metrics
+	metrics.WebhookProcessed.WithLabelValues(event.ClientID, string(event.Type()), "success").Inc()
+	metrics.WebhookProcessingTime.WithLabelValues(event.ClientID, string(event.Type())).Observe(w.clock.Now().Sub(start).Seconds())
+	w.LogOutcome(event, w.clock.Now().Sub(start))
+
+	if w.poison != nil {
+		w.poison.clear(msg.Body)
+	}
+	msg.Ack(false)
+
+	if w.results != nil && w.resultClients[event.ClientID] {
+		w.publishResult(ctx, event)
+	}
+
+	// Without deferred acks, forwarding is best-effort after the ack
+	if w.forwarder != nil && !w.ackAfterForward {
+		if err := w.forwarder.Forward(ctx, event); err != nil {
+			w.logger.Warn("Failed to forward event",
+				zap.Error(err),
+				zap.String("client_id", event.ClientID),
+				zap.String("webhook_id", event.WebhookID),
+				zap.String("request_id", event.RequestID))
+		}
+	}
+}
+
+func (w *Worker) publishResult(ctx context.Context, event *models.WebhookEvent) {
+	result := queue.Result{
+		WebhookID: event.WebhookID,
+		Status:    event.Status,
+		StoredAt:  w.clock.Now().UTC(),
+	}
+	if err := w.results.PublishResult(ctx, event.ClientID, result); err != nil {
+		w.logger.Warn("Failed to publish event result",
+			zap.Error(err),
+			zap.String("client_id", event.ClientID),
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("request_id", event.RequestID))
+	}
 }
 
 func (w *Worker) processEvent(ctx context.Context, event *models.WebhookEvent) error {
@@ -119,23 +549,54 @@ func (w *Worker) processEvent(ctx context.Context, event *models.WebhookEvent) e
 	}
 
 	// Update status
-	return w.db.UpdateEventStatus(ctx, event, models.EventStatusProcessed)
+	if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusProcessed); err != nil {
+		return err
+	}
+	event.Status = string(models.EventStatusProcessed)
+	return nil
 }
 
 func (w *Worker) handleError(ctx context.Context, event *models.WebhookEvent, msg amqp.Delivery, err error) {
 	w.logger.Error("Failed to process event",
 		zap.Error(err),
 		zap.String("client_id", event.ClientID),
-		zap.String("event", event.Event))
+		zap.String("event", event.Event),
+		zap.String("request_id", event.RequestID))
+
+	if recordErr := w.db.RecordClientError(ctx, event, err.Error(), w.clock.Now().UTC()); recordErr != nil {
+		w.logger.Error("Failed to record client error",
+			zap.Error(recordErr),
+			zap.String("client_id", event.ClientID))
+	}
+
+	// A message that keeps failing is quarantined even if its retry count
+	// never reaches the limit (it isn't carried across redeliveries)
+	if w.poison != nil && w.poison.recordFailure(msg.Body) {
+		w.quarantine(ctx, event, msg, err)
+		return
+	}
 
 	event.RetryCount++
-	metrics.WebhookRetries.WithLabelValues(event.ClientID, event.Event).Inc()
+	metrics.WebhookRetries.WithLabelValues(event.ClientID, string(event.Type())).Inc()
 
 	if event.RetryCount >= w.maxRetries {
 		// Max retries reached, mark as failed
+		event.Status = string(models.EventStatusFailed)
 		if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusFailed); err != nil {
 			w.logger.Error("Failed to update event status", zap.Error(err))
 		}
+		if w.deadLetterer != nil {
+			if dlErr := w.deadLetterer.DeadLetter(ctx, msg, err.Error()); dlErr != nil {
+				// Keep the message on the broker rather than lose it
+				w.logger.Error("Failed to dead-letter event",
+					zap.Error(dlErr),
+					zap.String("client_id", event.ClientID),
+					zap.String("webhook_id", event.WebhookID),
+					zap.String("request_id", event.RequestID))
+				msg.Nack(false, true)
+				return
+			}
+		}
 		msg.Ack(false)
 		return
 	}
@@ -144,18 +605,69 @@ func (w *Worker) handleError(ctx context.Context, event *models.WebhookEvent, ms
 	delay := w.calculateBackoff(event.RetryCount)
 
 	// Update status to retrying
+	event.Status = string(models.EventStatusRetrying)
 	if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusRetrying); err != nil {
 		w.logger.Error("Failed to update event status", zap.Error(err))
 	}
 
+	if w.retrier != nil {
+		err := w.retrier.Retry(ctx, msg, event.RetryCount, delay)
+		if err == nil {
+			msg.Ack(false)
+			return
+		}
+		w.logger.Error("Failed to schedule delayed retry, requeueing",
+			zap.Error(err),
+			zap.String("client_id", event.ClientID),
+			zap.String("webhook_id", event.WebhookID),
+			zap.String("request_id", event.RequestID))
+	}
+
 	// Requeue with delay
-	time.Sleep(delay)
+	w.clock.Sleep(delay)
 	msg.Nack(false, true)
 }
 
+// quarantine marks a poison message's event failed and rejects the message
+// with rejectPoison.
+func (w *Worker) quarantine(ctx context.Context, event *models.WebhookEvent, msg amqp.Delivery, err error) {
+	w.logger.Error("Quarantining poison message after repeated failures",
+		zap.String("client_id", event.ClientID),
+		zap.String("webhook_id", event.WebhookID),
+		zap.String("event", event.Event),
+		zap.String("request_id", event.RequestID))
+	metrics.PoisonMessages.WithLabelValues("repeated_failure").Inc()
+
+	event.Status = string(models.EventStatusFailed)
+	if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusFailed); err != nil {
+		w.logger.Error("Failed to update event status", zap.Error(err))
+	}
+	w.rejectPoison(ctx, msg, "repeated failures: "+err.Error())
+}
+
+// rejectPoison settles a message that would fail again if redelivered. It
+// goes to the dead-letterer if there is one; otherwise, or if that fails,
+// it is rejected without requeueing, so the queue's own dead-letter
+// exchange (if any) receives it.
+func (w *Worker) rejectPoison(ctx context.Context, msg amqp.Delivery, reason string) {
+	if w.deadLetterer != nil {
+		err := w.deadLetterer.DeadLetter(ctx, msg, reason)
+		if err == nil {
+			msg.Ack(false)
+			return
+		}
+		w.logger.Error("Failed to dead-letter poison message", zap.Error(err))
+	}
+	msg.Nack(false, false)
+}
+
 func (w *Worker) calculateBackoff(retryCount int) time.Duration {
 	// Exponential backoff with jitter
 	backoff := float64(w.baseDelay) * math.Pow(2, float64(retryCount-1))
 	jitter := (rand.Float64()*0.5 + 0.5) // 50% jitter
-	return time.Duration(backoff * jitter)
+	// Compare before converting so large retry counts can't overflow
+	if delay := backoff * jitter; delay < float64(w.maxDelay) {
+		return time.Duration(delay)
+	}
+	return w.maxDelay
 }
@@ -0,0 +1,632 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+	"webhook-processor/internal/storage"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+	"webhook-processor/pkg/metrics"
+
+	dto "github.com/prometheus/client_model/go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeAcknowledger records acks and nacks issued on a delivery.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acks    int
+	nacks   int
+	requeue bool
+	done    chan struct{}
+}
+
+func newFakeAcknowledger() *fakeAcknowledger {
+	return &fakeAcknowledger{done: make(chan struct{}, 1)}
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	a.acks++
+	a.mu.Unlock()
+	a.done <- struct{}{}
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	a.nacks++
+	a.requeue = requeue
+	a.mu.Unlock()
+	a.done <- struct{}{}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *fakeAcknowledger) counts() (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.acks, a.nacks
+}
+
+type fakeForwarder struct {
+	release chan error
+	called  chan struct{}
+}
+
+func (f *fakeForwarder) Forward(ctx context.Context, event *models.WebhookEvent) error {
+	f.called <- struct{}{}
+	return <-f.release
+}
+
+func newDelivery(t *testing.T, ack amqp.Acknowledger, event models.WebhookEvent) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(event)
+	require.NoError(t, err)
+	return amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Body:         body,
+		Headers: amqp.Table{
+			"webhook_id": "wh-1",
+			"client_id":  "client-a",
+		},
+	}
+}
+
+func TestAckDeferredUntilForwardSucceeds(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	forwarder := &fakeForwarder{release: make(chan error), called: make(chan struct{}, 1)}
+	w := NewWorker(nil, store, zap.NewNop(), WithForwarder(forwarder, true))
+
+	ack := newFakeAcknowledger()
+	go w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	select {
+	case <-forwarder.called:
+	case <-time.After(time.Second):
+		t.Fatal("forwarder was not called")
+	}
+
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks, "message must not be acked before downstream confirmation")
+	assert.Zero(t, nacks)
+	assert.Len(t, store.Inserts(), 1, "event is stored before forwarding")
+
+	forwarder.release <- nil
+
+	select {
+	case <-ack.done:
+	case <-time.After(time.Second):
+		t.Fatal("message was not acked after downstream success")
+	}
+	acks, nacks = ack.counts()
+	assert.Equal(t, 1, acks)
+	assert.Zero(t, nacks)
+}
+
+func TestForwardFailureIsRetried(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	forwarder := &fakeForwarder{release: make(chan error, 1), called: make(chan struct{}, 1)}
+	forwarder.release <- errors.New("downstream unavailable")
+	clk := clock.NewMock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(nil, store, zap.NewNop(), WithForwarder(forwarder, true), WithClock(clk))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks)
+	assert.Equal(t, 1, nacks)
+	assert.True(t, ack.requeue, "failed forwards are requeued for retry")
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusRetrying, status)
+}
+
+func TestAckBeforeForwardWhenNotDeferred(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	forwarder := &fakeForwarder{release: make(chan error, 1), called: make(chan struct{}, 1)}
+	forwarder.release <- errors.New("downstream unavailable")
+	w := NewWorker(nil, store, zap.NewNop(), WithForwarder(forwarder, false))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks, "best-effort forwarding does not affect the ack")
+	assert.Zero(t, nacks)
+}
+
+func TestHandleErrorBacksOffOnClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithClock(clk))
+
+	tests := []struct {
+		retryCount int
+		min, max   time.Duration
+	}{
+		{retryCount: 0, min: w.baseDelay / 2, max: w.baseDelay},
+		{retryCount: 1, min: w.baseDelay, max: 2 * w.baseDelay},
+	}
+
+	for _, tt := range tests {
+		clk.Set(start)
+		ack := newFakeAcknowledger()
+		event := &models.WebhookEvent{RetryCount: tt.retryCount}
+
+		w.handleError(context.Background(), event, amqp.Delivery{Acknowledger: ack}, errors.New("boom"))
+
+		waited := clk.Now().Sub(start)
+		assert.GreaterOrEqual(t, waited, tt.min)
+		assert.LessOrEqual(t, waited, tt.max)
+		_, nacks := ack.counts()
+		assert.Equal(t, 1, nacks)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithRetryPolicy(0, 0, 0))
+	assert.Equal(t, 3, w.maxRetries, "zero values keep the defaults")
+	assert.Equal(t, 10*time.Second, w.baseDelay)
+	assert.Equal(t, 5*time.Minute, w.maxDelay)
+
+	w = NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithRetryPolicy(5, time.Second, 30*time.Second))
+	assert.Equal(t, 5, w.maxRetries)
+	assert.LessOrEqual(t, w.calculateBackoff(1), time.Second, "the first retry waits at most the base delay")
+	for retry := 7; retry < 40; retry++ {
+		assert.Equal(t, 30*time.Second, w.calculateBackoff(retry), "retry %d is capped", retry)
+	}
+}
+
+func TestFailureRecordsClientLastError(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("mongo unavailable"))
+	clk := clock.NewMock(now)
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clk))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "bounced"}))
+
+	lastErr, err := store.GetClientLastError(context.Background(), "client-a")
+	require.NoError(t, err)
+	require.NotNil(t, lastErr)
+	assert.Contains(t, lastErr.LastError, "mongo unavailable")
+	assert.Equal(t, now, lastErr.LastErrorAt, "recorded before the backoff sleep")
+	assert.Equal(t, "wh-1", lastErr.WebhookID)
+	assert.Equal(t, "bounced", lastErr.Event)
+
+	_, nacks := ack.counts()
+	assert.Equal(t, 1, nacks)
+}
+
+// fakeResultPublisher records results and the store's inserts at the time
+// each was published.
+type fakeResultPublisher struct {
+	store   *storagetest.FakeStore
+	clients []string
+	results []queue.Result
+	stored  []int
+	err     error
+}
+
+func (p *fakeResultPublisher) PublishResult(ctx context.Context, clientID string, result queue.Result) error {
+	p.clients = append(p.clients, clientID)
+	p.results = append(p.results, result)
+	p.stored = append(p.stored, len(p.store.Inserts()))
+	return p.err
+}
+
+func TestResultPublishedAfterStorage(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := storagetest.NewFakeStore()
+	results := &fakeResultPublisher{store: store}
+	w := NewWorker(nil, store, zap.NewNop(), WithResultPublisher(results, []string{"client-a"}), WithClock(clock.NewMock(now)))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	require.Len(t, results.results, 1)
+	assert.Equal(t, []string{"client-a"}, results.clients)
+	assert.Equal(t, queue.Result{WebhookID: "wh-1", Status: string(models.EventStatusProcessed), StoredAt: now}, results.results[0])
+	assert.Equal(t, []int{1}, results.stored, "the result follows storage")
+	acks, _ := ack.counts()
+	assert.Equal(t, 1, acks)
+}
+
+func TestResultsOnlyForEnabledClients(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	results := &fakeResultPublisher{store: store}
+	w := NewWorker(nil, store, zap.NewNop(), WithResultPublisher(results, []string{"client-b"}))
+
+	w.handleDelivery(context.Background(), newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"}))
+
+	assert.Len(t, store.Inserts(), 1)
+	assert.Empty(t, results.results)
+}
+
+func TestResultNotPublishedForFailedEvents(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("mongo unavailable"))
+	results := &fakeResultPublisher{store: store}
+	w := NewWorker(nil, store, zap.NewNop(), WithResultPublisher(results, []string{"client-a"}),
+		WithClock(clock.NewMock(time.Now())))
+
+	w.handleDelivery(context.Background(), newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"}))
+
+	assert.Empty(t, results.results)
+}
+
+func TestResultFailureDoesNotFailEvent(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	results := &fakeResultPublisher{store: store, err: errors.New("broker unavailable")}
+	w := NewWorker(nil, store, zap.NewNop(), WithResultPublisher(results, []string{"client-a"}))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks)
+	assert.Zero(t, nacks)
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusProcessed, status)
+}
+
+func TestReceivedAtRestoredFromHeader(t *testing.T) {
+	receivedAt := time.Date(2024, 6, 1, 11, 59, 0, 0, time.UTC)
+	store := storagetest.NewFakeStore()
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clock.NewMock(receivedAt.Add(time.Minute))))
+
+	msg := newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"})
+	msg.Headers["received_at"] = receivedAt
+	w.handleDelivery(context.Background(), msg)
+
+	inserts := store.Inserts()
+	require.Len(t, inserts, 1)
+	assert.Equal(t, receivedAt, inserts[0].ReceivedAt, "stored receive time is the API's, not the consume time")
+}
+
+func TestMessageAgeRecorded(t *testing.T) {
+	messageAge := func() (uint64, float64) {
+		var m dto.Metric
+		require.NoError(t, metrics.WebhookMessageAge.Write(&m))
+		return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+	}
+	publishedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithClock(clock.NewMock(publishedAt.Add(30*time.Second))))
+	count, sum := messageAge()
+
+	msg := newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"})
+	msg.Timestamp = publishedAt
+	w.handleDelivery(context.Background(), msg)
+
+	gotCount, gotSum := messageAge()
+	assert.Equal(t, count+1, gotCount)
+	assert.InDelta(t, 30, gotSum-sum, 0.001, "age is from publish to pick-up")
+
+	w.handleDelivery(context.Background(), newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"}))
+	gotCount, _ = messageAge()
+	assert.Equal(t, count+1, gotCount, "messages without a timestamp have no age")
+}
+
+func TestRequestIDLoggedFromHeader(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("mongo unavailable"))
+	core, logs := observer.New(zap.InfoLevel)
+	w := NewWorker(nil, store, zap.New(core), WithClock(clock.NewMock(time.Now())))
+
+	msg := newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"})
+	msg.Headers[queue.RequestIDHeader] = "req-1"
+	w.handleDelivery(context.Background(), msg)
+
+	failures := logs.FilterMessage("Failed to process event").All()
+	require.Len(t, failures, 1)
+	assert.Equal(t, "req-1", failures[0].ContextMap()["request_id"], "the API's request ID is in the worker's logs")
+}
+
+func TestEventsStoredInClientSpecificStore(t *testing.T) {
+	shared := storagetest.NewFakeStore()
+	dedicated := storagetest.NewFakeStore()
+	router := storage.NewClientRouter(shared, map[string]storage.EventStore{"client-a": dedicated})
+	w := NewWorker(nil, router, zap.NewNop())
+
+	w.handleDelivery(context.Background(), newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"}))
+
+	assert.Len(t, dedicated.Inserts(), 1, "client-a's events go to its dedicated store")
+	assert.Empty(t, shared.Inserts())
+	status, ok := dedicated.LastStatus("wh-1")
+	require.True(t, ok)
+	assert.Equal(t, models.EventStatusProcessed, status)
+}
+
+type fakeDeadLetterer struct {
+	msgs    []amqp.Delivery
+	reasons []string
+	err     error
+}
+
+func (d *fakeDeadLetterer) DeadLetter(ctx context.Context, msg amqp.Delivery, reason string) error {
+	d.msgs = append(d.msgs, msg)
+	d.reasons = append(d.reasons, reason)
+	return d.err
+}
+
+func TestExhaustedEventIsDeadLettered(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	dl := &fakeDeadLetterer{}
+	w := NewWorker(nil, store, zap.NewNop(), WithDeadLetterer(dl), WithClock(clock.NewMock(time.Now())))
+
+	ack := newFakeAcknowledger()
+	msg := newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+	event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a", RetryCount: w.maxRetries - 1}
+	w.handleError(context.Background(), event, msg, errors.New("mongo unavailable"))
+
+	require.Len(t, dl.msgs, 1)
+	assert.Equal(t, msg.Body, dl.msgs[0].Body)
+	assert.Equal(t, msg.Headers, dl.msgs[0].Headers)
+	assert.Equal(t, "mongo unavailable", dl.reasons[0])
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks, "acked once the dead-letter copy is published")
+	assert.Zero(t, nacks)
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusFailed, status)
+}
+
+func TestDeadLetterFailureRequeues(t *testing.T) {
+	dl := &fakeDeadLetterer{err: errors.New("channel closed")}
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithDeadLetterer(dl), WithClock(clock.NewMock(time.Now())))
+
+	ack := newFakeAcknowledger()
+	event := &models.WebhookEvent{WebhookID: "wh-1", RetryCount: w.maxRetries - 1}
+	w.handleError(context.Background(), event, newDelivery(t, ack, models.WebhookEvent{}), errors.New("boom"))
+
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks, "the message must not be dropped when dead-lettering fails")
+	assert.Equal(t, 1, nacks)
+	assert.True(t, ack.requeue)
+}
+
+func TestPoisonMessagesAreDeadLettered(t *testing.T) {
+	t.Run("undecodable", func(t *testing.T) {
+		dl := &fakeDeadLetterer{}
+		w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithDeadLetterer(dl))
+
+		ack := newFakeAcknowledger()
+		w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: []byte("{not json")})
+
+		require.Len(t, dl.reasons, 1)
+		assert.Contains(t, dl.reasons[0], "unmarshal")
+		acks, nacks := ack.counts()
+		assert.Equal(t, 1, acks)
+		assert.Zero(t, nacks)
+	})
+
+	t.Run("quarantined", func(t *testing.T) {
+		store := storagetest.NewFakeStore()
+		dl := &fakeDeadLetterer{}
+		w := NewWorker(nil, store, zap.NewNop(), WithDeadLetterer(dl), WithPoisonThreshold(1), WithClock(clock.NewMock(time.Now())))
+
+		ack := newFakeAcknowledger()
+		event := &models.WebhookEvent{WebhookID: "wh-1", ClientID: "client-a"}
+		w.handleError(context.Background(), event, newDelivery(t, ack, models.WebhookEvent{}), errors.New("boom"))
+
+		require.Len(t, dl.reasons, 1)
+		assert.Equal(t, "repeated failures: boom", dl.reasons[0])
+		acks, nacks := ack.counts()
+		assert.Equal(t, 1, acks)
+		assert.Zero(t, nacks)
+		status, _ := store.LastStatus("wh-1")
+		assert.Equal(t, models.EventStatusFailed, status)
+	})
+
+	t.Run("dead-letterer fails", func(t *testing.T) {
+		dl := &fakeDeadLetterer{err: errors.New("channel closed")}
+		w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithDeadLetterer(dl))
+
+		ack := newFakeAcknowledger()
+		w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: []byte("{not json")})
+
+		acks, nacks := ack.counts()
+		assert.Zero(t, acks)
+		assert.Equal(t, 1, nacks)
+		assert.False(t, ack.requeue, "it would fail again if requeued")
+	})
+}
+
+type fakeRetrier struct {
+	retryCounts []int
+	delays      []time.Duration
+	err         error
+}
+
+func (r *fakeRetrier) Retry(ctx context.Context, msg amqp.Delivery, retryCount int, delay time.Duration) error {
+	r.retryCounts = append(r.retryCounts, retryCount)
+	r.delays = append(r.delays, delay)
+	return r.err
+}
+
+func TestDelayedRetryDoesNotSleep(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("mongo unavailable"))
+	retrier := &fakeRetrier{}
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clk), WithDelayedRetry(retrier))
+
+	ack := newFakeAcknowledger()
+	msg := newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+	msg.Headers[queue.RetryCountHeader] = int32(1)
+	w.handleDelivery(context.Background(), msg)
+
+	assert.Equal(t, start, clk.Now(), "the consumer doesn't wait out the backoff")
+	require.Equal(t, []int{2}, retrier.retryCounts, "the retry count carries over from the header")
+	assert.GreaterOrEqual(t, retrier.delays[0], w.baseDelay)
+	assert.LessOrEqual(t, retrier.delays[0], 2*w.baseDelay)
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks, "acked once the retry is scheduled")
+	assert.Zero(t, nacks)
+}
+
+func TestDelayedRetryFailureFallsBackToRequeue(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithClock(clk),
+		WithDelayedRetry(&fakeRetrier{err: errors.New("channel closed")}))
+
+	ack := newFakeAcknowledger()
+	event := &models.WebhookEvent{WebhookID: "wh-1"}
+	w.handleError(context.Background(), event, newDelivery(t, ack, models.WebhookEvent{}), errors.New("boom"))
+
+	assert.True(t, clk.Now().After(start), "falls back to sleeping")
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks)
+	assert.Equal(t, 1, nacks)
+	assert.True(t, ack.requeue)
+}
+
+func TestRetryCountHeaderExhaustsRetries(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	store.SetError(storagetest.InsertEvent, errors.New("mongo unavailable"))
+	retrier := &fakeRetrier{}
+	w := NewWorker(nil, store, zap.NewNop(), WithDelayedRetry(retrier))
+
+	ack := newFakeAcknowledger()
+	msg := newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+	msg.Headers[queue.RetryCountHeader] = int32(w.maxRetries - 1)
+	w.handleDelivery(context.Background(), msg)
+
+	assert.Empty(t, retrier.retryCounts, "no retry after the last attempt")
+	status, _ := store.LastStatus("wh-1")
+	assert.Equal(t, models.EventStatusFailed, status)
+}
+
+// fakeConsumer hands out deliveries on a channel the test controls.
+type fakeConsumer struct {
+	msgs chan amqp.Delivery
+}
+
+func newFakeConsumer() *fakeConsumer {
+	return &fakeConsumer{msgs: make(chan amqp.Delivery, 1)}
+}
+
+func (c *fakeConsumer) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return c.msgs, nil
+}
+
+func TestWorkerResumesAfterDeliveryChannelCloses(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	first, second := newFakeConsumer(), newFakeConsumer()
+
+	var mu sync.Mutex
+	redials := 0
+	redial := func() (Consumer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		redials++
+		if redials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return second, nil
+	}
+	w := NewWorker(first, store, zap.NewNop(), WithRedial(redial, time.Millisecond, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	close(first.msgs)
+	ack := newFakeAcknowledger()
+	second.msgs <- newDelivery(t, ack, models.WebhookEvent{Event: "opened"})
+
+	select {
+	case <-ack.done:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not resume consuming after the channel closed")
+	}
+	assert.Len(t, store.Inserts(), 1)
+	mu.Lock()
+	assert.Equal(t, 2, redials, "a failed redial is retried")
+	mu.Unlock()
+}
+
+func TestConcurrentDeliveriesAreAckedIndividually(t *testing.T) {
+	const n = 3
+	store := storagetest.NewFakeStore()
+	inFlight := make(chan struct{}, n)
+	release := make(chan struct{})
+	block := ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		inFlight <- struct{}{}
+		<-release
+		return nil
+	})
+	consumer := &fakeConsumer{msgs: make(chan amqp.Delivery, n)}
+	w := NewWorker(consumer, store, zap.NewNop(), WithConcurrency(n), WithProcessors(block))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	acks := make([]*fakeAcknowledger, n)
+	for i := range acks {
+		acks[i] = newFakeAcknowledger()
+		consumer.msgs <- newDelivery(t, acks[i], models.WebhookEvent{Event: "opened"})
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-inFlight:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d deliveries were processed at once", i, n)
+		}
+	}
+	close(release)
+
+	for i, ack := range acks {
+		select {
+		case <-ack.done:
+		case <-time.After(time.Second):
+			t.Fatalf("delivery %d was not settled", i)
+		}
+		acked, nacked := ack.counts()
+		assert.Equal(t, 1, acked)
+		assert.Zero(t, nacked)
+	}
+	assert.Len(t, store.Inserts(), n)
+}
+
+func TestWorkerStopsRedialingWhenCancelled(t *testing.T) {
+	consumer := newFakeConsumer()
+	attempts := make(chan struct{}, 100)
+	redial := func() (Consumer, error) {
+		attempts <- struct{}{}
+		return nil, errors.New("connection refused")
+	}
+	w := NewWorker(consumer, storagetest.NewFakeStore(), zap.NewNop(), WithRedial(redial, time.Hour, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+	close(consumer.msgs)
+
+	select {
+	case <-attempts:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not try to reconnect")
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, attempts, "no further attempts once ctx is cancelled")
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/queue"
+
+	"go.uber.org/zap"
+)
+
+// Actions for events found stuck in retrying status.
+const (
+	StaleActionRepublish = "republish"
+	StaleActionFail      = "fail"
+)
+
+// ReconcileResult summarises a stale-retrying reconciliation run.
+type ReconcileResult struct {
+	Found        int
+	Republished  int
+	MarkedFailed int
+	Errors       int
+}
+
+// ReconcileStaleRetrying handles events left in retrying status for longer
+// than threshold, e.g. because the process died mid-backoff and the broker
+// message was lost. Depending on action they are either re-published or
+// marked failed.
+func (w *Worker) ReconcileStaleRetrying(ctx context.Context, publisher queue.Publisher, threshold time.Duration, action string) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	if action != StaleActionRepublish && action != StaleActionFail {
+		return result, fmt.Errorf("unknown stale retrying action %q", action)
+	}
+
+	cutoff := w.clock.Now().UTC().Add(-threshold)
+	events, err := w.db.GetStaleRetryingEvents(ctx, cutoff)
+	if err != nil {
+		return result, fmt.Errorf("failed to load stale retrying events: %v", err)
+	}
+	result.Found = len(events)
+
+	for _, event := range events {
+		if action == StaleActionFail {
+			if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusFailed); err != nil {
+				w.logger.Error("Failed to mark stale event failed",
+					zap.Error(err),
+					zap.String("webhook_id", event.WebhookID))
+				result.Errors++
+				continue
+			}
+			result.MarkedFailed++
+			continue
+		}
+
+		if err := publisher.Publish(*event); err != nil {
+			w.logger.Error("Failed to re-publish stale event",
+				zap.Error(err),
+				zap.String("webhook_id", event.WebhookID))
+			result.Errors++
+			continue
+		}
+
+		event.RetryCount = 0
+		if err := w.db.UpdateEventStatus(ctx, event, models.EventStatusPending); err != nil {
+			w.logger.Error("Failed to reset stale event status",
+				zap.Error(err),
+				zap.String("webhook_id", event.WebhookID))
+		}
+		result.Republished++
+	}
+
+	w.logger.Info("Reconciled stale retrying events",
+		zap.String("action", action),
+		zap.Duration("threshold", threshold),
+		zap.Int("found", result.Found),
+		zap.Int("republished", result.Republished),
+		zap.Int("marked_failed", result.MarkedFailed),
+		zap.Int("errors", result.Errors))
+
+	return result, nil
+}
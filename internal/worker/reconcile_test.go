@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+	"webhook-processor/pkg/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type recordingPublisher struct {
+	published []models.WebhookEvent
+}
+
+func (p *recordingPublisher) Publish(event models.WebhookEvent) error {
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func staleFixture(now time.Time) *storagetest.FakeStore {
+	return storagetest.NewFakeStore(
+		&models.WebhookEvent{WebhookID: "stale-1", ClientID: "client-a", Status: "retrying", RetryCount: 2, UpdatedAt: now.Add(-2 * time.Hour)},
+		&models.WebhookEvent{WebhookID: "stale-2", ClientID: "client-b", Status: "retrying", RetryCount: 1, UpdatedAt: now.Add(-90 * time.Minute)},
+		&models.WebhookEvent{WebhookID: "fresh", ClientID: "client-a", Status: "retrying", RetryCount: 1, UpdatedAt: now.Add(-5 * time.Minute)},
+	)
+}
+
+func TestReconcileStaleRetryingRepublishes(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := staleFixture(now)
+	publisher := &recordingPublisher{}
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clock.NewMock(now)))
+
+	result, err := w.ReconcileStaleRetrying(context.Background(), publisher, time.Hour, StaleActionRepublish)
+	require.NoError(t, err)
+
+	assert.Equal(t, ReconcileResult{Found: 2, Republished: 2}, result)
+	require.Len(t, publisher.published, 2)
+	assert.Equal(t, "stale-1", publisher.published[0].WebhookID)
+	assert.Equal(t, "stale-2", publisher.published[1].WebhookID)
+	assert.Equal(t, []storagetest.StatusUpdate{
+		{WebhookID: "stale-1", ClientID: "client-a", Status: models.EventStatusPending},
+		{WebhookID: "stale-2", ClientID: "client-b", Status: models.EventStatusPending},
+	}, store.StatusUpdates(), "retry counts are reset and fresh events are left alone")
+}
+
+func TestReconcileStaleRetryingMarksFailed(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := staleFixture(now)
+	publisher := &recordingPublisher{}
+	w := NewWorker(nil, store, zap.NewNop(), WithClock(clock.NewMock(now)))
+
+	result, err := w.ReconcileStaleRetrying(context.Background(), publisher, time.Hour, StaleActionFail)
+	require.NoError(t, err)
+
+	assert.Equal(t, ReconcileResult{Found: 2, MarkedFailed: 2}, result)
+	assert.Empty(t, publisher.published)
+	assert.Equal(t, []storagetest.StatusUpdate{
+		{WebhookID: "stale-1", ClientID: "client-a", Status: models.EventStatusFailed, RetryCount: 2},
+		{WebhookID: "stale-2", ClientID: "client-b", Status: models.EventStatusFailed, RetryCount: 1},
+	}, store.StatusUpdates())
+}
+
+func TestReconcileStaleRetryingRejectsUnknownAction(t *testing.T) {
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop())
+
+	_, err := w.ReconcileStaleRetrying(context.Background(), &recordingPublisher{}, time.Hour, "drop")
+	assert.Error(t, err)
+}
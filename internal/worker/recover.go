@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"webhook-processor/pkg/metrics"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// settleTracker records whether a delivery has been acked, nacked or
+// rejected, so a panic handler doesn't settle it a second time (the broker
+// closes the channel on an unknown delivery tag).
+type settleTracker struct {
+	amqp.Acknowledger
+	settled atomic.Bool
+}
+
+func (s *settleTracker) Ack(tag uint64, multiple bool) error {
+	s.settled.Store(true)
+	return s.Acknowledger.Ack(tag, multiple)
+}
+
+func (s *settleTracker) Nack(tag uint64, multiple, requeue bool) error {
+	s.settled.Store(true)
+	return s.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (s *settleTracker) Reject(tag uint64, requeue bool) error {
+	s.settled.Store(true)
+	return s.Acknowledger.Reject(tag, requeue)
+}
+
+// recoverDelivery is deferred by handleDelivery. A panic while handling msg
+// is logged and the message dead-lettered instead of requeued, since it
+// would most likely panic again; the consumer keeps running.
+func (w *Worker) recoverDelivery(ctx context.Context, msg amqp.Delivery, tracker *settleTracker) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	w.logger.Error("Panic while handling message",
+		zap.Any("panic", r),
+		zap.ByteString("stack", debug.Stack()),
+		zap.Any("headers", msg.Headers))
+	metrics.PoisonMessages.WithLabelValues("panic").Inc()
+
+	if tracker.settled.Load() {
+		return
+	}
+	w.rejectPoison(ctx, msg, fmt.Sprintf("panic: %v", r))
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type panickingForwarder struct{}
+
+func (panickingForwarder) Forward(ctx context.Context, event *models.WebhookEvent) error {
+	panic("forwarder bug")
+}
+
+func TestPanicDeadLettersMessageAndKeepsConsuming(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	dl := &fakeDeadLetterer{}
+	explode := ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		if event.Event == "explode" {
+			var m map[string]int
+			m["boom"]++
+		}
+		return nil
+	})
+	consumer := newFakeConsumer()
+	w := NewWorker(consumer, store, zap.NewNop(), WithProcessors(explode), WithDeadLetterer(dl))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	bad := newFakeAcknowledger()
+	consumer.msgs <- newDelivery(t, bad, models.WebhookEvent{Event: "explode"})
+	select {
+	case <-bad.done:
+	case <-time.After(time.Second):
+		t.Fatal("panicking message was not settled")
+	}
+	acks, nacks := bad.counts()
+	assert.Equal(t, 1, acks, "acked once the dead-letter copy is published")
+	assert.Zero(t, nacks)
+	require.Len(t, dl.reasons, 1)
+	assert.Contains(t, dl.reasons[0], "panic: assignment to entry in nil map")
+
+	good := newFakeAcknowledger()
+	consumer.msgs <- newDelivery(t, good, models.WebhookEvent{Event: "opened"})
+	select {
+	case <-good.done:
+	case <-time.After(time.Second):
+		t.Fatal("worker stopped consuming after a panic")
+	}
+	acks, _ = good.counts()
+	assert.Equal(t, 1, acks)
+	assert.Len(t, store.Inserts(), 1)
+}
+
+func TestPanicWithoutDeadLettererRejects(t *testing.T) {
+	explode := ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		panic("processor bug")
+	})
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(), WithProcessors(explode))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	acks, nacks := ack.counts()
+	assert.Zero(t, acks)
+	assert.Equal(t, 1, nacks)
+	assert.False(t, ack.requeue, "a message that panicked must not be redelivered")
+}
+
+func TestPanicAfterAckDoesNotSettleTwice(t *testing.T) {
+	dl := &fakeDeadLetterer{}
+	w := NewWorker(nil, storagetest.NewFakeStore(), zap.NewNop(),
+		WithForwarder(panickingForwarder{}, false), WithDeadLetterer(dl))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{Event: "opened"}))
+
+	acks, nacks := ack.counts()
+	assert.Equal(t, 1, acks)
+	assert.Zero(t, nacks)
+	assert.Empty(t, dl.msgs, "an already acked message is not dead-lettered")
+}
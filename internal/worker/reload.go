@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Settings are the worker settings that can change without restarting the
+// process. They only affect how deliveries are fetched and fanned out, not
+// how each one is processed, so applying them never changes an event's
+// outcome.
+type Settings struct {
+	Concurrency    int
+	Prefetch       int
+	GlobalPrefetch int
+}
+
+// PrefetchSetter changes the QoS applied to channels opened afterwards;
+// *queue.ConsumerConnection implements it.
+type PrefetchSetter interface {
+	SetPrefetch(prefetch, global int)
+}
+
+// Restart drains the running consumer as Stop does, giving in-flight
+// deliveries until drainTimeout, then resumes consuming queueName under ctx
+// with concurrency on a fresh channel from the WithRedial func, so QoS
+// changes made in the meantime apply.
+func (w *Worker) Restart(ctx context.Context, queueName string, concurrency int, drainTimeout time.Duration) error {
+	if w.redial == nil {
+		return errors.New("restarting the worker requires WithRedial")
+	}
+
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	err := w.Stop(drainCtx)
+	cancel()
+	if err != nil {
+		w.logger.Warn("Restarting with deliveries still in flight", zap.Error(err))
+	}
+
+	w.mu.Lock()
+	done := w.consumerDone
+	w.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	w.mu.Lock()
+	w.concurrency = concurrency
+	w.mu.Unlock()
+	w.inflight.resume()
+
+	streams := w.reconnect(ctx, queueName)
+	if streams == nil {
+		return ctx.Err()
+	}
+	w.run(ctx, queueName, streams)
+	return nil
+}
+
+// Reloader restarts a Worker with the Settings load returns whenever the
+// process receives SIGHUP. Anything else load reads is left as it was
+// until the process restarts.
+type Reloader struct {
+	worker       *Worker
+	queueName    string
+	load         func() (Settings, error)
+	prefetch     PrefetchSetter
+	drainTimeout time.Duration
+	logger       *zap.Logger
+	current      Settings
+}
+
+// NewReloader returns a Reloader for w, which is running with current.
+func NewReloader(w *Worker, queueName string, current Settings, load func() (Settings, error), prefetch PrefetchSetter, drainTimeout time.Duration, logger *zap.Logger) *Reloader {
+	return &Reloader{
+		worker:       w,
+		queueName:    queueName,
+		load:         load,
+		prefetch:     prefetch,
+		drainTimeout: drainTimeout,
+		logger:       logger,
+		current:      current,
+	}
+}
+
+// Start handles SIGHUP in the background until ctx is done. The worker keeps
+// consuming under ctx after each restart.
+func (r *Reloader) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.reload(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Reloader) reload(ctx context.Context) {
+	settings, err := r.load()
+	if err != nil {
+		r.logger.Error("Failed to reload configuration, keeping current settings", zap.Error(err))
+		return
+	}
+	if settings == r.current {
+		r.logger.Info("Configuration reloaded, worker settings unchanged")
+		return
+	}
+
+	r.logger.Info("Configuration reloaded, restarting consumer",
+		zap.Int("concurrency", settings.Concurrency),
+		zap.Int("prefetch", settings.Prefetch),
+		zap.Int("global_prefetch", settings.GlobalPrefetch))
+	r.prefetch.SetPrefetch(settings.Prefetch, settings.GlobalPrefetch)
+	if err := r.worker.Restart(ctx, r.queueName, settings.Concurrency, r.drainTimeout); err != nil {
+		r.logger.Error("Failed to restart consumer", zap.Error(err))
+		return
+	}
+	r.current = settings
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingPrefetch records the last SetPrefetch call.
+type recordingPrefetch struct {
+	mu               sync.Mutex
+	prefetch, global int
+}
+
+func (p *recordingPrefetch) SetPrefetch(prefetch, global int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prefetch, p.global = prefetch, global
+}
+
+func (p *recordingPrefetch) get() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.prefetch, p.global
+}
+
+func TestSIGHUPAppliesNewConcurrency(t *testing.T) {
+	const n = 4
+	inFlight := make(chan struct{}, n)
+	release := make(chan struct{})
+	defer close(release)
+	block := ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		inFlight <- struct{}{}
+		<-release
+		return nil
+	})
+
+	first := &stoppableConsumer{fakeConsumer: newFakeConsumer()}
+	second := &fakeConsumer{msgs: make(chan amqp.Delivery, n)}
+	redialed := make(chan struct{})
+	redial := func() (Consumer, error) {
+		close(redialed)
+		return second, nil
+	}
+	w := NewWorker(first, storagetest.NewFakeStore(), zap.NewNop(),
+		WithProcessors(block), WithRedial(redial, time.Millisecond, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	prefetch := &recordingPrefetch{}
+	load := func() (Settings, error) {
+		return Settings{Concurrency: n, Prefetch: n, GlobalPrefetch: 2 * n}, nil
+	}
+	NewReloader(w, "webhook_queue", Settings{Concurrency: 1}, load, prefetch, time.Second, zap.NewNop()).Start(ctx)
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-redialed:
+	case <-time.After(time.Second):
+		t.Fatal("SIGHUP did not restart the consumer")
+	}
+
+	for i := 0; i < n; i++ {
+		second.msgs <- newDelivery(t, newFakeAcknowledger(), models.WebhookEvent{Event: "opened"})
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-inFlight:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d deliveries were processed at once after reload", i, n)
+		}
+	}
+
+	p, global := prefetch.get()
+	assert.Equal(t, n, p)
+	assert.Equal(t, 2*n, global)
+	_, closed := first.state()
+	assert.True(t, closed, "the old channel is closed once drained")
+}
+
+func TestReloadWithUnchangedSettingsKeepsConsumer(t *testing.T) {
+	redial := func() (Consumer, error) {
+		t.Error("consumer restarted without a settings change")
+		return newFakeConsumer(), nil
+	}
+	w := NewWorker(newFakeConsumer(), storagetest.NewFakeStore(), zap.NewNop(), WithRedial(redial, time.Millisecond, time.Millisecond))
+	require.NoError(t, w.Start(context.Background(), "webhook_queue"))
+
+	current := Settings{Concurrency: 2, Prefetch: 2}
+	load := func() (Settings, error) { return current, nil }
+	NewReloader(w, "webhook_queue", current, load, &recordingPrefetch{}, time.Second, zap.NewNop()).reload(context.Background())
+}
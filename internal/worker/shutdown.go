@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// consumerCanceler stops the broker delivering to a consumer;
+// *amqp.Channel implements it.
+type consumerCanceler interface {
+	Cancel(consumer string, noWait bool) error
+}
+
+// channelCloser closes the consumer's channel; *amqp.Channel implements it.
+type channelCloser interface {
+	Close() error
+}
+
+var consumerSeq atomic.Uint64
+
+// newConsumerTag returns a tag unique to this worker, so Stop can cancel
+// its consumer by name.
+func newConsumerTag() string {
+	return fmt.Sprintf("webhook-worker-%d-%d", os.Getpid(), consumerSeq.Add(1))
+}
+
+// Stop stops consuming, waits until ctx is done for deliveries already being
+// processed to finish and be settled, then closes the channel so the broker
+// requeues anything prefetched but not yet processed. If ctx ends first the
+// channel is closed anyway, the remaining deliveries are cancelled and ctx's
+// error returned. Stop is a no-op if the worker was never started.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	ch, stopConsuming, abortProcessing := w.channel, w.stopConsuming, w.abortProcessing
+	w.mu.Unlock()
+	if stopConsuming == nil {
+		return nil
+	}
+
+	stopConsuming()
+	if canceler, ok := ch.(consumerCanceler); ok {
+		for _, tag := range w.consumerTags() {
+			if err := canceler.Cancel(tag, false); err != nil {
+				w.logger.Warn("Failed to cancel consumer", zap.Error(err), zap.String("consumer", tag))
+			}
+		}
+	}
+
+	var err error
+	select {
+	case <-w.inflight.drain():
+		w.logger.Info("In-flight deliveries drained")
+	case <-ctx.Done():
+		err = ctx.Err()
+		w.logger.Warn("Shutdown timed out with deliveries still in flight", zap.Int("in_flight", w.inflight.count()))
+	}
+
+	if closer, ok := ch.(channelCloser); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close channel: %w", closeErr)
+		}
+	}
+	// Cancel stragglers only once the channel is closed, so their deliveries
+	// are requeued rather than settled as failures.
+	abortProcessing()
+	return err
+}
+
+// inflightTracker counts deliveries being handled. Once drain is called no
+// new ones begin, and the returned channel closes when the last one ends.
+type inflightTracker struct {
+	mu       sync.Mutex
+	n        int
+	draining bool
+	idle     chan struct{}
+}
+
+func (t *inflightTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.n++
+	return true
+}
+
+func (t *inflightTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+	if t.n == 0 && t.draining {
+		close(t.idle)
+	}
+}
+
+func (t *inflightTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.draining {
+		t.draining = true
+		t.idle = make(chan struct{})
+		if t.n == 0 {
+			close(t.idle)
+		}
+	}
+	return t.idle
+}
+
+// resume lets deliveries begin again after a drain.
+func (t *inflightTracker) resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = false
+}
+
+func (t *inflightTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.n
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stoppableConsumer is a fakeConsumer that records Cancel and Close.
+type stoppableConsumer struct {
+	*fakeConsumer
+
+	mu        sync.Mutex
+	cancelled string
+	closed    bool
+}
+
+func (c *stoppableConsumer) Cancel(consumer string, noWait bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelled = consumer
+	return nil
+}
+
+func (c *stoppableConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *stoppableConsumer) state() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled, c.closed
+}
+
+// blockingProcessor holds each event until released.
+func blockingProcessor() (Processor, <-chan struct{}, chan<- struct{}) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	return ProcessorFunc(func(ctx context.Context, event *models.WebhookEvent) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}), started, release
+}
+
+func TestStopDrainsInFlightDeliveries(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	consumer := &stoppableConsumer{fakeConsumer: newFakeConsumer()}
+	block, started, release := blockingProcessor()
+	w := NewWorker(consumer, store, zap.NewNop(), WithProcessors(block))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	ack := newFakeAcknowledger()
+	consumer.msgs <- newDelivery(t, ack, models.WebhookEvent{WebhookID: "wh-1", Event: "opened"})
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- w.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned with a delivery still in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancelled, closed := consumer.state()
+	assert.Equal(t, w.consumerTag, cancelled, "the consumer is cancelled straight away")
+	assert.False(t, closed, "the channel stays open until the delivery is acked")
+
+	close(release)
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return once the delivery finished")
+	}
+
+	acked, nacked := ack.counts()
+	assert.Equal(t, 1, acked)
+	assert.Zero(t, nacked)
+	assert.Len(t, store.Inserts(), 1, "the event is stored, not abandoned")
+	_, closed = consumer.state()
+	assert.True(t, closed)
+}
+
+func TestStopTimesOut(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	consumer := &stoppableConsumer{fakeConsumer: newFakeConsumer()}
+	block, started, _ := blockingProcessor()
+	w := NewWorker(consumer, store, zap.NewNop(), WithProcessors(block))
+
+	require.NoError(t, w.Start(context.Background(), "webhook_queue"))
+	ack := newFakeAcknowledger()
+	consumer.msgs <- newDelivery(t, ack, models.WebhookEvent{WebhookID: "wh-1", Event: "opened"})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.Stop(ctx), context.DeadlineExceeded)
+
+	select {
+	case <-ack.done:
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned delivery was not settled")
+	}
+	acked, _ := ack.counts()
+	assert.Zero(t, acked, "processing is cancelled once the channel is closed")
+	assert.Empty(t, store.Inserts())
+	_, closed := consumer.state()
+	assert.True(t, closed)
+}
+
+func TestDeliveriesAfterStopAreLeftForRedelivery(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	w := NewWorker(&stoppableConsumer{fakeConsumer: newFakeConsumer()}, store, zap.NewNop())
+	require.NoError(t, w.Start(context.Background(), "webhook_queue"))
+	require.NoError(t, w.Stop(context.Background()))
+
+	ack := newFakeAcknowledger()
+	w.handleDelivery(context.Background(), newDelivery(t, ack, models.WebhookEvent{WebhookID: "wh-1"}))
+
+	acked, nacked := ack.counts()
+	assert.Zero(t, acked)
+	assert.Zero(t, nacked)
+	assert.Empty(t, store.Inserts())
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	w := NewWorker(newFakeConsumer(), storagetest.NewFakeStore(), zap.NewNop())
+	assert.NoError(t, w.Stop(context.Background()))
+}
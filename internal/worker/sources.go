@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// SourceHeader carries the name of the source an event was consumed from.
+// It is added by the worker and kept when a delivery is retried, so a
+// retried event keeps the source it first arrived from.
+const SourceHeader = "source"
+
+// Source is a queue the worker consumes alongside the one passed to Start,
+// e.g. one bound to a second provider's exchange. Its events go through the
+// same pipeline and are tagged with Name.
+type Source struct {
+	Name  string
+	Queue string
+}
+
+// WithSources consumes sources as well as the queue passed to Start, whose
+// events are tagged primary. Every source is consumed on the worker's
+// channel, so they share its prefetch.
+func WithSources(primary string, sources ...Source) Option {
+	return func(w *Worker) {
+		w.primarySource = primary
+		w.sources = sources
+	}
+}
+
+// sourceStream is the deliveries from one source.
+type sourceStream struct {
+	source string
+	msgs   <-chan amqp.Delivery
+}
+
+// consumeSources starts a consumer on ch for queueName and each of the
+// worker's sources. If one fails those already started are left for the
+// channel's closure to clean up.
+func (w *Worker) consumeSources(ch Consumer, queueName string) ([]sourceStream, error) {
+	msgs, err := consume(ch, queueName, w.consumerTag)
+	if err != nil {
+		return nil, err
+	}
+	streams := []sourceStream{{source: w.primarySource, msgs: msgs}}
+	for _, s := range w.sources {
+		msgs, err := consume(ch, s.Queue, w.sourceConsumerTag(s))
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, sourceStream{source: s.Name, msgs: msgs})
+	}
+	return streams, nil
+}
+
+// sourceConsumerTag is the consumer tag for s, which must differ from the
+// other consumers on the channel.
+func (w *Worker) sourceConsumerTag(s Source) string {
+	return w.consumerTag + "." + s.Name
+}
+
+// consumerTags returns the tags of all the worker's consumers.
+func (w *Worker) consumerTags() []string {
+	tags := []string{w.consumerTag}
+	for _, s := range w.sources {
+		tags = append(tags, w.sourceConsumerTag(s))
+	}
+	return tags
+}
+
+// mergeStreams combines streams into one, tagging each delivery with its
+// source, until ctx is done. The result closes once every stream has closed,
+// as they all do when the channel does. A single untagged stream is
+// returned as it is.
+func mergeStreams(ctx context.Context, streams []sourceStream) <-chan amqp.Delivery {
+	if len(streams) == 1 && streams[0].source == "" {
+		return streams[0].msgs
+	}
+
+	out := make(chan amqp.Delivery)
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s sourceStream) {
+			defer wg.Done()
+			for msg := range s.msgs {
+				msg.Headers = withSource(msg.Headers, s.source)
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// withSource returns a copy of headers with SourceHeader set to source,
+// unless it is already set.
+func withSource(headers amqp.Table, source string) amqp.Table {
+	if _, ok := headers[SourceHeader]; ok || source == "" {
+		return headers
+	}
+	tagged := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		tagged[k] = v
+	}
+	tagged[SourceHeader] = source
+	return tagged
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"webhook-processor/internal/models"
+	"webhook-processor/internal/storage/storagetest"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// queueConsumer serves a delivery channel per queue and records the
+// consumer tags used.
+type queueConsumer struct {
+	mu     sync.Mutex
+	queues map[string]chan amqp.Delivery
+	tags   []string
+}
+
+func newQueueConsumer(queues ...string) *queueConsumer {
+	c := &queueConsumer{queues: make(map[string]chan amqp.Delivery)}
+	for _, q := range queues {
+		c.queues[q] = make(chan amqp.Delivery, 1)
+	}
+	return c
+}
+
+func (c *queueConsumer) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tags = append(c.tags, consumer)
+	return c.queues[queue], nil
+}
+
+func TestEventsFromEverySourceAreProcessed(t *testing.T) {
+	store := storagetest.NewFakeStore()
+	consumer := newQueueConsumer("webhook_queue", "sendgrid_webhooks")
+	w := NewWorker(consumer, store, zap.NewNop(),
+		WithSources("webhook_events", Source{Name: "sendgrid", Queue: "sendgrid_webhooks"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Start(ctx, "webhook_queue"))
+
+	mailercloud, sendgrid := newFakeAcknowledger(), newFakeAcknowledger()
+	consumer.queues["webhook_queue"] <- newDelivery(t, mailercloud, models.WebhookEvent{Event: "opened"})
+	msg := newDelivery(t, sendgrid, models.WebhookEvent{Event: "bounce"})
+	msg.Headers["webhook_id"] = "wh-2"
+	consumer.queues["sendgrid_webhooks"] <- msg
+
+	for _, ack := range []*fakeAcknowledger{mailercloud, sendgrid} {
+		select {
+		case <-ack.done:
+		case <-time.After(time.Second):
+			t.Fatal("delivery was not processed")
+		}
+		acks, _ := ack.counts()
+		assert.Equal(t, 1, acks)
+	}
+
+	inserts := store.Inserts()
+	require.Len(t, inserts, 2)
+	sources := map[string]string{}
+	for _, e := range inserts {
+		sources[e.Event] = e.Source
+	}
+	assert.Equal(t, map[string]string{"opened": "webhook_events", "bounce": "sendgrid"}, sources)
+
+	consumer.mu.Lock()
+	tags := append([]string(nil), consumer.tags...)
+	consumer.mu.Unlock()
+	sort.Strings(tags)
+	want := w.consumerTags()
+	sort.Strings(want)
+	assert.Equal(t, want, tags, "each source has its own consumer tag")
+}
+
+func TestWithSourceKeepsRetriedSource(t *testing.T) {
+	headers := amqp.Table{"webhook_id": "wh-1"}
+	tagged := withSource(headers, "sendgrid")
+	assert.Equal(t, "sendgrid", tagged[SourceHeader])
+	assert.NotContains(t, headers, SourceHeader, "the delivery's headers are not modified")
+
+	assert.Equal(t, "sendgrid", withSource(tagged, "webhook_events")[SourceHeader],
+		"an event retried through the main queue keeps its source")
+	assert.Nil(t, withSource(nil, ""))
+}
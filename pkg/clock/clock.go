@@ -0,0 +1,64 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts reading and waiting on time so time-based logic can be
+// tested deterministically.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+// New returns a Clock backed by the time package.
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Mock is a manually advanced Clock for tests. Sleep advances the mock time
+// instead of blocking.
+type Mock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewMock returns a Mock set to t.
+func NewMock(t time.Time) *Mock {
+	return &Mock{now: t}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) Sleep(d time.Duration) {
+	m.Advance(d)
+}
+
+// Advance moves the mock time forward by d.
+func (m *Mock) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+// Set moves the mock time to t.
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = t
+}
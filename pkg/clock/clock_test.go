@@ -0,0 +1,24 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockAdvanceAndSleep(t *testing.T) {
+	start := time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC)
+	m := NewMock(start)
+
+	assert.Equal(t, start, m.Now())
+
+	m.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), m.Now())
+
+	m.Sleep(10 * time.Second)
+	assert.Equal(t, start.Add(70*time.Second), m.Now())
+
+	m.Set(start)
+	assert.Equal(t, start, m.Now())
+}
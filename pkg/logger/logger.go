@@ -1,6 +1,8 @@
 package logger
 
 import (
+	"os"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -9,13 +11,32 @@ type Logger struct {
 	*zap.SugaredLogger
 }
 
-func NewLogger(level string) *Logger {
+// BaseFields are attached to every log entry so lines can be correlated
+// across services and instances.
+type BaseFields struct {
+	Service  string
+	Env      string
+	Instance string
+}
+
+// NewLogger builds the JSON logger. An empty base.Instance defaults to the
+// hostname.
+func NewLogger(level string, base BaseFields) *Logger {
+	logger, _ := newConfig(level, base).Build()
+	return &Logger{logger.Sugar()}
+}
+
+func newConfig(level string, base BaseFields) zap.Config {
 	logLevel, err := zapcore.ParseLevel(level)
 	if err != nil {
 		logLevel = zapcore.InfoLevel
 	}
 
-	config := zap.Config{
+	if base.Instance == "" {
+		base.Instance, _ = os.Hostname()
+	}
+
+	return zap.Config{
 		Encoding:         "json",
 		Level:            zap.NewAtomicLevelAt(logLevel),
 		OutputPaths:      []string{"stdout"},
@@ -28,8 +49,10 @@ func NewLogger(level string) *Logger {
 			EncodeTime:   zapcore.ISO8601TimeEncoder,
 			EncodeCaller: zapcore.ShortCallerEncoder,
 		},
+		InitialFields: map[string]interface{}{
+			"service":  base.Service,
+			"env":      base.Env,
+			"instance": base.Instance,
+		},
 	}
-
-	logger, _ := config.Build()
-	return &Logger{logger.Sugar()}
 }
@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseFieldsOnEveryEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	config := newConfig("debug", BaseFields{Service: "webhook-api", Env: "staging", Instance: "api-1"})
+	config.OutputPaths = []string{path}
+	zl, err := config.Build()
+	require.NoError(t, err)
+
+	log := &Logger{zl.Sugar()}
+	log.Info("first")
+	log.Desugar().Named("worker").Warn("second")
+	require.NoError(t, log.Sync())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	for _, l := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(l), &entry))
+		assert.Equal(t, "webhook-api", entry["service"])
+		assert.Equal(t, "staging", entry["env"])
+		assert.Equal(t, "api-1", entry["instance"])
+	}
+}
+
+func TestInstanceDefaultsToHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	config := newConfig("info", BaseFields{Service: "webhook-api"})
+	assert.Equal(t, hostname, config.InitialFields["instance"])
+}
@@ -22,18 +22,137 @@ var (
 		Buckets: prometheus.DefBuckets,
 	}, []string{"client_id", "event_type"})
 
+	WebhookStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webhook_stage_duration_seconds",
+		Help:    "Time spent in each stage of handling a webhook request",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"stage"})
+
+	// AMQP timestamps have whole-second precision, so ages under a second
+	// read as zero
+	WebhookMessageAge = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhook_message_age_seconds",
+		Help:    "Time from publishing a webhook event to the worker picking it up",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	})
+
 	WebhookQueueSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "webhook_queue_size",
 		Help: "Current size of the webhook processing queue",
 	}, []string{"client_id"})
 
+	WebhookStaleSignatures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_signature_stale_total",
+		Help: "The total number of signed webhooks rejected for a timestamp outside the replay window",
+	}, []string{"client_id"})
+
 	WebhookRetries = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "webhook_retries_total",
 		Help: "The total number of webhook event retries",
 	}, []string{"client_id", "event_type"})
 
+	WebhookTooOld = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_events_too_old_total",
+		Help: "The total number of webhook events discarded for exceeding the maximum age",
+	}, []string{"client_id", "event_type"})
+
+	WebhookMissingEmail = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_events_missing_email_total",
+		Help: "The total number of webhook events rejected because a required email was absent",
+	}, []string{"client_id", "event_type"})
+
+	MaintenanceMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_maintenance_mode",
+		Help: "1 while the API is rejecting webhooks for maintenance, 0 otherwise",
+	})
+
+	LoadShedFraction = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_load_shed_fraction",
+		Help: "The share of webhooks currently shed under resource pressure, from 0 to 1",
+	})
+
+	LoadShedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_load_shed_requests_total",
+		Help: "The total number of webhooks rejected with 503 under resource pressure",
+	})
+
+	ContentLengthMismatch = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_content_length_mismatch_total",
+		Help: "The total number of requests rejected because the body did not match Content-Length",
+	})
+
+	BodyTooLarge = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_body_too_large_total",
+		Help: "The total number of requests rejected because the body exceeded the maximum size",
+	})
+
+	ReconcileMissing = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_reconcile_missing_events",
+		Help: "Published minus stored events for the most recently reconciled window",
+	})
+
+	ReconcileDiscrepancies = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_reconcile_discrepancies_total",
+		Help: "The total number of reconciliation windows where published and stored counts differed",
+	})
+
+	InvalidEmails = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_invalid_emails_total",
+		Help: "The total number of emails array entries set aside as invalid",
+	}, []string{"reason"})
+
+	StoredBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_stored_bytes_total",
+		Help: "The approximate total size in bytes of event documents written to storage",
+	}, []string{"event"})
+
+	PoisonMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poison_messages_total",
+		Help: "The total number of messages rejected without requeue as unprocessable",
+	}, []string{"reason"})
+
+	AsyncPublishBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_async_publish_buffered",
+		Help: "The number of accepted events waiting to be published in async mode",
+	})
+
+	AsyncPublishDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_async_publish_dropped_total",
+		Help: "The total number of accepted events dropped after exhausting async publish retries",
+	})
+
+	PublishRetryBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_publish_retry_buffered",
+		Help: "The number of events held in memory waiting for the broker to accept them",
+	})
+
+	PublishRetryDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_publish_retry_dropped_total",
+		Help: "The total number of buffered events dropped before the broker accepted them",
+	}, []string{"reason"})
+
+	WebhookSignatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_signature_failures_total",
+		Help: "The total number of webhooks rejected for a missing or invalid signature",
+	}, []string{"client_id"})
+
 	RateLimitExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "webhook_rate_limit_exceeded_total",
 		Help: "The total number of times rate limits were exceeded",
 	}, []string{"client_id", "limit_type"})
+
+	UnknownClients = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_unknown_client_total",
+		Help: "The total number of webhooks whose Webhook-Id isn't mapped to a client, by hashed Webhook-Id bucket",
+	}, []string{"webhook_bucket"})
+
+	StorageQuotaExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_storage_quota_exceeded_total",
+		Help: "The total number of events not stored because the client's daily storage quota was used up",
+	}, []string{"client_id", "action"})
+
+	RateLimitTrackedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_rate_limit_tracked_clients",
+		Help: "The number of clients a rate limiter currently holds state for",
+	}, []string{"limiter"})
 )
@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// OTLPExporter periodically pushes the metrics registered with gatherer to an
+// OpenTelemetry collector using OTLP/HTTP with JSON encoding, so the existing
+// Prometheus metric definitions are reused as-is. Counters become cumulative
+// monotonic sums; gauges, histograms and summaries map to their OTLP
+// equivalents.
+type OTLPExporter struct {
+	url         string
+	interval    time.Duration
+	serviceName string
+	gatherer    prometheus.Gatherer
+	client      *http.Client
+	logger      *zap.Logger
+	startTime   time.Time
+}
+
+// NewOTLPExporter creates an exporter pushing to endpoint, the collector's
+// OTLP/HTTP base URL (e.g. "http://otel-collector:4318").
+func NewOTLPExporter(endpoint, serviceName string, interval time.Duration, gatherer prometheus.Gatherer, logger *zap.Logger) *OTLPExporter {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		interval:    interval,
+		serviceName: serviceName,
+		gatherer:    gatherer,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+		startTime:   time.Now(),
+	}
+}
+
+// URL returns the metrics endpoint the exporter pushes to.
+func (e *OTLPExporter) URL() string {
+	return e.url
+}
+
+// Start exports every interval until ctx is cancelled, with a final export on
+// shutdown so the last interval isn't lost.
+func (e *OTLPExporter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.Export(shutdownCtx); err != nil {
+					e.logger.Warn("Final OTLP metrics export failed", zap.Error(err))
+				}
+				cancel()
+				return
+			case <-ticker.C:
+				if err := e.Export(ctx); err != nil {
+					e.logger.Warn("OTLP metrics export failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Export gathers the current metric values and pushes them once.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+
+	body, err := json.Marshal(e.buildRequest(families, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to marshal OTLP request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send OTLP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types below follow the OTLP protobuf JSON mapping, in which 64-bit
+// integers are encoded as strings.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const aggregationTemporalityCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+type otlpSummaryPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+func (e *OTLPExporter) buildRequest(families []*dto.MetricFamily, now time.Time) otlpRequest {
+	start := strconv.FormatInt(e.startTime.UnixNano(), 10)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, family := range families {
+		m := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+			for _, metric := range family.GetMetric() {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{
+					Attributes:        labelAttributes(metric),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					AsDouble:          metric.GetCounter().GetValue(),
+				})
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, metric := range family.GetMetric() {
+				value := metric.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = metric.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{
+					Attributes:   labelAttributes(metric),
+					TimeUnixNano: ts,
+					AsDouble:     value,
+				})
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: aggregationTemporalityCumulative}
+			for _, metric := range family.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramPoint(metric, start, ts))
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, metric := range family.GetMetric() {
+				summary := metric.GetSummary()
+				point := otlpSummaryPoint{
+					Attributes:        labelAttributes(metric),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             strconv.FormatUint(summary.GetSampleCount(), 10),
+					Sum:               summary.GetSampleSum(),
+				}
+				for _, q := range summary.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, otlpQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, point)
+			}
+		default:
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				{Key: "service.name", Value: otlpAttrValue{StringValue: e.serviceName}},
+			}},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "webhook-processor"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// histogramPoint converts Prometheus' cumulative buckets into OTLP's
+// per-bucket counts, which carry one extra overflow bucket past the last
+// bound.
+func histogramPoint(metric *dto.Metric, start, ts string) otlpHistogramPoint {
+	h := metric.GetHistogram()
+	point := otlpHistogramPoint{
+		Attributes:        labelAttributes(metric),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+	}
+
+	var previous uint64
+	for _, b := range h.GetBucket() {
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+		previous = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+
+	return point
+}
+
+func labelAttributes(metric *dto.Metric) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		attrs = append(attrs, otlpAttribute{Key: label.GetName(), Value: otlpAttrValue{StringValue: label.GetValue()}})
+	}
+	return attrs
+}
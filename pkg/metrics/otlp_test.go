@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOTLPExporterPushesRegisteredMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_events_total", Help: "test"}, []string{"client_id"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("client-a").Add(3)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	var received otlpRequest
+	var path, contentType string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/", "webhook-test", 0, registry, zap.NewNop())
+	require.NoError(t, exporter.Export(context.Background()))
+
+	assert.Equal(t, "/v1/metrics", path)
+	assert.Equal(t, "application/json", contentType)
+	require.Len(t, received.ResourceMetrics, 1)
+	assert.Equal(t, "webhook-test", received.ResourceMetrics[0].Resource.Attributes[0].Value.StringValue)
+
+	metrics := map[string]otlpMetric{}
+	for _, m := range received.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	sum := metrics["test_events_total"].Sum
+	require.NotNil(t, sum)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, aggregationTemporalityCumulative, sum.AggregationTemporality)
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, 3.0, sum.DataPoints[0].AsDouble)
+	assert.Equal(t, "client_id", sum.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "client-a", sum.DataPoints[0].Attributes[0].Value.StringValue)
+
+	hist := metrics["test_duration_seconds"].Histogram
+	require.NotNil(t, hist)
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, "3", hist.DataPoints[0].Count)
+	assert.Equal(t, []float64{0.1, 1}, hist.DataPoints[0].ExplicitBounds)
+	assert.Equal(t, []string{"1", "1", "1"}, hist.DataPoints[0].BucketCounts, "per-bucket counts with an overflow bucket")
+}
+
+func TestOTLPExporterReportsCollectorErrors(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL, "webhook-test", 0, prometheus.NewRegistry(), zap.NewNop())
+
+	assert.Error(t, exporter.Export(context.Background()))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -230,10 +231,11 @@ type ToggleWebhookResponse struct {
 	Message string `json:"message"`
 }
 
-func (c *Client) toggleWebhookStatus(webhookID string) error {
-	// Always set to "1" since we're activating webhooks
+// toggleWebhookStatus sets the webhook status to statusEnabled or
+// statusDisabled.
+func (c *Client) toggleWebhookStatus(webhookID, status string) error {
 	toggleReq := ToggleWebhookRequest{
-		Status: "1",
+		Status: status,
 	}
 
 	jsonData, err := json.Marshal(toggleReq)
@@ -294,13 +296,79 @@ func convertStatus(status string) int {
 	return 0
 }
 
-func processWebhooks(clientID, apiKey, webhookURL string) error {
-	client := &Client{
-		ID:      clientID,
-		APIKey:  apiKey,
-		BaseURL: mailercloudBaseURL,
+// syncOptions controls optional behaviour of processWebhooks.
+type syncOptions struct {
+	// dedupe keeps a single webhook delivering to our URL and disables any
+	// others, so events aren't delivered more than once.
+	dedupe bool
+	// dryRun logs the changes that would be made without making them.
+	dryRun bool
+}
+
+// sameURL reports whether two webhook URLs point at the same endpoint,
+// ignoring case and a trailing slash.
+func sameURL(a, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// findDuplicates picks the webhook to keep among those delivering to
+// webhookURL and returns the rest as duplicates. An active webhook is
+// preferred, then the first listed. keep is nil if none point at webhookURL.
+func findDuplicates(webhooks []Webhook, webhookURL string) (keep *Webhook, duplicates []Webhook) {
+	var matching []Webhook
+	for _, webhook := range webhooks {
+		if sameURL(webhook.URL, webhookURL) {
+			matching = append(matching, webhook)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, nil
+	}
+
+	keepIndex := 0
+	for i, webhook := range matching {
+		if webhook.Status == 1 {
+			keepIndex = i
+			break
+		}
+	}
+
+	kept := matching[keepIndex]
+	duplicates = append(duplicates, matching[:keepIndex]...)
+	duplicates = append(duplicates, matching[keepIndex+1:]...)
+	return &kept, duplicates
+}
+
+// dedupeWebhooks disables every active webhook delivering to webhookURL
+// except one, and returns the single webhook that should be synced. If none
+// point at webhookURL yet, the first webhook is returned to be repointed.
+func dedupeWebhooks(client *Client, webhooks []Webhook, webhookURL string, dryRun bool) Webhook {
+	keep, duplicates := findDuplicates(webhooks, webhookURL)
+	if keep == nil {
+		return webhooks[0]
+	}
+
+	for _, duplicate := range duplicates {
+		if duplicate.Status != 1 {
+			log.Printf("Duplicate webhook %s (%s) is already inactive", duplicate.ID, duplicate.Name)
+			continue
+		}
+		if dryRun {
+			log.Printf("[dry-run] Would disable duplicate webhook %s (%s), keeping %s", duplicate.ID, duplicate.Name, keep.ID)
+			continue
+		}
+		if err := client.toggleWebhookStatus(duplicate.ID, statusDisabled); err != nil {
+			log.Printf("Error disabling duplicate webhook %s: %v", duplicate.ID, err)
+			continue
+		}
+		log.Printf("Disabled duplicate webhook %s (%s), keeping %s", duplicate.ID, duplicate.Name, keep.ID)
 	}
 
+	return *keep
+}
+
+func processWebhooks(client *Client, webhookURL string, opts syncOptions) error {
+	clientID := client.ID
 	log.Printf("Processing webhooks for client: %s", clientID)
 
 	// Step 1: Get all webhooks
@@ -315,6 +383,16 @@ func processWebhooks(clientID, apiKey, webhookURL string) error {
 	}
 	log.Printf("Found %d webhooks", len(webhooks))
 
+	// Repointing every webhook at our URL would deliver each event once per
+	// webhook, so with dedupe only one is synced.
+	if opts.dedupe {
+		keep := dedupeWebhooks(client, webhooks, webhookURL, opts.dryRun)
+		if len(webhooks) > 1 {
+			log.Printf("Syncing only webhook %s; %d others left unchanged or disabled", keep.ID, len(webhooks)-1)
+		}
+		webhooks = []Webhook{keep}
+	}
+
 	for _, webhook := range webhooks {
 		log.Printf("-----------------------------------")
 		log.Printf("Processing webhook:")
@@ -328,6 +406,10 @@ func processWebhooks(clientID, apiKey, webhookURL string) error {
 
 		// Step 2: Check and update URL if needed
 		if webhook.URL != webhookURL {
+			if opts.dryRun {
+				log.Printf("[dry-run] Would update webhook URL to: %s", webhookURL)
+				continue
+			}
 			log.Printf("Current URL doesn't match expected URL (%s). Updating...", webhookURL)
 			if err := client.updateWebhookURL(webhook.ID, &webhook, webhookURL); err != nil {
 				log.Printf("Error updating webhook URL: %v", err)
@@ -347,8 +429,12 @@ func processWebhooks(clientID, apiKey, webhookURL string) error {
 
 		// Step 4: Activate if needed
 		if details.Status != 1 {
+			if opts.dryRun {
+				log.Printf("[dry-run] Would activate webhook")
+				continue
+			}
 			log.Printf("Webhook is not active. Activating...")
-			if err := client.toggleWebhookStatus(webhook.ID); err != nil {
+			if err := client.toggleWebhookStatus(webhook.ID, statusEnabled); err != nil {
 				log.Printf("Error activating webhook: %v", err)
 				continue
 			}
@@ -416,6 +502,11 @@ func loadAPIKeysFromEnv() map[string]string {
 }
 
 func main() {
+	dedupe := flag.Bool("dedupe", false, "keep one webhook per client delivering to our URL and disable the rest")
+	dryRun := flag.Bool("dry-run", false, "log the changes that would be made without making them")
+	flag.Parse()
+	opts := syncOptions{dedupe: *dedupe, dryRun: *dryRun}
+
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
 	// Load environment variables - try production first, then development
@@ -454,7 +545,12 @@ func main() {
 		log.Printf("Processing client: %s", clientID)
 		log.Printf("========================================")
 
-		if err := processWebhooks(clientID, apiKey, webhookURL); err != nil {
+		client := &Client{
+			ID:      clientID,
+			APIKey:  apiKey,
+			BaseURL: mailercloudBaseURL,
+		}
+		if err := processWebhooks(client, webhookURL, opts); err != nil {
 			log.Printf("Error processing webhooks for client %s: %v", clientID, err)
 		} else {
 			log.Printf("Successfully processed webhooks for client: %s", clientID)
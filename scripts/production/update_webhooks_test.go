@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockMailerCloud serves the webhook endpoints used by the updater and
+// records status toggles and URL updates.
+type mockMailerCloud struct {
+	mu       sync.Mutex
+	webhooks []Webhook
+	toggles  map[string]string
+	updates  map[string]string
+}
+
+func newMockMailerCloud(t *testing.T, webhooks ...Webhook) (*mockMailerCloud, *Client) {
+	t.Helper()
+	m := &mockMailerCloud{
+		webhooks: webhooks,
+		toggles:  make(map[string]string),
+		updates:  make(map[string]string),
+	}
+	server := httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(server.Close)
+	return m, &Client{ID: "client-a", APIKey: "key", BaseURL: server.URL}
+}
+
+func (m *mockMailerCloud) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/webhooks/search":
+		json.NewEncoder(w).Encode(WebhookList{Data: m.webhooks, Total: len(m.webhooks)})
+	case strings.HasPrefix(r.URL.Path, "/webhooks/toggle/"):
+		var req ToggleWebhookRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.toggles[strings.TrimPrefix(r.URL.Path, "/webhooks/toggle/")] = req.Status
+		json.NewEncoder(w).Encode(ToggleWebhookResponse{Message: "ok"})
+	case strings.HasPrefix(r.URL.Path, "/webhooks/detail/"):
+		id := strings.TrimPrefix(r.URL.Path, "/webhooks/detail/")
+		for _, webhook := range m.webhooks {
+			if webhook.ID == id {
+				status := statusInactive
+				if webhook.Status == 1 {
+					status = statusActive
+				}
+				json.NewEncoder(w).Encode(WebhookDetailResponse{Webhook: WebhookDetail{ID: id, URL: webhook.URL, Status: status}})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/webhooks/"):
+		var req UpdateWebhookRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.updates[strings.TrimPrefix(r.URL.Path, "/webhooks/")] = req.URL
+		json.NewEncoder(w).Encode(UpdateWebhookResponse{Message: "ok"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const ourURL = "https://hooks.example.com/webhook"
+
+func duplicateWebhooks() []Webhook {
+	return []Webhook{
+		{ID: "wh-1", Name: "old", URL: ourURL, Status: 0},
+		{ID: "wh-2", Name: "primary", URL: ourURL, Status: 1},
+		{ID: "wh-3", Name: "copy", URL: ourURL + "/", Status: 1},
+		{ID: "wh-4", Name: "other tool", URL: "https://other.example.com/hook", Status: 1},
+	}
+}
+
+func TestFindDuplicatesPrefersActive(t *testing.T) {
+	keep, duplicates := findDuplicates(duplicateWebhooks(), ourURL)
+	if keep == nil || keep.ID != "wh-2" {
+		t.Fatalf("expected to keep wh-2, got %+v", keep)
+	}
+	var ids []string
+	for _, d := range duplicates {
+		ids = append(ids, d.ID)
+	}
+	if strings.Join(ids, ",") != "wh-1,wh-3" {
+		t.Errorf("expected duplicates wh-1,wh-3, got %v", ids)
+	}
+}
+
+func TestProcessWebhooksDisablesDuplicates(t *testing.T) {
+	m, client := newMockMailerCloud(t, duplicateWebhooks()...)
+
+	if err := processWebhooks(client, ourURL, syncOptions{dedupe: true}); err != nil {
+		t.Fatalf("processWebhooks: %v", err)
+	}
+
+	if len(m.toggles) != 1 || m.toggles["wh-3"] != statusDisabled {
+		t.Errorf("expected only wh-3 to be disabled, got %v", m.toggles)
+	}
+	if len(m.updates) != 0 {
+		t.Errorf("expected no URL updates, got %v", m.updates)
+	}
+}
+
+func TestProcessWebhooksDedupeDryRun(t *testing.T) {
+	m, client := newMockMailerCloud(t, duplicateWebhooks()...)
+
+	if err := processWebhooks(client, ourURL, syncOptions{dedupe: true, dryRun: true}); err != nil {
+		t.Fatalf("processWebhooks: %v", err)
+	}
+
+	if len(m.toggles) != 0 || len(m.updates) != 0 {
+		t.Errorf("dry run must not change webhooks, got toggles=%v updates=%v", m.toggles, m.updates)
+	}
+}
+
+func TestProcessWebhooksWithoutDedupeRepointsAll(t *testing.T) {
+	m, client := newMockMailerCloud(t, duplicateWebhooks()...)
+
+	if err := processWebhooks(client, ourURL, syncOptions{}); err != nil {
+		t.Fatalf("processWebhooks: %v", err)
+	}
+
+	if m.updates["wh-4"] != ourURL {
+		t.Errorf("expected wh-4 to be repointed, got %v", m.updates)
+	}
+}